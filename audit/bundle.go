@@ -0,0 +1,284 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/secret"
+)
+
+// BundleVersion is the version of the export format written by Writer.
+const BundleVersion = 1
+
+const (
+	RecordHeader     = "header"
+	RecordEvent      = "event"
+	RecordCheckpoint = "checkpoint"
+	RecordTrailer    = "trailer"
+)
+
+// Record is one line of an NDJSON bundle. The first line is the header;
+// events follow in chain order, each checkpoint right after its event, and
+// the trailer closes the bundle with the number of events.
+type Record struct {
+	Type       string              `json:"type"`
+	Version    int                 `json:"version,omitempty"`
+	ExportedAt *time.Time          `json:"exported_at,omitempty"`
+	Event      *pg.AuditEvent      `json:"event,omitempty"`
+	Checkpoint *pg.AuditCheckpoint `json:"checkpoint,omitempty"`
+	Events     *int                `json:"events,omitempty"`
+}
+
+// Writer writes a bundle.
+type Writer struct {
+	enc    *json.Encoder
+	events int
+}
+
+// NewWriter writes the header of a bundle to w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	bw := &Writer{enc: json.NewEncoder(w)}
+	now := time.Now().UTC()
+	return bw, bw.enc.Encode(&Record{Type: RecordHeader, Version: BundleVersion, ExportedAt: &now})
+}
+
+func (bw *Writer) Event(ev *pg.AuditEvent) error {
+	bw.events++
+	return bw.enc.Encode(&Record{Type: RecordEvent, Event: ev})
+}
+
+func (bw *Writer) Checkpoint(cp *pg.AuditCheckpoint) error {
+	return bw.enc.Encode(&Record{Type: RecordCheckpoint, Checkpoint: cp})
+}
+
+// Close writes the trailer. A bundle without one is reported truncated.
+func (bw *Writer) Close() error {
+	return bw.enc.Encode(&Record{Type: RecordTrailer, Events: &bw.events})
+}
+
+const (
+	ProblemMalformed          = "malformed"
+	ProblemOutOfOrder         = "out_of_order"
+	ProblemModified           = "modified"
+	ProblemBrokenLink         = "broken_link"
+	ProblemBadHash            = "bad_hash"
+	ProblemBadSignature       = "bad_signature"
+	ProblemCheckpointMismatch = "checkpoint_mismatch"
+	ProblemTruncated          = "truncated"
+	ProblemUnclaimedErasure   = "unclaimed_erasure"
+)
+
+// Problem is a finding of Verify. Line is the line of the bundle it was
+// found on.
+type Problem struct {
+	Line    int    `json:"line"`
+	EventID int64  `json:"event_id,omitempty"`
+	Kind    string `json:"kind"`
+	Detail  string `json:"detail"`
+}
+
+// Report sums up the verification of a bundle.
+type Report struct {
+	Events      int `json:"events"`
+	Checkpoints int `json:"checkpoints"`
+
+	// Unchained counts the events recorded before the hash chain existed.
+	Unchained int `json:"unchained"`
+
+	// Erased counts the events anonymized by an erasure, whose content
+	// cannot be checked against its hash any more.
+	Erased int `json:"erased"`
+
+	// Partial is set when the bundle does not start at the genesis of the
+	// chain; its first event is then trusted as the anchor.
+	Partial bool `json:"partial"`
+
+	// Unsigned counts the events after the last valid checkpoint. They are
+	// chained but not yet covered by a signature.
+	Unsigned         int   `json:"unsigned"`
+	LastCheckpointID int64 `json:"last_checkpoint_id"`
+
+	Problems []Problem `json:"problems"`
+}
+
+func (r *Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *Report) problem(line int, id int64, kind, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, Problem{
+		Line:    line,
+		EventID: id,
+		Kind:    kind,
+		Detail:  fmt.Sprintf(format, args...),
+	})
+}
+
+// Verify reads a bundle and checks every event against its hashes, the
+// links between events, and every checkpoint against pub. Verification
+// goes on after a problem so the report lists all of them; an error is
+// returned only when the bundle cannot be read at all.
+func Verify(r io.Reader, pub *rsa.PublicKey) (*Report, error) {
+	report := &Report{Problems: []Problem{}}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	line := 0
+	closed := false
+	var last *pg.AuditEvent
+	erasures := erasures{}
+	for sc.Scan() {
+		line++
+
+		if closed {
+			report.problem(line, 0, ProblemMalformed, "record after the trailer")
+			continue
+		}
+
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			report.problem(line, 0, ProblemMalformed, "%v", err)
+			continue
+		}
+
+		if line == 1 {
+			if rec.Type != RecordHeader {
+				return nil, fmt.Errorf("line 1: not a bundle header")
+			}
+			if rec.Version != BundleVersion {
+				return nil, fmt.Errorf("unsupported bundle version %v", rec.Version)
+			}
+			continue
+		}
+
+		switch {
+		case rec.Type == RecordEvent && rec.Event != nil:
+			verifyEvent(report, line, last, rec.Event)
+			erasures.track(line, rec.Event)
+			last = rec.Event
+
+		case rec.Type == RecordCheckpoint && rec.Checkpoint != nil:
+			verifyCheckpoint(report, line, last, rec.Checkpoint, pub)
+
+		case rec.Type == RecordTrailer && rec.Events != nil:
+			closed = true
+			if *rec.Events != report.Events {
+				report.problem(line, 0, ProblemTruncated,
+					"the trailer counts %v events, the bundle holds %v", *rec.Events, report.Events)
+			}
+
+		default:
+			report.problem(line, 0, ProblemMalformed, "unexpected %q record", rec.Type)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if line == 0 {
+		return nil, fmt.Errorf("empty bundle")
+	}
+	for _, ev := range erasures {
+		report.problem(ev.line, ev.id, ProblemUnclaimedErasure,
+			"erased event without a later erasure of its account")
+	}
+	if !closed {
+		report.problem(line, 0, ProblemTruncated, "the bundle has no trailer")
+	}
+
+	return report, nil
+}
+
+type erased struct {
+	line     int
+	id       int64
+	accounts [2]string
+}
+
+// erasures are the erased events of a bundle not yet claimed by the event
+// of their erasure, which follows them and targets the pseudonym of the
+// account. An event merely flagged erased is not claimed.
+type erasures []erased
+
+func (es *erasures) track(line int, ev *pg.AuditEvent) {
+	if ev.Erased {
+		*es = append(*es, erased{line, ev.ID, [2]string{ev.Actor, ev.Target}})
+		return
+	}
+	if ev.Action != ActionErase || ev.Outcome != OutcomeSuccess {
+		return
+	}
+
+	left := (*es)[:0]
+	for _, e := range *es {
+		if e.accounts[0] != ev.Target && e.accounts[1] != ev.Target {
+			left = append(left, e)
+		}
+	}
+	*es = left
+}
+
+func verifyEvent(report *Report, line int, last, ev *pg.AuditEvent) {
+	report.Events++
+	report.Unsigned++
+
+	if last != nil && ev.ID <= last.ID {
+		report.problem(line, ev.ID, ProblemOutOfOrder, "event %v follows event %v", ev.ID, last.ID)
+	}
+
+	if ev.Hash == "" {
+		if last != nil && last.Hash != "" {
+			report.problem(line, ev.ID, ProblemBrokenLink, "unchained event after the start of the chain")
+		}
+		report.Unchained++
+		return
+	}
+
+	if ev.Erased {
+		report.Erased++
+	} else if content, err := ContentHash(ev); err != nil || content != ev.ContentHash {
+		report.problem(line, ev.ID, ProblemModified, "content does not match its hash")
+	}
+
+	if ev.Hash != ChainHash(ev.PrevHash, ev.ContentHash) {
+		report.problem(line, ev.ID, ProblemBadHash, "hash does not match its content and link")
+	}
+
+	switch {
+	case last != nil && last.Hash != "":
+		if ev.PrevHash != last.Hash {
+			report.problem(line, ev.ID, ProblemBrokenLink,
+				"previous hash does not match event %v; events are missing or were altered", last.ID)
+		}
+	case ev.PrevHash != GenesisHash:
+		report.Partial = true
+	}
+}
+
+func verifyCheckpoint(report *Report, line int, last *pg.AuditEvent, cp *pg.AuditCheckpoint, pub *rsa.PublicKey) {
+	report.Checkpoints++
+
+	sig, err := base64.StdEncoding.DecodeString(cp.Signature)
+	if err == nil {
+		err = secret.VerifySignature(pub, CheckpointPayload(cp), sig)
+	}
+	if err != nil {
+		report.problem(line, cp.EventID, ProblemBadSignature, "checkpoint %v: invalid signature", cp.ID)
+		return
+	}
+
+	if last == nil || last.ID != cp.EventID || last.Hash != cp.Hash {
+		report.problem(line, cp.EventID, ProblemCheckpointMismatch,
+			"checkpoint %v does not match the event it follows", cp.ID)
+		return
+	}
+
+	report.Unsigned = 0
+	report.LastCheckpointID = cp.ID
+}
@@ -0,0 +1,229 @@
+package audit_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dontang97/ui/audit"
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/secret"
+	"github.com/stretchr/testify/suite"
+)
+
+type _Suite struct {
+	suite.Suite
+
+	events []pg.AuditEvent
+}
+
+func (s *_Suite) SetupSuite() {
+	secret.InitSecretKey("../secret")
+}
+
+func (s *_Suite) TearDownSuite() {
+}
+
+func (s *_Suite) SetupTest() {
+	s.events = nil
+
+	prev := audit.GenesisHash
+	at := time.Date(2021, 3, 1, 12, 0, 0, 123456789, time.UTC)
+	for i, acct := range []string{"kobe", "lebron", "curry"} {
+		ev := pg.AuditEvent{
+			ID:       int64(i + 1),
+			At:       at.Add(time.Duration(i) * time.Minute),
+			Actor:    acct,
+			Target:   acct,
+			Action:   "update",
+			Diff:     pg.JSONB(`{"fullname": {"before": "a", "after": "b"}}`),
+			SourceIP: "127.0.0.1",
+			Outcome:  "success",
+			Status:   200,
+		}
+		s.Nil(audit.Seal(&ev, prev))
+		prev = ev.Hash
+		s.events = append(s.events, ev)
+	}
+}
+
+func (s *_Suite) TearDownTest() {
+}
+
+func (s *_Suite) checkpoint(ev *pg.AuditEvent) *pg.AuditCheckpoint {
+	cp := &pg.AuditCheckpoint{ID: ev.ID, EventID: ev.ID, Hash: ev.Hash, CreatedAt: ev.At}
+	sig, err := secret.Sign(audit.CheckpointPayload(cp))
+	s.Nil(err)
+	cp.Signature = base64.StdEncoding.EncodeToString(sig)
+	return cp
+}
+
+// bundle exports events with a checkpoint after the last one.
+func (s *_Suite) bundle(events []pg.AuditEvent) string {
+	var buf bytes.Buffer
+	bw, err := audit.NewWriter(&buf)
+	s.Nil(err)
+	for i := range events {
+		s.Nil(bw.Event(&events[i]))
+	}
+	s.Nil(bw.Checkpoint(s.checkpoint(&events[len(events)-1])))
+	s.Nil(bw.Close())
+	return buf.String()
+}
+
+func (s *_Suite) verify(bundle string) *audit.Report {
+	report, err := audit.Verify(strings.NewReader(bundle), secret.PublicKey())
+	s.Nil(err)
+	return report
+}
+
+func kinds(report *audit.Report) []string {
+	ks := []string{}
+	for _, p := range report.Problems {
+		ks = append(ks, p.Kind)
+	}
+	return ks
+}
+
+func (s *_Suite) TestSeal() {
+	ev := s.events[0]
+	s.Equal(audit.GenesisHash, ev.PrevHash)
+	s.Equal(0, ev.At.Nanosecond()%int(audit.Precision))
+
+	// the jsonb of the database may reorder keys and spaces
+	ev.Diff = pg.JSONB(`{"fullname":{"after":"b","before":"a"}}`)
+	content, err := audit.ContentHash(&ev)
+	s.Nil(err)
+	s.Equal(ev.ContentHash, content)
+}
+
+func (s *_Suite) TestVerify() {
+	report := s.verify(s.bundle(s.events))
+	s.Equal([]string{}, kinds(report))
+	s.Equal(true, report.OK())
+	s.Equal(3, report.Events)
+	s.Equal(1, report.Checkpoints)
+	s.Equal(0, report.Unsigned)
+	s.Equal(false, report.Partial)
+	s.Equal(int64(3), report.LastCheckpointID)
+
+	// a bundle may start in the middle of the chain
+	report = s.verify(s.bundle(s.events[1:]))
+	s.Equal([]string{}, kinds(report))
+	s.Equal(true, report.Partial)
+}
+
+func (s *_Suite) TestVerifyModified() {
+	s.events[1].Target = "jordan"
+	report := s.verify(s.bundle(s.events))
+	s.Equal([]string{audit.ProblemModified}, kinds(report))
+	s.Equal(int64(2), report.Problems[0].EventID)
+
+	// rehashing the record breaks the link to the next one
+	s.SetupTest()
+	s.events[1].Target = "jordan"
+	s.Nil(audit.Seal(&s.events[1], s.events[0].Hash))
+	report = s.verify(s.bundle(s.events))
+	s.Equal([]string{audit.ProblemBrokenLink}, kinds(report))
+	s.Equal(int64(3), report.Problems[0].EventID)
+}
+
+func (s *_Suite) TestVerifyErased() {
+	erased, err := audit.Erase(&s.events[1], "lebron", "erased_0123456789ab")
+	s.Nil(err)
+	s.Equal(true, erased)
+	s.Equal("erased_0123456789ab", s.events[1].Actor)
+	s.Equal("erased_0123456789ab", s.events[1].Target)
+	s.Equal("", s.events[1].SourceIP)
+	s.JSONEq(`{"fullname": {"before": "[REDACTED]", "after": "[REDACTED]"}}`, string(s.events[1].Diff))
+
+	erased, err = audit.Erase(&s.events[2], "lebron", "erased_0123456789ab")
+	s.Nil(err)
+	s.Equal(false, erased)
+
+	// the chain holds across the erased event, which the event of the
+	// erasure claims
+	ev := pg.AuditEvent{
+		ID:      4,
+		At:      s.events[2].At.Add(time.Minute),
+		Target:  "erased_0123456789ab",
+		Action:  audit.ActionErase,
+		Outcome: audit.OutcomeSuccess,
+		Status:  200,
+	}
+	s.Nil(audit.Seal(&ev, s.events[2].Hash))
+	report := s.verify(s.bundle(append(s.events, ev)))
+	s.Equal([]string{}, kinds(report))
+	s.Equal(1, report.Erased)
+
+	// an event flagged erased by anyone else is not
+	report = s.verify(s.bundle(s.events))
+	s.Equal([]string{audit.ProblemUnclaimedErasure}, kinds(report))
+	s.Equal(int64(2), report.Problems[0].EventID)
+
+	// and nothing else may change with it
+	s.events[1].Action = "delete"
+	s.Nil(audit.Seal(&s.events[1], s.events[0].Hash))
+	report = s.verify(s.bundle(append(s.events, ev)))
+	s.Equal([]string{audit.ProblemBrokenLink}, kinds(report))
+}
+
+func (s *_Suite) TestVerifyGap() {
+	events := []pg.AuditEvent{s.events[0], s.events[2]}
+	report := s.verify(s.bundle(events))
+	s.Equal([]string{audit.ProblemBrokenLink}, kinds(report))
+	s.Equal(int64(3), report.Problems[0].EventID)
+}
+
+func (s *_Suite) TestVerifyOrder() {
+	events := []pg.AuditEvent{s.events[0], s.events[2], s.events[1]}
+	report := s.verify(s.bundle(events))
+	s.Equal([]string{
+		audit.ProblemBrokenLink,
+		audit.ProblemOutOfOrder, audit.ProblemBrokenLink,
+	}, kinds(report))
+}
+
+func (s *_Suite) TestVerifySignature() {
+	bundle := s.bundle(s.events)
+
+	lines := strings.Split(strings.TrimSpace(bundle), "\n")
+	var rec audit.Record
+	s.Nil(json.Unmarshal([]byte(lines[4]), &rec))
+	s.Equal(audit.RecordCheckpoint, rec.Type)
+	rec.Checkpoint.Hash = s.events[1].Hash
+	rec.Checkpoint.EventID = 2
+	js, err := json.Marshal(&rec)
+	s.Nil(err)
+	lines[4] = string(js)
+
+	report := s.verify(strings.Join(lines, "\n"))
+	s.Equal([]string{audit.ProblemBadSignature}, kinds(report))
+	s.Equal(3, report.Unsigned)
+}
+
+func (s *_Suite) TestVerifyTruncated() {
+	bundle := s.bundle(s.events)
+	lines := strings.Split(strings.TrimSpace(bundle), "\n")
+
+	report := s.verify(strings.Join(lines[:len(lines)-1], "\n"))
+	s.Equal([]string{audit.ProblemTruncated}, kinds(report))
+
+	// dropping the newest events keeps the chain intact but not the count
+	trailer := lines[len(lines)-1]
+	report = s.verify(strings.Join(append(lines[:3], trailer), "\n"))
+	s.Equal([]string{audit.ProblemTruncated}, kinds(report))
+	s.Equal(2, report.Unsigned)
+
+	_, err := audit.Verify(strings.NewReader(""), secret.PublicKey())
+	s.NotNil(err)
+	_, err = audit.Verify(strings.NewReader(lines[1]), secret.PublicKey())
+	s.NotNil(err)
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(_Suite))
+}
@@ -0,0 +1,96 @@
+// Package audit makes the audit log tamper-evident: events are chained by
+// hashes, checkpoints of the chain are signed, and exported bundles can be
+// verified offline.
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/dontang97/ui/pg"
+)
+
+// GenesisHash is the PrevHash of the first event of the chain.
+var GenesisHash = hex.EncodeToString(make([]byte, sha256.Size))
+
+// Precision is the resolution of the stored event times. Times are
+// truncated to it before hashing so that hashes survive the round trip.
+const Precision = time.Microsecond
+
+// canonicalJSON re-encodes a JSON document with sorted keys and no
+// insignificant spaces. The database normalizes jsonb on its own terms, so
+// the stored bytes cannot be hashed as they are.
+func canonicalJSON(js []byte) ([]byte, error) {
+	if len(js) == 0 {
+		return []byte("null"), nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// ContentHash digests every field of ev except its ID, which the database
+// assigns after hashing, and the chain fields.
+func ContentHash(ev *pg.AuditEvent) (string, error) {
+	diff, err := canonicalJSON(ev.Diff)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := json.Marshal([]interface{}{
+		ev.At.UTC().Truncate(Precision).Format(time.RFC3339Nano),
+		ev.Actor,
+		ev.Target,
+		ev.Action,
+		json.RawMessage(diff),
+		ev.SourceIP,
+		ev.RequestID,
+		ev.Outcome,
+		ev.Status,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ChainHash links an event, by its content hash, to the previous event.
+func ChainHash(prevHash, contentHash string) string {
+	sum := sha256.Sum256([]byte(prevHash + contentHash))
+	return hex.EncodeToString(sum[:])
+}
+
+// Seal fills the chain fields of ev, which follows the event hashed
+// prevHash, or GenesisHash for the first event.
+func Seal(ev *pg.AuditEvent, prevHash string) error {
+	ev.At = ev.At.UTC().Truncate(Precision)
+
+	content, err := ContentHash(ev)
+	if err != nil {
+		return err
+	}
+
+	ev.ContentHash = content
+	ev.PrevHash = prevHash
+	ev.Hash = ChainHash(prevHash, content)
+	return nil
+}
+
+// CheckpointPayload is the message signed by a checkpoint.
+func CheckpointPayload(cp *pg.AuditCheckpoint) []byte {
+	return []byte("ui-audit-checkpoint:" +
+		strconv.FormatInt(cp.EventID, 10) + ":" +
+		cp.Hash + ":" +
+		cp.CreatedAt.UTC().Truncate(Precision).Format(time.RFC3339Nano))
+}
@@ -0,0 +1,58 @@
+package audit
+
+import (
+	"encoding/json"
+
+	"github.com/dontang97/ui/pg"
+)
+
+const (
+	// Redacted replaces the erased values of an event.
+	Redacted = "[REDACTED]"
+
+	// ActionErase is the action of the event recording an erasure. It
+	// targets the pseudonym, and claims the events erased before it.
+	ActionErase    = "erase"
+	OutcomeSuccess = "success"
+)
+
+// Erase anonymizes what ev holds about the account name in place: name
+// becomes pseudonym as actor or target, the source address is dropped, and
+// the diff of an event targeting the account keeps the changed fields but
+// not their values. The chain fields are kept, so the erased event still
+// links its neighbours, but it no longer matches its ContentHash. It
+// reports whether ev was about name at all.
+func Erase(ev *pg.AuditEvent, name, pseudonym string) (bool, error) {
+	if ev.Actor != name && ev.Target != name {
+		return false, nil
+	}
+
+	if ev.Actor == name {
+		ev.Actor = pseudonym
+	}
+	if ev.Target == name {
+		ev.Target = pseudonym
+
+		if len(ev.Diff) > 0 {
+			changes := map[string]map[string]interface{}{}
+			if err := json.Unmarshal(ev.Diff, &changes); err != nil {
+				return false, err
+			}
+			for _, change := range changes {
+				for k, v := range change {
+					if v != nil {
+						change[k] = Redacted
+					}
+				}
+			}
+			diff, err := json.Marshal(changes)
+			if err != nil {
+				return false, err
+			}
+			ev.Diff = diff
+		}
+	}
+	ev.SourceIP = ""
+	ev.Erased = true
+	return true, nil
+}
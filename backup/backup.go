@@ -0,0 +1,286 @@
+// Package backup writes the user store to an encrypted archive and
+// restores it. Archives hold the rows of the tables as JSON objects, their
+// values as Postgres encodes them to JSON. The only Store is pg.PG, which
+// reads the keys of the tables from the Postgres catalogs and decodes the
+// rows with json_populate_record, so backups are taken from and restored
+// to Postgres only.
+//
+// The rows are compressed with gzip and sealed with AES-256-GCM chunk by
+// chunk. A trailer closes them with the row count and the SHA-256 of
+// the rows of each table, and a restore applies nothing unless the whole
+// archive checks out.
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+)
+
+// Version is the version of the archives this build writes. It reads those
+// of every earlier one as well.
+const Version = 1
+
+const (
+	// ConflictFail fails a restore at the first row whose key is taken by
+	// a different row of the store.
+	ConflictFail = "fail"
+
+	// ConflictSkip keeps the rows of the store over those of the archive.
+	ConflictSkip = "skip"
+
+	// ConflictReplace replaces the rows of the store with those of the
+	// archive, except for append-only tables, whose rows are kept.
+	ConflictReplace = "replace"
+)
+
+// ValidConflict reports whether policy is a conflict policy.
+func ValidConflict(policy string) bool {
+	switch policy {
+	case ConflictFail, ConflictSkip, ConflictReplace:
+		return true
+	}
+	return false
+}
+
+// Store is a user store a backup is taken from and restored to. It encodes
+// the rows as Postgres does, for the archives to restore to Postgres.
+type Store interface {
+	// Snapshot calls fn with each row of each table as of one point in
+	// time, table after table in the order they restore in.
+	Snapshot(ctx context.Context, fn func(table string, row json.RawMessage) error) error
+
+	// Restore writes the rows next returns until io.EOF in one
+	// transaction, settling the rows whose key is taken by policy. Nothing
+	// is written if next or a row fails. Rows identical to those of the
+	// store are left as they are under any policy.
+	Restore(ctx context.Context, policy string, next func() (string, json.RawMessage, error)) (Report, error)
+}
+
+// TableReport counts what a restore did with the rows of a table.
+type TableReport struct {
+	Restored  int `json:"restored"`
+	Unchanged int `json:"unchanged"`
+	Replaced  int `json:"replaced"`
+	Skipped   int `json:"skipped"`
+}
+
+// Report is the TableReport of each table of a restore.
+type Report map[string]*TableReport
+
+// ConflictError is a row of the archive whose key is taken by a different
+// row of the store, under ConflictFail. Key holds the key columns of the
+// row only, not to leak the rest.
+type ConflictError struct {
+	Table string
+	Key   json.RawMessage
+}
+
+func (err *ConflictError) Error() string {
+	return fmt.Sprintf("row %s of %v conflicts with the store", err.Key, err.Table)
+}
+
+// TableSum is the row count and checksum of a table of an archive.
+type TableSum struct {
+	Rows   int    `json:"rows"`
+	SHA256 string `json:"sha256"`
+}
+
+// Trailer closes an archive.
+type Trailer struct {
+	CreatedAt time.Time           `json:"created_at"`
+	Tables    map[string]TableSum `json:"tables"`
+}
+
+// record is a line of an archive: a row of a table, or the trailer.
+type record struct {
+	Table string          `json:"table,omitempty"`
+	Row   json.RawMessage `json:"row,omitempty"`
+	End   *Trailer        `json:"end,omitempty"`
+}
+
+type tableSum struct {
+	rows int
+	hash hash.Hash
+}
+
+func (ts *tableSum) add(row []byte) {
+	ts.rows++
+	ts.hash.Write(row)
+	ts.hash.Write([]byte{'\n'})
+}
+
+func (ts *tableSum) sum() TableSum {
+	return TableSum{Rows: ts.rows, SHA256: hex.EncodeToString(ts.hash.Sum(nil))}
+}
+
+// Writer writes an archive.
+type Writer struct {
+	seal *sealWriter
+	gz   *gzip.Writer
+	enc  *json.Encoder
+	sums map[string]*tableSum
+}
+
+// NewWriter starts an archive sealed with key on w.
+func NewWriter(w io.Writer, key []byte) (*Writer, error) {
+	seal, err := newSealWriter(w, key)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(seal)
+	return &Writer{seal: seal, gz: gz, enc: json.NewEncoder(gz), sums: map[string]*tableSum{}}, nil
+}
+
+// Write adds a row of table, a JSON object.
+func (aw *Writer) Write(table string, row json.RawMessage) error {
+	// rows are summed as they are stored, compacted
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, row); err != nil {
+		return fmt.Errorf("row of %v: %w", table, err)
+	}
+	compact := buf.Bytes()
+
+	sum, ok := aw.sums[table]
+	if !ok {
+		sum = &tableSum{hash: sha256.New()}
+		aw.sums[table] = sum
+	}
+	sum.add(compact)
+
+	return aw.enc.Encode(record{Table: table, Row: json.RawMessage(compact)})
+}
+
+// Close writes the trailer and seals the archive, and returns the trailer.
+// It does not close the underlying writer.
+func (aw *Writer) Close() (*Trailer, error) {
+	trailer := &Trailer{CreatedAt: time.Now().UTC(), Tables: map[string]TableSum{}}
+	for table, sum := range aw.sums {
+		trailer.Tables[table] = sum.sum()
+	}
+
+	if err := aw.enc.Encode(record{End: trailer}); err != nil {
+		return nil, err
+	}
+	if err := aw.gz.Close(); err != nil {
+		return nil, err
+	}
+	return trailer, aw.seal.Close()
+}
+
+// Reader reads an archive.
+type Reader struct {
+	dec     *json.Decoder
+	sums    map[string]*tableSum
+	trailer *Trailer
+}
+
+// NewReader opens an archive sealed with key from r.
+func NewReader(r io.Reader, key []byte) (*Reader, error) {
+	open, err := newOpenReader(bufio.NewReader(r), key)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(open)
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+	return &Reader{dec: json.NewDecoder(gz), sums: map[string]*tableSum{}}, nil
+}
+
+// Next returns the next row of the archive and its table. It returns
+// io.EOF past the last row only once the trailer has checked out against
+// the rows.
+func (ar *Reader) Next() (string, json.RawMessage, error) {
+	if ar.trailer != nil {
+		return "", nil, io.EOF
+	}
+
+	rec := record{}
+	if err := ar.dec.Decode(&rec); err != nil {
+		if err == io.EOF {
+			// the trailer is missing
+			return "", nil, ErrCorrupted
+		}
+		return "", nil, err
+	}
+
+	if rec.End != nil {
+		if err := ar.check(rec.End); err != nil {
+			return "", nil, err
+		}
+		ar.trailer = rec.End
+		return "", nil, io.EOF
+	}
+
+	if rec.Table == "" || len(rec.Row) == 0 {
+		return "", nil, ErrCorrupted
+	}
+	sum, ok := ar.sums[rec.Table]
+	if !ok {
+		sum = &tableSum{hash: sha256.New()}
+		ar.sums[rec.Table] = sum
+	}
+	sum.add(rec.Row)
+	return rec.Table, rec.Row, nil
+}
+
+func (ar *Reader) check(trailer *Trailer) error {
+	if len(trailer.Tables) != len(ar.sums) {
+		return fmt.Errorf("backup archive has %v tables, its trailer %v", len(ar.sums), len(trailer.Tables))
+	}
+	for table, sum := range ar.sums {
+		if got, want := sum.sum(), trailer.Tables[table]; got != want {
+			return fmt.Errorf("backup archive checksum mismatch in %v: %v rows %v, trailer %v rows %v",
+				table, got.Rows, got.SHA256, want.Rows, want.SHA256)
+		}
+	}
+
+	// nothing may follow the trailer
+	if _, err := ar.dec.Token(); err != io.EOF {
+		return ErrCorrupted
+	}
+	return nil
+}
+
+// Trailer returns the trailer of the archive once Next has returned io.EOF.
+func (ar *Reader) Trailer() *Trailer {
+	return ar.trailer
+}
+
+// Backup writes a snapshot of store to w, sealed with key.
+func Backup(ctx context.Context, store Store, w io.Writer, key []byte) (*Trailer, error) {
+	aw, err := NewWriter(w, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Snapshot(ctx, aw.Write); err != nil {
+		return nil, err
+	}
+	return aw.Close()
+}
+
+// Restore restores the archive read from r, sealed with key, to store.
+func Restore(ctx context.Context, store Store, r io.Reader, key []byte, policy string) (*Trailer, Report, error) {
+	if !ValidConflict(policy) {
+		return nil, nil, fmt.Errorf("unknown conflict policy %q", policy)
+	}
+
+	ar, err := NewReader(r, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	report, err := store.Restore(ctx, policy, ar.Next)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ar.Trailer(), report, nil
+}
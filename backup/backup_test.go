@@ -0,0 +1,237 @@
+package backup_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/dontang97/ui/backup"
+	"github.com/stretchr/testify/suite"
+)
+
+// memStore is a store of tables of rows keyed by their "id".
+type memStore struct {
+	order  []string
+	tables map[string]map[string]json.RawMessage
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		order:  []string{"tenants", "users"},
+		tables: map[string]map[string]json.RawMessage{"tenants": {}, "users": {}},
+	}
+}
+
+func (m *memStore) Snapshot(_ context.Context, fn func(string, json.RawMessage) error) error {
+	for _, table := range m.order {
+		for _, row := range m.tables[table] {
+			if err := fn(table, row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *memStore) Restore(_ context.Context, policy string, next func() (string, json.RawMessage, error)) (backup.Report, error) {
+	report := backup.Report{}
+	staged := map[string]map[string]json.RawMessage{}
+	for table, rows := range m.tables {
+		staged[table] = map[string]json.RawMessage{}
+		for id, row := range rows {
+			staged[table][id] = row
+		}
+		report[table] = &backup.TableReport{}
+	}
+
+	for {
+		table, row, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		key := struct {
+			ID string `json:"id"`
+		}{}
+		if err := json.Unmarshal(row, &key); err != nil {
+			return nil, err
+		}
+		old, ok := staged[table][key.ID]
+		switch {
+		case !ok:
+			report[table].Restored++
+		case bytes.Equal(old, row):
+			report[table].Unchanged++
+			continue
+		case policy == backup.ConflictSkip:
+			report[table].Skipped++
+			continue
+		case policy == backup.ConflictReplace:
+			report[table].Replaced++
+		default:
+			return nil, &backup.ConflictError{Table: table, Key: json.RawMessage(`{"id":"` + key.ID + `"}`)}
+		}
+		staged[table][key.ID] = row
+	}
+
+	m.tables = staged
+	return report, nil
+}
+
+type _Suite struct {
+	suite.Suite
+
+	key   []byte
+	store *memStore
+}
+
+func (s *_Suite) SetupTest() {
+	s.key = bytes.Repeat([]byte{7}, backup.KeySize)
+	s.store = newMemStore()
+	s.store.tables["tenants"]["default"] = json.RawMessage(`{"id":"default","name":"Default"}`)
+	s.store.tables["users"]["kobe_bryant"] = json.RawMessage(`{"id":"kobe_bryant","fullname":"Kobe"}`)
+}
+
+func (s *_Suite) archive() []byte {
+	var buf bytes.Buffer
+	trailer, err := backup.Backup(context.Background(), s.store, &buf, s.key)
+	s.Nil(err)
+	s.Equal(1, trailer.Tables["users"].Rows)
+	return buf.Bytes()
+}
+
+func (s *_Suite) TestRoundTrip() {
+	archive := s.archive()
+	s.True(bytes.HasPrefix(archive, []byte("UIBACKUP")))
+	s.False(bytes.Contains(archive, []byte("kobe_bryant")))
+
+	store := newMemStore()
+	store.tables["tenants"]["default"] = json.RawMessage(`{"id":"default","name":"Default"}`)
+	trailer, report, err := backup.Restore(context.Background(), store, bytes.NewReader(archive), s.key, backup.ConflictFail)
+	s.Nil(err)
+	s.Equal(2, len(trailer.Tables))
+	s.Equal(backup.TableReport{Unchanged: 1}, *report["tenants"])
+	s.Equal(backup.TableReport{Restored: 1}, *report["users"])
+	s.Equal(s.store.tables, store.tables)
+}
+
+func (s *_Suite) TestLargeArchive() {
+	// rows beyond one chunk of the stream
+	long := strings.Repeat("x", 1000)
+	for i := 0; i < 500; i++ {
+		id := fmt.Sprintf("user_%04d", i)
+		s.store.tables["users"][id] = json.RawMessage(`{"id":"` + id + `","fullname":"` + long + `"}`)
+	}
+
+	var buf bytes.Buffer
+	_, err := backup.Backup(context.Background(), s.store, &buf, s.key)
+	s.Nil(err)
+
+	store := newMemStore()
+	_, _, err = backup.Restore(context.Background(), store, &buf, s.key, backup.ConflictFail)
+	s.Nil(err)
+	s.Equal(s.store.tables, store.tables)
+}
+
+func (s *_Suite) TestConflicts() {
+	archive := s.archive()
+	changed := func() *memStore {
+		store := newMemStore()
+		store.tables["users"]["kobe_bryant"] = json.RawMessage(`{"id":"kobe_bryant","fullname":"Mamba"}`)
+		return store
+	}
+
+	store := changed()
+	_, _, err := backup.Restore(context.Background(), store, bytes.NewReader(archive), s.key, backup.ConflictFail)
+	conflict, ok := err.(*backup.ConflictError)
+	s.True(ok)
+	s.Equal("users", conflict.Table)
+	s.Equal(changed().tables, store.tables)
+
+	store = changed()
+	_, report, err := backup.Restore(context.Background(), store, bytes.NewReader(archive), s.key, backup.ConflictSkip)
+	s.Nil(err)
+	s.Equal(1, report["users"].Skipped)
+	s.Equal(changed().tables["users"], store.tables["users"])
+
+	store = changed()
+	_, report, err = backup.Restore(context.Background(), store, bytes.NewReader(archive), s.key, backup.ConflictReplace)
+	s.Nil(err)
+	s.Equal(1, report["users"].Replaced)
+	s.Equal(s.store.tables, store.tables)
+
+	_, _, err = backup.Restore(context.Background(), store, bytes.NewReader(archive), s.key, "merge")
+	s.NotNil(err)
+}
+
+func (s *_Suite) TestInvalidArchives() {
+	archive := s.archive()
+	restore := func(archive []byte, key []byte) error {
+		store := newMemStore()
+		_, _, err := backup.Restore(context.Background(), store, bytes.NewReader(archive), key, backup.ConflictFail)
+		if err != nil {
+			s.Equal(0, len(store.tables["users"]))
+		}
+		return err
+	}
+
+	s.Nil(restore(archive, s.key))
+
+	s.Equal(backup.ErrNotArchive, restore([]byte("not an archive at all"), s.key))
+
+	// another key
+	s.Equal(backup.ErrCorrupted, restore(archive, bytes.Repeat([]byte{8}, backup.KeySize)))
+	s.NotNil(restore(archive, []byte("short")))
+
+	// a flipped bit
+	tampered := append([]byte{}, archive...)
+	tampered[len(tampered)/2] ^= 1
+	s.Equal(backup.ErrCorrupted, restore(tampered, s.key))
+
+	// the header is authenticated
+	tampered = append([]byte{}, archive...)
+	tampered[12] ^= 1
+	s.Equal(backup.ErrCorrupted, restore(tampered, s.key))
+
+	// truncated, or with more after the end
+	s.Equal(backup.ErrCorrupted, restore(archive[:len(archive)-1], s.key))
+	s.Equal(backup.ErrCorrupted, restore(append(append([]byte{}, archive...), 0), s.key))
+
+	// a version from the future
+	future := append([]byte{}, archive...)
+	future[9] = backup.Version + 1
+	err := restore(future, s.key)
+	s.NotNil(err)
+	s.Contains(err.Error(), "version")
+}
+
+func (s *_Suite) TestReader() {
+	// rows are stored compacted, as they are summed
+	var buf bytes.Buffer
+	w, err := backup.NewWriter(&buf, s.key)
+	s.Nil(err)
+	s.Nil(w.Write("users", json.RawMessage(`{"id": "kobe_bryant"}`)))
+	_, err = w.Close()
+	s.Nil(err)
+
+	r, err := backup.NewReader(&buf, s.key)
+	s.Nil(err)
+	table, row, err := r.Next()
+	s.Nil(err)
+	s.Equal("users", table)
+	s.Equal(`{"id":"kobe_bryant"}`, string(row))
+	_, _, err = r.Next()
+	s.Equal(io.EOF, err)
+	s.Equal(1, r.Trailer().Tables["users"].Rows)
+}
+
+func TestBackup(t *testing.T) {
+	suite.Run(t, new(_Suite))
+}
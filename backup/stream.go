@@ -0,0 +1,197 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// magic starts every archive.
+	magic = "UIBACKUP"
+
+	// KeySize is the size of the AES-256 key of the archives.
+	KeySize = 32
+
+	// chunkSize is the plaintext sealed at a time, so an archive of any
+	// size is encrypted and decrypted in constant memory.
+	chunkSize = 64 << 10
+
+	noncePrefixSize = 7
+	headerSize      = len(magic) + 2 + noncePrefixSize
+)
+
+var (
+	// ErrNotArchive is returned for input that is not a backup archive.
+	ErrNotArchive = errors.New("not a backup archive")
+
+	// ErrCorrupted is returned for an archive that fails to authenticate:
+	// corrupted, truncated, tampered with or sealed with another key.
+	ErrCorrupted = errors.New("backup archive corrupted, truncated or of another key")
+)
+
+// An archive is its header, magic, version and a random nonce prefix,
+// followed by chunks of at most chunkSize plaintext, each sealed with
+// AES-256-GCM as a big-endian length and the ciphertext. The nonce of a
+// chunk is the prefix, its index and whether it is the last one, and the
+// header is authenticated with each, so chunks can neither be reordered,
+// dropped nor appended, and the header not altered.
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("backup key must be %v bytes, not %v", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, 0, noncePrefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = append(nonce, byte(index>>24), byte(index>>16), byte(index>>8), byte(index))
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+type sealWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	index  uint32
+}
+
+func newSealWriter(w io.Writer, key []byte) (*sealWriter, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	binary.BigEndian.PutUint16(header[len(magic):], Version)
+	if _, err := rand.Read(header[len(magic)+2:]); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &sealWriter{w: w, aead: aead, header: header, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (sw *sealWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if len(sw.buf) == chunkSize {
+			if err := sw.seal(false); err != nil {
+				return n, err
+			}
+		}
+		m := copy(sw.buf[len(sw.buf):chunkSize], p)
+		sw.buf = sw.buf[:len(sw.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+func (sw *sealWriter) seal(last bool) error {
+	nonce := chunkNonce(sw.header[len(magic)+2:], sw.index, last)
+	sealed := sw.aead.Seal(nil, nonce, sw.buf, sw.header)
+	sw.buf = sw.buf[:0]
+	sw.index++
+
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(sealed)))
+	if _, err := sw.w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := sw.w.Write(sealed)
+	return err
+}
+
+// Close seals the last chunk. It does not close the underlying writer.
+func (sw *sealWriter) Close() error {
+	return sw.seal(true)
+}
+
+type openReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	header []byte
+	buf    *bytes.Reader
+	index  uint32
+	last   bool
+}
+
+func newOpenReader(r io.Reader, key []byte) (*openReader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(magic)]) != magic {
+		return nil, ErrNotArchive
+	}
+	if v := binary.BigEndian.Uint16(header[len(magic):]); v == 0 || v > Version {
+		return nil, fmt.Errorf("backup archive version %v, this build reads up to %v", v, Version)
+	}
+
+	return &openReader{r: r, aead: aead, header: header, buf: bytes.NewReader(nil)}, nil
+}
+
+func (or *openReader) Read(p []byte) (int, error) {
+	for or.buf.Len() == 0 {
+		if or.last {
+			// nothing may follow the last chunk
+			if n, _ := or.r.Read(make([]byte, 1)); n > 0 {
+				return 0, ErrCorrupted
+			}
+			return 0, io.EOF
+		}
+		if err := or.open(); err != nil {
+			return 0, err
+		}
+	}
+	return or.buf.Read(p)
+}
+
+func (or *openReader) open() error {
+	var size [4]byte
+	if _, err := io.ReadFull(or.r, size[:]); err != nil {
+		return ErrCorrupted
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > chunkSize+uint32(or.aead.Overhead()) {
+		return ErrCorrupted
+	}
+	sealed := make([]byte, n)
+	if _, err := io.ReadFull(or.r, sealed); err != nil {
+		return ErrCorrupted
+	}
+
+	// a chunk opens as either the last one or not
+	prefix := or.header[len(magic)+2:]
+	for _, last := range []bool{false, true} {
+		plain, err := or.aead.Open(nil, chunkNonce(prefix, or.index, last), sealed, or.header)
+		if err == nil {
+			or.buf.Reset(plain)
+			or.index++
+			or.last = last
+			return nil
+		}
+	}
+	return ErrCorrupted
+}
@@ -0,0 +1,204 @@
+// Package dump writes users as the rows of a bulk export, in CSV, NDJSON
+// or Parquet. Rows are written as they come, so an export of any size
+// takes constant memory; Parquet holds one row group at a time.
+package dump
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	CSV     = "csv"
+	NDJSON  = "ndjson"
+	Parquet = "parquet"
+
+	ColumnTenant     = "tenant"
+	ColumnAccount    = "account"
+	ColumnFullname   = "fullname"
+	ColumnCreatedAt  = "created_at"
+	ColumnUpdatedAt  = "updated_at"
+	ColumnAttributes = "attributes"
+
+	// parquetRowGroupSize bounds the rows a Parquet export holds in memory.
+	parquetRowGroupSize = 4 << 20
+)
+
+// Columns are the columns a dump may have, in their default order. The
+// password never leaves the service.
+var Columns = []string{
+	ColumnTenant,
+	ColumnAccount,
+	ColumnFullname,
+	ColumnCreatedAt,
+	ColumnUpdatedAt,
+	ColumnAttributes,
+}
+
+// parquetColumns are the Parquet types of the columns. Times are UTC
+// microseconds since the epoch, and attributes their JSON, null for none.
+var parquetColumns = map[string]string{
+	ColumnTenant:     "name=tenant, type=BYTE_ARRAY, convertedtype=UTF8",
+	ColumnAccount:    "name=account, type=BYTE_ARRAY, convertedtype=UTF8",
+	ColumnFullname:   "name=fullname, type=BYTE_ARRAY, convertedtype=UTF8",
+	ColumnCreatedAt:  "name=created_at, type=INT64, convertedtype=TIMESTAMP_MICROS",
+	ColumnUpdatedAt:  "name=updated_at, type=INT64, convertedtype=TIMESTAMP_MICROS",
+	ColumnAttributes: "name=attributes, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL",
+}
+
+// ContentTypes are the media types of the formats.
+var ContentTypes = map[string]string{
+	CSV:     "text/csv",
+	NDJSON:  "application/x-ndjson",
+	Parquet: "application/vnd.apache.parquet",
+}
+
+// ParseColumns parses a comma separated list of columns, all of them when
+// empty, and returns the first unknown one as an error.
+func ParseColumns(list string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return Columns, nil
+	}
+
+	columns := []string{}
+	seen := map[string]bool{}
+	for _, col := range strings.Split(list, ",") {
+		col = strings.TrimSpace(col)
+		if _, ok := parquetColumns[col]; !ok {
+			return nil, fmt.Errorf("unknown column %q", col)
+		}
+		if !seen[col] {
+			seen[col] = true
+			columns = append(columns, col)
+		}
+	}
+	return columns, nil
+}
+
+// Writer writes the rows of a dump. Close completes it, and must be called
+// for the dump to be readable; it does not close the underlying writer.
+type Writer interface {
+	Write(user *pg.User) error
+	Close() error
+}
+
+// NewWriter starts a dump of columns in format to w.
+func NewWriter(w io.Writer, format string, columns []string) (Writer, error) {
+	switch format {
+	case CSV:
+		cw := &csvWriter{w: csv.NewWriter(w), columns: columns}
+		return cw, cw.w.Write(columns)
+	case NDJSON:
+		return &ndjsonWriter{enc: json.NewEncoder(w), columns: columns}, nil
+	case Parquet:
+		md := []string{}
+		for _, col := range columns {
+			md = append(md, parquetColumns[col])
+		}
+		pw, err := writer.NewCSVWriterFromWriter(md, w, 1)
+		if err != nil {
+			return nil, err
+		}
+		pw.RowGroupSize = parquetRowGroupSize
+		pw.CompressionType = parquet.CompressionCodec_SNAPPY
+		return &parquetWriter{w: pw, columns: columns}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// value returns column of user, a time for the times and nil for no
+// attributes.
+func value(user *pg.User, column string) interface{} {
+	switch column {
+	case ColumnTenant:
+		return user.Tenant
+	case ColumnAccount:
+		return user.Acct
+	case ColumnFullname:
+		return user.Fullname
+	case ColumnCreatedAt:
+		return user.Created_at.UTC()
+	case ColumnUpdatedAt:
+		return user.Updated_at.UTC()
+	case ColumnAttributes:
+		if len(user.Attributes) == 0 {
+			return nil
+		}
+		return json.RawMessage(user.Attributes)
+	}
+	return nil
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	columns []string
+}
+
+func (cw *csvWriter) Write(user *pg.User) error {
+	record := make([]string, len(cw.columns))
+	for i, col := range cw.columns {
+		switch v := value(user, col).(type) {
+		case string:
+			record[i] = v
+		case time.Time:
+			record[i] = v.Format(time.RFC3339Nano)
+		case json.RawMessage:
+			record[i] = string(v)
+		}
+	}
+	return cw.w.Write(record)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type ndjsonWriter struct {
+	enc     *json.Encoder
+	columns []string
+}
+
+func (nw *ndjsonWriter) Write(user *pg.User) error {
+	row := make(map[string]interface{}, len(nw.columns))
+	for _, col := range nw.columns {
+		row[col] = value(user, col)
+	}
+	return nw.enc.Encode(row)
+}
+
+func (nw *ndjsonWriter) Close() error {
+	return nil
+}
+
+type parquetWriter struct {
+	w       *writer.CSVWriter
+	columns []string
+}
+
+func (pw *parquetWriter) Write(user *pg.User) error {
+	row := make([]interface{}, len(pw.columns))
+	for i, col := range pw.columns {
+		switch v := value(user, col).(type) {
+		case time.Time:
+			row[i] = v.UnixNano() / int64(time.Microsecond)
+		case json.RawMessage:
+			row[i] = string(v)
+		default:
+			row[i] = v
+		}
+	}
+	return pw.w.Write(row)
+}
+
+func (pw *parquetWriter) Close() error {
+	return pw.w.WriteStop()
+}
@@ -0,0 +1,104 @@
+package dump_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/dontang97/ui/dump"
+	"github.com/dontang97/ui/pg"
+	"github.com/stretchr/testify/suite"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+type _Suite struct {
+	suite.Suite
+
+	users []pg.User
+}
+
+func (s *_Suite) SetupTest() {
+	at := time.Date(2021, 3, 1, 12, 0, 0, 123456000, time.UTC)
+	s.users = []pg.User{
+		{Tenant: pg.DefaultTenant, Acct: "kobe_bryant", Pwd: "123456789", Fullname: "Kobe, \"Black Mamba\"", Created_at: at, Updated_at: at, Attributes: pg.JSONB(`{"team": "lakers"}`)},
+		{Tenant: pg.DefaultTenant, Acct: "lebron_james", Pwd: "123456789", Fullname: "LeBron James", Created_at: at, Updated_at: at.Add(time.Hour)},
+	}
+}
+
+func (s *_Suite) dump(format string, columns []string) []byte {
+	var buf bytes.Buffer
+	w, err := dump.NewWriter(&buf, format, columns)
+	s.Nil(err)
+	for i := range s.users {
+		s.Nil(w.Write(&s.users[i]))
+	}
+	s.Nil(w.Close())
+	return buf.Bytes()
+}
+
+func (s *_Suite) TestParseColumns() {
+	columns, err := dump.ParseColumns("")
+	s.Nil(err)
+	s.Equal(dump.Columns, columns)
+
+	columns, err = dump.ParseColumns("account, created_at,account")
+	s.Nil(err)
+	s.Equal([]string{"account", "created_at"}, columns)
+
+	_, err = dump.ParseColumns("account,password")
+	s.NotNil(err)
+}
+
+func (s *_Suite) TestCSV() {
+	s.Equal(`account,fullname,created_at,attributes
+kobe_bryant,"Kobe, ""Black Mamba""",2021-03-01T12:00:00.123456Z,"{""team"": ""lakers""}"
+lebron_james,LeBron James,2021-03-01T12:00:00.123456Z,
+`, string(s.dump(dump.CSV, []string{"account", "fullname", "created_at", "attributes"})))
+}
+
+func (s *_Suite) TestNDJSON() {
+	lines := bytes.Split(bytes.TrimSpace(s.dump(dump.NDJSON, dump.Columns)), []byte("\n"))
+	s.Equal(2, len(lines))
+	s.JSONEq(`{
+		"tenant": "default",
+		"account": "kobe_bryant",
+		"fullname": "Kobe, \"Black Mamba\"",
+		"created_at": "2021-03-01T12:00:00.123456Z",
+		"updated_at": "2021-03-01T12:00:00.123456Z",
+		"attributes": {"team": "lakers"}
+	}`, string(lines[0]))
+
+	row := map[string]interface{}{}
+	s.Nil(json.Unmarshal(lines[1], &row))
+	s.Nil(row["attributes"])
+	s.NotContains(row, "password")
+}
+
+func (s *_Suite) TestParquet() {
+	type user struct {
+		Account    string  `parquet:"name=account, type=BYTE_ARRAY, convertedtype=UTF8"`
+		UpdatedAt  int64   `parquet:"name=updated_at, type=INT64, convertedtype=TIMESTAMP_MICROS"`
+		Attributes *string `parquet:"name=attributes, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	}
+
+	f, err := buffer.NewBufferFile(s.dump(dump.Parquet, []string{"account", "updated_at", "attributes"}))
+	s.Nil(err)
+	pr, err := reader.NewParquetReader(f, new(user), 1)
+	s.Nil(err)
+	defer pr.ReadStop()
+	s.Equal(int64(2), pr.GetNumRows())
+
+	users := make([]user, 2)
+	s.Nil(pr.Read(&users))
+	s.Equal("kobe_bryant", users[0].Account)
+	s.Equal(`{"team": "lakers"}`, *users[0].Attributes)
+	s.Equal("lebron_james", users[1].Account)
+	s.Equal(s.users[1].Updated_at.UnixNano()/1000, users[1].UpdatedAt)
+	s.Nil(users[1].Attributes)
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(_Suite))
+}
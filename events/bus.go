@@ -0,0 +1,150 @@
+// Package events fans the user lifecycle events out to the live streams of
+// GET /ui/v1/events. The bus is fed by LISTEN/NOTIFY on Postgres, so every
+// instance streams the events of all, or as an outbox sink where LISTEN is
+// unavailable, so an instance streams the events its relay delivers. It
+// remembers the latest events so a stream that reconnects resumes where it
+// left off.
+package events
+
+import (
+	"context"
+	"sync"
+
+	"github.com/dontang97/ui/outbox"
+)
+
+const (
+	// Channel is the Postgres channel the user events are announced on.
+	Channel = "user_events"
+
+	// DefaultBacklog is how many of the latest events the bus remembers.
+	DefaultBacklog = 1024
+
+	// subscriptionBuffer is how many events a stream may fall behind before
+	// it is closed.
+	subscriptionBuffer = 64
+)
+
+// Streamed are the event types sent to the streams.
+var Streamed = map[string]bool{
+	outbox.UserCreated: true,
+	outbox.UserUpdated: true,
+	outbox.UserDeleted: true,
+}
+
+// Bus broadcasts events to its subscriptions in the order they are
+// published. It is safe for concurrent use.
+type Bus struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]bool
+	recent []*outbox.Envelope
+	size   int
+}
+
+func NewBus(backlog int) *Bus {
+	return &Bus{
+		subs: map[*Subscription]bool{},
+		size: backlog,
+	}
+}
+
+// Subscription receives the events published after it was made. C is
+// closed when the subscriber falls too far behind or the bus loses track
+// of the events, and the subscriber has to resume from its last event.
+type Subscription struct {
+	C <-chan *outbox.Envelope
+
+	bus    *Bus
+	c      chan *outbox.Envelope
+	closed bool
+}
+
+// Close unsubscribes s.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.drop(s)
+}
+
+// Publish sends env to every subscription and remembers it.
+func (b *Bus) Publish(env *outbox.Envelope) {
+	if !Streamed[env.Type] {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.size > 0 {
+		if len(b.recent) == b.size {
+			b.recent = b.recent[1:]
+		}
+		b.recent = append(b.recent, env)
+	}
+
+	for s := range b.subs {
+		select {
+		case s.c <- env:
+		default:
+			b.drop(s)
+		}
+	}
+}
+
+// Deliver makes the bus an outbox.Sink.
+func (b *Bus) Deliver(_ context.Context, env *outbox.Envelope) error {
+	b.Publish(env)
+	return nil
+}
+
+// Reset forgets the remembered events and closes every subscription, for
+// when events may have been missed.
+func (b *Bus) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.recent = nil
+	for s := range b.subs {
+		b.drop(s)
+	}
+}
+
+// Subscribe subscribes to the events published from now on. With after
+// non-zero it also returns the remembered events published after the one
+// of ID after, and ok is false when that event is no longer remembered.
+func (b *Bus) Subscribe(after int64) (s *Subscription, backlog []*outbox.Envelope, ok bool) {
+	c := make(chan *outbox.Envelope, subscriptionBuffer)
+	s = &Subscription{C: c, bus: b, c: c}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[s] = true
+
+	if after == 0 {
+		return s, nil, true
+	}
+	for i, env := range b.recent {
+		if env.ID == after {
+			backlog = append(backlog, b.recent[i+1:]...)
+			return s, backlog, true
+		}
+	}
+	return s, nil, false
+}
+
+// Subscribers returns the number of subscriptions.
+func (b *Bus) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// drop must be called with b.mu held.
+func (b *Bus) drop(s *Subscription) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(b.subs, s)
+	close(s.c)
+}
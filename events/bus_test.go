@@ -0,0 +1,130 @@
+package events_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dontang97/ui/events"
+	"github.com/dontang97/ui/outbox"
+	"github.com/stretchr/testify/suite"
+)
+
+type _Suite struct {
+	suite.Suite
+}
+
+func (s *_Suite) SetupTest() {
+}
+
+func (s *_Suite) TearDownTest() {
+}
+
+func envelope(id int64, typ string) *outbox.Envelope {
+	return &outbox.Envelope{ID: id, Type: typ, Account: "kobe_bryant"}
+}
+
+func ids(envs []*outbox.Envelope) []int64 {
+	list := []int64{}
+	for _, env := range envs {
+		list = append(list, env.ID)
+	}
+	return list
+}
+
+func (s *_Suite) TestPublish() {
+	bus := events.NewBus(8)
+	a, _, _ := bus.Subscribe(0)
+	b, _, _ := bus.Subscribe(0)
+	s.Equal(2, bus.Subscribers())
+
+	bus.Publish(envelope(1, outbox.UserCreated))
+	// logins are not streamed
+	bus.Publish(envelope(2, outbox.UserLoggedIn))
+	bus.Publish(envelope(3, outbox.UserDeleted))
+
+	for _, sub := range []*events.Subscription{a, b} {
+		s.Equal(int64(1), (<-sub.C).ID)
+		s.Equal(int64(3), (<-sub.C).ID)
+	}
+
+	a.Close()
+	a.Close()
+	s.Equal(1, bus.Subscribers())
+	_, ok := <-a.C
+	s.Equal(false, ok)
+	b.Close()
+}
+
+func (s *_Suite) TestDeliver() {
+	bus := events.NewBus(8)
+	var sink outbox.Sink = bus
+	sub, _, _ := bus.Subscribe(0)
+
+	s.Nil(sink.Deliver(context.Background(), envelope(1, outbox.UserLoggedIn)))
+	s.Nil(sink.Deliver(context.Background(), envelope(2, outbox.UserUpdated)))
+	s.Equal(int64(2), (<-sub.C).ID)
+
+	_, backlog, ok := bus.Subscribe(2)
+	s.Equal(true, ok)
+	s.Equal(0, len(backlog))
+	sub.Close()
+}
+
+func (s *_Suite) TestResume() {
+	bus := events.NewBus(3)
+	// in the order of commit rather than of ID
+	for _, id := range []int64{1, 2, 4, 3, 5} {
+		bus.Publish(envelope(id, outbox.UserUpdated))
+	}
+
+	sub, backlog, ok := bus.Subscribe(4)
+	s.Equal(true, ok)
+	s.Equal([]int64{3, 5}, ids(backlog))
+	sub.Close()
+
+	sub, backlog, ok = bus.Subscribe(5)
+	s.Equal(true, ok)
+	s.Equal([]int64{}, ids(backlog))
+	sub.Close()
+
+	// forgotten already
+	sub, backlog, ok = bus.Subscribe(2)
+	s.Equal(false, ok)
+	s.Equal(0, len(backlog))
+	sub.Close()
+
+	bus.Reset()
+	sub, _, ok = bus.Subscribe(5)
+	s.Equal(false, ok)
+	sub.Close()
+}
+
+func (s *_Suite) TestSlowSubscriber() {
+	bus := events.NewBus(0)
+	sub, _, _ := bus.Subscribe(0)
+
+	for id := int64(1); id <= 100; id++ {
+		bus.Publish(envelope(id, outbox.UserUpdated))
+	}
+	s.Equal(0, bus.Subscribers())
+
+	n := 0
+	for range sub.C {
+		n++
+	}
+	s.Equal(64, n)
+	sub.Close()
+}
+
+func (s *_Suite) TestReset() {
+	bus := events.NewBus(8)
+	sub, _, _ := bus.Subscribe(0)
+	bus.Reset()
+	_, ok := <-sub.C
+	s.Equal(false, ok)
+	s.Equal(0, bus.Subscribers())
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(_Suite))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dontang97/ui/audit"
+	"github.com/dontang97/ui/secret"
+)
+
+// auditVerify checks an exported audit bundle and prints the report.
+//
+//	ui audit-verify [-jwt-key-folder dir] bundle.ndjson
+func auditVerify(args []string) int {
+	fs := flag.NewFlagSet("audit-verify", flag.ExitOnError)
+	keyDir := fs.String("jwt-key-folder", "./secret", "the folder of the RSA public key that signed the checkpoints")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: ui audit-verify [-jwt-key-folder dir] bundle.ndjson")
+		return 2
+	}
+
+	pub, err := secret.ReadPublicKey(*keyDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer f.Close()
+
+	report, err := audit.Verify(f, pub)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
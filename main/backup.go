@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dontang97/ui/backup"
+	"github.com/dontang97/ui/secret"
+	"github.com/dontang97/ui/ui"
+)
+
+// userBackup writes the user store, on Postgres, to an archive sealed with
+// the backup key of the secret folder, and prints its trailer.
+//
+//	ui backup [-db-host host] [-key-folder ./secret] [-o users.backup]
+func userBackup(args []string) int {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	DBHost := fs.String("db-host", "db", "the database host")
+	DBPort := fs.Int("db-port", 5432, "the database port")
+	keyDir := fs.String("key-folder", "./secret", "the folder of the backup key, ui_backup.key")
+	out := fs.String("o", "", "the archive to write, stdout when empty")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: ui backup [flags]")
+		return 2
+	}
+
+	key, err := secret.ReadBackupKey(*keyDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+
+	_ui := ui.New()
+	_ui.Connect(*DBHost, *DBPort)
+	defer _ui.Disconnect()
+
+	trailer, err := backup.Backup(context.Background(), _ui, bw, key)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "backup failed:", err)
+		return 1
+	}
+
+	enc := json.NewEncoder(os.Stderr)
+	enc.SetIndent("", "  ")
+	enc.Encode(trailer)
+	return 0
+}
+
+// userRestore restores an archive of ui backup to Postgres, all of it or
+// nothing, and prints what it did with the rows of each table.
+//
+//	ui restore [-db-host host] [-key-folder ./secret] [-on-conflict fail|skip|replace] users.backup
+func userRestore(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	DBHost := fs.String("db-host", "db", "the database host")
+	DBPort := fs.Int("db-port", 5432, "the database port")
+	keyDir := fs.String("key-folder", "./secret", "the folder of the backup key, ui_backup.key")
+	onConflict := fs.String("on-conflict", backup.ConflictFail, "what to do with the rows whose key is taken by a different row: fail, skip or replace")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: ui restore [flags] users.backup|-")
+		return 2
+	}
+	if !backup.ValidConflict(*onConflict) {
+		fmt.Fprintf(os.Stderr, "invalid -on-conflict %q\n", *onConflict)
+		return 2
+	}
+
+	key, err := secret.ReadBackupKey(*keyDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var r io.Reader = os.Stdin
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		defer f.Close()
+		r = f
+	}
+
+	_ui := ui.New()
+	_ui.Connect(*DBHost, *DBPort)
+	defer _ui.Disconnect()
+
+	trailer, report, err := backup.Restore(context.Background(), _ui, r, key, *onConflict)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "restore failed, nothing restored:", err)
+		return 1
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(map[string]interface{}{"created_at": trailer.CreatedAt, "tables": report})
+	return 0
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dontang97/ui/dump"
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/ui"
+)
+
+// userExport dumps the users of a tenant, as GET /ui/v1/users/export does,
+// to a file or stdout.
+//
+//	ui export [-db-host host] [-tenant id] [-format csv|ndjson|parquet] [-columns list] [-gzip] [-o users.csv]
+func userExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	DBHost := fs.String("db-host", "db", "the database host")
+	DBPort := fs.Int("db-port", 5432, "the database port")
+	tenant := fs.String("tenant", pg.DefaultTenant, "the tenant whose users are exported")
+	format := fs.String("format", dump.CSV, "csv, ndjson or parquet")
+	columns := fs.String("columns", "", "comma separated columns of the rows, all of them when empty")
+	compress := fs.Bool("gzip", false, "gzip the export")
+	out := fs.String("o", "", "the file to write, stdout when empty")
+	keyDir := fs.String("key-folder", "", "the folder of the master key, ui_master.key, of a database with field encryption on")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: ui export [flags]")
+		return 2
+	}
+
+	if _, ok := dump.ContentTypes[*format]; !ok {
+		fmt.Fprintf(os.Stderr, "unknown format %q, use csv, ndjson or parquet\n", *format)
+		return 2
+	}
+	cols, err := dump.ParseColumns(*columns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+
+	_ui := ui.New()
+	_ui.Connect(*DBHost, *DBPort)
+	defer _ui.Disconnect()
+
+	if *keyDir != "" {
+		if _, err := loadFieldKeys(_ui, *keyDir); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	n, err := _ui.Dump(ui.WithTenant(context.Background(), *tenant), bw, &ui.DumpOptions{
+		Format:  *format,
+		Columns: cols,
+		Gzip:    *compress,
+	})
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export failed after %v users: %v\n", n, err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %v users\n", n)
+	return 0
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/schema"
+	"github.com/dontang97/ui/ui"
+)
+
+// userImport signs up the users of a CSV or JSONL file, as POST
+// /ui/v1/users/import does, and prints the report.
+//
+//	ui import [-db-host host] [-tenant id] [-on-conflict fail|skip|update] [-dry-run] users.csv
+func userImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	DBHost := fs.String("db-host", "db", "the database host")
+	DBPort := fs.Int("db-port", 5432, "the database port")
+	tenant := fs.String("tenant", pg.DefaultTenant, "the tenant the users sign up in")
+	format := fs.String("format", "", "csv or jsonl, by the extension of the file when empty")
+	onConflict := fs.String("on-conflict", ui.ImportConflictFail, "what to do with the rows of existing accounts: fail, skip or update")
+	dryRun := fs.Bool("dry-run", false, "report what the import would do without changing anything")
+	batchSize := fs.Int("batch-size", ui.DefaultImportBatchSize, "the rows imported per transaction")
+	execTimeout := fs.Duration("db-exec-timeout", ui.DefaultExecTimeout, "the deadline of the transaction of each batch")
+	attributesSchema := fs.String("attributes-schema", "", "the JSON Schema file the profile attributes of users are validated against, none accepted without it")
+	keyDir := fs.String("key-folder", "", "the folder of the master key, ui_master.key, of a database with field encryption on")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: ui import [flags] users.csv|users.jsonl")
+		return 2
+	}
+
+	if *format == "" {
+		switch filepath.Ext(fs.Arg(0)) {
+		case ".csv":
+			*format = ui.ImportCSV
+		case ".jsonl", ".ndjson":
+			*format = ui.ImportJSONL
+		}
+	}
+	if *format != ui.ImportCSV && *format != ui.ImportJSONL {
+		fmt.Fprintln(os.Stderr, "unknown format, use -format csv or -format jsonl")
+		return 2
+	}
+	if !ui.ValidImportConflict(*onConflict) {
+		fmt.Fprintf(os.Stderr, "invalid -on-conflict %q\n", *onConflict)
+		return 2
+	}
+	if *batchSize <= 0 || *batchSize > ui.MaxImportBatchSize {
+		fmt.Fprintf(os.Stderr, "-batch-size must be within 1 and %v\n", ui.MaxImportBatchSize)
+		return 2
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer f.Close()
+
+	_ui := ui.New()
+	_ui.ExecTimeout = *execTimeout
+	if *attributesSchema != "" {
+		sch, err := schema.Load(*attributesSchema)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		_ui.AttributeSchema = sch
+	}
+	_ui.Connect(*DBHost, *DBPort)
+	defer _ui.Disconnect()
+
+	if *keyDir != "" {
+		if _, err := loadFieldKeys(_ui, *keyDir); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	// the audit events of the accounts name the import, run by no account
+	base := pg.AuditEvent{RequestID: "import-" + strconv.FormatInt(time.Now().Unix(), 10)}
+	report, err := _ui.Import(ui.WithTenant(context.Background(), *tenant), f, &ui.ImportOptions{
+		Format:     *format,
+		OnConflict: *onConflict,
+		DryRun:     *dryRun,
+		BatchSize:  *batchSize,
+	}, base)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
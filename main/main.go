@@ -2,35 +2,217 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"flag"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/dontang97/ui/events"
+	"github.com/dontang97/ui/outbox"
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/pii"
 	"github.com/dontang97/ui/router"
+	"github.com/dontang97/ui/schema"
 	"github.com/dontang97/ui/secret"
 	"github.com/dontang97/ui/ui"
+	"github.com/dontang97/ui/webhook"
 )
 
 func main() {
+	// no credential or token reaches the logs, whatever an error quotes
+	log.SetOutput(ui.RedactWriter(os.Stderr))
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "audit-verify":
+			os.Exit(auditVerify(os.Args[2:]))
+		case "import":
+			os.Exit(userImport(os.Args[2:]))
+		case "export":
+			os.Exit(userExport(os.Args[2:]))
+		case "backup":
+			os.Exit(userBackup(os.Args[2:]))
+		case "restore":
+			os.Exit(userRestore(os.Args[2:]))
+		case "rotate-key":
+			os.Exit(rotateKey(os.Args[2:]))
+		}
+	}
+
 	var wait time.Duration
 	flag.DurationVar(&wait, "graceful-timeout", time.Second*15, "the duration for which the server gracefully wait for existing connections to finish - e.g. 15s or 1m")
 
 	keyDir := flag.String("jwt-key-folder", "./secret", "the folder of RSA key pair used to generate JWT")
 	DBHost := flag.String("db-host", "db", "the database host")
 	DBPort := flag.Int("db-port", 5432, "the database port")
+	replicas := flag.String("db-replicas", "", "comma separated host:port of read replicas - e.g. db-replica-1:5432,db-replica-2:5432")
+	replicaCheck := flag.Duration("db-replica-check-interval", 5*time.Second, "how often the read replicas are health checked")
+	maxReplicaLag := flag.Duration("db-max-replica-lag", pg.DefaultMaxReplicaLag, "the replay lag beyond which a replica stops serving reads")
+	maxOpenConns := flag.Int("db-max-open-conns", 0, "the maximum number of open connections to each database, 0 for no limit")
+	maxIdleConns := flag.Int("db-max-idle-conns", 0, "the maximum number of idle connections to each database, 0 for the default of 2")
+	connMaxLifetime := flag.Duration("db-conn-max-lifetime", 0, "how long a connection may be reused, 0 for ever")
+	connMaxIdleTime := flag.Duration("db-conn-max-idle-time", 0, "how long a connection may stay idle, 0 for ever")
+	poolSaturation := flag.Duration("db-pool-saturation-warning", 30*time.Second, "how long a connection pool may stay saturated before a warning is logged")
+	readYourWrites := flag.Duration("read-your-writes-window", pg.DefaultReadYourWritesWindow, "how long the reads of a written account stay on the primary")
+	queryTimeout := flag.Duration("db-query-timeout", ui.DefaultQueryTimeout, "the deadline of each database read - e.g. 5s")
+	execTimeout := flag.Duration("db-exec-timeout", ui.DefaultExecTimeout, "the deadline of each database write - e.g. 10s")
+	admins := flag.String("admin-accounts", "", "comma separated accounts granted the admin role of their tenant at login, as tenant/account or account of the default tenant; no signup or rename takes these names, and only the holders of the role update, delete, erase or import them")
+	superAdmins := flag.String("super-admin-accounts", "", "comma separated accounts granted the super admin role across tenants at login, as tenant/account or account of the default tenant; no signup or rename takes these names, and only the holders of the role update, delete, erase or import them")
+	rowSecurity := flag.Bool("db-row-security", false, "confine the transactions of a request to its tenant with the row level security policies as well")
+	groupsClaim := flag.Bool("jwt-groups-claim", false, "name the groups of the account in the JWT of a login")
+	attributesSchema := flag.String("attributes-schema", "", "the JSON Schema file the profile attributes of users are validated against, none accepted without it")
+	requireIfMatch := flag.Bool("require-if-match", false, "reject user updates and deletes without an If-Match header")
+	reservedAccounts := flag.String("reserved-accounts", strings.Join(ui.DefaultReservedAccounts, ","), "comma separated names no account is signed up with or renamed to, but for case, underscores, trailing digits and look-alike characters")
+	aliasGracePeriod := flag.Duration("alias-grace-period", ui.DefaultAliasGracePeriod, "how long the former name of a renamed account stays reserved for it and redirects to it, at least as long as a JWT lives")
+	outboxFile := flag.String("outbox-file", "", "append the user lifecycle events to this NDJSON file")
+	outboxStdout := flag.Bool("outbox-stdout", false, "write the user lifecycle events to the standard output")
+	outboxURLs := flag.String("outbox-http", "", "comma separated URLs the user lifecycle events are POSTed to")
+	outboxMaxAttempts := flag.Int("outbox-max-attempts", outbox.DefaultMaxAttempts, "the delivery attempts before an event is set aside as dead")
+	webhookMaxAttempts := flag.Int("webhook-max-attempts", webhook.DefaultMaxAttempts, "the delivery attempts before a webhook delivery is given up")
+	eventsKeepAlive := flag.Duration("events-keepalive", ui.DefaultEventKeepAlive, "how often an idle event stream sends a keepalive comment")
+	eventsListen := flag.Bool("events-listen", true, "feed the event streams by LISTEN/NOTIFY, so every instance streams the events of all; without it, as behind a pooler in transaction mode, the streams of an instance carry the events its outbox relay delivers")
+	eventsBacklog := flag.Int("events-backlog", events.DefaultBacklog, "how many of the latest user events a reconnecting stream resumes from without the database")
+	checkpointInterval := flag.Duration("audit-checkpoint-interval", 10*time.Minute, "how often the head of the audit chain is signed, 0 to disable")
+	piiEncryption := flag.Bool("pii-encryption", false, "seal the fullnames of users at rest with data keys wrapped by the master key ui_master.key of the JWT key folder; the user search then matches whole fullnames only, in the exact and caseless modes, by their blind index, and the audit log and the user events carry no fullname")
+	reencryptInterval := flag.Duration("pii-reencrypt-interval", time.Minute, "how often the data keys are reloaded and the users not sealed with the latest one re-encrypted")
 
 	flag.Parse()
 
 	secret.InitSecretKey(*keyDir)
 
 	_ui := ui.New()
+	_ui.QueryTimeout = *queryTimeout
+	_ui.ExecTimeout = *execTimeout
+	_ui.RequireIfMatch = *requireIfMatch
+	_ui.AliasGracePeriod = *aliasGracePeriod
+	_ui.ReservedAccounts = nil
+	for _, name := range strings.Split(*reservedAccounts, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			_ui.ReservedAccounts = append(_ui.ReservedAccounts, name)
+		}
+	}
+	_ui.GroupsClaim = *groupsClaim
+	_ui.RowSecurity = *rowSecurity
+	for _, grant := range []struct {
+		list  string
+		accts map[string]bool
+	}{
+		{*admins, _ui.Admins},
+		{*superAdmins, _ui.SuperAdmins},
+	} {
+		for _, acct := range strings.Split(grant.list, ",") {
+			if acct = strings.TrimSpace(acct); acct != "" {
+				grant.accts[acct] = true
+			}
+		}
+	}
+	if *attributesSchema != "" {
+		sch, err := schema.Load(*attributesSchema)
+		if err != nil {
+			log.Fatal(err)
+		}
+		_ui.AttributeSchema = sch
+	}
+	_ui.EventBus = events.NewBus(*eventsBacklog)
+	_ui.EventKeepAlive = *eventsKeepAlive
+	_ui.MaxReplicaLag = *maxReplicaLag
+	_ui.ReadYourWritesWindow = *readYourWrites
+	_ui.SetPool(pg.PoolConfig{
+		MaxOpenConns:    *maxOpenConns,
+		MaxIdleConns:    *maxIdleConns,
+		ConnMaxLifetime: *connMaxLifetime,
+		ConnMaxIdleTime: *connMaxIdleTime,
+	})
 	_ui.Connect(*DBHost, *DBPort)
 	defer _ui.Disconnect()
 
+	var master *pii.Master
+	if *piiEncryption {
+		var err error
+		if master, err = loadFieldKeys(_ui, *keyDir); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	expvar.Publish("db_pools", expvar.Func(func() interface{} {
+		return _ui.PoolStats()
+	}))
+
+	bg, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	for _, addr := range strings.Split(*replicas, ",") {
+		if addr = strings.TrimSpace(addr); addr == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			log.Fatal(fmt.Errorf("invalid replica port %q", port))
+		}
+		if err := _ui.ConnectReplica(host, p); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *replicas != "" {
+		go _ui.MonitorReplicas(bg, *replicaCheck)
+	}
+	go _ui.MonitorPool(bg, time.Second, *poolSaturation)
+
+	// the webhooks are a sink of their own, subscribed to through the API
+	sinks := outbox.Multi{&webhook.Fanout{PG: &_ui.PG}}
+	if *outboxFile != "" {
+		f, err := outbox.NewFileSink(*outboxFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		sinks = append(sinks, f)
+	}
+	if *outboxStdout {
+		sinks = append(sinks, outbox.NewStdoutSink())
+	}
+	for _, url := range strings.Split(*outboxURLs, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			sinks = append(sinks, &outbox.HTTPSink{URL: url, Client: &http.Client{Timeout: outbox.DefaultDeliveryTimeout}})
+		}
+	}
+	if !*eventsListen {
+		sinks = append(sinks, _ui.EventBus)
+	}
+	relay := &outbox.Relay{PG: &_ui.PG, Sink: sinks, MaxAttempts: *outboxMaxAttempts}
+	go relay.Run(bg)
+
+	dispatcher := &webhook.Dispatcher{PG: &_ui.PG, Client: &http.Client{Timeout: webhook.DefaultTimeout}, MaxAttempts: *webhookMaxAttempts}
+	go dispatcher.Run(bg)
+	if *eventsListen {
+		go func() {
+			if err := _ui.ListenEvents(bg); err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+	if *checkpointInterval > 0 {
+		go _ui.RunAuditCheckpoints(bg, *checkpointInterval)
+	}
+	if master != nil {
+		go _ui.RunReencryption(bg, master, *reencryptInterval)
+	}
+	go _ui.KeyFullnames(bg)
+
 	srv := router.Route(_ui)
+	// the event streams end on shutdown and the clients reconnect elsewhere
+	srv.RegisterOnShutdown(_ui.EventBus.Reset)
 	go func() {
 		fmt.Println("Start ui server...")
 		if err := srv.ListenAndServe(); err != nil {
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dontang97/ui/pii"
+	"github.com/dontang97/ui/secret"
+	"github.com/dontang97/ui/ui"
+)
+
+// loadFieldKeys turns the field encryption of _ui on with the master key in
+// keyDir, and returns the master.
+func loadFieldKeys(_ui *ui.UI, keyDir string) (*pii.Master, error) {
+	master, err := readMaster(keyDir)
+	if err != nil {
+		return nil, err
+	}
+	return master, _ui.LoadKeys(context.Background(), master)
+}
+
+// rotateKey makes a new data key, which the running instances take up and
+// re-encrypt the users with on their next reload. With -old-key-folder it
+// rotates the master key instead: the keys wrapped by the master key there
+// are wrapped by the one of -key-folder, and the instances must restart
+// with it.
+//
+//	ui rotate-key [-db-host host] [-key-folder ./secret] [-old-key-folder dir]
+func rotateKey(args []string) int {
+	fs := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	DBHost := fs.String("db-host", "db", "the database host")
+	DBPort := fs.Int("db-port", 5432, "the database port")
+	keyDir := fs.String("key-folder", "./secret", "the folder of the master key, ui_master.key")
+	oldKeyDir := fs.String("old-key-folder", "", "the folder of the master key to rotate away from")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: ui rotate-key [flags]")
+		return 2
+	}
+
+	master, err := readMaster(*keyDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	_ui := ui.New()
+	_ui.Connect(*DBHost, *DBPort)
+	defer _ui.Disconnect()
+
+	if *oldKeyDir != "" {
+		old, err := readMaster(*oldKeyDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		n, err := _ui.RewrapKeys(context.Background(), old, master)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "rotation failed:", err)
+			return 1
+		}
+		fmt.Printf("rewrapped %v keys from master key %v to %v\n", n, old.ID(), master.ID())
+		return 0
+	}
+
+	key, err := _ui.RotateKey(context.Background(), master)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "rotation failed:", err)
+		return 1
+	}
+	fmt.Printf("data key %v is active\n", key.ID)
+	return 0
+}
+
+func readMaster(keyDir string) (*pii.Master, error) {
+	key, err := secret.ReadMasterKey(keyDir)
+	if err != nil {
+		return nil, err
+	}
+	return pii.NewMaster(key)
+}
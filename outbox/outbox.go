@@ -0,0 +1,67 @@
+// Package outbox delivers the user lifecycle events to downstream systems.
+// Messages are written to the outbox table in the transaction of the
+// mutation they announce, and a relay hands them to sinks afterwards:
+// at least once, in order for each account, and set aside as dead after
+// too many failed attempts.
+package outbox
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	UserCreated  = "user.created"
+	UserUpdated  = "user.updated"
+	UserDeleted  = "user.deleted"
+	UserLoggedIn = "user.login"
+	UserErased   = "user.erased"
+	UserRenamed  = "user.renamed"
+)
+
+// Envelope is what sinks receive of a message. ID is unique and stable
+// across redeliveries, so consumers can drop duplicates with it.
+type Envelope struct {
+	ID         int64     `json:"id"`
+	Type       string    `json:"type"`
+	Tenant     string    `json:"tenant"`
+	Account    string    `json:"account"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       pg.JSONB  `json:"data"`
+}
+
+// NewEnvelope returns the envelope of msg.
+func NewEnvelope(msg *pg.OutboxMessage) *Envelope {
+	return &Envelope{
+		ID:         msg.ID,
+		Type:       msg.Type,
+		Tenant:     msg.Tenant,
+		Account:    msg.Account,
+		OccurredAt: msg.CreatedAt,
+		Data:       msg.Payload,
+	}
+}
+
+// Enqueue writes a message of type about account of tenant with data as its
+// payload. tx must be the transaction of the mutation.
+func Enqueue(tx *gorm.DB, typ, tenant, account string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	msg := &pg.OutboxMessage{
+		Tenant:        tenant,
+		Account:       account,
+		Type:          typ,
+		Payload:       payload,
+		CreatedAt:     now,
+		Status:        pg.OutboxPending,
+		NextAttemptAt: now,
+	}
+	return tx.Table(pg.TableOutbox.String()).Create(msg).Error
+}
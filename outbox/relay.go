@@ -0,0 +1,216 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	DefaultBatchSize       = 100
+	DefaultMaxAttempts     = 10
+	DefaultInterval        = time.Second
+	DefaultDeliveryTimeout = time.Second * 10
+
+	maxBackoff  = time.Minute * 10
+	maxErrorLen = 1000
+)
+
+// Backoff is the delay before the next attempt after attempts failures.
+func Backoff(attempts int) time.Duration {
+	if attempts > 10 {
+		return maxBackoff
+	}
+	d := time.Second << uint(attempts)
+	if d > maxBackoff {
+		return maxBackoff
+	}
+	return d
+}
+
+// Relay moves the pending messages of the outbox to Sink. Several relays
+// may run against the same database; each message is leased by the one
+// delivering it.
+type Relay struct {
+	PG   *pg.PG
+	Sink Sink
+
+	// Zero values stand for the defaults above.
+	BatchSize       int
+	MaxAttempts     int
+	Interval        time.Duration
+	DeliveryTimeout time.Duration
+}
+
+func orDefault(v, def int) int {
+	if v > 0 {
+		return v
+	}
+	return def
+}
+
+func orDefaultDuration(v, def time.Duration) time.Duration {
+	if v > 0 {
+		return v
+	}
+	return def
+}
+
+// Run relays until ctx is done, polling every Interval while the outbox
+// holds nothing due.
+func (rl *Relay) Run(ctx context.Context) {
+	interval := orDefaultDuration(rl.Interval, DefaultInterval)
+	for {
+		n, err := rl.RelayOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Print(err)
+		}
+		if n > 0 && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// pendingSQL selects the oldest due message of each account, skipping the
+// accounts whose oldest message is being claimed by another relay. A
+// message that is not due yet, claimed ones among them, holds back the
+// later ones of its account; dead messages do not.
+const pendingSQL = `
+SELECT * FROM outbox o
+WHERE o.status = ? AND o.next_attempt_at <= ?
+AND NOT EXISTS (
+	SELECT 1 FROM outbox p
+	WHERE p.tenant = o.tenant AND p.account = o.account AND p.status = ? AND p.id < o.id
+)
+ORDER BY o.id
+LIMIT ?
+FOR UPDATE SKIP LOCKED`
+
+// leaseMargin is added to the time a claimed batch takes to deliver at
+// worst, before its messages are due again.
+const leaseMargin = time.Minute
+
+// RelayOnce delivers one batch and returns the number of messages it
+// attempted. The batch is claimed in a transaction of its own, which counts
+// the attempt and leases the messages until they could all have timed out,
+// and delivered after it commits: no lock or connection is held while a
+// sink is slow. The outcome of each is recorded on its own, unless a relay
+// took the message over once the lease ran out.
+func (rl *Relay) RelayOnce(ctx context.Context) (int, error) {
+	msgs, err := rl.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range msgs {
+		if ctx.Err() != nil {
+			return i, rl.release(msgs[i:])
+		}
+		if err := rl.deliver(ctx, &msgs[i]); err != nil {
+			return i + 1, err
+		}
+	}
+	return len(msgs), nil
+}
+
+// claim selects a batch of due messages, and leases them with their
+// attempt counted.
+func (rl *Relay) claim(ctx context.Context) ([]pg.OutboxMessage, error) {
+	msgs := []pg.OutboxMessage{}
+	err := rl.PG.Transaction(ctx, func(tx *gorm.DB) error {
+		if res := tx.Raw(pendingSQL,
+			pg.OutboxPending, time.Now().UTC(), pg.OutboxPending,
+			orDefault(rl.BatchSize, DefaultBatchSize)).Scan(&msgs); res.Error != nil {
+			return res.Error
+		}
+		if len(msgs) == 0 {
+			return nil
+		}
+
+		ids := make([]int64, len(msgs))
+		for i := range msgs {
+			ids[i] = msgs[i].ID
+			msgs[i].Attempts++
+		}
+		timeout := orDefaultDuration(rl.DeliveryTimeout, DefaultDeliveryTimeout)
+		lease := time.Now().UTC().Add(time.Duration(len(msgs))*timeout + leaseMargin)
+		return tx.
+			Table(pg.TableOutbox.String()).
+			Where(pg.FieldOutboxID.String()+" IN (?)", ids).
+			Updates(map[string]interface{}{
+				pg.FieldOutboxAttempts.String():      gorm.Expr(pg.FieldOutboxAttempts.String() + " + 1"),
+				pg.FieldOutboxNextAttemptAt.String(): lease,
+			}).Error
+	})
+	return msgs, err
+}
+
+// release gives back the claimed messages left undelivered, due at once and
+// without the attempt.
+func (rl *Relay) release(msgs []pg.OutboxMessage) error {
+	// ctx is done, and the messages are released regardless
+	ctx, cancel := context.WithTimeout(context.Background(), orDefaultDuration(rl.DeliveryTimeout, DefaultDeliveryTimeout))
+	defer cancel()
+
+	for _, msg := range msgs {
+		if err := rl.record(ctx, &msg, map[string]interface{}{
+			pg.FieldOutboxAttempts.String():      msg.Attempts - 1,
+			pg.FieldOutboxNextAttemptAt.String(): time.Now().UTC(),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliver hands msg, claimed, to the sink and records the outcome. Only a
+// failure to record it is returned.
+func (rl *Relay) deliver(ctx context.Context, msg *pg.OutboxMessage) error {
+	dctx, cancel := context.WithTimeout(ctx, orDefaultDuration(rl.DeliveryTimeout, DefaultDeliveryTimeout))
+	err := rl.Sink.Deliver(dctx, NewEnvelope(msg))
+	cancel()
+
+	now := time.Now().UTC()
+	values := map[string]interface{}{}
+	switch {
+	case err == nil:
+		values[pg.FieldOutboxStatus.String()] = pg.OutboxDelivered
+		values[pg.FieldOutboxDeliveredAt.String()] = now
+		values[pg.FieldOutboxLastError.String()] = ""
+
+	case isPermanent(err) || msg.Attempts >= orDefault(rl.MaxAttempts, DefaultMaxAttempts):
+		log.Printf("Outbox message %v (%v of %v) is dead: %v", msg.ID, msg.Type, msg.Tenant+"/"+msg.Account, err)
+		values[pg.FieldOutboxStatus.String()] = pg.OutboxDead
+		values[pg.FieldOutboxLastError.String()] = truncate(err.Error(), maxErrorLen)
+
+	default:
+		values[pg.FieldOutboxNextAttemptAt.String()] = now.Add(Backoff(msg.Attempts - 1))
+		values[pg.FieldOutboxLastError.String()] = truncate(err.Error(), maxErrorLen)
+	}
+
+	return rl.record(ctx, msg, values)
+}
+
+// record updates msg with values, unless it was claimed again since.
+func (rl *Relay) record(ctx context.Context, msg *pg.OutboxMessage, values map[string]interface{}) error {
+	return rl.PG.WithContext(ctx).
+		Table(pg.TableOutbox.String()).
+		Where(pg.FieldOutboxID.String()+" = ? AND "+pg.FieldOutboxAttempts.String()+" = ?", msg.ID, msg.Attempts).
+		Updates(values).Error
+}
+
+func truncate(s string, n int) string {
+	if rs := []rune(s); len(rs) > n {
+		return string(rs[:n])
+	}
+	return s
+}
@@ -0,0 +1,159 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Sink delivers envelopes to a downstream system. A nil error means the
+// envelope was accepted; it may be delivered again after a crash.
+type Sink interface {
+	Deliver(context.Context, *Envelope) error
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as a failure that retrying cannot fix, so the message
+// goes to the dead letters at once.
+func Permanent(err error) error {
+	return &permanentError{err}
+}
+
+func isPermanent(err error) bool {
+	var pe *permanentError
+	return errors.As(err, &pe)
+}
+
+// WriterSink writes each envelope as a line of JSON.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// NewStdoutSink writes the envelopes to the standard output.
+func NewStdoutSink() *WriterSink {
+	return NewWriterSink(os.Stdout)
+}
+
+func (s *WriterSink) Deliver(_ context.Context, env *Envelope) error {
+	line, err := json.Marshal(env)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+// FileSink appends the envelopes to an NDJSON file, synced after each one.
+type FileSink struct {
+	WriterSink
+	f *os.File
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{WriterSink: WriterSink{w: f}, f: f}, nil
+}
+
+func (s *FileSink) Deliver(ctx context.Context, env *Envelope) error {
+	if err := s.WriterSink.Deliver(ctx, env); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
+
+// HTTPSink POSTs each envelope as JSON to URL. Any 2xx status accepts it;
+// other 4xx statuses than 408 and 429 reject it for good. The
+// Idempotency-Key header carries the ID of the message.
+type HTTPSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *HTTPSink) Deliver(ctx context.Context, env *Envelope) error {
+	body, err := json.Marshal(env)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", strconv.FormatInt(env.ID, 10))
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	switch code := resp.StatusCode; {
+	case code >= 200 && code < 300:
+		return nil
+	case code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests:
+		return Permanent(fmt.Errorf("%v answered %v", s.URL, resp.Status))
+	default:
+		return fmt.Errorf("%v answered %v", s.URL, resp.Status)
+	}
+}
+
+// Multi delivers to every sink. It fails if one of them does, and the
+// message is then delivered again to all of them.
+type Multi []Sink
+
+func (m Multi) Deliver(ctx context.Context, env *Envelope) error {
+	var first error
+	permanent := true
+	for _, s := range m {
+		if err := s.Deliver(ctx, env); err != nil {
+			if first == nil {
+				first = err
+			}
+			permanent = permanent && isPermanent(err)
+		}
+	}
+	if first != nil && !permanent && isPermanent(first) {
+		// retry while a sink may still take it
+		return errors.Unwrap(first)
+	}
+	return first
+}
@@ -0,0 +1,148 @@
+package outbox_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dontang97/ui/outbox"
+	"github.com/dontang97/ui/pg"
+	"github.com/stretchr/testify/suite"
+)
+
+type _Suite struct {
+	suite.Suite
+
+	env *outbox.Envelope
+}
+
+func (s *_Suite) SetupTest() {
+	s.env = &outbox.Envelope{
+		ID:         42,
+		Type:       outbox.UserCreated,
+		Account:    "kobe_bryant",
+		OccurredAt: time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC),
+		Data:       pg.JSONB(`{"account":"kobe_bryant","fullname":"Kobe Bryant"}`),
+	}
+}
+
+func (s *_Suite) TearDownTest() {
+}
+
+// failSink fails with err and counts its calls.
+type failSink struct {
+	err   error
+	calls int
+}
+
+func (f *failSink) Deliver(context.Context, *outbox.Envelope) error {
+	f.calls++
+	return f.err
+}
+
+func (s *_Suite) TestWriterSink() {
+	var buf bytes.Buffer
+	sink := outbox.NewWriterSink(&buf)
+	s.Equal(nil, sink.Deliver(context.Background(), s.env))
+	s.Equal(nil, sink.Deliver(context.Background(), s.env))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	s.Equal(2, len(lines))
+	env := outbox.Envelope{}
+	s.Equal(nil, json.Unmarshal([]byte(lines[0]), &env))
+	s.Equal(*s.env, env)
+}
+
+func (s *_Suite) TestFileSink() {
+	dir, err := ioutil.TempDir("", "outbox")
+	s.Equal(nil, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.ndjson")
+
+	// the file is appended to across restarts
+	for i := 0; i < 2; i++ {
+		sink, err := outbox.NewFileSink(path)
+		s.Equal(nil, err)
+		s.Equal(nil, sink.Deliver(context.Background(), s.env))
+		s.Equal(nil, sink.Close())
+	}
+
+	b, err := ioutil.ReadFile(path)
+	s.Equal(nil, err)
+	s.Equal(2, strings.Count(string(b), "\n"))
+}
+
+func (s *_Suite) TestHTTPSink() {
+	status := http.StatusAccepted
+	var got outbox.Envelope
+	var key string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key = r.Header.Get("Idempotency-Key")
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	sink := &outbox.HTTPSink{URL: srv.URL}
+	s.Equal(nil, sink.Deliver(context.Background(), s.env))
+	s.Equal("42", key)
+	s.Equal(*s.env, got)
+
+	// a rejected event is not retried, an unavailable receiver is
+	status = http.StatusBadRequest
+	err := sink.Deliver(context.Background(), s.env)
+	s.NotNil(err)
+	s.Equal(false, errors.Unwrap(err) == nil)
+
+	for _, status = range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		err = sink.Deliver(context.Background(), s.env)
+		s.NotNil(err)
+		s.Equal(nil, errors.Unwrap(err))
+	}
+
+	srv.Close()
+	s.NotNil(sink.Deliver(context.Background(), s.env))
+}
+
+func (s *_Suite) TestMulti() {
+	var buf bytes.Buffer
+	ok := outbox.NewWriterSink(&buf)
+	transient := &failSink{err: errors.New("unavailable")}
+	permanent := &failSink{err: outbox.Permanent(errors.New("rejected"))}
+
+	s.Equal(nil, outbox.Multi{ok, ok}.Deliver(context.Background(), s.env))
+
+	// every sink gets the event even when one fails
+	buf.Reset()
+	err := outbox.Multi{transient, ok}.Deliver(context.Background(), s.env)
+	s.Equal(transient.err, err)
+	s.Equal(1, strings.Count(buf.String(), "\n"))
+
+	// the message goes dead only when no sink may take it later
+	err = outbox.Multi{permanent, transient}.Deliver(context.Background(), s.env)
+	s.Equal("rejected", err.Error())
+	s.Equal(nil, errors.Unwrap(err))
+
+	err = outbox.Multi{permanent, permanent}.Deliver(context.Background(), s.env)
+	s.Equal(permanent.err, err)
+}
+
+func (s *_Suite) TestBackoff() {
+	s.Equal(time.Second, outbox.Backoff(0))
+	s.Equal(8*time.Second, outbox.Backoff(3))
+	s.Equal(10*time.Minute, outbox.Backoff(10))
+	s.Equal(10*time.Minute, outbox.Backoff(100))
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(_Suite))
+}
@@ -0,0 +1,28 @@
+package pg
+
+import (
+	"time"
+)
+
+const (
+	TableAccountAliases Table = "account_aliases"
+
+	FieldAliasID        Field = "id"
+	FieldAliasTenant    Field = "tenant"
+	FieldAliasAlias     Field = "alias"
+	FieldAliasAcct      Field = "acct"
+	FieldAliasRenamedAt Field = "renamed_at"
+	FieldAliasExpiresAt Field = "expires_at"
+)
+
+// AccountAlias is a former name of an account. Until ExpiresAt it stays
+// reserved for the account and resolves to it; after, it is the record of
+// the rename only.
+type AccountAlias struct {
+	ID        int64     `json:"-"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Alias     string    `json:"alias"`
+	Acct      string    `json:"account"`
+	RenamedAt time.Time `json:"renamed_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
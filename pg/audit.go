@@ -0,0 +1,65 @@
+package pg
+
+import (
+	"time"
+)
+
+const (
+	TableAuditEvents      Table = "audit_events"
+	TableAuditCheckpoints Table = "audit_checkpoints"
+
+	FieldAuditID        Field = "id"
+	FieldAuditAt        Field = "at"
+	FieldAuditActor     Field = "actor"
+	FieldAuditTarget    Field = "target"
+	FieldAuditAction    Field = "action"
+	FieldAuditOutcome   Field = "outcome"
+	FieldAuditRequestID Field = "request_id"
+	FieldAuditHash      Field = "hash"
+	FieldAuditSourceIP  Field = "source_ip"
+	FieldAuditDiff      Field = "diff"
+	FieldAuditErased    Field = "erased"
+
+	FieldCheckpointID      Field = "id"
+	FieldCheckpointEventID Field = "event_id"
+)
+
+// AuditEvent records one account mutation or authentication attempt.
+// Actor is the account of the JWT the request carried, empty for anonymous
+// requests, and Diff maps each changed field to its redacted before and
+// after values.
+//
+// Events form a hash chain: ContentHash digests the content of the event,
+// and Hash digests PrevHash, the Hash of the previous event, together with
+// ContentHash.
+//
+// Erased events had the personal data of an account anonymized after they
+// were sealed. They keep their chain fields but no longer match their
+// ContentHash.
+type AuditEvent struct {
+	ID        int64     `json:"id"`
+	At        time.Time `json:"at"`
+	Actor     string    `json:"actor"`
+	Target    string    `json:"target"`
+	Action    string    `json:"action"`
+	Diff      JSONB     `json:"diff"`
+	SourceIP  string    `json:"source_ip"`
+	RequestID string    `json:"request_id"`
+	Outcome   string    `json:"outcome"`
+	Status    int       `json:"status"`
+
+	ContentHash string `json:"content_hash"`
+	PrevHash    string `json:"prev_hash"`
+	Hash        string `json:"hash"`
+	Erased      bool   `json:"erased,omitempty"`
+}
+
+// AuditCheckpoint is a signature over the Hash of the event EventID, and
+// so over every event up to it.
+type AuditCheckpoint struct {
+	ID        int64     `json:"id"`
+	EventID   int64     `json:"event_id"`
+	Hash      string    `json:"hash"`
+	Signature string    `json:"signature"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,338 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/dontang97/ui/backup"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+// cursorFetchSize is the rows each FETCH of a cursor brings over.
+const cursorFetchSize = 1000
+
+// backupTables are the tables of a backup, in the order they restore in:
+// the rows a row refers to come first.
+var backupTables = []Table{
+	TableTenants,
+	TableDataKeys,
+	TableUsers,
+	TableUserHistory,
+	TableAccountAliases,
+	TableGroups,
+	TableGroupMembers,
+	TableGroupSubgroups,
+	TableAuditEvents,
+	TableAuditCheckpoints,
+	TableOutbox,
+	TableWebhooks,
+	TableWebhookDeliveries,
+}
+
+// restoreQuietTriggers are disabled while restoring: restored rows keep
+// their timestamps, their versions come with the archive, and their events
+// are history, not announced again.
+var restoreQuietTriggers = map[Table][]string{
+	TableUsers:  {"update_timestamp", "user_history"},
+	TableGroups: {"update_timestamp"},
+	TableOutbox: {"notify_user_event"},
+}
+
+// appendOnlyTables keep their rows on a restore that replaces.
+var appendOnlyTables = map[Table]bool{
+	TableAuditEvents:      true,
+	TableAuditCheckpoints: true,
+}
+
+// ScanCursor calls fn with each row of query through a server-side cursor
+// of tx, which must be a transaction, so however many rows there are only
+// cursorFetchSize of them are held at a time.
+func ScanCursor(tx *gorm.DB, query string, values []interface{}, fn func(*sql.Rows) error) error {
+	if res := tx.Exec("DECLARE scan_cursor NO SCROLL CURSOR FOR "+query, values...); res.Error != nil {
+		return res.Error
+	}
+
+	for {
+		rows, err := tx.Raw("FETCH FORWARD " + strconv.Itoa(cursorFetchSize) + " FROM scan_cursor").Rows()
+		if err != nil {
+			return err
+		}
+		n := 0
+		for rows.Next() {
+			n++
+			if err := fn(rows); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if n < cursorFetchSize {
+			return tx.Exec("CLOSE scan_cursor").Error
+		}
+	}
+}
+
+// backupTable is what a backup or restore needs to know of a table.
+type backupTable struct {
+	table   Table
+	key     []string
+	columns map[string]bool
+}
+
+func loadBackupTable(tx *gorm.DB, table Table) (*backupTable, error) {
+	t := &backupTable{table: table, columns: map[string]bool{}}
+
+	keys, err := tx.Raw(`
+		SELECT a.attname
+		FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY (i.indkey)
+		WHERE i.indrelid = ?::regclass AND i.indisprimary
+		ORDER BY array_position(i.indkey::int2[], a.attnum)`, table.String()).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer keys.Close()
+	for keys.Next() {
+		var col string
+		if err := keys.Scan(&col); err != nil {
+			return nil, err
+		}
+		t.key = append(t.key, col)
+	}
+	if err := keys.Err(); err != nil {
+		return nil, err
+	}
+	if len(t.key) == 0 {
+		return nil, fmt.Errorf("%v has no primary key", table)
+	}
+
+	cols, err := tx.Raw(`
+		SELECT column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = ?`, table.String()).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer cols.Close()
+	for cols.Next() {
+		var col string
+		if err := cols.Scan(&col); err != nil {
+			return nil, err
+		}
+		t.columns[col] = true
+	}
+	return t, cols.Err()
+}
+
+// quoted returns the quoted cols, each prefixed with alias when given.
+func quoted(alias string, cols []string) string {
+	q := make([]string, len(cols))
+	for i, col := range cols {
+		if alias != "" {
+			q[i] = alias + "." + pq.QuoteIdentifier(col)
+		} else {
+			q[i] = pq.QuoteIdentifier(col)
+		}
+	}
+	return strings.Join(q, ", ")
+}
+
+// Snapshot calls fn with the rows of the backupTables as JSON objects, as
+// of the start of one repeatable read transaction. The rows are encoded by
+// row_to_json, the encoding of the archives.
+func (pg *PG) Snapshot(ctx context.Context, fn func(string, json.RawMessage) error) error {
+	return pg.ReadTransaction(ctx, func(tx *gorm.DB) error {
+		for _, table := range backupTables {
+			t, err := loadBackupTable(tx, table)
+			if err != nil {
+				return err
+			}
+
+			if err := ScanCursor(tx,
+				`SELECT row_to_json(t) FROM `+pq.QuoteIdentifier(table.String())+` t ORDER BY `+quoted("t", t.key),
+				nil, func(rows *sql.Rows) error {
+					var row []byte
+					if err := rows.Scan(&row); err != nil {
+						return err
+					}
+					return fn(table.String(), json.RawMessage(row))
+				}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Restore inserts the rows next returns, in one transaction, and brings
+// the sequences of the tables past them. The rows of a key taken by a
+// different row are settled by policy. A row may lack columns, of an
+// archive older than the schema, which then take their defaults, but not
+// have columns the schema lacks.
+//
+// The triggers of restoreQuietTriggers are disabled until the commit,
+// which locks their tables meanwhile.
+func (pg *PG) Restore(ctx context.Context, policy string, next func() (string, json.RawMessage, error)) (backup.Report, error) {
+	report := backup.Report{}
+	err := pg.Transaction(ctx, func(tx *gorm.DB) error {
+		tables := map[string]*backupTable{}
+		for _, table := range backupTables {
+			t, err := loadBackupTable(tx, table)
+			if err != nil {
+				return err
+			}
+			tables[table.String()] = t
+			report[table.String()] = &backup.TableReport{}
+
+			for _, trigger := range restoreQuietTriggers[table] {
+				if res := tx.Exec(`ALTER TABLE ` + pq.QuoteIdentifier(table.String()) +
+					` DISABLE TRIGGER ` + pq.QuoteIdentifier(trigger)); res.Error != nil {
+					return res.Error
+				}
+			}
+		}
+
+		for {
+			name, row, err := next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			t, ok := tables[name]
+			if !ok {
+				return fmt.Errorf("unknown table %q", name)
+			}
+			if err := t.restore(tx, row, policy, report[name]); err != nil {
+				return err
+			}
+		}
+
+		for _, table := range backupTables {
+			if err := tables[table.String()].resetSequence(tx); err != nil {
+				return err
+			}
+			for _, trigger := range restoreQuietTriggers[table] {
+				if res := tx.Exec(`ALTER TABLE ` + pq.QuoteIdentifier(table.String()) +
+					` ENABLE TRIGGER ` + pq.QuoteIdentifier(trigger)); res.Error != nil {
+					return res.Error
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (t *backupTable) restore(tx *gorm.DB, row json.RawMessage, policy string, report *backup.TableReport) error {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(row, &fields); err != nil {
+		return fmt.Errorf("row of %v: %w", t.table, err)
+	}
+	cols := []string{}
+	for col := range fields {
+		if !t.columns[col] {
+			return fmt.Errorf("column %q of %v is not in the database", col, t.table)
+		}
+		cols = append(cols, col)
+	}
+	key := map[string]json.RawMessage{}
+	for _, col := range t.key {
+		if _, ok := fields[col]; !ok {
+			return fmt.Errorf("row of %v lacks its key %q", t.table, col)
+		}
+		key[col] = fields[col]
+	}
+
+	name := pq.QuoteIdentifier(t.table.String())
+	record := `json_populate_record(NULL::` + name + `, ?::json) r`
+	match := []string{}
+	for _, col := range t.key {
+		match = append(match, "t."+pq.QuoteIdentifier(col)+" = r."+pq.QuoteIdentifier(col))
+	}
+
+	res := tx.Exec(`INSERT INTO `+name+` (`+quoted("", cols)+`)
+		SELECT `+quoted("r", cols)+` FROM `+record+`
+		ON CONFLICT DO NOTHING`, string(row))
+	if res.Error != nil {
+		return fmt.Errorf("row of %v: %w", t.table, res.Error)
+	}
+	if res.RowsAffected == 1 {
+		report.Restored++
+		return nil
+	}
+
+	// the key, or another unique column, is taken
+	var same bool
+	err := tx.Raw(`SELECT (`+quoted("t", cols)+`) IS NOT DISTINCT FROM (`+quoted("r", cols)+`)
+		FROM `+name+` t, `+record+`
+		WHERE `+strings.Join(match, " AND "), string(row)).Row().Scan(&same)
+	found := err != sql.ErrNoRows
+	if err != nil && found {
+		return err
+	}
+	if same {
+		report.Unchanged++
+		return nil
+	}
+
+	conflict := func() error {
+		js, _ := json.Marshal(key)
+		return &backup.ConflictError{Table: t.table.String(), Key: js}
+	}
+	switch {
+	case policy == backup.ConflictSkip:
+		report.Skipped++
+		return nil
+	case policy != backup.ConflictReplace || !found:
+		return conflict()
+	case appendOnlyTables[t.table]:
+		report.Skipped++
+		return nil
+	}
+
+	set := []string{}
+	for _, col := range cols {
+		set = append(set, pq.QuoteIdentifier(col)+" = r."+pq.QuoteIdentifier(col))
+	}
+	if res := tx.Exec(`UPDATE `+name+` t SET `+strings.Join(set, ", ")+`
+		FROM `+record+`
+		WHERE `+strings.Join(match, " AND "), string(row)); res.Error != nil {
+		return fmt.Errorf("row of %v: %w", t.table, res.Error)
+	}
+	report.Replaced++
+	return nil
+}
+
+// resetSequence brings the sequence of the serial key of the table, if it
+// has one, past the keys restored.
+func (t *backupTable) resetSequence(tx *gorm.DB) error {
+	if len(t.key) != 1 {
+		return nil
+	}
+
+	var seq sql.NullString
+	if err := tx.Raw("SELECT pg_get_serial_sequence(?, ?)", t.table.String(), t.key[0]).Row().Scan(&seq); err != nil {
+		return err
+	}
+	if !seq.Valid {
+		return nil
+	}
+
+	key := pq.QuoteIdentifier(t.key[0])
+	return tx.Exec(`SELECT setval(?, COALESCE((SELECT MAX(`+key+`) FROM `+pq.QuoteIdentifier(t.table.String())+`), 0) + 1, false)`,
+		seq.String).Error
+}
@@ -0,0 +1,125 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ctxDB binds every statement gorm issues to ctx, so a canceled or timed out
+// request aborts its SQL instead of leaving it running on the server.
+type ctxDB struct {
+	ctx context.Context
+	db  *sql.DB
+}
+
+func (c ctxDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	res, err := c.db.ExecContext(c.ctx, query, args...)
+	return res, contextError(c.ctx, err)
+}
+
+func (c ctxDB) Prepare(query string) (*sql.Stmt, error) {
+	stmt, err := c.db.PrepareContext(c.ctx, query)
+	return stmt, contextError(c.ctx, err)
+}
+
+func (c ctxDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	rows, err := c.db.QueryContext(c.ctx, query, args...)
+	return rows, contextError(c.ctx, err)
+}
+
+func (c ctxDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.db.QueryRowContext(c.ctx, query, args...)
+}
+
+// ctxTx is ctxDB for a transaction. It also satisfies gorm's commit/rollback
+// interface so Commit() and Rollback() on the wrapping *gorm.DB keep working.
+type ctxTx struct {
+	ctx context.Context
+	tx  *sql.Tx
+}
+
+func (c ctxTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	res, err := c.tx.ExecContext(c.ctx, query, args...)
+	return res, contextError(c.ctx, err)
+}
+
+func (c ctxTx) Prepare(query string) (*sql.Stmt, error) {
+	stmt, err := c.tx.PrepareContext(c.ctx, query)
+	return stmt, contextError(c.ctx, err)
+}
+
+func (c ctxTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	rows, err := c.tx.QueryContext(c.ctx, query, args...)
+	return rows, contextError(c.ctx, err)
+}
+
+func (c ctxTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.tx.QueryRowContext(c.ctx, query, args...)
+}
+
+func (c ctxTx) Commit() error {
+	return contextError(c.ctx, c.tx.Commit())
+}
+
+func (c ctxTx) Rollback() error {
+	return c.tx.Rollback()
+}
+
+// contextError reports an aborted statement as the context error that caused
+// it. The driver surfaces a canceled query as a plain server error (57014),
+// which callers could not tell apart from any other failure.
+func contextError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ctx.Err(), err)
+}
+
+// WithContext returns a handle on the database whose statements are all
+// bound to ctx.
+func (pg *PG) WithContext(ctx context.Context) *gorm.DB {
+	db, err := gorm.Open(pg.db.Dialect().GetName(), ctxDB{ctx: ctx, db: pg.db.DB()})
+	if err != nil {
+		// gorm.Open only fails for an unknown source type
+		panic(err)
+	}
+	return db
+}
+
+// Transaction runs fc in a transaction bound to ctx. The transaction is
+// committed if fc returns nil and rolled back otherwise.
+func (pg *PG) Transaction(ctx context.Context, fc func(tx *gorm.DB) error) error {
+	return transaction(ctx, pg.db, nil, fc)
+}
+
+func transaction(ctx context.Context, db *gorm.DB, opts *sql.TxOptions, fc func(tx *gorm.DB) error) (err error) {
+	sqlTx, err := db.DB().BeginTx(ctx, opts)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+
+	c := ctxTx{ctx: ctx, tx: sqlTx}
+	tx, err := gorm.Open(db.Dialect().GetName(), c)
+	if err != nil {
+		sqlTx.Rollback()
+		return err
+	}
+
+	panicked := true
+	defer func() {
+		if panicked || err != nil {
+			c.Rollback()
+		}
+	}()
+
+	err = fc(tx)
+	if err == nil {
+		err = c.Commit()
+	}
+
+	panicked = false
+	return err
+}
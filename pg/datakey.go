@@ -0,0 +1,35 @@
+package pg
+
+import (
+	"time"
+)
+
+const (
+	TableDataKeys Table = "data_keys"
+
+	FieldDataKeyID        Field = "id"
+	FieldDataKeyPurpose   Field = "purpose"
+	FieldDataKeyWrapped   Field = "wrapped"
+	FieldDataKeyMasterID  Field = "master_id"
+	FieldDataKeyCreatedAt Field = "created_at"
+
+	// DataKeyData seals the personal data of the users, the latest one
+	// the new values, and DataKeyIndex keys their blind indexes. There is
+	// one index key, as the indexes of every row depend on it.
+	DataKeyData  = "data"
+	DataKeyIndex = "index"
+
+	// ReencryptSetting marks a transaction that only re-encrypts users, so
+	// their updated_at, and so their ETags, stay as they are.
+	ReencryptSetting = "ui.reencrypt"
+)
+
+// DataKey is a key of the field encryption, wrapped by the master key of
+// MasterID.
+type DataKey struct {
+	ID        int64     `json:"id"`
+	Purpose   string    `json:"purpose"`
+	Wrapped   string    `json:"-"`
+	MasterID  string    `json:"master_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,42 @@
+package pg
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	TableGroups         Table = "groups"
+	TableGroupMembers   Table = "group_members"
+	TableGroupSubgroups Table = "group_subgroups"
+
+	FieldGroupID          Field = "id"
+	FieldGroupTenant      Field = "tenant"
+	FieldGroupName        Field = "name"
+	FieldGroupDescription Field = "description"
+	FieldGroupRoles       Field = "roles"
+	FieldGroupUpdatedAt   Field = "updated_at"
+
+	FieldMemberGroupID Field = "group_id"
+	FieldMemberTenant  Field = "tenant"
+	FieldMemberAcct    Field = "acct"
+
+	FieldSubgroupParentID Field = "parent_id"
+	FieldSubgroupChildID  Field = "child_id"
+
+	FieldGroupNameMaxLen        = 64
+	FieldGroupDescriptionMaxLen = 256
+)
+
+// Group gathers accounts, and other groups, under a name unique within its
+// tenant. Its members are granted its Roles at login.
+type Group struct {
+	ID          int64          `json:"-"`
+	Tenant      string         `json:"-"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Roles       pq.StringArray `json:"roles"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
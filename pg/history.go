@@ -0,0 +1,44 @@
+package pg
+
+import (
+	"time"
+)
+
+const (
+	TableUserHistory Table = "user_history"
+
+	FieldVersionID        Field = "id"
+	FieldVersionTenant    Field = "tenant"
+	FieldVersionAcct      Field = "acct"
+	FieldVersionValidFrom Field = "valid_from"
+	FieldVersionValidTo   Field = "valid_to"
+)
+
+// UserVersion is a version of the profile of a user, kept by a trigger on
+// every write of the users. It was current from ValidFrom until ValidTo,
+// nil while it still is.
+type UserVersion struct {
+	ID         int64      `json:"version"`
+	Tenant     string     `json:"tenant,omitempty"`
+	Acct       string     `json:"account"`
+	Fullname   string     `json:"fullname"`
+	Attributes JSONB      `json:"attributes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	ValidFrom  time.Time  `json:"valid_from"`
+	ValidTo    *time.Time `json:"valid_to"`
+
+	FullnameIndex string `json:"-" gorm:"column:fullname_bidx"`
+}
+
+// User returns the user as of the version, without its password.
+func (v *UserVersion) User() User {
+	return User{
+		Tenant:     v.Tenant,
+		Acct:       v.Acct,
+		Fullname:   v.Fullname,
+		Created_at: v.CreatedAt,
+		Updated_at: v.UpdatedAt,
+		Attributes: v.Attributes,
+	}
+}
@@ -0,0 +1,45 @@
+package pg
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// JSONB holds the raw JSON of a jsonb column. A nil JSONB is stored as NULL
+// and encoded as null.
+type JSONB json.RawMessage
+
+func (j JSONB) Value() (driver.Value, error) {
+	if j == nil {
+		return nil, nil
+	}
+	return string(j), nil
+}
+
+func (j *JSONB) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*j = nil
+	case []byte:
+		// the driver may reuse its buffer
+		*j = append(JSONB{}, v...)
+	case string:
+		*j = JSONB(v)
+	default:
+		return fmt.Errorf("cannot scan %T into JSONB", src)
+	}
+	return nil
+}
+
+func (j JSONB) MarshalJSON() ([]byte, error) {
+	if j == nil {
+		return []byte("null"), nil
+	}
+	return json.RawMessage(j).MarshalJSON()
+}
+
+func (j *JSONB) UnmarshalJSON(data []byte) error {
+	*j = append(JSONB{}, data...)
+	return nil
+}
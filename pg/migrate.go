@@ -0,0 +1,65 @@
+package pg
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	migrationDir string = "./pg/migrations"
+
+	TableSchemaMigrations Table = "schema_migrations"
+)
+
+// migrate applies, in file name order, every migrationDir/*.sql not yet
+// recorded in schema_migrations. Each file runs in its own transaction.
+func (pg *PG) migrate() {
+	if res := pg.DB().Exec(`CREATE TABLE IF NOT EXISTS ` + TableSchemaMigrations.String() + ` (
+	version    VARCHAR(255) PRIMARY KEY NOT NULL,
+	applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+)`); res.Error != nil {
+		log.Fatal(res.Error)
+	}
+
+	files, err := filepath.Glob(filepath.Join(migrationDir, "*.sql"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		version := strings.TrimSuffix(filepath.Base(file), ".sql")
+
+		var count int
+		if res := pg.DB().
+			Table(TableSchemaMigrations.String()).
+			Where("version = ?", version).
+			Count(&count); res.Error != nil {
+			log.Fatal(res.Error)
+		}
+		if count > 0 {
+			continue
+		}
+
+		sql, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = pg.DB().Transaction(func(tx *gorm.DB) error {
+			if res := tx.Exec(string(sql)); res.Error != nil {
+				return res.Error
+			}
+			return tx.Exec(`INSERT INTO `+TableSchemaMigrations.String()+` (version) VALUES (?)`, version).Error
+		})
+		if err != nil {
+			log.Fatalf("migration %v: %v", version, err)
+		}
+		log.Printf("Applied migration %v", version)
+	}
+}
@@ -0,0 +1,6 @@
+-- keyset pagination of GET /ui/v1/users sorts by (column, acct)
+CREATE INDEX IF NOT EXISTS users_created_at_acct_idx ON users (created_at, acct);
+CREATE INDEX IF NOT EXISTS users_updated_at_acct_idx ON users (updated_at, acct);
+
+-- account prefix filter (acct LIKE 'prefix%')
+CREATE INDEX IF NOT EXISTS users_acct_pattern_idx ON users (acct varchar_pattern_ops);
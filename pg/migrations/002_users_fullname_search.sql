@@ -0,0 +1,8 @@
+-- fullname search of GET /ui/v1/users/search
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- caseless and prefix matches
+CREATE INDEX IF NOT EXISTS users_fullname_lower_idx ON users (lower(fullname) text_pattern_ops);
+
+-- contains and similarity matches
+CREATE INDEX IF NOT EXISTS users_fullname_trgm_idx ON users USING GIN (lower(fullname) gin_trgm_ops);
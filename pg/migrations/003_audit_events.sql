@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+	id         BIGSERIAL    PRIMARY KEY,
+	at         TIMESTAMP    NOT NULL,
+	actor      VARCHAR(64)  NOT NULL DEFAULT '',
+	target     VARCHAR(64)  NOT NULL,
+	action     VARCHAR(20)  NOT NULL,
+	diff       JSONB,
+	source_ip  VARCHAR(64)  NOT NULL DEFAULT '',
+	request_id VARCHAR(64)  NOT NULL DEFAULT '',
+	outcome    VARCHAR(20)  NOT NULL,
+	status     INTEGER      NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_target_idx ON audit_events (target, id);
+CREATE INDEX IF NOT EXISTS audit_events_actor_idx ON audit_events (actor, id);
+CREATE INDEX IF NOT EXISTS audit_events_action_idx ON audit_events (action, id);
+CREATE INDEX IF NOT EXISTS audit_events_at_idx ON audit_events (at);
+
+-- the audit log is append-only
+CREATE OR REPLACE FUNCTION audit_events_append_only()
+RETURNS TRIGGER AS $$
+BEGIN
+      RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+FOR EACH ROW EXECUTE PROCEDURE audit_events_append_only();
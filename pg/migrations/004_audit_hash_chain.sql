@@ -0,0 +1,11 @@
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS content_hash VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS prev_hash VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS hash VARCHAR(64) NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS audit_checkpoints (
+	id         BIGSERIAL    PRIMARY KEY,
+	event_id   BIGINT       NOT NULL REFERENCES audit_events (id),
+	hash       VARCHAR(64)  NOT NULL,
+	signature  TEXT         NOT NULL,
+	created_at TIMESTAMP    NOT NULL
+);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS outbox (
+	id              BIGSERIAL    PRIMARY KEY,
+	account         VARCHAR(20)  NOT NULL,
+	type            VARCHAR(32)  NOT NULL,
+	payload         JSONB        NOT NULL,
+	created_at      TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	status          VARCHAR(16)  NOT NULL DEFAULT 'pending',
+	attempts        INTEGER      NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_error      TEXT         NOT NULL DEFAULT '',
+	delivered_at    TIMESTAMP
+);
+
+-- the relay polls the pending messages and keeps each account in order
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (next_attempt_at, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS outbox_account_pending_idx ON outbox (account, id) WHERE status = 'pending';
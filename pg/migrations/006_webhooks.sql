@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+	id          BIGSERIAL     PRIMARY KEY,
+	url         VARCHAR(2048) NOT NULL,
+	secret      VARCHAR(128)  NOT NULL,
+	event_types TEXT[]        NOT NULL DEFAULT '{}',
+	active      BOOLEAN       NOT NULL DEFAULT TRUE,
+	failures    INTEGER       NOT NULL DEFAULT 0,
+	open_until  TIMESTAMP,
+	created_at  TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at  TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id              BIGSERIAL    PRIMARY KEY,
+	webhook_id      BIGINT       NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+	outbox_id       BIGINT       NOT NULL,
+	redelivery_of   BIGINT       REFERENCES webhook_deliveries (id) ON DELETE SET NULL,
+	event_type      VARCHAR(32)  NOT NULL,
+	payload         JSONB        NOT NULL,
+	status          VARCHAR(16)  NOT NULL DEFAULT 'pending',
+	attempts        INTEGER      NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_status     INTEGER      NOT NULL DEFAULT 0,
+	last_error      TEXT         NOT NULL DEFAULT '',
+	created_at      TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	delivered_at    TIMESTAMP
+);
+
+-- the outbox delivers at least once; an event is queued once per webhook
+CREATE UNIQUE INDEX IF NOT EXISTS webhook_deliveries_event_idx
+ON webhook_deliveries (webhook_id, outbox_id) WHERE redelivery_of IS NULL;
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx
+ON webhook_deliveries (next_attempt_at, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, id);
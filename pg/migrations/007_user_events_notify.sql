@@ -0,0 +1,21 @@
+-- announce each committed user event on the user_events channel, beside
+-- update_timestamp as the other trigger kept on the mutations of users.
+-- NOTIFY is sent on commit, so listeners never see a rolled back event.
+CREATE OR REPLACE FUNCTION notify_user_event()
+RETURNS TRIGGER AS $$
+BEGIN
+	PERFORM pg_notify('user_events', json_build_object(
+		'id', NEW.id,
+		'type', NEW.type,
+		'account', NEW.account,
+		'occurred_at', to_char(NEW.created_at, 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"'),
+		'data', NEW.payload
+	)::text);
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS notify_user_event ON outbox;
+CREATE TRIGGER notify_user_event AFTER INSERT ON outbox
+FOR EACH ROW WHEN (NEW.type IN ('user.created', 'user.updated', 'user.deleted'))
+EXECUTE PROCEDURE notify_user_event();
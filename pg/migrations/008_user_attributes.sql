@@ -0,0 +1,6 @@
+-- profile attributes beyond the fixed columns, validated by the service
+-- against the JSON Schema of the operator
+ALTER TABLE users ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}';
+
+-- attribute equality filters of GET /ui/v1/users (attributes @> '{...}')
+CREATE INDEX IF NOT EXISTS users_attributes_idx ON users USING GIN (attributes jsonb_path_ops);
@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS groups (
+	id          BIGSERIAL    PRIMARY KEY,
+	name        VARCHAR(64)  NOT NULL UNIQUE,
+	description VARCHAR(256) NOT NULL DEFAULT '',
+	roles       TEXT[]       NOT NULL DEFAULT '{}',
+	created_at  TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at  TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER update_timestamp BEFORE INSERT OR UPDATE ON groups
+FOR EACH ROW EXECUTE PROCEDURE update_timestamp();
+
+-- the accounts directly in a group
+CREATE TABLE IF NOT EXISTS group_members (
+	group_id   BIGINT      NOT NULL REFERENCES groups (id) ON DELETE CASCADE,
+	acct       VARCHAR(20) NOT NULL REFERENCES users (acct) ON DELETE CASCADE,
+	created_at TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (group_id, acct)
+);
+
+-- the members of login lookups
+CREATE INDEX IF NOT EXISTS group_members_acct_idx ON group_members (acct);
+
+-- the groups nested in a group, whose members are members of it too. The
+-- service keeps the graph acyclic.
+CREATE TABLE IF NOT EXISTS group_subgroups (
+	parent_id  BIGINT    NOT NULL REFERENCES groups (id) ON DELETE CASCADE,
+	child_id   BIGINT    NOT NULL REFERENCES groups (id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (parent_id, child_id),
+	CHECK (parent_id <> child_id)
+);
+
+CREATE INDEX IF NOT EXISTS group_subgroups_child_idx ON group_subgroups (child_id);
@@ -0,0 +1,75 @@
+CREATE TABLE IF NOT EXISTS tenants (
+	id         VARCHAR(32) PRIMARY KEY,
+	name       VARCHAR(64) NOT NULL DEFAULT '',
+	created_at TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- the accounts from before tenants
+INSERT INTO tenants (id, name) VALUES ('default', 'Default') ON CONFLICT DO NOTHING;
+
+-- accounts are unique within their tenant only
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tenant VARCHAR(32) NOT NULL DEFAULT 'default' REFERENCES tenants (id);
+ALTER TABLE group_members DROP CONSTRAINT IF EXISTS group_members_acct_fkey;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_pkey;
+ALTER TABLE users ADD PRIMARY KEY (tenant, acct);
+
+-- keyset pagination and the account prefix filter within a tenant
+DROP INDEX IF EXISTS users_created_at_acct_idx;
+DROP INDEX IF EXISTS users_updated_at_acct_idx;
+DROP INDEX IF EXISTS users_acct_pattern_idx;
+CREATE INDEX IF NOT EXISTS users_tenant_created_at_acct_idx ON users (tenant, created_at, acct);
+CREATE INDEX IF NOT EXISTS users_tenant_updated_at_acct_idx ON users (tenant, updated_at, acct);
+CREATE INDEX IF NOT EXISTS users_tenant_acct_pattern_idx ON users (tenant, acct varchar_pattern_ops);
+
+-- groups and their members belong to a tenant
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS tenant VARCHAR(32) NOT NULL DEFAULT 'default' REFERENCES tenants (id);
+ALTER TABLE groups DROP CONSTRAINT IF EXISTS groups_name_key;
+ALTER TABLE groups ADD CONSTRAINT groups_tenant_name_key UNIQUE (tenant, name);
+
+ALTER TABLE group_members ADD COLUMN IF NOT EXISTS tenant VARCHAR(32) NOT NULL DEFAULT 'default';
+ALTER TABLE group_members ADD CONSTRAINT group_members_tenant_acct_fkey
+	FOREIGN KEY (tenant, acct) REFERENCES users (tenant, acct) ON DELETE CASCADE;
+DROP INDEX IF EXISTS group_members_acct_idx;
+CREATE INDEX IF NOT EXISTS group_members_tenant_acct_idx ON group_members (tenant, acct);
+
+-- the events of an account are relayed in order within its tenant
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS tenant VARCHAR(32) NOT NULL DEFAULT 'default';
+DROP INDEX IF EXISTS outbox_account_pending_idx;
+CREATE INDEX IF NOT EXISTS outbox_tenant_account_pending_idx ON outbox (tenant, account, id) WHERE status = 'pending';
+
+CREATE OR REPLACE FUNCTION notify_user_event()
+RETURNS TRIGGER AS $$
+BEGIN
+	PERFORM pg_notify('user_events', json_build_object(
+		'id', NEW.id,
+		'type', NEW.type,
+		'tenant', NEW.tenant,
+		'account', NEW.account,
+		'occurred_at', to_char(NEW.created_at, 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"'),
+		'data', NEW.payload
+	)::text);
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- defense in depth: a transaction that sets ui.tenant only sees and writes
+-- the rows of that tenant, even through a query missing its tenant filter.
+-- Without the setting the policies do not restrict. FORCE applies them to
+-- the owner of the tables, which the service connects as.
+ALTER TABLE users ENABLE ROW LEVEL SECURITY;
+ALTER TABLE users FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_isolation ON users;
+CREATE POLICY tenant_isolation ON users
+	USING (tenant = COALESCE(NULLIF(current_setting('ui.tenant', true), ''), tenant));
+
+ALTER TABLE groups ENABLE ROW LEVEL SECURITY;
+ALTER TABLE groups FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_isolation ON groups;
+CREATE POLICY tenant_isolation ON groups
+	USING (tenant = COALESCE(NULLIF(current_setting('ui.tenant', true), ''), tenant));
+
+ALTER TABLE group_members ENABLE ROW LEVEL SECURITY;
+ALTER TABLE group_members FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_isolation ON group_members;
+CREATE POLICY tenant_isolation ON group_members
+	USING (tenant = COALESCE(NULLIF(current_setting('ui.tenant', true), ''), tenant));
@@ -0,0 +1,27 @@
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS erased BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- the audit log stays append-only, except for the erasure of the personal
+-- data of an account: a transaction that sets ui.audit_erasure may
+-- anonymize the actor, target, source address and diff of an event, and
+-- must flag it erased. The chain fields never change.
+CREATE OR REPLACE FUNCTION audit_events_append_only()
+RETURNS TRIGGER AS $$
+BEGIN
+	IF TG_OP = 'UPDATE'
+		AND current_setting('ui.audit_erasure', true) = 'on'
+		AND NEW.erased
+		AND NEW.id = OLD.id
+		AND NEW.at = OLD.at
+		AND NEW.action = OLD.action
+		AND NEW.request_id = OLD.request_id
+		AND NEW.outcome = OLD.outcome
+		AND NEW.status = OLD.status
+		AND NEW.content_hash = OLD.content_hash
+		AND NEW.prev_hash = OLD.prev_hash
+		AND NEW.hash = OLD.hash
+	THEN
+		RETURN NEW;
+	END IF;
+	RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ language 'plpgsql';
@@ -0,0 +1,31 @@
+-- field level encryption: fullname holds the value sealed with a data key
+-- once encryption is on, and fullname_bidx its blind index for the exact
+-- matches. Values from before encryption stay plaintext until the
+-- background re-encryption seals them.
+ALTER TABLE users ALTER COLUMN fullname TYPE TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS fullname_bidx VARCHAR(64) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS users_tenant_fullname_bidx_idx ON users (tenant, fullname_bidx);
+
+-- the data keys, wrapped by the master key, which stays out of the database
+CREATE TABLE IF NOT EXISTS data_keys (
+	id         BIGSERIAL   PRIMARY KEY,
+	purpose    VARCHAR(16) NOT NULL,
+	wrapped    TEXT        NOT NULL,
+	master_id  VARCHAR(64) NOT NULL,
+	created_at TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS data_keys_index_idx ON data_keys (purpose) WHERE purpose = 'index';
+
+-- re-encryption changes how a user is stored, not the user: it keeps
+-- updated_at, and so the ETags
+CREATE OR REPLACE FUNCTION update_timestamp()
+RETURNS TRIGGER AS $$
+BEGIN
+	IF TG_OP = 'UPDATE' AND current_setting('ui.reencrypt', true) = 'on' THEN
+		RETURN NEW;
+	END IF;
+	NEW.updated_at = now();
+	RETURN NEW;
+END;
+$$ language 'plpgsql';
@@ -0,0 +1,54 @@
+-- every version of the profile of a user, valid from valid_from until
+-- valid_to, NULL while it is the current one. Passwords are not versioned:
+-- an update of the password alone makes no version.
+CREATE TABLE IF NOT EXISTS user_history (
+	id            BIGSERIAL   PRIMARY KEY,
+	tenant        VARCHAR(32) NOT NULL,
+	acct          VARCHAR(20) NOT NULL,
+	fullname      TEXT        NOT NULL,
+	fullname_bidx VARCHAR(64) NOT NULL DEFAULT '',
+	attributes    JSONB       NOT NULL DEFAULT '{}',
+	created_at    TIMESTAMP,
+	updated_at    TIMESTAMP,
+	valid_from    TIMESTAMP   NOT NULL,
+	valid_to      TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS user_history_tenant_acct_idx ON user_history (tenant, acct, valid_from);
+
+-- the users from before the history, as of their last update
+INSERT INTO user_history (tenant, acct, fullname, fullname_bidx, attributes, created_at, updated_at, valid_from)
+SELECT tenant, acct, fullname, fullname_bidx, attributes, created_at, updated_at, COALESCE(updated_at, now())
+FROM users;
+
+-- the re-encryption seals the same profile anew, which is no version. The
+-- versions keep the values as they were sealed, and so the data keys that
+-- sealed them.
+CREATE OR REPLACE FUNCTION user_history()
+RETURNS TRIGGER AS $$
+BEGIN
+	IF TG_OP = 'UPDATE' AND (current_setting('ui.reencrypt', true) = 'on' OR
+		(NEW.fullname, NEW.attributes) IS NOT DISTINCT FROM (OLD.fullname, OLD.attributes)) THEN
+		RETURN NULL;
+	END IF;
+	IF TG_OP IN ('UPDATE', 'DELETE') THEN
+		UPDATE user_history SET valid_to = now()
+		WHERE tenant = OLD.tenant AND acct = OLD.acct AND valid_to IS NULL;
+	END IF;
+	IF TG_OP IN ('INSERT', 'UPDATE') THEN
+		INSERT INTO user_history (tenant, acct, fullname, fullname_bidx, attributes, created_at, updated_at, valid_from)
+		VALUES (NEW.tenant, NEW.acct, NEW.fullname, NEW.fullname_bidx, NEW.attributes, NEW.created_at, NEW.updated_at, now());
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS user_history ON users;
+CREATE TRIGGER user_history AFTER INSERT OR UPDATE OR DELETE ON users
+FOR EACH ROW EXECUTE PROCEDURE user_history();
+
+ALTER TABLE user_history ENABLE ROW LEVEL SECURITY;
+ALTER TABLE user_history FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_isolation ON user_history;
+CREATE POLICY tenant_isolation ON user_history
+	USING (tenant = COALESCE(NULLIF(current_setting('ui.tenant', true), ''), tenant));
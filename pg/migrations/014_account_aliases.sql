@@ -0,0 +1,24 @@
+-- the former names of renamed accounts, reserved for them and resolving to
+-- them until expires_at
+CREATE TABLE IF NOT EXISTS account_aliases (
+	id         BIGSERIAL   PRIMARY KEY,
+	tenant     VARCHAR(32) NOT NULL,
+	alias      VARCHAR(20) NOT NULL,
+	acct       VARCHAR(20) NOT NULL,
+	renamed_at TIMESTAMP   NOT NULL,
+	expires_at TIMESTAMP   NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS account_aliases_tenant_alias_idx ON account_aliases (tenant, alias, expires_at);
+CREATE INDEX IF NOT EXISTS account_aliases_tenant_acct_idx ON account_aliases (tenant, acct);
+
+-- the memberships follow the account through a rename
+ALTER TABLE group_members DROP CONSTRAINT IF EXISTS group_members_tenant_acct_fkey;
+ALTER TABLE group_members ADD CONSTRAINT group_members_tenant_acct_fkey
+	FOREIGN KEY (tenant, acct) REFERENCES users (tenant, acct) ON DELETE CASCADE ON UPDATE CASCADE;
+
+ALTER TABLE account_aliases ENABLE ROW LEVEL SECURITY;
+ALTER TABLE account_aliases FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_isolation ON account_aliases;
+CREATE POLICY tenant_isolation ON account_aliases
+	USING (tenant = COALESCE(NULLIF(current_setting('ui.tenant', true), ''), tenant));
@@ -0,0 +1,11 @@
+-- fullname_bidx becomes the match key of the exact lookups: the case fold
+-- of the fullname, or its blind index once sealed. A plaintext fold is
+-- longer than a blind index.
+ALTER TABLE users ALTER COLUMN fullname_bidx TYPE TEXT;
+ALTER TABLE user_history ALTER COLUMN fullname_bidx TYPE TEXT;
+
+-- the keys are made anew, and the fullnames normalized, by the service,
+-- which has the Unicode tables and the index key; like the re-encryption,
+-- this changes how the users are stored, not the users
+SELECT set_config('ui.reencrypt', 'on', true);
+UPDATE users SET fullname_bidx = '';
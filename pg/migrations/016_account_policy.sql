@@ -0,0 +1,11 @@
+-- the form an account shares with the names it is visually confusable
+-- with; accountSkeleton of the service maps the names alike
+CREATE OR REPLACE FUNCTION acct_skeleton(acct TEXT)
+RETURNS TEXT AS $$
+	SELECT replace(replace(translate(lower(acct), '015i', 'olsl'), 'rn', 'm'), 'vv', 'w');
+$$ LANGUAGE SQL IMMUTABLE;
+
+-- the accounts differing in case only are confusable too. The service
+-- refuses new ones under a lock on the skeleton; the index is not unique,
+-- as such accounts may already exist.
+CREATE INDEX IF NOT EXISTS users_tenant_acct_skeleton_idx ON users (tenant, acct_skeleton(acct));
@@ -0,0 +1,14 @@
+-- announce the user events by id only: pg_notify fails on payloads of about
+-- 8000 bytes, which the attributes of a user alone may exceed. Listeners
+-- read the event from the outbox.
+CREATE OR REPLACE FUNCTION notify_user_event()
+RETURNS TRIGGER AS $$
+BEGIN
+	PERFORM pg_notify('user_events', json_build_object(
+		'id', NEW.id,
+		'type', NEW.type,
+		'tenant', NEW.tenant
+	)::text);
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
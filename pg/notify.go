@@ -0,0 +1,54 @@
+package pg
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	listenMinReconnect = time.Second
+	listenMaxReconnect = time.Minute
+	listenPing         = time.Second * 90
+)
+
+// Listen hands the payload of every notification on channel of the
+// primary to notify until ctx is done. The connection is reestablished
+// when lost, after which reconnected is called: notifications sent in
+// between are gone.
+func (pg *PG) Listen(ctx context.Context, channel string, notify func(payload string), reconnected func()) error {
+	l := pq.NewListener(pg.dsn, listenMinReconnect, listenMaxReconnect, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Listening on %v: %v", channel, err)
+		}
+	})
+	defer l.Close()
+
+	if err := l.Listen(channel); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(listenPing)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-l.Notify:
+			if n == nil {
+				reconnected()
+				continue
+			}
+			notify(n.Extra)
+		case <-ticker.C:
+			// detects a dead connection when the channel is quiet
+			go func() {
+				if err := l.Ping(); err != nil {
+					log.Printf("Listening on %v: %v", channel, err)
+				}
+			}()
+		}
+	}
+}
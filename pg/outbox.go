@@ -0,0 +1,41 @@
+package pg
+
+import (
+	"time"
+)
+
+const (
+	TableOutbox Table = "outbox"
+
+	FieldOutboxID            Field = "id"
+	FieldOutboxTenant        Field = "tenant"
+	FieldOutboxAccount       Field = "account"
+	FieldOutboxType          Field = "type"
+	FieldOutboxPayload       Field = "payload"
+	FieldOutboxStatus        Field = "status"
+	FieldOutboxAttempts      Field = "attempts"
+	FieldOutboxNextAttemptAt Field = "next_attempt_at"
+	FieldOutboxLastError     Field = "last_error"
+	FieldOutboxDeliveredAt   Field = "delivered_at"
+
+	OutboxPending   = "pending"
+	OutboxDelivered = "delivered"
+	OutboxDead      = "dead"
+)
+
+// OutboxMessage is a user lifecycle event waiting to be delivered to the
+// downstream systems. It is written in the transaction of the mutation it
+// announces, so it exists if and only if the mutation was committed.
+type OutboxMessage struct {
+	ID            int64      `json:"id"`
+	Tenant        string     `json:"tenant"`
+	Account       string     `json:"account"`
+	Type          string     `json:"type"`
+	Payload       JSONB      `json:"payload"`
+	CreatedAt     time.Time  `json:"created_at"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	LastError     string     `json:"last_error"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+}
@@ -20,24 +20,29 @@ const (
 )
 
 type PG struct {
-	db *gorm.DB
+	db   *gorm.DB
+	dsn  string
+	pool PoolConfig
+
+	replicas
+}
+
+func dataSource(host string, port int) string {
+	return fmt.Sprintf(
+		"host=%s port=%d dbname=%s user=%s password=%s sslmode=disable",
+		host,
+		port,
+		DBName,
+		Username,
+		Password,
+	)
 }
 
 func (pg *PG) Connect(host string, port int) {
 	var err error
 	var db *gorm.DB
 	for i := 0; i < RetryCount; i++ {
-		db, err = gorm.Open(
-			"postgres",
-			fmt.Sprintf(
-				"host=%s port=%d dbname=%s user=%s password=%s sslmode=disable",
-				host,
-				port,
-				DBName,
-				Username,
-				Password,
-			),
-		)
+		db, err = gorm.Open("postgres", dataSource(host, port))
 		if err != nil {
 			log.Print(err)
 			log.Print(fmt.Sprintf("Retry connecting to DB...%v", i+1))
@@ -48,7 +53,10 @@ func (pg *PG) Connect(host string, port int) {
 		panic(err)
 	}
 	pg.db = db
+	pg.dsn = dataSource(host, port)
+	pg.pool.apply(db.DB())
 	pg.initDBSQL()
+	pg.migrate()
 }
 
 func (pg *PG) DB() *gorm.DB {
@@ -56,6 +64,7 @@ func (pg *PG) DB() *gorm.DB {
 }
 
 func (pg *PG) Disconnect() {
+	pg.disconnectReplicas()
 	pg.db.Close()
 }
 
@@ -82,15 +91,31 @@ const (
 	FieldUserCreatedAt Field = "created_at"
 	FieldUserUpdatedAt Field = "updated_at"
 
+	// FieldUserAttributes holds the profile attributes as a JSON object.
+	FieldUserAttributes Field = "attributes"
+
+	// FieldUserTenant scopes the account, unique within its tenant only.
+	FieldUserTenant Field = "tenant"
+
+	// FieldUserFullnameIndex is the match key of the fullname, for its
+	// exact lookups: its case fold, or once FieldUserFullname is sealed,
+	// the blind index of that.
+	FieldUserFullnameIndex Field = "fullname_bidx"
+
+	// FieldUserFullnameMaxLen counts grapheme clusters, not bytes.
 	FieldUserFullnameMaxLen = 50
 )
 
 type User struct {
+	Tenant     string    `json:"tenant,omitempty"`
 	Acct       string    `json:"account"`
-	Pwd        string    `json:"password"`
+	Pwd        string    `json:"-"`
 	Fullname   string    `json:"fullname"`
 	Created_at time.Time `json:"created_at"`
 	Updated_at time.Time `json:"updated_at"`
+	Attributes JSONB     `json:"attributes,omitempty"`
+
+	FullnameIndex string `json:"-" gorm:"column:fullname_bidx"`
 }
 
 func (pg *PG) initDBSQL() {
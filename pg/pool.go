@@ -0,0 +1,169 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sort"
+	"time"
+)
+
+// PoolConfig bounds the connection pool of each database, the primary and
+// every replica alike. Zero values keep the defaults of database/sql.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+func (cfg *PoolConfig) apply(db *sql.DB) {
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
+}
+
+// PoolStats is sql.DBStats of one database.
+type PoolStats struct {
+	Database string `json:"database"`
+
+	MaxOpen int `json:"max_open"`
+	Open    int `json:"open"`
+	InUse   int `json:"in_use"`
+	Idle    int `json:"idle"`
+
+	WaitCount        int64   `json:"wait_count"`
+	WaitDurationSecs float64 `json:"wait_duration_seconds"`
+
+	MaxIdleClosed     int64 `json:"max_idle_closed"`
+	MaxIdleTimeClosed int64 `json:"max_idle_time_closed"`
+	MaxLifetimeClosed int64 `json:"max_lifetime_closed"`
+}
+
+func newPoolStats(name string, st sql.DBStats) PoolStats {
+	return PoolStats{
+		Database:          name,
+		MaxOpen:           st.MaxOpenConnections,
+		Open:              st.OpenConnections,
+		InUse:             st.InUse,
+		Idle:              st.Idle,
+		WaitCount:         st.WaitCount,
+		WaitDurationSecs:  st.WaitDuration.Seconds(),
+		MaxIdleClosed:     st.MaxIdleClosed,
+		MaxIdleTimeClosed: st.MaxIdleTimeClosed,
+		MaxLifetimeClosed: st.MaxLifetimeClosed,
+	}
+}
+
+const PrimaryName = "primary"
+
+// pools returns the pool of the primary and of every replica by name.
+func (pg *PG) pools() map[string]*sql.DB {
+	dbs := map[string]*sql.DB{}
+	if pg.db != nil {
+		dbs[PrimaryName] = pg.db.DB()
+	}
+
+	pg.replicas.mu.RLock()
+	defer pg.replicas.mu.RUnlock()
+	for _, r := range pg.replicas.list {
+		if r.db != nil {
+			dbs[r.addr] = r.db.DB()
+		}
+	}
+	return dbs
+}
+
+// SetPool configures the pools of the connected databases and of the
+// replicas connected later.
+func (pg *PG) SetPool(cfg PoolConfig) {
+	pg.pool = cfg
+	for _, db := range pg.pools() {
+		pg.pool.apply(db)
+	}
+}
+
+// PoolStats returns the statistics of the primary first, then of the
+// replicas.
+func (pg *PG) PoolStats() []PoolStats {
+	dbs := pg.pools()
+	names := []string{}
+	for name := range dbs {
+		if name != PrimaryName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if _, ok := dbs[PrimaryName]; ok {
+		names = append([]string{PrimaryName}, names...)
+	}
+
+	stats := []PoolStats{}
+	for _, name := range names {
+		stats = append(stats, newPoolStats(name, dbs[name].Stats()))
+	}
+	return stats
+}
+
+// saturated reports whether a pool ran out of connections between two
+// samples: callers waited for one, or every allowed connection is in use.
+func saturated(prev, cur sql.DBStats) bool {
+	if cur.WaitCount > prev.WaitCount {
+		return true
+	}
+	return cur.MaxOpenConnections > 0 && cur.InUse >= cur.MaxOpenConnections
+}
+
+// MonitorPool samples the pools every interval until ctx is done, and logs
+// a warning when one stays saturated for persist or longer.
+func (pg *PG) MonitorPool(ctx context.Context, interval, persist time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	prev := map[string]sql.DBStats{}
+	since := map[string]time.Time{}
+	warned := map[string]bool{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for name, db := range pg.pools() {
+				cur := db.Stats()
+				p, ok := prev[name]
+				prev[name] = cur
+				if !ok {
+					continue
+				}
+
+				if !saturated(p, cur) {
+					if warned[name] {
+						log.Printf("Connection pool of %v is no longer saturated", name)
+					}
+					delete(since, name)
+					delete(warned, name)
+					continue
+				}
+
+				if _, ok := since[name]; !ok {
+					since[name] = now
+				}
+				if !warned[name] && now.Sub(since[name]) >= persist {
+					warned[name] = true
+					log.Printf("Warning: connection pool of %v saturated for %v: %v/%v in use, %v waits for %v in total",
+						name, now.Sub(since[name]).Round(time.Second), cur.InUse, cur.MaxOpenConnections,
+						cur.WaitCount, cur.WaitDuration)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package pg
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/suite"
+)
+
+type _PoolSuite struct {
+	suite.Suite
+}
+
+func (s *_PoolSuite) SetupTest() {
+}
+
+func (s *_PoolSuite) TearDownTest() {
+}
+
+func (s *_PoolSuite) TestSaturated() {
+	s.Equal(false, saturated(sql.DBStats{}, sql.DBStats{InUse: 5}))
+	s.Equal(false, saturated(sql.DBStats{WaitCount: 3}, sql.DBStats{MaxOpenConnections: 10, InUse: 9, WaitCount: 3}))
+	s.Equal(true, saturated(sql.DBStats{WaitCount: 3}, sql.DBStats{MaxOpenConnections: 10, InUse: 9, WaitCount: 4}))
+	s.Equal(true, saturated(sql.DBStats{}, sql.DBStats{MaxOpenConnections: 10, InUse: 10}))
+}
+
+func (s *_PoolSuite) TestSetPool() {
+	// sql.Open does not connect, so the pools need no server
+	db, err := sql.Open("postgres", dataSource("localhost", 5432))
+	s.Equal(nil, err)
+	defer db.Close()
+
+	pg := &PG{}
+	pg.SetPool(PoolConfig{MaxOpenConns: 7, ConnMaxLifetime: time.Minute})
+	pg.pool.apply(db)
+	s.Equal(7, db.Stats().MaxOpenConnections)
+
+	s.Equal([]PoolStats{}, pg.PoolStats())
+
+	// the primary comes first, then the replicas by address
+	pg.db, _ = gorm.Open("postgres", db)
+	for _, addr := range []string{"r2:5432", "r1:5432"} {
+		r := &replica{addr: addr}
+		r.db, _ = gorm.Open("postgres", db)
+		pg.replicas.list = append(pg.replicas.list, r)
+	}
+	names := []string{}
+	for _, st := range pg.PoolStats() {
+		names = append(names, st.Database)
+		s.Equal(7, st.MaxOpen)
+	}
+	s.Equal([]string{PrimaryName, "r1:5432", "r2:5432"}, names)
+}
+
+func TestPoolSuite(t *testing.T) {
+	suite.Run(t, new(_PoolSuite))
+}
@@ -0,0 +1,259 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// DefaultMaxReplicaLag is the replay lag beyond which a replica stops
+	// serving reads.
+	DefaultMaxReplicaLag = time.Second * 5
+
+	// DefaultReadYourWritesWindow is how long the reads of an account stay
+	// on the primary after it was written.
+	DefaultReadYourWritesWindow = time.Second * 5
+)
+
+// replica is a read-only standby of the primary.
+type replica struct {
+	addr    string
+	db      *gorm.DB
+	healthy int32
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+func (r *replica) setHealthy(ok bool) {
+	var v int32
+	if ok {
+		v = 1
+	}
+	if atomic.SwapInt32(&r.healthy, v) != v {
+		if ok {
+			log.Printf("Replica %v is back in service", r.addr)
+		} else {
+			log.Printf("Replica %v is out of service", r.addr)
+		}
+	}
+}
+
+// replicas routes reads away from the primary. Without any replica every
+// read goes to the primary.
+type replicas struct {
+	mu   sync.RWMutex
+	list []*replica
+	next uint32
+
+	// MaxReplicaLag and ReadYourWritesWindow default to the constants
+	// above when zero.
+	MaxReplicaLag        time.Duration
+	ReadYourWritesWindow time.Duration
+
+	// written records when each key, an account, was last written.
+	writtenMu sync.Mutex
+	written   map[string]time.Time
+}
+
+// ConnectReplica adds a read replica. A replica that cannot be reached
+// is added out of service and taken in by MonitorReplicas once it is.
+func (pg *PG) ConnectReplica(host string, port int) error {
+	sqlDB, err := sql.Open("postgres", dataSource(host, port))
+	if err != nil {
+		return err
+	}
+
+	pg.pool.apply(sqlDB)
+
+	r := &replica{addr: fmt.Sprintf("%v:%v", host, port)}
+	if r.db, err = gorm.Open("postgres", sqlDB); err != nil {
+		log.Print(err)
+	} else {
+		r.healthy = 1
+	}
+
+	pg.replicas.mu.Lock()
+	pg.replicas.list = append(pg.replicas.list, r)
+	pg.replicas.mu.Unlock()
+	return nil
+}
+
+func (pg *PG) disconnectReplicas() {
+	pg.replicas.mu.Lock()
+	defer pg.replicas.mu.Unlock()
+
+	for _, r := range pg.replicas.list {
+		r.db.Close()
+	}
+	pg.replicas.list = nil
+}
+
+func (rs *replicas) maxLag() time.Duration {
+	if rs.MaxReplicaLag > 0 {
+		return rs.MaxReplicaLag
+	}
+	return DefaultMaxReplicaLag
+}
+
+func (rs *replicas) window() time.Duration {
+	if rs.ReadYourWritesWindow > 0 {
+		return rs.ReadYourWritesWindow
+	}
+	return DefaultReadYourWritesWindow
+}
+
+// MarkWritten pins the reads of keys to the primary for the read-your-writes
+// window, until the replicas have caught up with the write.
+func (pg *PG) MarkWritten(keys ...string) {
+	rs := &pg.replicas
+	rs.mu.RLock()
+	none := len(rs.list) == 0
+	rs.mu.RUnlock()
+	if none {
+		return
+	}
+
+	now := time.Now()
+	rs.writtenMu.Lock()
+	defer rs.writtenMu.Unlock()
+
+	if rs.written == nil {
+		rs.written = map[string]time.Time{}
+	}
+	for _, key := range keys {
+		if key != "" {
+			rs.written[key] = now
+		}
+	}
+}
+
+func (rs *replicas) recentlyWritten(keys []string) bool {
+	rs.writtenMu.Lock()
+	defer rs.writtenMu.Unlock()
+
+	for _, key := range keys {
+		if at, ok := rs.written[key]; ok && time.Since(at) < rs.window() {
+			return true
+		}
+	}
+	return false
+}
+
+// pick returns a healthy replica in turn, or nil.
+func (rs *replicas) pick() *replica {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	n := uint32(len(rs.list))
+	if n == 0 {
+		return nil
+	}
+	start := atomic.AddUint32(&rs.next, 1)
+	for i := uint32(0); i < n; i++ {
+		if r := rs.list[(start+i)%n]; r.isHealthy() {
+			return r
+		}
+	}
+	return nil
+}
+
+// ReadContext is WithContext for read-only statements. They go to a
+// healthy replica unless one of keys was written within the read-your-writes
+// window; they fall back to the primary when no replica is healthy.
+func (pg *PG) ReadContext(ctx context.Context, keys ...string) *gorm.DB {
+	if pg.replicas.recentlyWritten(keys) {
+		return pg.WithContext(ctx)
+	}
+
+	r := pg.replicas.pick()
+	if r == nil {
+		return pg.WithContext(ctx)
+	}
+
+	db, err := gorm.Open(r.db.Dialect().GetName(), ctxDB{ctx: ctx, db: r.db.DB()})
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+// ReadTransaction runs fc in a read-only, repeatable read transaction
+// bound to ctx, on a healthy replica or else the primary, for the reads
+// that need one connection or one snapshot throughout, such as a cursor.
+func (pg *PG) ReadTransaction(ctx context.Context, fc func(tx *gorm.DB) error) error {
+	db := pg.db
+	if r := pg.replicas.pick(); r != nil {
+		db = r.db
+	}
+	return transaction(ctx, db, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}, fc)
+}
+
+// checkReplica reports whether r answers and replays the primary within
+// the allowed lag.
+func (pg *PG) checkReplica(ctx context.Context, r *replica) bool {
+	if r.db == nil {
+		return false
+	}
+
+	// a replica that has replayed all it received is not lagging, however
+	// long ago the last transaction on an idle primary was
+	var lag float64
+	row := r.db.DB().QueryRowContext(ctx, `
+		SELECT CASE
+			WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+		END`)
+	if err := row.Scan(&lag); err != nil {
+		log.Printf("Replica %v: %v", r.addr, err)
+		return false
+	}
+	return time.Duration(lag*float64(time.Second)) <= pg.replicas.maxLag()
+}
+
+// MonitorReplicas checks the replicas every interval until ctx is done,
+// taking them out of service while they are down or lagging.
+func (pg *PG) MonitorReplicas(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pg.replicas.mu.RLock()
+		list := append([]*replica{}, pg.replicas.list...)
+		pg.replicas.mu.RUnlock()
+
+		for _, r := range list {
+			checkCtx, cancel := context.WithTimeout(ctx, interval)
+			r.setHealthy(pg.checkReplica(checkCtx, r))
+			cancel()
+		}
+
+		pg.replicas.forget()
+	}
+}
+
+// forget drops the writes older than the read-your-writes window.
+func (rs *replicas) forget() {
+	rs.writtenMu.Lock()
+	defer rs.writtenMu.Unlock()
+
+	for key, at := range rs.written {
+		if time.Since(at) >= rs.window() {
+			delete(rs.written, key)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package pg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type _ReplicaSuite struct {
+	suite.Suite
+
+	pg *PG
+	r1 *replica
+	r2 *replica
+}
+
+func (s *_ReplicaSuite) SetupTest() {
+	s.r1 = &replica{addr: "r1:5432", healthy: 1}
+	s.r2 = &replica{addr: "r2:5432", healthy: 1}
+	s.pg = &PG{}
+	s.pg.replicas.list = []*replica{s.r1, s.r2}
+}
+
+func (s *_ReplicaSuite) TearDownTest() {
+}
+
+func (s *_ReplicaSuite) TestPick() {
+	picked := map[*replica]int{}
+	for i := 0; i < 4; i++ {
+		picked[s.pg.replicas.pick()]++
+	}
+	s.Equal(map[*replica]int{s.r1: 2, s.r2: 2}, picked)
+
+	// failover to the healthy one, then to the primary
+	s.r1.setHealthy(false)
+	for i := 0; i < 4; i++ {
+		s.Equal(s.r2, s.pg.replicas.pick())
+	}
+	s.r2.setHealthy(false)
+	s.Nil(s.pg.replicas.pick())
+
+	s.r1.setHealthy(true)
+	s.Equal(s.r1, s.pg.replicas.pick())
+
+	s.Nil((&PG{}).replicas.pick())
+}
+
+func (s *_ReplicaSuite) TestReadYourWrites() {
+	s.pg.ReadYourWritesWindow = 50 * time.Millisecond
+
+	s.pg.MarkWritten("kobe_bryant", "")
+	s.Equal(true, s.pg.replicas.recentlyWritten([]string{"kobe_bryant"}))
+	s.Equal(true, s.pg.replicas.recentlyWritten([]string{"lebron_james", "kobe_bryant"}))
+	s.Equal(false, s.pg.replicas.recentlyWritten([]string{"lebron_james"}))
+	s.Equal(false, s.pg.replicas.recentlyWritten([]string{""}))
+	s.Equal(false, s.pg.replicas.recentlyWritten(nil))
+
+	time.Sleep(60 * time.Millisecond)
+	s.Equal(false, s.pg.replicas.recentlyWritten([]string{"kobe_bryant"}))
+
+	s.pg.replicas.forget()
+	s.Equal(0, len(s.pg.replicas.written))
+
+	// without replicas every read is on the primary already
+	primary := &PG{}
+	primary.MarkWritten("kobe_bryant")
+	s.Equal(0, len(primary.replicas.written))
+}
+
+func TestReplicaSuite(t *testing.T) {
+	suite.Run(t, new(_ReplicaSuite))
+}
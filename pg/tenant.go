@@ -0,0 +1,30 @@
+package pg
+
+import (
+	"time"
+)
+
+const (
+	TableTenants Table = "tenants"
+
+	FieldTenantID   Field = "id"
+	FieldTenantName Field = "name"
+
+	FieldTenantIDMaxLen   = 32
+	FieldTenantNameMaxLen = 64
+
+	// DefaultTenant holds the accounts that signed up without naming a
+	// tenant, and every account from before tenants.
+	DefaultTenant = "default"
+
+	// TenantSetting is the setting the row level security policies scope
+	// the rows of a transaction by. Unset, they do not restrict.
+	TenantSetting = "ui.tenant"
+)
+
+// Tenant is a customer team with its own namespace of accounts and groups.
+type Tenant struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,71 @@
+package pg
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	TableWebhooks          Table = "webhooks"
+	TableWebhookDeliveries Table = "webhook_deliveries"
+
+	FieldWebhookID         Field = "id"
+	FieldWebhookURL        Field = "url"
+	FieldWebhookSecret     Field = "secret"
+	FieldWebhookEventTypes Field = "event_types"
+	FieldWebhookActive     Field = "active"
+	FieldWebhookFailures   Field = "failures"
+	FieldWebhookOpenUntil  Field = "open_until"
+	FieldWebhookUpdatedAt  Field = "updated_at"
+
+	FieldDeliveryID            Field = "id"
+	FieldDeliveryWebhookID     Field = "webhook_id"
+	FieldDeliveryOutboxID      Field = "outbox_id"
+	FieldDeliveryPayload       Field = "payload"
+	FieldDeliveryStatus        Field = "status"
+	FieldDeliveryAttempts      Field = "attempts"
+	FieldDeliveryNextAttemptAt Field = "next_attempt_at"
+	FieldDeliveryLastStatus    Field = "last_status"
+	FieldDeliveryLastError     Field = "last_error"
+	FieldDeliveryDeliveredAt   Field = "delivered_at"
+
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryDead      = "dead"
+)
+
+// Webhook is a subscription of an HTTP endpoint to the user lifecycle
+// events. An empty EventTypes subscribes to every type. Failures counts
+// the consecutive failed deliveries; while OpenUntil is ahead the circuit
+// is open and nothing is sent.
+type Webhook struct {
+	ID         int64          `json:"id"`
+	URL        string         `json:"url"`
+	Secret     string         `json:"-"`
+	EventTypes pq.StringArray `json:"event_types"`
+	Active     bool           `json:"active"`
+	Failures   int            `json:"failures"`
+	OpenUntil  *time.Time     `json:"open_until"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+// WebhookDelivery is one event queued for one webhook, with the outcome of
+// its last attempt. A manual redelivery is a new delivery pointing to the
+// original with RedeliveryOf.
+type WebhookDelivery struct {
+	ID            int64      `json:"id"`
+	WebhookID     int64      `json:"webhook_id"`
+	OutboxID      int64      `json:"outbox_id"`
+	RedeliveryOf  *int64     `json:"redelivery_of"`
+	EventType     string     `json:"event_type"`
+	Payload       JSONB      `json:"payload"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	LastStatus    int        `json:"last_status"`
+	LastError     string     `json:"last_error"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+}
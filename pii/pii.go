@@ -0,0 +1,241 @@
+// Package pii seals the columns holding personal data with envelope
+// encryption. Each value is sealed with AES-256-GCM under a data key, and
+// the data keys are stored wrapped by a master key that never leaves the
+// service. Exact matches are looked up by a blind index, an HMAC of the
+// value under a key of its own, stored next to the ciphertext.
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// Prefix starts every sealed value, followed by the ID of its data key
+	// and a colon. Values without it are plaintext from before encryption.
+	Prefix = "pii:v1:"
+
+	// KeySize is the size of the master and data keys.
+	KeySize = 32
+)
+
+// ErrNoKey is returned for a value sealed with a data key the keyring does
+// not hold.
+var ErrNoKey = errors.New("the data key of the value is unknown")
+
+// NewKey returns a random key.
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %v bytes, not %v", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed value too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], aad)
+}
+
+// Master wraps the data keys.
+type Master struct {
+	aead cipher.AEAD
+	id   string
+}
+
+// NewMaster returns the master of key.
+func NewMaster(key []byte) (*Master, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &Master{aead: aead, id: hex.EncodeToString(sum[:8])}, nil
+}
+
+// ID identifies the master key, without revealing it, so the keys it
+// wrapped are told from those of another.
+func (m *Master) ID() string {
+	return m.id
+}
+
+// Wrap seals a data key.
+func (m *Master) Wrap(key []byte) (string, error) {
+	sealed, err := seal(m.aead, key, []byte(m.id))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Unwrap opens a data key wrapped by Wrap.
+func (m *Master) Unwrap(wrapped string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	key, err := open(m.aead, sealed, []byte(m.id))
+	if err != nil {
+		return nil, errors.New("the key is not wrapped by this master key")
+	}
+	return key, nil
+}
+
+// Keyring holds the unwrapped data keys. Values are sealed with the
+// active one, the latest added, and opened with whichever sealed them. It
+// is safe for concurrent use.
+type Keyring struct {
+	mu     sync.RWMutex
+	active int64
+	keys   map[int64]cipher.AEAD
+	index  []byte
+}
+
+// NewKeyring returns a keyring of the blind index key index and no data
+// keys.
+func NewKeyring(index []byte) *Keyring {
+	return &Keyring{keys: map[int64]cipher.AEAD{}, index: index}
+}
+
+// Add adds the data key of ID id, which becomes the active one unless a
+// later one is held.
+func (kr *Keyring) Add(id int64, key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kr.keys[id] = aead
+	if id > kr.active {
+		kr.active = id
+	}
+	return nil
+}
+
+// Has reports whether the keyring holds the data key of ID id.
+func (kr *Keyring) Has(id int64) bool {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.keys[id] != nil
+}
+
+// Active returns the ID of the active data key, 0 for none.
+func (kr *Keyring) Active() int64 {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.active
+}
+
+// ActivePrefix returns how the values sealed with the active data key
+// start.
+func (kr *Keyring) ActivePrefix() string {
+	return Prefix + strconv.FormatInt(kr.Active(), 10) + ":"
+}
+
+// Seal seals plaintext of column with the active data key. The column is
+// authenticated too, so a value cannot be moved to another column.
+func (kr *Keyring) Seal(column, plaintext string) (string, error) {
+	kr.mu.RLock()
+	id, aead := kr.active, kr.keys[kr.active]
+	kr.mu.RUnlock()
+	if aead == nil {
+		return "", errors.New("no data key")
+	}
+
+	sealed, err := seal(aead, []byte(plaintext), []byte(column))
+	if err != nil {
+		return "", err
+	}
+	return Prefix + strconv.FormatInt(id, 10) + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open opens a value of column sealed by Seal. A value that is not sealed
+// is returned as it is.
+func (kr *Keyring) Open(column, value string) (string, error) {
+	id, sealed, ok := parse(value)
+	if !ok {
+		return value, nil
+	}
+
+	kr.mu.RLock()
+	aead := kr.keys[id]
+	kr.mu.RUnlock()
+	if aead == nil {
+		return "", ErrNoKey
+	}
+
+	b, err := base64.RawStdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(aead, b, []byte(column))
+	if err != nil {
+		return "", fmt.Errorf("the value of %v does not open: %w", column, err)
+	}
+	return string(plaintext), nil
+}
+
+// BlindIndex returns the blind index of plaintext of column. Equal values
+// of a column have equal indexes, and nothing else can be told of them.
+func (kr *Keyring) BlindIndex(column, plaintext string) string {
+	mac := hmac.New(sha256.New, kr.index)
+	mac.Write([]byte(column))
+	mac.Write([]byte{0})
+	mac.Write([]byte(plaintext))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// IsSealed reports whether value is sealed.
+func IsSealed(value string) bool {
+	_, _, ok := parse(value)
+	return ok
+}
+
+func parse(value string) (int64, string, bool) {
+	if !strings.HasPrefix(value, Prefix) {
+		return 0, "", false
+	}
+	rest := value[len(Prefix):]
+	i := strings.IndexByte(rest, ':')
+	if i < 0 {
+		return 0, "", false
+	}
+	id, err := strconv.ParseInt(rest[:i], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return id, rest[i+1:], true
+}
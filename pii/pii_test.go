@@ -0,0 +1,115 @@
+package pii_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/dontang97/ui/pii"
+	"github.com/stretchr/testify/suite"
+)
+
+type _Suite struct {
+	suite.Suite
+
+	master *pii.Master
+	kr     *pii.Keyring
+}
+
+func (s *_Suite) SetupTest() {
+	var err error
+	s.master, err = pii.NewMaster(bytes.Repeat([]byte{1}, pii.KeySize))
+	s.Nil(err)
+	s.kr = pii.NewKeyring(bytes.Repeat([]byte{2}, pii.KeySize))
+	s.Nil(s.kr.Add(1, bytes.Repeat([]byte{3}, pii.KeySize)))
+}
+
+func (s *_Suite) TestSealOpen() {
+	sealed, err := s.kr.Seal("fullname", "Kobe Bryant")
+	s.Nil(err)
+	s.True(strings.HasPrefix(sealed, "pii:v1:1:"))
+	s.True(pii.IsSealed(sealed))
+	s.NotContains(sealed, "Kobe")
+
+	again, err := s.kr.Seal("fullname", "Kobe Bryant")
+	s.Nil(err)
+	s.NotEqual(sealed, again)
+
+	plaintext, err := s.kr.Open("fullname", sealed)
+	s.Nil(err)
+	s.Equal("Kobe Bryant", plaintext)
+
+	// bound to its column
+	_, err = s.kr.Open("email", sealed)
+	s.NotNil(err)
+
+	// plaintext from before encryption
+	plaintext, err = s.kr.Open("fullname", "LeBron James")
+	s.Nil(err)
+	s.Equal("LeBron James", plaintext)
+	s.False(pii.IsSealed("LeBron James"))
+}
+
+func (s *_Suite) TestRotation() {
+	old, err := s.kr.Seal("fullname", "Kobe Bryant")
+	s.Nil(err)
+
+	s.Nil(s.kr.Add(2, bytes.Repeat([]byte{4}, pii.KeySize)))
+	s.Equal(int64(2), s.kr.Active())
+	s.Equal("pii:v1:2:", s.kr.ActivePrefix())
+
+	sealed, err := s.kr.Seal("fullname", "Kobe Bryant")
+	s.Nil(err)
+	s.True(strings.HasPrefix(sealed, s.kr.ActivePrefix()))
+
+	// the old values still open
+	for _, v := range []string{old, sealed} {
+		plaintext, err := s.kr.Open("fullname", v)
+		s.Nil(err)
+		s.Equal("Kobe Bryant", plaintext)
+	}
+
+	// a key added late does not take over
+	s.Nil(s.kr.Add(1, bytes.Repeat([]byte{3}, pii.KeySize)))
+	s.Equal(int64(2), s.kr.Active())
+
+	_, err = pii.NewKeyring(nil).Open("fullname", sealed)
+	s.Equal(pii.ErrNoKey, err)
+}
+
+func (s *_Suite) TestBlindIndex() {
+	idx := s.kr.BlindIndex("fullname", "Kobe Bryant")
+	s.Equal(64, len(idx))
+	s.Equal(idx, s.kr.BlindIndex("fullname", "Kobe Bryant"))
+	s.NotEqual(idx, s.kr.BlindIndex("fullname", "kobe bryant"))
+	s.NotEqual(idx, s.kr.BlindIndex("email", "Kobe Bryant"))
+
+	// data key rotation keeps the indexes
+	s.Nil(s.kr.Add(2, bytes.Repeat([]byte{4}, pii.KeySize)))
+	s.Equal(idx, s.kr.BlindIndex("fullname", "Kobe Bryant"))
+	s.NotEqual(idx, pii.NewKeyring(bytes.Repeat([]byte{5}, pii.KeySize)).BlindIndex("fullname", "Kobe Bryant"))
+}
+
+func (s *_Suite) TestWrap() {
+	key, err := pii.NewKey()
+	s.Nil(err)
+
+	wrapped, err := s.master.Wrap(key)
+	s.Nil(err)
+	unwrapped, err := s.master.Unwrap(wrapped)
+	s.Nil(err)
+	s.Equal(key, unwrapped)
+
+	other, err := pii.NewMaster(bytes.Repeat([]byte{9}, pii.KeySize))
+	s.Nil(err)
+	s.NotEqual(s.master.ID(), other.ID())
+	_, err = other.Unwrap(wrapped)
+	s.NotNil(err)
+
+	_, err = pii.NewMaster([]byte("short"))
+	s.NotNil(err)
+}
+
+func TestPII(t *testing.T) {
+	suite.Run(t, new(_Suite))
+}
@@ -1,8 +1,12 @@
 package router
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"expvar"
 	"log"
 	"net/http"
+	"regexp"
 	"strings"
 	"time"
 
@@ -19,14 +23,73 @@ const (
 type API interface {
 	// v1 api
 	Users(http.ResponseWriter, *http.Request)
+	ImportUsers(http.ResponseWriter, *http.Request)
+	ExportUsers(http.ResponseWriter, *http.Request)
+	Search(http.ResponseWriter, *http.Request)
 	FullnameQuery(http.ResponseWriter, *http.Request)
 	UserInfo(http.ResponseWriter, *http.Request)
+	UserHistory(http.ResponseWriter, *http.Request)
+	RevertUser(http.ResponseWriter, *http.Request)
+	RenameUser(http.ResponseWriter, *http.Request)
+
+	// ResolveAlias sends the requests naming an account by a former name
+	// to its current one.
+	ResolveAlias(http.Handler) http.Handler
 	SignUp(http.ResponseWriter, *http.Request)
 	Delete(http.ResponseWriter, *http.Request)
 	Update(http.ResponseWriter, *http.Request)
 	Login(http.ResponseWriter, *http.Request)
+	Events(http.ResponseWriter, *http.Request)
+	ExportUser(http.ResponseWriter, *http.Request)
+	EraseUser(http.ResponseWriter, *http.Request)
+
+	// admin api
+	Tenants(http.ResponseWriter, *http.Request)
+	AddTenant(http.ResponseWriter, *http.Request)
+	Audit(http.ResponseWriter, *http.Request)
+	AuditExport(http.ResponseWriter, *http.Request)
+	DBStats(http.ResponseWriter, *http.Request)
+	Webhooks(http.ResponseWriter, *http.Request)
+	AddWebhook(http.ResponseWriter, *http.Request)
+	WebhookInfo(http.ResponseWriter, *http.Request)
+	UpdateWebhook(http.ResponseWriter, *http.Request)
+	DeleteWebhook(http.ResponseWriter, *http.Request)
+	WebhookDeliveries(http.ResponseWriter, *http.Request)
+	Redeliver(http.ResponseWriter, *http.Request)
+	Groups(http.ResponseWriter, *http.Request)
+	AddGroup(http.ResponseWriter, *http.Request)
+	GroupInfo(http.ResponseWriter, *http.Request)
+	UpdateGroup(http.ResponseWriter, *http.Request)
+	DeleteGroup(http.ResponseWriter, *http.Request)
+	GroupMembers(http.ResponseWriter, *http.Request)
+	AddGroupMember(http.ResponseWriter, *http.Request)
+	RemoveGroupMember(http.ResponseWriter, *http.Request)
+	AddSubgroup(http.ResponseWriter, *http.Request)
+	RemoveSubgroup(http.ResponseWriter, *http.Request)
 }
 
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestIDMiddleFunc tags each request with the X-Request-ID of the client,
+// or a random one, and echoes it in the response.
+var RequestIDMiddleFunc mux.MiddlewareFunc = func(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				log.Print(err)
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(ui.WithRequestID(r.Context(), id)))
+	})
+}
+
+// JWTMiddleFunc admits the bearers of a valid JWT. The request acts in the
+// tenant of the JWT, or in the one named by X-Tenant for a super admin.
 var JWTMiddleFunc mux.MiddlewareFunc = func(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
@@ -40,13 +103,26 @@ var JWTMiddleFunc mux.MiddlewareFunc = func(next http.Handler) http.Handler {
 		}
 
 		token := auth[0][len("Bearer "):]
-		if err := secret.VerifyUserJWT(token, acct); err != nil {
+		claims, err := secret.ParseUserJWT(token)
+		tenant := r.Header.Get("X-Tenant")
+		if err == nil && acct != "" && claims.Acct != acct &&
+			!claims.HasRole(secret.RoleAdmin) && !claims.HasRole(secret.RoleSuperAdmin) {
+			// only admins may act on other accounts
+			err = secret.NewJWTError(secret.JWTAcctNotMatchError)
+		}
+		if err == nil && tenant != "" && tenant != ui.ClaimsTenant(claims) &&
+			!claims.HasRole(secret.RoleSuperAdmin) {
+			// only super admins may act in other tenants
+			err = secret.NewJWTError(secret.JWTTenantNotMatchError)
+		}
+		if err != nil {
 			if je, ok := err.(*secret.JWTError); ok {
 				switch je.Code() {
 				case secret.JWTUnknownError,
 					secret.JWTNotActiveError,
 					secret.JWTExpiredError,
 					secret.JWTAcctNotMatchError,
+					secret.JWTTenantNotMatchError,
 					secret.JWTNotAuthError:
 					ui.WriteJsonResponse(ui.StatusNoAuth, map[string]string{"error": je.Error()}, w)
 					return
@@ -58,12 +134,52 @@ var JWTMiddleFunc mux.MiddlewareFunc = func(next http.Handler) http.Handler {
 			return
 		}
 
+		ctx := secret.NewContext(r.Context(), claims)
+		if tenant != "" {
+			ctx = ui.WithTenant(ctx, tenant)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// AdminMiddleFunc admits only callers whose JWT, checked by JWTMiddleFunc,
+// carries secret.RoleAdmin or secret.RoleSuperAdmin.
+var AdminMiddleFunc mux.MiddlewareFunc = func(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := secret.FromContext(r.Context())
+		if !ok || !(claims.HasRole(secret.RoleAdmin) || claims.HasRole(secret.RoleSuperAdmin)) {
+			ui.WriteJsonResponse(ui.StatusNoAuth,
+				map[string]string{"error": "The admin role is required"}, w)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
+// SuperAdminMiddleFunc admits only callers whose JWT, checked by
+// JWTMiddleFunc, carries secret.RoleSuperAdmin. It guards the endpoints
+// that span the tenants.
+var SuperAdminMiddleFunc mux.MiddlewareFunc = func(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := secret.FromContext(r.Context())
+		if !ok || !claims.HasRole(secret.RoleSuperAdmin) {
+			ui.WriteJsonResponse(ui.StatusNoAuth,
+				map[string]string{"error": "The super admin role is required"}, w)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// connContext hands the connection to the handlers, so the event streams
+// can push their write deadline past WriteTimeout.
+var connContext = ui.WithConn
+
 func Route(api API) *http.Server {
 	root := mux.NewRouter()
+	root.Use(RequestIDMiddleFunc)
 	ui := root.PathPrefix("/ui").Subrouter()
 
 	ui.HandleFunc("", func(w http.ResponseWriter, _ *http.Request) {
@@ -81,15 +197,85 @@ func Route(api API) *http.Server {
 	users := v1.PathPrefix("/users").Subrouter()
 	users.Use(JWTMiddleFunc)
 	users.HandleFunc("", api.Users).Methods(http.MethodGet)
+	users.HandleFunc("/search", api.Search).Methods(http.MethodGet)
+
+	imports := users.PathPrefix("/import").Subrouter()
+	imports.Use(AdminMiddleFunc)
+	imports.HandleFunc("", api.ImportUsers).Methods(http.MethodPost)
+
+	exports := users.PathPrefix("/export").Subrouter()
+	exports.Use(AdminMiddleFunc)
+	exports.HandleFunc("", api.ExportUsers).Methods(http.MethodGet)
 
 	user := v1.PathPrefix("/user").Subrouter()
 	user.Use(JWTMiddleFunc)
 	user.HandleFunc("", api.FullnameQuery).Queries("fullname", "{fullname}")
 
 	acct := user.PathPrefix("/{acct:[A-Za-z0-9_]{8,20}}").Subrouter()
+	acct.Use(api.ResolveAlias)
 	acct.HandleFunc("", api.UserInfo).Methods(http.MethodGet)
 	acct.HandleFunc("", api.Delete).Methods(http.MethodDelete)
 	acct.HandleFunc("", api.Update).Methods(http.MethodPut)
+	acct.HandleFunc("/export", api.ExportUser).Methods(http.MethodGet)
+	acct.HandleFunc("/erase", api.EraseUser).Methods(http.MethodPost)
+	acct.HandleFunc("/history", api.UserHistory).Methods(http.MethodGet)
+	acct.HandleFunc("/rename", api.RenameUser).Methods(http.MethodPost)
+
+	revert := acct.PathPrefix("/history/{version:[0-9]{1,18}}/revert").Subrouter()
+	revert.Use(AdminMiddleFunc)
+	revert.HandleFunc("", api.RevertUser).Methods(http.MethodPost)
+
+	// the events carry whole profiles, which only the admins see
+	evts := v1.PathPrefix("/events").Subrouter()
+	evts.Use(JWTMiddleFunc, AdminMiddleFunc)
+	evts.HandleFunc("", api.Events).Methods(http.MethodGet)
+
+	tenants := v1.PathPrefix("/tenants").Subrouter()
+	tenants.Use(JWTMiddleFunc, SuperAdminMiddleFunc)
+	tenants.HandleFunc("", api.Tenants).Methods(http.MethodGet)
+	tenants.HandleFunc("", api.AddTenant).Methods(http.MethodPost)
+
+	audit := v1.PathPrefix("/audit").Subrouter()
+	audit.Use(JWTMiddleFunc, SuperAdminMiddleFunc)
+	audit.HandleFunc("", api.Audit).Methods(http.MethodGet)
+	audit.HandleFunc("/export", api.AuditExport).Methods(http.MethodGet)
+
+	db := v1.PathPrefix("/db").Subrouter()
+	db.Use(JWTMiddleFunc, SuperAdminMiddleFunc)
+	db.HandleFunc("/stats", api.DBStats).Methods(http.MethodGet)
+
+	webhooks := v1.PathPrefix("/webhooks").Subrouter()
+	webhooks.Use(JWTMiddleFunc, SuperAdminMiddleFunc)
+	webhooks.HandleFunc("", api.Webhooks).Methods(http.MethodGet)
+	webhooks.HandleFunc("", api.AddWebhook).Methods(http.MethodPost)
+
+	webhook := webhooks.PathPrefix("/{id:[0-9]{1,18}}").Subrouter()
+	webhook.HandleFunc("", api.WebhookInfo).Methods(http.MethodGet)
+	webhook.HandleFunc("", api.UpdateWebhook).Methods(http.MethodPut)
+	webhook.HandleFunc("", api.DeleteWebhook).Methods(http.MethodDelete)
+	webhook.HandleFunc("/deliveries", api.WebhookDeliveries).Methods(http.MethodGet)
+	webhook.HandleFunc("/deliveries/{delivery:[0-9]{1,18}}/redeliver", api.Redeliver).Methods(http.MethodPost)
+
+	groups := v1.PathPrefix("/groups").Subrouter()
+	groups.Use(JWTMiddleFunc, AdminMiddleFunc)
+	groups.HandleFunc("", api.Groups).Methods(http.MethodGet)
+	groups.HandleFunc("", api.AddGroup).Methods(http.MethodPost)
+
+	group := groups.PathPrefix("/{group:[A-Za-z0-9_.-]{1,64}}").Subrouter()
+	group.HandleFunc("", api.GroupInfo).Methods(http.MethodGet)
+	group.HandleFunc("", api.UpdateGroup).Methods(http.MethodPut)
+	group.HandleFunc("", api.DeleteGroup).Methods(http.MethodDelete)
+	group.HandleFunc("/members", api.GroupMembers).Methods(http.MethodGet)
+	group.HandleFunc("/members/{member:[A-Za-z0-9_]{8,20}}", api.AddGroupMember).Methods(http.MethodPut)
+	group.HandleFunc("/members/{member:[A-Za-z0-9_]{8,20}}", api.RemoveGroupMember).Methods(http.MethodDelete)
+	group.HandleFunc("/groups/{child:[A-Za-z0-9_.-]{1,64}}", api.AddSubgroup).Methods(http.MethodPut)
+	group.HandleFunc("/groups/{child:[A-Za-z0-9_.-]{1,64}}", api.RemoveSubgroup).Methods(http.MethodDelete)
+
+	// the variables published with expvar, the database pools among them,
+	// and the command line with its admin accounts: super admins only
+	debug := root.PathPrefix("/debug").Subrouter()
+	debug.Use(JWTMiddleFunc, SuperAdminMiddleFunc)
+	debug.Handle("/vars", expvar.Handler()).Methods(http.MethodGet)
 
 	//r.Use(mux.CORSMethodMiddleware(r))
 
@@ -99,6 +285,7 @@ func Route(api API) *http.Server {
 		ReadTimeout:  time.Second * 15,
 		IdleTimeout:  time.Second * 60,
 		Handler:      root,
+		ConnContext:  connContext,
 	}
 
 	return srv
@@ -2,13 +2,18 @@ package router_test
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/dontang97/ui/pg"
 	"github.com/dontang97/ui/router"
+	"github.com/dontang97/ui/secret"
+	"github.com/dontang97/ui/ui"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/suite"
 )
@@ -18,12 +23,17 @@ type _Suite struct {
 	srv *http.Server
 
 	// for mocking middle func
-	JWTMiddleFunc mux.MiddlewareFunc
+	JWTMiddleFunc        mux.MiddlewareFunc
+	AdminMiddleFunc      mux.MiddlewareFunc
+	SuperAdminMiddleFunc mux.MiddlewareFunc
 
 	// test fields
 	flagLogin         bool
 	flagLogout        bool
 	flagUsers         bool
+	flagSearch        bool
+	flagImportUsers   bool
+	flagExportUsers   bool
 	flagFullnameQuery bool
 
 	flagUserInfo    bool
@@ -32,6 +42,40 @@ type _Suite struct {
 	flagSignup bool
 	flagDelete bool
 	flagUpdate bool
+	flagEvents bool
+
+	flagExportUser  bool
+	flagEraseUser   bool
+	flagUserHistory bool
+	flagRevertUser  bool
+	flagRenameUser  bool
+
+	flagResolveAlias bool
+
+	flagTenants     bool
+	flagAddTenant   bool
+	flagAudit       bool
+	flagAuditExport bool
+	flagDBStats     bool
+
+	flagWebhooks          bool
+	flagAddWebhook        bool
+	flagWebhookInfo       bool
+	flagUpdateWebhook     bool
+	flagDeleteWebhook     bool
+	flagWebhookDeliveries bool
+	flagRedeliver         bool
+
+	flagGroups            bool
+	flagAddGroup          bool
+	flagGroupInfo         bool
+	flagUpdateGroup       bool
+	flagDeleteGroup       bool
+	flagGroupMembers      bool
+	flagAddGroupMember    bool
+	flagRemoveGroupMember bool
+	flagAddSubgroup       bool
+	flagRemoveSubgroup    bool
 }
 
 func (s *_Suite) Login(http.ResponseWriter, *http.Request) {
@@ -46,6 +90,18 @@ func (s *_Suite) Users(http.ResponseWriter, *http.Request) {
 	s.flagUsers = true
 }
 
+func (s *_Suite) ImportUsers(http.ResponseWriter, *http.Request) {
+	s.flagImportUsers = true
+}
+
+func (s *_Suite) ExportUsers(http.ResponseWriter, *http.Request) {
+	s.flagExportUsers = true
+}
+
+func (s *_Suite) Search(http.ResponseWriter, *http.Request) {
+	s.flagSearch = true
+}
+
 func (s *_Suite) FullnameQuery(http.ResponseWriter, *http.Request) {
 	s.flagFullnameQuery = true
 }
@@ -68,6 +124,125 @@ func (s *_Suite) Update(http.ResponseWriter, *http.Request) {
 	s.flagUpdate = true
 }
 
+func (s *_Suite) ExportUser(http.ResponseWriter, *http.Request) {
+	s.flagExportUser = true
+}
+
+func (s *_Suite) EraseUser(http.ResponseWriter, *http.Request) {
+	s.flagEraseUser = true
+}
+
+func (s *_Suite) UserHistory(http.ResponseWriter, *http.Request) {
+	s.flagUserHistory = true
+}
+
+func (s *_Suite) RevertUser(http.ResponseWriter, *http.Request) {
+	s.flagRevertUser = true
+}
+
+func (s *_Suite) RenameUser(http.ResponseWriter, *http.Request) {
+	s.flagRenameUser = true
+}
+
+func (s *_Suite) ResolveAlias(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.flagResolveAlias = true
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *_Suite) Audit(http.ResponseWriter, *http.Request) {
+	s.flagAudit = true
+}
+
+func (s *_Suite) AuditExport(http.ResponseWriter, *http.Request) {
+	s.flagAuditExport = true
+}
+
+func (s *_Suite) Events(http.ResponseWriter, *http.Request) {
+	s.flagEvents = true
+}
+
+func (s *_Suite) Tenants(http.ResponseWriter, *http.Request) {
+	s.flagTenants = true
+}
+
+func (s *_Suite) AddTenant(http.ResponseWriter, *http.Request) {
+	s.flagAddTenant = true
+}
+
+func (s *_Suite) DBStats(http.ResponseWriter, *http.Request) {
+	s.flagDBStats = true
+}
+
+func (s *_Suite) Webhooks(http.ResponseWriter, *http.Request) {
+	s.flagWebhooks = true
+}
+
+func (s *_Suite) AddWebhook(http.ResponseWriter, *http.Request) {
+	s.flagAddWebhook = true
+}
+
+func (s *_Suite) WebhookInfo(http.ResponseWriter, *http.Request) {
+	s.flagWebhookInfo = true
+}
+
+func (s *_Suite) UpdateWebhook(http.ResponseWriter, *http.Request) {
+	s.flagUpdateWebhook = true
+}
+
+func (s *_Suite) DeleteWebhook(http.ResponseWriter, *http.Request) {
+	s.flagDeleteWebhook = true
+}
+
+func (s *_Suite) WebhookDeliveries(http.ResponseWriter, *http.Request) {
+	s.flagWebhookDeliveries = true
+}
+
+func (s *_Suite) Redeliver(http.ResponseWriter, *http.Request) {
+	s.flagRedeliver = true
+}
+
+func (s *_Suite) Groups(http.ResponseWriter, *http.Request) {
+	s.flagGroups = true
+}
+
+func (s *_Suite) AddGroup(http.ResponseWriter, *http.Request) {
+	s.flagAddGroup = true
+}
+
+func (s *_Suite) GroupInfo(http.ResponseWriter, *http.Request) {
+	s.flagGroupInfo = true
+}
+
+func (s *_Suite) UpdateGroup(http.ResponseWriter, *http.Request) {
+	s.flagUpdateGroup = true
+}
+
+func (s *_Suite) DeleteGroup(http.ResponseWriter, *http.Request) {
+	s.flagDeleteGroup = true
+}
+
+func (s *_Suite) GroupMembers(http.ResponseWriter, *http.Request) {
+	s.flagGroupMembers = true
+}
+
+func (s *_Suite) AddGroupMember(http.ResponseWriter, *http.Request) {
+	s.flagAddGroupMember = true
+}
+
+func (s *_Suite) RemoveGroupMember(http.ResponseWriter, *http.Request) {
+	s.flagRemoveGroupMember = true
+}
+
+func (s *_Suite) AddSubgroup(http.ResponseWriter, *http.Request) {
+	s.flagAddSubgroup = true
+}
+
+func (s *_Suite) RemoveSubgroup(http.ResponseWriter, *http.Request) {
+	s.flagRemoveSubgroup = true
+}
+
 func (s *_Suite) SetupSuite() {
 	s.JWTMiddleFunc, router.JWTMiddleFunc = router.JWTMiddleFunc, func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -75,6 +250,14 @@ func (s *_Suite) SetupSuite() {
 		})
 	}
 
+	s.AdminMiddleFunc, router.AdminMiddleFunc = router.AdminMiddleFunc, func(next http.Handler) http.Handler {
+		return next
+	}
+
+	s.SuperAdminMiddleFunc, router.SuperAdminMiddleFunc = router.SuperAdminMiddleFunc, func(next http.Handler) http.Handler {
+		return next
+	}
+
 	s.srv = router.Route(s)
 	go func() {
 		s.Equal(http.ErrServerClosed, s.srv.ListenAndServe())
@@ -87,12 +270,17 @@ func (s *_Suite) SetupSuite() {
 func (s *_Suite) TearDownSuite() {
 	s.srv.Shutdown(context.Background())
 	router.JWTMiddleFunc, s.JWTMiddleFunc = s.JWTMiddleFunc, nil
+	router.AdminMiddleFunc, s.AdminMiddleFunc = s.AdminMiddleFunc, nil
+	router.SuperAdminMiddleFunc, s.SuperAdminMiddleFunc = s.SuperAdminMiddleFunc, nil
 }
 
 func (s *_Suite) SetupTest() {
 	s.flagLogin = false
 	s.flagLogout = false
 	s.flagUsers = false
+	s.flagSearch = false
+	s.flagImportUsers = false
+	s.flagExportUsers = false
 	s.flagFullnameQuery = false
 
 	s.flagUserInfo = false
@@ -101,6 +289,40 @@ func (s *_Suite) SetupTest() {
 	s.flagSignup = false
 	s.flagDelete = false
 	s.flagUpdate = false
+	s.flagEvents = false
+
+	s.flagExportUser = false
+	s.flagEraseUser = false
+	s.flagUserHistory = false
+	s.flagRevertUser = false
+	s.flagRenameUser = false
+
+	s.flagResolveAlias = false
+
+	s.flagTenants = false
+	s.flagAddTenant = false
+	s.flagAudit = false
+	s.flagAuditExport = false
+	s.flagDBStats = false
+
+	s.flagWebhooks = false
+	s.flagAddWebhook = false
+	s.flagWebhookInfo = false
+	s.flagUpdateWebhook = false
+	s.flagDeleteWebhook = false
+	s.flagWebhookDeliveries = false
+	s.flagRedeliver = false
+
+	s.flagGroups = false
+	s.flagAddGroup = false
+	s.flagGroupInfo = false
+	s.flagUpdateGroup = false
+	s.flagDeleteGroup = false
+	s.flagGroupMembers = false
+	s.flagAddGroupMember = false
+	s.flagRemoveGroupMember = false
+	s.flagAddSubgroup = false
+	s.flagRemoveSubgroup = false
 }
 
 func (s *_Suite) TearDownTest() {
@@ -130,6 +352,21 @@ func (s *_Suite) TestRoute() {
 	s.Equal(nil, err)
 	s.Equal(true, s.flagUsers)
 
+	// Get /ui/v1/users/search
+	_, err = http.Get("http://" + router.Addr + "/ui/v1/users/search?q=test")
+	s.Equal(nil, err)
+	s.Equal(true, s.flagSearch)
+
+	// Post /ui/v1/users/import
+	_, err = http.Post("http://"+router.Addr+"/ui/v1/users/import", "text/csv", nil)
+	s.Equal(nil, err)
+	s.Equal(true, s.flagImportUsers)
+
+	// Get /ui/v1/users/export
+	_, err = http.Get("http://" + router.Addr + "/ui/v1/users/export")
+	s.Equal(nil, err)
+	s.Equal(true, s.flagExportUsers)
+
 	// Get /ui/v1/user?fullname={fullname}
 	_, err = http.Get("http://" + router.Addr + "/ui/v1/user?fullname=test")
 	s.Equal(nil, err)
@@ -140,6 +377,7 @@ func (s *_Suite) TestRoute() {
 	s.Equal(nil, err)
 	s.Equal("user_acct", s.acctVarUserInfo)
 	s.Equal(true, s.flagUserInfo)
+	s.Equal(true, s.flagResolveAlias)
 
 	// Post /ui/v1/signup
 	_, err = http.Post("http://"+router.Addr+"/ui/v1/signup", "", nil)
@@ -162,10 +400,356 @@ func (s *_Suite) TestRoute() {
 	s.Equal(nil, err)
 	s.Equal(true, s.flagUpdate)
 
+	// Get /ui/v1/user/{acct:[A-Za-z0-9_]{8,20}}/export
+	_, err = http.Get("http://" + router.Addr + "/ui/v1/user/user_acct/export")
+	s.Equal(nil, err)
+	s.Equal(true, s.flagExportUser)
+
+	// Post /ui/v1/user/{acct:[A-Za-z0-9_]{8,20}}/erase
+	_, err = http.Post("http://"+router.Addr+"/ui/v1/user/user_acct/erase", "", nil)
+	s.Equal(nil, err)
+	s.Equal(true, s.flagEraseUser)
+
+	// Get /ui/v1/user/{acct:[A-Za-z0-9_]{8,20}}/history
+	_, err = http.Get("http://" + router.Addr + "/ui/v1/user/user_acct/history")
+	s.Equal(nil, err)
+	s.Equal(true, s.flagUserHistory)
+
+	// Post /ui/v1/user/{acct:[A-Za-z0-9_]{8,20}}/history/{version}/revert
+	_, err = http.Post("http://"+router.Addr+"/ui/v1/user/user_acct/history/3/revert", "", nil)
+	s.Equal(nil, err)
+	s.Equal(true, s.flagRevertUser)
+
+	// Post /ui/v1/user/{acct:[A-Za-z0-9_]{8,20}}/rename
+	_, err = http.Post("http://"+router.Addr+"/ui/v1/user/user_acct/rename", "", nil)
+	s.Equal(nil, err)
+	s.Equal(true, s.flagRenameUser)
+
 	// Post /ui/v1/login
 	_, err = http.Post("http://"+router.Addr+"/ui/v1/login", "", nil)
 	s.Equal(nil, err)
 	s.Equal(true, s.flagLogin)
+
+	// Get /ui/v1/audit
+	resp, err = http.Get("http://" + router.Addr + "/ui/v1/audit")
+	s.Equal(nil, err)
+	s.Equal(true, s.flagAudit)
+	s.Equal(32, len(resp.Header.Get("X-Request-ID")))
+
+	// Get /ui/v1/audit/export
+	_, err = http.Get("http://" + router.Addr + "/ui/v1/audit/export")
+	s.Equal(nil, err)
+	s.Equal(true, s.flagAuditExport)
+
+	// Get /ui/v1/events
+	_, err = http.Get("http://" + router.Addr + "/ui/v1/events")
+	s.Equal(nil, err)
+	s.Equal(true, s.flagEvents)
+
+	// Get /ui/v1/db/stats
+	_, err = http.Get("http://" + router.Addr + "/ui/v1/db/stats")
+	s.Equal(nil, err)
+	s.Equal(true, s.flagDBStats)
+
+	// /ui/v1/webhooks
+	for _, c := range []struct {
+		method string
+		path   string
+		flag   *bool
+	}{
+		{http.MethodGet, "/ui/v1/webhooks", &s.flagWebhooks},
+		{http.MethodPost, "/ui/v1/webhooks", &s.flagAddWebhook},
+		{http.MethodGet, "/ui/v1/webhooks/7", &s.flagWebhookInfo},
+		{http.MethodPut, "/ui/v1/webhooks/7", &s.flagUpdateWebhook},
+		{http.MethodDelete, "/ui/v1/webhooks/7", &s.flagDeleteWebhook},
+		{http.MethodGet, "/ui/v1/webhooks/7/deliveries", &s.flagWebhookDeliveries},
+		{http.MethodPost, "/ui/v1/webhooks/7/deliveries/12/redeliver", &s.flagRedeliver},
+	} {
+		req, err := http.NewRequest(c.method, "http://"+router.Addr+c.path, nil)
+		s.Equal(nil, err)
+		_, err = http.DefaultClient.Do(req)
+		s.Equal(nil, err)
+		s.Equal(true, *c.flag, c.method+" "+c.path)
+	}
+
+	// /ui/v1/tenants
+	_, err = http.Get("http://" + router.Addr + "/ui/v1/tenants")
+	s.Equal(nil, err)
+	s.Equal(true, s.flagTenants)
+	_, err = http.Post("http://"+router.Addr+"/ui/v1/tenants", "application/json", nil)
+	s.Equal(nil, err)
+	s.Equal(true, s.flagAddTenant)
+
+	// /ui/v1/groups
+	for _, c := range []struct {
+		method string
+		path   string
+		flag   *bool
+	}{
+		{http.MethodGet, "/ui/v1/groups", &s.flagGroups},
+		{http.MethodPost, "/ui/v1/groups", &s.flagAddGroup},
+		{http.MethodGet, "/ui/v1/groups/lakers", &s.flagGroupInfo},
+		{http.MethodPut, "/ui/v1/groups/lakers", &s.flagUpdateGroup},
+		{http.MethodDelete, "/ui/v1/groups/lakers", &s.flagDeleteGroup},
+		{http.MethodGet, "/ui/v1/groups/lakers/members", &s.flagGroupMembers},
+		{http.MethodPut, "/ui/v1/groups/lakers/members/kobe_bryant", &s.flagAddGroupMember},
+		{http.MethodDelete, "/ui/v1/groups/lakers/members/kobe_bryant", &s.flagRemoveGroupMember},
+		{http.MethodPut, "/ui/v1/groups/nba/groups/lakers", &s.flagAddSubgroup},
+		{http.MethodDelete, "/ui/v1/groups/nba/groups/lakers", &s.flagRemoveSubgroup},
+	} {
+		req, err := http.NewRequest(c.method, "http://"+router.Addr+c.path, nil)
+		s.Equal(nil, err)
+		_, err = http.DefaultClient.Do(req)
+		s.Equal(nil, err)
+		s.Equal(true, *c.flag, c.method+" "+c.path)
+	}
+
+	// Get /debug/vars
+	resp, err = http.Get("http://" + router.Addr + "/debug/vars")
+	s.Equal(nil, err)
+	s.Equal(http.StatusOK, resp.StatusCode)
+
+	// the request ID of the client is kept
+	req, err = http.NewRequest(http.MethodGet, "http://"+router.Addr+"/ui", nil)
+	s.Equal(nil, err)
+	req.Header.Set("X-Request-ID", "client-id.1")
+	resp, err = c.Do(req)
+	s.Equal(nil, err)
+	s.Equal("client-id.1", resp.Header.Get("X-Request-ID"))
+}
+
+func (s *_Suite) TestDebugVarsAuth() {
+	secret.InitSecretKey("../secret")
+
+	// the routes with the middlewares of the server, not the mocks
+	jwt, superAdmin := router.JWTMiddleFunc, router.SuperAdminMiddleFunc
+	router.JWTMiddleFunc, router.SuperAdminMiddleFunc = s.JWTMiddleFunc, s.SuperAdminMiddleFunc
+	h := router.Route(s).Handler
+	router.JWTMiddleFunc, router.SuperAdminMiddleFunc = jwt, superAdmin
+
+	for role, status := range map[string]int{"-": http.StatusUnauthorized, "": http.StatusUnauthorized, secret.RoleAdmin: http.StatusUnauthorized, secret.RoleSuperAdmin: http.StatusOK} {
+		req := httptest.NewRequest(http.MethodGet, "http://test.com/debug/vars", nil)
+		if role != "-" {
+			token, err := secret.CreateUserJWT("kobe_bryant", secret.WithRoles(role))
+			s.Equal(nil, err)
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rcd := httptest.NewRecorder()
+		h.ServeHTTP(rcd, req)
+		s.Equal(status, rcd.Code, role)
+	}
+}
+
+func (s *_Suite) TestEventsAuth() {
+	secret.InitSecretKey("../secret")
+
+	jwt, admin := router.JWTMiddleFunc, router.AdminMiddleFunc
+	router.JWTMiddleFunc, router.AdminMiddleFunc = s.JWTMiddleFunc, s.AdminMiddleFunc
+	h := router.Route(s).Handler
+	router.JWTMiddleFunc, router.AdminMiddleFunc = jwt, admin
+
+	for role, status := range map[string]int{"": http.StatusUnauthorized, secret.RoleAdmin: http.StatusOK, secret.RoleSuperAdmin: http.StatusOK} {
+		token, err := secret.CreateUserJWT("kobe_bryant", secret.WithRoles(role))
+		s.Equal(nil, err)
+		req := httptest.NewRequest(http.MethodGet, "http://test.com/ui/v1/events", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rcd := httptest.NewRecorder()
+		h.ServeHTTP(rcd, req)
+		s.Equal(status, rcd.Code, role)
+	}
+}
+
+// TestGroupRoleEscalation has a tenant admin try to get the super admin
+// role through a group, and to grant roles it does not hold.
+func (s *_Suite) TestGroupRoleEscalation() {
+	secret.InitSecretKey("../secret")
+
+	jwt, admin, superAdmin := router.JWTMiddleFunc, router.AdminMiddleFunc, router.SuperAdminMiddleFunc
+	router.JWTMiddleFunc, router.AdminMiddleFunc, router.SuperAdminMiddleFunc = s.JWTMiddleFunc, s.AdminMiddleFunc, s.SuperAdminMiddleFunc
+	h := router.Route(ui.New()).Handler
+	router.JWTMiddleFunc, router.AdminMiddleFunc, router.SuperAdminMiddleFunc = jwt, admin, superAdmin
+
+	addGroup, login, memberOf, recordLogin, audit := ui.AddGroupHdl, ui.LoginHdl, ui.MemberOfHdl, ui.RecordLoginHdl, ui.AuditHdl
+	defer func() {
+		ui.AddGroupHdl, ui.LoginHdl, ui.MemberOfHdl, ui.RecordLoginHdl, ui.AuditHdl = addGroup, login, memberOf, recordLogin, audit
+	}()
+	ui.AddGroupHdl = func(context.Context, *ui.UI, *pg.Group) error {
+		s.Fail("the group is not added")
+		return nil
+	}
+	ui.LoginHdl = func(context.Context, *ui.UI, ...interface{}) ([]pg.User, error) {
+		return []pg.User{{Acct: "kobe_bryant", Pwd: "123456789"}}, nil
+	}
+	ui.RecordLoginHdl = func(context.Context, *ui.UI, string) error {
+		return nil
+	}
+	ui.AuditHdl = func(context.Context, *ui.UI, *pg.AuditEvent) error {
+		return nil
+	}
+
+	serve := func(method, target, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rcd := httptest.NewRecorder()
+		h.ServeHTTP(rcd, req)
+		return rcd
+	}
+
+	token, err := secret.CreateUserJWT("kobe_bryant", secret.WithRoles(secret.RoleAdmin))
+	s.Equal(nil, err)
+	for body, status := range map[string]int{
+		`{"name": "escalate", "roles": ["super_admin"]}`: http.StatusBadRequest,
+		`{"name": "escalate", "roles": ["auditor"]}`:     http.StatusUnauthorized,
+	} {
+		rcd := serve(http.MethodPost, "http://test.com/ui/v1/groups", token, body)
+		s.Equal(status, rcd.Code, body)
+	}
+
+	// a group granting it from before logs in without it
+	ui.MemberOfHdl = func(context.Context, *ui.UI, string) ([]pg.Group, error) {
+		return []pg.Group{{Name: "escalate", Roles: []string{secret.RoleSuperAdmin, secret.RoleAdmin}}}, nil
+	}
+	rcd := serve(http.MethodPost, "http://test.com/ui/v1/login", "", `{"account": "kobe_bryant", "password": "123456789"}`)
+	s.Equal(http.StatusOK, rcd.Code)
+	resp := struct {
+		Data map[string]string `json:"data"`
+	}{}
+	s.Equal(nil, json.Unmarshal(rcd.Body.Bytes(), &resp))
+	claims, err := secret.ParseUserJWT(resp.Data["JWT"])
+	s.Equal(nil, err)
+	s.Equal([]string{secret.RoleAdmin}, claims.Roles)
+
+	s.Equal(http.StatusUnauthorized, serve(http.MethodGet, "http://test.com/debug/vars", resp.Data["JWT"], "").Code)
+	rcd = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://test.com/ui/v1/groups", nil)
+	req.Header.Set("Authorization", "Bearer "+resp.Data["JWT"])
+	req.Header.Set("X-Tenant", "celtics")
+	h.ServeHTTP(rcd, req)
+	s.Equal(http.StatusUnauthorized, rcd.Code)
+}
+
+func (s *_Suite) TestJWTMiddleFunc() {
+	secret.InitSecretKey("../secret")
+
+	var claims *secret.UserClaims
+	var tenant string
+	r := mux.NewRouter()
+	r.Use(s.JWTMiddleFunc)
+	r.HandleFunc("/{acct}", func(w http.ResponseWriter, r *http.Request) {
+		claims, _ = secret.FromContext(r.Context())
+		tenant = ui.Tenant(r.Context())
+	})
+
+	for _, c := range []struct {
+		acct  string
+		roles []string
+		code  int
+	}{
+		{"user_acct", nil, http.StatusOK},
+		{"other_acct", nil, http.StatusUnauthorized},
+		{"admin_acct", []string{secret.RoleAdmin}, http.StatusOK},
+	} {
+		token, err := secret.CreateUserJWT(c.acct, secret.WithRoles(c.roles...))
+		s.Equal(nil, err)
+
+		claims = nil
+		req := httptest.NewRequest(http.MethodGet, "http://test.com/user_acct", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rcd := httptest.NewRecorder()
+		r.ServeHTTP(rcd, req)
+		s.Equal(c.code, rcd.Code, c.acct)
+		if c.code == http.StatusOK {
+			s.Equal(c.acct, claims.Acct)
+		}
+	}
+
+	// only super admins act in other tenants
+	for _, c := range []struct {
+		tenant string
+		header string
+		roles  []string
+		code   int
+		acting string
+	}{
+		{"", "", nil, http.StatusOK, "default"},
+		{"lakers", "", nil, http.StatusOK, "lakers"},
+		{"lakers", "lakers", nil, http.StatusOK, "lakers"},
+		{"", "default", nil, http.StatusOK, "default"},
+		{"lakers", "celtics", []string{secret.RoleAdmin}, http.StatusUnauthorized, ""},
+		{"lakers", "celtics", []string{secret.RoleSuperAdmin}, http.StatusOK, "celtics"},
+	} {
+		opts := []secret.ClaimOption{secret.WithRoles(c.roles...)}
+		if c.tenant != "" {
+			opts = append(opts, secret.WithTenant(c.tenant))
+		}
+		token, err := secret.CreateUserJWT("user_acct", opts...)
+		s.Equal(nil, err)
+
+		tenant = ""
+		req := httptest.NewRequest(http.MethodGet, "http://test.com/user_acct", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if c.header != "" {
+			req.Header.Set("X-Tenant", c.header)
+		}
+		rcd := httptest.NewRecorder()
+		r.ServeHTTP(rcd, req)
+		s.Equal(c.code, rcd.Code, c.tenant+" "+c.header)
+		s.Equal(c.acting, tenant, c.tenant+" "+c.header)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://test.com/user_acct", nil)
+	rcd := httptest.NewRecorder()
+	r.ServeHTTP(rcd, req)
+	s.Equal(http.StatusUnauthorized, rcd.Code)
+}
+
+func (s *_Suite) TestAdminMiddleFunc() {
+	h := s.AdminMiddleFunc(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "http://test.com", nil)
+	rcd := httptest.NewRecorder()
+	h.ServeHTTP(rcd, req)
+	s.Equal(http.StatusUnauthorized, rcd.Code)
+
+	req = req.WithContext(secret.NewContext(req.Context(), &secret.UserClaims{Acct: "user_acct"}))
+	rcd = httptest.NewRecorder()
+	h.ServeHTTP(rcd, req)
+	s.Equal(http.StatusUnauthorized, rcd.Code)
+
+	req = req.WithContext(secret.NewContext(req.Context(),
+		&secret.UserClaims{Acct: "user_acct", Roles: []string{secret.RoleAdmin}}))
+	rcd = httptest.NewRecorder()
+	h.ServeHTTP(rcd, req)
+	s.Equal(http.StatusTeapot, rcd.Code)
+
+	req = req.WithContext(secret.NewContext(req.Context(),
+		&secret.UserClaims{Acct: "user_acct", Roles: []string{secret.RoleSuperAdmin}}))
+	rcd = httptest.NewRecorder()
+	h.ServeHTTP(rcd, req)
+	s.Equal(http.StatusTeapot, rcd.Code)
+}
+
+func (s *_Suite) TestSuperAdminMiddleFunc() {
+	h := s.SuperAdminMiddleFunc(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	for roles, code := range map[string]int{
+		"":                    http.StatusUnauthorized,
+		secret.RoleAdmin:      http.StatusUnauthorized,
+		secret.RoleSuperAdmin: http.StatusTeapot,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://test.com", nil)
+		req = req.WithContext(secret.NewContext(req.Context(),
+			&secret.UserClaims{Acct: "user_acct", Roles: []string{roles}}))
+		rcd := httptest.NewRecorder()
+		h.ServeHTTP(rcd, req)
+		s.Equal(code, rcd.Code, roles)
+	}
 }
 
 func TestRun(t *testing.T) {
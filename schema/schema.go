@@ -0,0 +1,417 @@
+// Package schema validates the profile attributes of users against a JSON
+// Schema supplied by the operator. It implements the validation keywords of
+// draft 7 that describe flat profile data; a schema using any other keyword
+// is rejected when loaded rather than silently not enforced.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	TypeObject  = "object"
+	TypeArray   = "array"
+	TypeString  = "string"
+	TypeNumber  = "number"
+	TypeInteger = "integer"
+	TypeBoolean = "boolean"
+	TypeNull    = "null"
+)
+
+// annotations carry no constraint and are accepted anywhere.
+var annotations = map[string]bool{
+	"$schema":     true,
+	"$id":         true,
+	"$comment":    true,
+	"title":       true,
+	"description": true,
+	"default":     true,
+	"examples":    true,
+}
+
+var formats = map[string]func(string) bool{
+	"date": func(s string) bool {
+		_, err := time.Parse("2006-01-02", s)
+		return err == nil
+	},
+	"date-time": func(s string) bool {
+		_, err := time.Parse(time.RFC3339Nano, s)
+		return err == nil
+	},
+	"email": func(s string) bool {
+		addr, err := mail.ParseAddress(s)
+		return err == nil && addr.Address == s
+	},
+}
+
+// Schema is a compiled JSON Schema. Nil fields do not constrain.
+type Schema struct {
+	Types []string
+
+	Properties           map[string]*Schema
+	Required             []string
+	AdditionalProperties *Schema
+	NoAdditional         bool
+
+	Enum []interface{}
+
+	MinLength *int
+	MaxLength *int
+	Pattern   *regexp.Regexp
+	Format    string
+
+	Minimum          *float64
+	Maximum          *float64
+	ExclusiveMinimum *float64
+	ExclusiveMaximum *float64
+
+	Items    *Schema
+	MinItems *int
+	MaxItems *int
+}
+
+// Violation is a value that breaks the schema, at Path as a JSON Pointer.
+type Violation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// Load reads and compiles the schema in file, whose root must describe an
+// object.
+func Load(file string) (*Schema, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// Parse compiles the schema in b, whose root must describe an object.
+func Parse(b []byte) (*Schema, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+
+	s, err := compile(doc, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Types) != 1 || s.Types[0] != TypeObject {
+		return nil, fmt.Errorf("the root schema must be of type %q", TypeObject)
+	}
+	return s, nil
+}
+
+func compile(doc map[string]interface{}, path string) (*Schema, error) {
+	s := &Schema{}
+	for key, v := range doc {
+		where := path + "/" + key
+		var err error
+		switch key {
+		case "type":
+			s.Types, err = compileTypes(v)
+		case "properties":
+			props, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%v: must be an object", where)
+			}
+			s.Properties = map[string]*Schema{}
+			for name, p := range props {
+				if s.Properties[name], err = compileSub(p, where+"/"+name); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			s.Required, err = compileStrings(v)
+		case "additionalProperties":
+			if b, ok := v.(bool); ok {
+				s.NoAdditional = !b
+			} else if s.AdditionalProperties, err = compileSub(v, where); err != nil {
+				return nil, err
+			}
+		case "enum":
+			list, ok := v.([]interface{})
+			if !ok || len(list) == 0 {
+				return nil, fmt.Errorf("%v: must be a non-empty array", where)
+			}
+			s.Enum = list
+		case "const":
+			s.Enum = []interface{}{v}
+		case "minLength":
+			s.MinLength, err = compileCount(v)
+		case "maxLength":
+			s.MaxLength, err = compileCount(v)
+		case "pattern":
+			str, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%v: must be a string", where)
+			}
+			s.Pattern, err = regexp.Compile(str)
+		case "format":
+			str, _ := v.(string)
+			if _, ok := formats[str]; !ok {
+				return nil, fmt.Errorf("%v: unsupported format %v", where, v)
+			}
+			s.Format = str
+		case "minimum":
+			s.Minimum, err = compileNumber(v)
+		case "maximum":
+			s.Maximum, err = compileNumber(v)
+		case "exclusiveMinimum":
+			s.ExclusiveMinimum, err = compileNumber(v)
+		case "exclusiveMaximum":
+			s.ExclusiveMaximum, err = compileNumber(v)
+		case "items":
+			if s.Items, err = compileSub(v, where); err != nil {
+				return nil, err
+			}
+		case "minItems":
+			s.MinItems, err = compileCount(v)
+		case "maxItems":
+			s.MaxItems, err = compileCount(v)
+		default:
+			if !annotations[key] {
+				return nil, fmt.Errorf("%v: unsupported keyword", where)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", where, err)
+		}
+	}
+	return s, nil
+}
+
+func compileSub(v interface{}, path string) (*Schema, error) {
+	doc, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%v: must be a schema object", path)
+	}
+	return compile(doc, path)
+}
+
+func compileTypes(v interface{}) ([]string, error) {
+	types := []string{}
+	if str, ok := v.(string); ok {
+		types = append(types, str)
+	} else {
+		var err error
+		if types, err = compileStrings(v); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, typ := range types {
+		switch typ {
+		case TypeObject, TypeArray, TypeString, TypeNumber, TypeInteger, TypeBoolean, TypeNull:
+		default:
+			return nil, fmt.Errorf("unknown type %q", typ)
+		}
+	}
+	return types, nil
+}
+
+func compileStrings(v interface{}) ([]string, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be an array of strings")
+	}
+	strs := []string{}
+	for _, item := range list {
+		str, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be an array of strings")
+		}
+		strs = append(strs, str)
+	}
+	return strs, nil
+}
+
+func compileNumber(v interface{}) (*float64, error) {
+	n, ok := v.(float64)
+	if !ok {
+		return nil, fmt.Errorf("must be a number")
+	}
+	return &n, nil
+}
+
+func compileCount(v interface{}) (*int, error) {
+	n, ok := v.(float64)
+	if !ok || n < 0 || n != math.Trunc(n) {
+		return nil, fmt.Errorf("must be a non-negative integer")
+	}
+	c := int(n)
+	return &c, nil
+}
+
+// PropertyType returns the type of the top-level property name when the
+// schema gives it exactly one.
+func (s *Schema) PropertyType(name string) (string, bool) {
+	p, ok := s.Properties[name]
+	if !ok || len(p.Types) != 1 {
+		return "", false
+	}
+	return p.Types[0], true
+}
+
+// Validate returns every violation of s by v, a value decoded by
+// encoding/json into interface{}, sorted by path.
+func (s *Schema) Validate(v interface{}) []Violation {
+	violations := s.validate(v, "")
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Path < violations[j].Path
+	})
+	return violations
+}
+
+func typeOf(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return TypeNull
+	case bool:
+		return TypeBoolean
+	case float64:
+		if v == math.Trunc(v) {
+			return TypeInteger
+		}
+		return TypeNumber
+	case string:
+		return TypeString
+	case []interface{}:
+		return TypeArray
+	case map[string]interface{}:
+		return TypeObject
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func (s *Schema) validate(v interface{}, path string) []Violation {
+	violation := func(format string, args ...interface{}) []Violation {
+		return []Violation{{Path: path, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	if len(s.Types) > 0 {
+		typ, ok := typeOf(v), false
+		for _, t := range s.Types {
+			if t == typ || (t == TypeNumber && typ == TypeInteger) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return violation("must be of type %v", strings.Join(s.Types, " or "))
+		}
+	}
+
+	if s.Enum != nil {
+		ok := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, v) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			js, _ := json.Marshal(s.Enum)
+			return violation("must be one of %s", js)
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		return s.validateString(v, violation)
+	case float64:
+		return s.validateNumber(v, violation)
+	case []interface{}:
+		return s.validateArray(v, path, violation)
+	case map[string]interface{}:
+		return s.validateObject(v, path)
+	}
+	return nil
+}
+
+func (s *Schema) validateString(v string, violation func(string, ...interface{}) []Violation) []Violation {
+	n := utf8.RuneCountInString(v)
+	if s.MinLength != nil && n < *s.MinLength {
+		return violation("must be at least %v characters long", *s.MinLength)
+	}
+	if s.MaxLength != nil && n > *s.MaxLength {
+		return violation("must be at most %v characters long", *s.MaxLength)
+	}
+	if s.Pattern != nil && !s.Pattern.MatchString(v) {
+		return violation("must match %v", s.Pattern)
+	}
+	if s.Format != "" && !formats[s.Format](v) {
+		return violation("must be a valid %v", s.Format)
+	}
+	return nil
+}
+
+func (s *Schema) validateNumber(v float64, violation func(string, ...interface{}) []Violation) []Violation {
+	if s.Minimum != nil && v < *s.Minimum {
+		return violation("must be at least %v", *s.Minimum)
+	}
+	if s.Maximum != nil && v > *s.Maximum {
+		return violation("must be at most %v", *s.Maximum)
+	}
+	if s.ExclusiveMinimum != nil && v <= *s.ExclusiveMinimum {
+		return violation("must be greater than %v", *s.ExclusiveMinimum)
+	}
+	if s.ExclusiveMaximum != nil && v >= *s.ExclusiveMaximum {
+		return violation("must be less than %v", *s.ExclusiveMaximum)
+	}
+	return nil
+}
+
+func (s *Schema) validateArray(v []interface{}, path string, violation func(string, ...interface{}) []Violation) []Violation {
+	if s.MinItems != nil && len(v) < *s.MinItems {
+		return violation("must have at least %v items", *s.MinItems)
+	}
+	if s.MaxItems != nil && len(v) > *s.MaxItems {
+		return violation("must have at most %v items", *s.MaxItems)
+	}
+
+	violations := []Violation{}
+	if s.Items != nil {
+		for i, item := range v {
+			violations = append(violations, s.Items.validate(item, fmt.Sprintf("%v/%v", path, i))...)
+		}
+	}
+	return violations
+}
+
+func (s *Schema) validateObject(v map[string]interface{}, path string) []Violation {
+	violations := []Violation{}
+	for _, name := range s.Required {
+		if _, ok := v[name]; !ok {
+			violations = append(violations, Violation{Path: path + "/" + pointerEscape(name), Message: "is required"})
+		}
+	}
+
+	for name, value := range v {
+		where := path + "/" + pointerEscape(name)
+		if p, ok := s.Properties[name]; ok {
+			violations = append(violations, p.validate(value, where)...)
+		} else if s.NoAdditional {
+			violations = append(violations, Violation{Path: where, Message: "is not allowed"})
+		} else if s.AdditionalProperties != nil {
+			violations = append(violations, s.AdditionalProperties.validate(value, where)...)
+		}
+	}
+	return violations
+}
+
+// pointerEscape escapes name as a reference token of a JSON Pointer.
+func pointerEscape(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
@@ -0,0 +1,133 @@
+package schema_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/dontang97/ui/schema"
+	"github.com/stretchr/testify/suite"
+)
+
+type _Suite struct {
+	suite.Suite
+}
+
+func (s *_Suite) SetupTest() {
+}
+
+func (s *_Suite) TearDownTest() {
+}
+
+func (s *_Suite) validate(sch *schema.Schema, doc string) []schema.Violation {
+	var v interface{}
+	s.Equal(nil, json.Unmarshal([]byte(doc), &v))
+	return sch.Validate(v)
+}
+
+func (s *_Suite) TestParse() {
+	sch, err := schema.Parse([]byte(`{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"title": "profile",
+		"type": "object",
+		"properties": {
+			"phone": {"type": "string", "description": "E.164"},
+			"tags": {"type": "array", "items": {"type": "string"}}
+		}
+	}`))
+	s.Equal(nil, err)
+	typ, ok := sch.PropertyType("phone")
+	s.Equal(true, ok)
+	s.Equal(schema.TypeString, typ)
+	_, ok = sch.PropertyType("fax")
+	s.Equal(false, ok)
+
+	for _, doc := range []string{
+		`[]`,
+		`{"type": "array"}`,
+		`{"properties": {}}`,
+		`{"type": "object", "properties": {"a": {"type": "text"}}}`,
+		`{"type": "object", "properties": {"a": {"$ref": "#/definitions/a"}}}`,
+		`{"type": "object", "properties": {"a": {"type": "string", "format": "uuid"}}}`,
+		`{"type": "object", "properties": {"a": {"type": "string", "pattern": "("}}}`,
+		`{"type": "object", "properties": {"a": {"type": "string", "maxLength": -1}}}`,
+		`{"type": "object", "additionalProperties": {"oneOf": []}}`,
+	} {
+		_, err := schema.Parse([]byte(doc))
+		s.NotNil(err, doc)
+	}
+}
+
+func (s *_Suite) TestValidate() {
+	sch, err := schema.Parse([]byte(`{
+		"type": "object",
+		"properties": {
+			"department": {"enum": ["sales", "engineering"]},
+			"phone": {"type": "string", "pattern": "^\\+[0-9]{8,15}$"},
+			"nickname": {"type": "string", "minLength": 2, "maxLength": 4},
+			"email": {"type": "string", "format": "email"},
+			"hired": {"type": "string", "format": "date"},
+			"level": {"type": "integer", "minimum": 1, "maximum": 9},
+			"quota": {"type": ["number", "null"], "exclusiveMinimum": 0},
+			"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2},
+			"manager": {
+				"type": "object",
+				"properties": {"account": {"type": "string"}},
+				"required": ["account"]
+			}
+		},
+		"required": ["department"],
+		"additionalProperties": {"type": "string"}
+	}`))
+	s.Equal(nil, err)
+
+	s.Equal(0, len(s.validate(sch, `{
+		"department": "sales",
+		"phone": "+886912345678",
+		"nickname": "小飛俠",
+		"email": "kobe@example.com",
+		"hired": "1996-07-11",
+		"level": 8,
+		"quota": null,
+		"tags": ["mamba"],
+		"manager": {"account": "jerry_west"},
+		"team": "lakers"
+	}`)))
+
+	violations := s.validate(sch, `{
+		"phone": "0912",
+		"nickname": "k",
+		"email": "Kobe <kobe@example.com>",
+		"hired": "07/11/1996",
+		"level": 8.5,
+		"quota": 0,
+		"tags": ["mamba", 24],
+		"manager": {},
+		"team": 24
+	}`)
+	paths := map[string]string{}
+	for _, v := range violations {
+		paths[v.Path] = v.Message
+	}
+	s.Equal(map[string]string{
+		"/department":      "is required",
+		"/phone":           `must match ^\+[0-9]{8,15}$`,
+		"/nickname":        "must be at least 2 characters long",
+		"/email":           "must be a valid email",
+		"/hired":           "must be a valid date",
+		"/level":           "must be of type integer",
+		"/quota":           "must be greater than 0",
+		"/tags/1":          "must be of type string",
+		"/manager/account": "is required",
+		"/team":            "must be of type string",
+	}, paths)
+	s.Equal("/department", violations[0].Path)
+
+	s.Equal([]schema.Violation{{Path: "/department", Message: `must be one of ["sales","engineering"]`}},
+		s.validate(sch, `{"department": "marketing"}`))
+	s.Equal([]schema.Violation{{Path: "/tags", Message: "must have at most 2 items"}},
+		s.validate(sch, `{"department": "sales", "tags": ["a", "b", "c"]}`))
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(_Suite))
+}
@@ -1,6 +1,7 @@
 package secret
 
 import (
+	"context"
 	"crypto/rsa"
 	"io/ioutil"
 	"log"
@@ -13,8 +14,9 @@ const (
 	pubKeyFile = "/ui_rsa_pub.pem"
 	priKeyFile = "/ui_rsa_pri.pem"
 
-	validDuration time.Duration = time.Minute * 15
-	//validDuration time.Duration = time.Second * 1
+	// ValidDuration is how long a JWT is valid from its issue.
+	ValidDuration time.Duration = time.Minute * 15
+	//ValidDuration time.Duration = time.Second * 1
 )
 
 var (
@@ -30,16 +32,21 @@ type JWTError struct {
 	code JWTErrorCode
 }
 
+func NewJWTError(code JWTErrorCode) *JWTError {
+	return &JWTError{code}
+}
+
 func (err *JWTError) Code() JWTErrorCode {
 	return err.code
 }
 
 const (
-	JWTUnknownError      JWTErrorCode = 0
-	JWTNotActiveError    JWTErrorCode = 1
-	JWTExpiredError      JWTErrorCode = 2
-	JWTAcctNotMatchError JWTErrorCode = 3
-	JWTNotAuthError      JWTErrorCode = 4
+	JWTUnknownError        JWTErrorCode = 0
+	JWTNotActiveError      JWTErrorCode = 1
+	JWTExpiredError        JWTErrorCode = 2
+	JWTAcctNotMatchError   JWTErrorCode = 3
+	JWTNotAuthError        JWTErrorCode = 4
+	JWTTenantNotMatchError JWTErrorCode = 5
 )
 
 func (err *JWTError) Error() string {
@@ -52,17 +59,99 @@ func (err *JWTError) Error() string {
 		return "The account is not matched"
 	case JWTNotAuthError:
 		return "Not authorized JWT"
+	case JWTTenantNotMatchError:
+		return "The tenant is not matched"
 	}
 
 	return "Unknown JWT error"
 }
 
 const (
-	JWTClaimFieldAcct = "acct"
-	JWTClaimFieldAuth = "authorized"
-	JWTClaimFieldExp  = "exp"
+	JWTClaimFieldAcct   = "acct"
+	JWTClaimFieldAuth   = "authorized"
+	JWTClaimFieldExp    = "exp"
+	JWTClaimFieldRoles  = "roles"
+	JWTClaimFieldGroups = "groups"
+	JWTClaimFieldTenant = "tenant"
 )
 
+// RoleAdmin may act on every account of its tenant and manage its groups.
+const RoleAdmin = "admin"
+
+// RoleSuperAdmin may act in every tenant and read the admin endpoints of
+// the deployment.
+const RoleSuperAdmin = "super_admin"
+
+// UserClaims are the verified claims of a user JWT.
+type UserClaims struct {
+	Acct   string
+	Tenant string
+	Roles  []string
+	Groups []string
+}
+
+func (c *UserClaims) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// InGroup reports whether the JWT names group among the groups of the
+// account, directly or through nesting. Only JWTs created WithGroups do.
+func (c *UserClaims) InGroup(group string) bool {
+	for _, g := range c.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// ClaimOption adds optional claims to a user JWT.
+type ClaimOption func(jwt.MapClaims)
+
+// WithRoles grants roles to the bearer.
+func WithRoles(roles ...string) ClaimOption {
+	return func(claims jwt.MapClaims) {
+		if len(roles) > 0 {
+			claims[JWTClaimFieldRoles] = roles
+		}
+	}
+}
+
+// WithTenant names the tenant of the bearer. JWTs without one were issued
+// before tenants and belong to the default tenant.
+func WithTenant(tenant string) ClaimOption {
+	return func(claims jwt.MapClaims) {
+		claims[JWTClaimFieldTenant] = tenant
+	}
+}
+
+// WithGroups names the groups of the bearer.
+func WithGroups(groups ...string) ClaimOption {
+	return func(claims jwt.MapClaims) {
+		if len(groups) > 0 {
+			claims[JWTClaimFieldGroups] = groups
+		}
+	}
+}
+
+type claimsKey struct{}
+
+// NewContext returns a copy of ctx carrying the claims of the caller.
+func NewContext(ctx context.Context, claims *UserClaims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// FromContext returns the claims stored by NewContext, if any.
+func FromContext(ctx context.Context) (*UserClaims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*UserClaims)
+	return claims, ok
+}
+
 func InitSecretKey(keyDir string) {
 	var err error
 	if pubKey, err = ioutil.ReadFile(keyDir + pubKeyFile); err != nil {
@@ -79,14 +168,17 @@ func InitSecretKey(keyDir string) {
 	}
 }
 
-func CreateUserJWT(acct string) (string, error) {
+func CreateUserJWT(acct string, opts ...ClaimOption) (string, error) {
 	var err error
 
 	//Creating Access Token
 	atClaims := jwt.MapClaims{}
 	atClaims[JWTClaimFieldAuth] = true
 	atClaims[JWTClaimFieldAcct] = acct
-	atClaims[JWTClaimFieldExp] = time.Now().Add(validDuration).Unix()
+	atClaims[JWTClaimFieldExp] = time.Now().Add(ValidDuration).Unix()
+	for _, opt := range opts {
+		opt(atClaims)
+	}
 	at := jwt.NewWithClaims(jwt.SigningMethodRS256, atClaims)
 	if err != nil {
 		return "", err
@@ -98,35 +190,63 @@ func CreateUserJWT(acct string) (string, error) {
 	return token, nil
 }
 
-func VerifyUserJWT(tokenStr, acct string) error {
+// ParseUserJWT verifies tokenStr and returns its claims.
+func ParseUserJWT(tokenStr string) (*UserClaims, error) {
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
 		return rsaPubKey, nil
 	})
 	if err != nil {
 		if ve, ok := err.(*jwt.ValidationError); ok {
 			if ve.Errors&jwt.ValidationErrorNotValidYet != 0 {
-				return &JWTError{JWTNotActiveError}
+				return nil, &JWTError{JWTNotActiveError}
 			} else if ve.Errors&jwt.ValidationErrorExpired != 0 {
-				return &JWTError{JWTExpiredError}
+				return nil, &JWTError{JWTExpiredError}
 			}
 
 			log.Print(err)
-			return &JWTError{JWTUnknownError}
+			return nil, &JWTError{JWTUnknownError}
 		}
 	}
 
-	if token.Valid {
+	if token != nil && token.Valid {
 		claims := token.Claims.(jwt.MapClaims)
-		s := claims[JWTClaimFieldAcct].(string)
-		if acct != "" && s != acct {
-			return &JWTError{JWTAcctNotMatchError}
+		s, _ := claims[JWTClaimFieldAcct].(string)
+		if auth, _ := claims[JWTClaimFieldAuth].(bool); !auth {
+			return nil, &JWTError{JWTNotAuthError}
+		}
+
+		uc := &UserClaims{Acct: s}
+		uc.Tenant, _ = claims[JWTClaimFieldTenant].(string)
+		if roles, ok := claims[JWTClaimFieldRoles].([]interface{}); ok {
+			for _, role := range roles {
+				if r, ok := role.(string); ok {
+					uc.Roles = append(uc.Roles, r)
+				}
+			}
 		}
-		if !claims[JWTClaimFieldAuth].(bool) {
-			return &JWTError{JWTNotAuthError}
+		if groups, ok := claims[JWTClaimFieldGroups].([]interface{}); ok {
+			for _, group := range groups {
+				if g, ok := group.(string); ok {
+					uc.Groups = append(uc.Groups, g)
+				}
+			}
 		}
 
-		return nil
+		return uc, nil
+	}
+
+	return nil, &JWTError{JWTUnknownError}
+}
+
+func VerifyUserJWT(tokenStr, acct string) error {
+	claims, err := ParseUserJWT(tokenStr)
+	if err != nil {
+		return err
+	}
+
+	if acct != "" && claims.Acct != acct {
+		return &JWTError{JWTAcctNotMatchError}
 	}
 
-	return &JWTError{JWTUnknownError}
+	return nil
 }
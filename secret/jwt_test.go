@@ -1,6 +1,7 @@
 package secret
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
@@ -43,6 +44,43 @@ func (s *_Suite) TestJWT() {
 	s.Equal("The account is not matched", err.Error())
 }
 
+func (s *_Suite) TestJWTRoles() {
+	token, err := CreateUserJWT("kobe", WithRoles(RoleAdmin))
+	s.Equal(nil, err)
+
+	claims, err := ParseUserJWT(token)
+	s.Equal(nil, err)
+	s.Equal("kobe", claims.Acct)
+	s.Equal(true, claims.HasRole(RoleAdmin))
+
+	token, err = CreateUserJWT("kobe")
+	s.Equal(nil, err)
+
+	claims, err = ParseUserJWT(token)
+	s.Equal(nil, err)
+	s.Equal(false, claims.HasRole(RoleAdmin))
+
+	s.Equal(0, len(claims.Groups))
+
+	ctx := NewContext(context.Background(), claims)
+	got, ok := FromContext(ctx)
+	s.Equal(true, ok)
+	s.Equal(claims, got)
+}
+
+func (s *_Suite) TestJWTGroups() {
+	token, err := CreateUserJWT("kobe", WithRoles(), WithGroups("lakers", "nba"), WithTenant("la"))
+	s.Equal(nil, err)
+
+	claims, err := ParseUserJWT(token)
+	s.Equal(nil, err)
+	s.Equal([]string{"lakers", "nba"}, claims.Groups)
+	s.Equal(true, claims.InGroup("nba"))
+	s.Equal(false, claims.InGroup("celtics"))
+	s.Equal(0, len(claims.Roles))
+	s.Equal("la", claims.Tenant)
+}
+
 func TestRun(t *testing.T) {
 	suite.Run(t, new(_Suite))
 }
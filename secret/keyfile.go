@@ -0,0 +1,46 @@
+package secret
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// The key files hold the base64 of a random 32 byte AES-256 key, e.g. made
+// by
+//
+//	head -c 32 /dev/urandom | base64 > secret/ui_backup.key
+const (
+	// backupKeyFile seals the backups of the user store.
+	backupKeyFile = "/ui_backup.key"
+
+	// masterKeyFile wraps the data keys that seal the personal data of
+	// the users.
+	masterKeyFile = "/ui_master.key"
+)
+
+// ReadBackupKey reads the backup key in keyDir.
+func ReadBackupKey(keyDir string) ([]byte, error) {
+	return readKeyFile(keyDir + backupKeyFile)
+}
+
+// ReadMasterKey reads the master key in keyDir.
+func ReadMasterKey(keyDir string) ([]byte, error) {
+	return readKeyFile(keyDir + masterKeyFile)
+}
+
+func readKeyFile(file string) ([]byte, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", file, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%v: the key must be 32 bytes, not %v", file, len(key))
+	}
+	return key, nil
+}
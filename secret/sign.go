@@ -0,0 +1,39 @@
+package secret
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"io/ioutil"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Sign signs data with the RSA private key of InitSecretKey
+// (RSASSA-PKCS1-v1_5 with SHA-256).
+func Sign(data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	return rsa.SignPKCS1v15(rand.Reader, rsaPriKey, crypto.SHA256, digest[:])
+}
+
+// VerifySignature checks a signature made by Sign against pub.
+func VerifySignature(pub *rsa.PublicKey, data, sig []byte) error {
+	digest := sha256.Sum256(data)
+	return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig)
+}
+
+// PublicKey returns the RSA public key of InitSecretKey.
+func PublicKey() *rsa.PublicKey {
+	return rsaPubKey
+}
+
+// ReadPublicKey reads the RSA public key in keyDir, for tools that verify
+// signatures without holding the private key.
+func ReadPublicKey(keyDir string) (*rsa.PublicKey, error) {
+	pem, err := ioutil.ReadFile(keyDir + pubKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return jwt.ParseRSAPublicKeyFromPEM(pem)
+}
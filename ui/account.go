@@ -0,0 +1,134 @@
+package ui
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/secret"
+	"github.com/jinzhu/gorm"
+)
+
+// The reasons an account name is refused for.
+const (
+	AccountReserved     = "reserved"
+	AccountCaseConflict = "case_conflict"
+	AccountConfusable   = "confusable"
+)
+
+// DefaultReservedAccounts are the names no account is signed up with, or
+// renamed to, unless the operator configures others.
+var DefaultReservedAccounts = []string{
+	"admin", "administrator", "root", "superuser", "sysadmin", "system",
+	"support", "security", "postmaster", "webmaster", "hostmaster",
+	"noreply", "moderator", "operator", "anonymous",
+}
+
+// AccountPolicyError refuses an account name for Reason.
+type AccountPolicyError struct {
+	Reason string
+}
+
+func (e *AccountPolicyError) Error() string {
+	return "the account name is not allowed: " + e.Reason
+}
+
+// confusables map the characters of account names to the ones they are
+// mistaken for.
+var confusables = strings.NewReplacer("0", "o", "1", "l", "i", "l", "5", "s")
+
+// accountSkeleton returns the form acct shares with the names it is
+// visually confusable with: lowercased, its look-alike characters mapped to
+// one, and rn and vv read as m and w. It is the acct_skeleton function of
+// the database.
+func accountSkeleton(acct string) string {
+	s := confusables.Replace(strings.ToLower(acct))
+	s = strings.ReplaceAll(s, "rn", "m")
+	return strings.ReplaceAll(s, "vv", "w")
+}
+
+// reservedAccount reports whether acct is one of ui.ReservedAccounts, but
+// for case, underscores, trailing digits and look-alike characters.
+func (ui *UI) reservedAccount(acct string) bool {
+	stem := strings.TrimRight(strings.ReplaceAll(acct, "_", ""), "0123456789")
+	skeleton := accountSkeleton(stem)
+	for _, name := range ui.ReservedAccounts {
+		if accountSkeleton(strings.ReplaceAll(name, "_", "")) == skeleton {
+			return true
+		}
+	}
+	return false
+}
+
+// roleAccount reports whether the operator grants acct of tenant a role by
+// its name, in Admins or SuperAdmins. Once such a name is freed, by a
+// delete, an erasure or a rename, whoever took it would get the role, so no
+// signup or rename takes it: the holders of the role import it.
+func (ui *UI) roleAccount(tenant, acct string) bool {
+	name := TenantAccount(tenant, acct)
+	return ui.Admins[name] || ui.SuperAdmins[name]
+}
+
+// outranks reports whether acct of tenant is granted by its name a role the
+// caller of ctx does not hold. Whoever set its password would log in with
+// the role, so the caller may not update, delete, erase or import it.
+func (ui *UI) outranks(ctx context.Context, tenant, acct string) bool {
+	claims, ok := secret.FromContext(ctx)
+	holds := func(role string) bool {
+		return ok && (claims.HasRole(role) || claims.HasRole(secret.RoleSuperAdmin))
+	}
+
+	name := TenantAccount(tenant, acct)
+	return ui.SuperAdmins[name] && !holds(secret.RoleSuperAdmin) ||
+		ui.Admins[name] && !holds(secret.RoleAdmin)
+}
+
+// writeOutranked refuses an operation on acct, which outranks the caller.
+func writeOutranked(acct string, w http.ResponseWriter) {
+	WriteJsonResponse(StatusNoAuth,
+		map[string]string{"user": acct, "error": "The role the account is granted is required"}, w)
+}
+
+// checkAccount refuses acct when an account of the tenant other than self
+// has it but for case, or is visually confusable with it. It holds a lock
+// on the skeleton of acct until tx ends, so concurrent signups and renames
+// to confusable names are checked one after the other.
+func checkAccount(ctx context.Context, tx *gorm.DB, acct, self string) error {
+	tenant := Tenant(ctx)
+	if res := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(? || '/' || acct_skeleton(?)))", tenant, acct); res.Error != nil {
+		return res.Error
+	}
+
+	var other string
+	err := usersOf(ctx, tx).
+		Select(pg.FieldUserAcct.String()).
+		Where("acct_skeleton("+pg.FieldUserAcct.String()+") = acct_skeleton(?)", acct).
+		Where(pg.FieldUserAcct.String()+" <> ? AND "+pg.FieldUserAcct.String()+" <> ?", acct, self).
+		Limit(1).
+		Row().
+		Scan(&other)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil
+	case err != nil:
+		return err
+	case strings.EqualFold(other, acct):
+		return &AccountPolicyError{Reason: AccountCaseConflict}
+	default:
+		return &AccountPolicyError{Reason: AccountConfusable}
+	}
+}
+
+// writeAccountNotAllowed answers that acct is refused, if err refuses it,
+// and reports whether it did.
+func writeAccountNotAllowed(acct string, err error, w http.ResponseWriter) bool {
+	var pe *AccountPolicyError
+	if !errors.As(err, &pe) {
+		return false
+	}
+	WriteJsonResponse(StatusAccountNotAllowed, map[string]string{"user": acct, "reason": pe.Reason}, w)
+	return true
+}
@@ -0,0 +1,82 @@
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/schema"
+)
+
+const (
+	// MaxAttributesSize bounds the JSON of the attributes of a user.
+	MaxAttributesSize = 16 << 10
+
+	// attributeFilterPrefix marks the attribute equality filters of
+	// GET /ui/v1/users, e.g. attr.department=sales.
+	attributeFilterPrefix = "attr."
+)
+
+// parseAttributes checks the attributes v of a request body against the
+// schema of the operator. Without a schema only an empty object is taken.
+func (ui *UI) parseAttributes(v interface{}) (pg.JSONB, []schema.Violation) {
+	attrs, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, []schema.Violation{{Path: "", Message: "must be of type object"}}
+	}
+
+	if ui.AttributeSchema == nil {
+		if len(attrs) > 0 {
+			return nil, []schema.Violation{{Path: "", Message: "no attributes are accepted"}}
+		}
+	} else if violations := ui.AttributeSchema.Validate(attrs); len(violations) > 0 {
+		return nil, violations
+	}
+
+	js, err := json.Marshal(attrs)
+	if err != nil {
+		return nil, []schema.Violation{{Path: "", Message: err.Error()}}
+	}
+	if len(js) > MaxAttributesSize {
+		return nil, []schema.Violation{{Path: "", Message: fmt.Sprintf("must be at most %v bytes", MaxAttributesSize)}}
+	}
+	return pg.JSONB(js), nil
+}
+
+// parseAttributeFilters returns the attr.{name} filters of values as the
+// object the attributes of a user must contain. Only the top-level
+// properties the schema gives a scalar type can be filtered on.
+func parseAttributeFilters(values url.Values, sch *schema.Schema) (map[string]interface{}, error) {
+	filters := map[string]interface{}{}
+	for key := range values {
+		if !strings.HasPrefix(key, attributeFilterPrefix) {
+			continue
+		}
+		name, v := strings.TrimPrefix(key, attributeFilterPrefix), values.Get(key)
+		if sch == nil {
+			return nil, &queryError{key, v}
+		}
+		typ, _ := sch.PropertyType(name)
+
+		var err error
+		switch typ {
+		case schema.TypeString:
+			filters[name] = v
+		case schema.TypeInteger:
+			filters[name], err = strconv.ParseInt(v, 10, 64)
+		case schema.TypeNumber:
+			filters[name], err = strconv.ParseFloat(v, 64)
+		case schema.TypeBoolean:
+			filters[name], err = strconv.ParseBool(v)
+		default:
+			return nil, &queryError{key, v}
+		}
+		if err != nil {
+			return nil, &queryError{key, v}
+		}
+	}
+	return filters, nil
+}
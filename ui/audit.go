@@ -0,0 +1,360 @@
+package ui
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dontang97/ui/audit"
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/secret"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	AuditActionSignUp = "signup"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+	AuditActionLogin  = "login"
+	AuditActionExport = "export"
+	AuditActionErase  = audit.ActionErase
+	AuditActionImport = "import"
+	AuditActionRevert = "revert"
+	AuditActionRename = "rename"
+
+	AuditOutcomeSuccess = audit.OutcomeSuccess
+	AuditOutcomeFailure = "failure"
+	AuditOutcomeError   = "error"
+
+	// auditMaxLen bounds the client supplied strings kept in an event.
+	auditMaxLen = 64
+
+	DefaultAuditLimit = 100
+	MaxAuditLimit     = 1000
+)
+
+type AuditHandlerFunc func(context.Context, *UI, *pg.AuditEvent) error
+type QueryAuditHandlerFunc func(context.Context, *UI, *AuditQuery) ([]pg.AuditEvent, error)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the ID of the request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID stored by WithRequestID, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// auditWriter records the status of the response so the audit event of a
+// handler can be completed once it returns.
+type auditWriter struct {
+	http.ResponseWriter
+	ui     *UI
+	status int
+	event  pg.AuditEvent
+
+	// tenant is the tenant of the target
+	tenant string
+}
+
+func (aw *auditWriter) WriteHeader(code int) {
+	if aw.status == 0 {
+		aw.status = code
+	}
+	aw.ResponseWriter.WriteHeader(code)
+}
+
+func (aw *auditWriter) Write(b []byte) (int, error) {
+	if aw.status == 0 {
+		aw.status = http.StatusOK
+	}
+	return aw.ResponseWriter.Write(b)
+}
+
+// startAudit begins the audit event of action. Handlers set its target and
+// diff and defer finish, which records it whatever the outcome.
+func (ui *UI) startAudit(w http.ResponseWriter, r *http.Request, action string) *auditWriter {
+	aw := &auditWriter{ResponseWriter: w, ui: ui, tenant: Tenant(r.Context())}
+	aw.event.Action = action
+	aw.event.RequestID = RequestID(r.Context())
+	aw.event.SourceIP = r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		aw.event.SourceIP = host
+	}
+	if claims, ok := secret.FromContext(r.Context()); ok {
+		aw.event.Actor = TenantAccount(ClaimsTenant(claims), claims.Acct)
+	}
+	return aw
+}
+
+// auditFields lists the audited fields of user, nil for no user.
+func auditFields(user *pg.User) map[string]string {
+	if user == nil {
+		return nil
+	}
+	return map[string]string{
+		"fullname":   user.Fullname,
+		"password":   user.Pwd,
+		"attributes": string(user.Attributes),
+	}
+}
+
+// diff stores the fields that differ between before and after. Either may
+// be nil for a user that did not exist.
+func (aw *auditWriter) diff(before, after *pg.User) {
+	b, a := auditFields(before), auditFields(after)
+
+	changes := map[string]map[string]interface{}{}
+	for _, fields := range []map[string]string{b, a} {
+		for field := range fields {
+			vb, okb := b[field]
+			va, oka := a[field]
+			if okb == oka && vb == va {
+				continue
+			}
+
+			change := map[string]interface{}{"before": nil, "after": nil}
+			if okb {
+				change["before"] = vb
+			}
+			if oka {
+				change["after"] = va
+			}
+			if credentialFields[field] || aw.ui.sealedField(field) {
+				for k, v := range change {
+					if v != nil {
+						change[k] = redacted
+					}
+				}
+			}
+			changes[field] = change
+		}
+	}
+
+	if js, err := json.Marshal(changes); err == nil {
+		aw.event.Diff = js
+	}
+}
+
+func truncate(s string, n int) string {
+	if rs := []rune(s); len(rs) > n {
+		return string(rs[:n])
+	}
+	return s
+}
+
+func (aw *auditWriter) finish() {
+	if aw.status == 0 {
+		aw.status = http.StatusOK
+	}
+
+	ev := &aw.event
+	ev.At = time.Now().UTC()
+	ev.Status = aw.status
+	if ev.Target != "" {
+		ev.Target = TenantAccount(aw.tenant, ev.Target)
+	}
+	ev.Target = truncate(ev.Target, auditMaxLen)
+	ev.RequestID = truncate(ev.RequestID, auditMaxLen)
+	switch {
+	case aw.status >= http.StatusInternalServerError:
+		ev.Outcome = AuditOutcomeError
+	case aw.status >= http.StatusBadRequest:
+		ev.Outcome = AuditOutcomeFailure
+	default:
+		ev.Outcome = AuditOutcomeSuccess
+	}
+
+	// the event is recorded even when the client has gone away
+	ctx, cancel := context.WithTimeout(context.Background(), aw.ui.ExecTimeout)
+	defer cancel()
+
+	if err := AuditHdl(ctx, aw.ui, ev); err != nil {
+		log.Print(err)
+	}
+}
+
+// auditAccount records the event of one of the accounts of tenant a bulk
+// request changed, besides the event of the request itself, base.
+func (ui *UI) auditAccount(base pg.AuditEvent, tenant, action, target string, before, after *pg.User) {
+	aw := &auditWriter{ui: ui, status: http.StatusOK, tenant: tenant}
+	aw.event = pg.AuditEvent{
+		Actor:     base.Actor,
+		Target:    target,
+		Action:    action,
+		SourceIP:  base.SourceIP,
+		RequestID: base.RequestID,
+	}
+	aw.diff(before, after)
+	aw.finish()
+}
+
+// auditChainLock is the advisory lock serializing appends to the chain.
+const auditChainLock = 0x61756469
+
+// lastAuditHash returns the Hash of the newest event, or audit.GenesisHash.
+func lastAuditHash(tx *gorm.DB) (string, error) {
+	rows, err := tx.
+		Table(pg.TableAuditEvents.String()).
+		Select(pg.FieldAuditHash.String()).
+		Where(pg.FieldAuditHash.String() + " <> ''").
+		Order(pg.FieldAuditID.String() + " DESC").
+		Limit(1).
+		Rows()
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	hash := audit.GenesisHash
+	if rows.Next() {
+		if err := rows.Scan(&hash); err != nil {
+			return "", err
+		}
+	}
+	return hash, rows.Err()
+}
+
+// AuditHdl appends ev to the hash chain of the audit log.
+var AuditHdl AuditHandlerFunc = func(ctx context.Context, ui *UI, ev *pg.AuditEvent) error {
+	return ui.Transaction(ctx, func(tx *gorm.DB) error {
+		if res := tx.Exec("SELECT pg_advisory_xact_lock(?)", auditChainLock); res.Error != nil {
+			return res.Error
+		}
+
+		prev, err := lastAuditHash(tx)
+		if err != nil {
+			return err
+		}
+		if err := audit.Seal(ev, prev); err != nil {
+			return err
+		}
+
+		return tx.Table(pg.TableAuditEvents.String()).Create(ev).Error
+	})
+}
+
+////////////////////////////////////
+//////    GET /ui/v1/audit    //////
+////////////////////////////////////
+
+// AuditQuery is the parsed query string of GET /ui/v1/audit. Events are
+// listed from the newest; Before is the ID the page starts below.
+type AuditQuery struct {
+	Actor   string
+	Target  string
+	Action  string
+	Outcome string
+	Since   time.Time
+	Until   time.Time
+	Before  int64
+	Limit   int
+}
+
+func parseAuditQuery(r *http.Request) (*AuditQuery, error) {
+	values := r.URL.Query()
+	q := &AuditQuery{
+		Actor:   values.Get("actor"),
+		Target:  values.Get("target"),
+		Action:  values.Get("action"),
+		Outcome: values.Get("outcome"),
+		Limit:   DefaultAuditLimit,
+	}
+
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > MaxAuditLimit {
+			return nil, &queryError{"limit", v}
+		}
+		q.Limit = n
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, &queryError{"cursor", v}
+		}
+		q.Before = n
+	}
+
+	for name, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		v := values.Get(name)
+		if v == "" {
+			continue
+		}
+		var err error
+		if *t, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return nil, &queryError{name, v}
+		}
+	}
+
+	return q, nil
+}
+
+var AuditQueryHdl QueryAuditHandlerFunc = func(ctx context.Context, ui *UI, q *AuditQuery) ([]pg.AuditEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.QueryTimeout)
+	defer cancel()
+
+	db := ui.WithContext(ctx).Table(pg.TableAuditEvents.String())
+	for field, v := range map[pg.Field]string{
+		pg.FieldAuditActor:   q.Actor,
+		pg.FieldAuditTarget:  q.Target,
+		pg.FieldAuditAction:  q.Action,
+		pg.FieldAuditOutcome: q.Outcome,
+	} {
+		if v != "" {
+			db = db.Where(field.String()+" = ?", v)
+		}
+	}
+	if !q.Since.IsZero() {
+		db = db.Where(pg.FieldAuditAt.String()+" >= ?", q.Since.UTC())
+	}
+	if !q.Until.IsZero() {
+		db = db.Where(pg.FieldAuditAt.String()+" < ?", q.Until.UTC())
+	}
+	if q.Before > 0 {
+		db = db.Where(pg.FieldAuditID.String()+" < ?", q.Before)
+	}
+
+	events := []pg.AuditEvent{}
+	res := db.Order(pg.FieldAuditID.String() + " DESC").Limit(q.Limit + 1).Find(&events)
+	return events, res.Error
+}
+
+func (ui *UI) Audit(w http.ResponseWriter, r *http.Request) {
+	q, err := parseAuditQuery(r)
+	if err != nil {
+		qe := err.(*queryError)
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": qe.field, "value": qe.value}}, w)
+		return
+	}
+
+	events, err := AuditQueryHdl(r.Context(), ui, q)
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+
+	data := map[string]interface{}{}
+	if len(events) > q.Limit {
+		events = events[:q.Limit]
+
+		next := *r.URL
+		values := next.Query()
+		values.Set("cursor", strconv.FormatInt(events[len(events)-1].ID, 10))
+		next.RawQuery = values.Encode()
+		data["next"] = next.RequestURI()
+	}
+	data["events"] = events
+
+	WriteJsonResponse(StatusOK, data, w)
+}
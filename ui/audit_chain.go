@@ -0,0 +1,176 @@
+package ui
+
+import (
+	"context"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dontang97/ui/audit"
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/secret"
+	"github.com/jinzhu/gorm"
+)
+
+// CheckpointHandlerFunc signs the current head of the audit chain. It
+// returns nil when the head is already covered by a checkpoint.
+type CheckpointHandlerFunc func(context.Context, *UI) (*pg.AuditCheckpoint, error)
+
+// ExportAuditHandlerFunc writes the events from ID args[0] on, with their
+// checkpoints, to the bundle.
+type ExportAuditHandlerFunc func(context.Context, *UI, *audit.Writer, int64) error
+
+var CheckpointHdl CheckpointHandlerFunc = func(ctx context.Context, ui *UI) (*pg.AuditCheckpoint, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
+
+	var cp *pg.AuditCheckpoint
+	err := ui.Transaction(ctx, func(tx *gorm.DB) error {
+		if res := tx.Exec("SELECT pg_advisory_xact_lock(?)", auditChainLock); res.Error != nil {
+			return res.Error
+		}
+
+		heads := []pg.AuditEvent{}
+		if res := tx.
+			Table(pg.TableAuditEvents.String()).
+			Where(pg.FieldAuditHash.String() + " <> ''").
+			Order(pg.FieldAuditID.String() + " DESC").
+			Limit(1).
+			Find(&heads); res.Error != nil {
+			return res.Error
+		}
+		if len(heads) == 0 {
+			return nil
+		}
+		head := heads[0]
+
+		var count int
+		if res := tx.
+			Table(pg.TableAuditCheckpoints.String()).
+			Where(pg.FieldCheckpointEventID.String()+" = ?", head.ID).
+			Count(&count); res.Error != nil {
+			return res.Error
+		}
+		if count > 0 {
+			return nil
+		}
+
+		cp = &pg.AuditCheckpoint{
+			EventID:   head.ID,
+			Hash:      head.Hash,
+			CreatedAt: time.Now().UTC().Truncate(audit.Precision),
+		}
+		sig, err := secret.Sign(audit.CheckpointPayload(cp))
+		if err != nil {
+			return err
+		}
+		cp.Signature = base64.StdEncoding.EncodeToString(sig)
+
+		return tx.Table(pg.TableAuditCheckpoints.String()).Create(cp).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// RunAuditCheckpoints signs the head of the audit chain every interval
+// until ctx is done.
+func (ui *UI) RunAuditCheckpoints(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cp, err := CheckpointHdl(ctx, ui)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		if cp != nil {
+			log.Printf("Signed audit checkpoint %v at event %v", cp.ID, cp.EventID)
+		}
+	}
+}
+
+var ExportAuditHdl ExportAuditHandlerFunc = func(ctx context.Context, ui *UI, bw *audit.Writer, sinceID int64) error {
+	// checkpoints are few, one per interval, so they are loaded up front
+	// and interleaved with the streamed events
+	checkpoints := []pg.AuditCheckpoint{}
+	if res := ui.WithContext(ctx).
+		Table(pg.TableAuditCheckpoints.String()).
+		Where(pg.FieldCheckpointEventID.String()+" >= ?", sinceID).
+		Order(pg.FieldCheckpointID.String()).
+		Find(&checkpoints); res.Error != nil {
+		return res.Error
+	}
+	byEvent := map[int64][]pg.AuditCheckpoint{}
+	for _, cp := range checkpoints {
+		byEvent[cp.EventID] = append(byEvent[cp.EventID], cp)
+	}
+
+	rows, err := ui.WithContext(ctx).
+		Table(pg.TableAuditEvents.String()).
+		Where(pg.FieldAuditID.String()+" >= ?", sinceID).
+		Order(pg.FieldAuditID.String()).
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ev pg.AuditEvent
+		if err := ui.DB().ScanRows(rows, &ev); err != nil {
+			return err
+		}
+		if err := bw.Event(&ev); err != nil {
+			return err
+		}
+		for i := range byEvent[ev.ID] {
+			if err := bw.Checkpoint(&byEvent[ev.ID][i]); err != nil {
+				return err
+			}
+		}
+	}
+	return rows.Err()
+}
+
+///////////////////////////////////////////
+//////    GET /ui/v1/audit/export    //////
+///////////////////////////////////////////
+
+func (ui *UI) AuditExport(w http.ResponseWriter, r *http.Request) {
+	var sinceID int64
+	if v := r.URL.Query().Get("since_id"); v != "" {
+		var err error
+		if sinceID, err = strconv.ParseInt(v, 10, 64); err != nil || sinceID < 0 {
+			WriteJsonResponse(StatusInvalidContent,
+				map[string]map[string]string{"invalid": {"field": "since_id", "value": v}}, w)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.ndjson"`)
+
+	bw, err := audit.NewWriter(w)
+	if err == nil {
+		err = ExportAuditHdl(r.Context(), ui, bw, sinceID)
+	}
+	if err == nil {
+		err = bw.Close()
+	}
+	if err != nil {
+		// the status has gone out with the header; the missing trailer
+		// tells the verifier that the bundle is truncated
+		log.Print(err)
+	}
+}
@@ -0,0 +1,86 @@
+package ui
+
+import (
+	"context"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/secret"
+)
+
+// MemberOfHandlerFunc returns the groups an account of the tenant of the
+// context belongs to, directly or through nested groups, whose roles Login
+// puts in its JWT.
+type MemberOfHandlerFunc func(context.Context, *UI, string) ([]pg.Group, error)
+
+var MemberOfHdl MemberOfHandlerFunc = func(ctx context.Context, ui *UI, acct string) ([]pg.Group, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.QueryTimeout)
+	defer cancel()
+
+	groups := []pg.Group{}
+	res := ui.readDB(ctx, acct).Raw(`
+		WITH RECURSIVE member_of (id) AS (
+			SELECT `+pg.FieldMemberGroupID.String()+` FROM `+pg.TableGroupMembers.String()+`
+			WHERE `+pg.FieldMemberTenant.String()+` = ? AND `+pg.FieldMemberAcct.String()+` = ?
+			UNION
+			SELECT s.`+pg.FieldSubgroupParentID.String()+` FROM `+pg.TableGroupSubgroups.String()+` s
+			JOIN member_of m ON s.`+pg.FieldSubgroupChildID.String()+` = m.id
+		)
+		SELECT g.* FROM `+pg.TableGroups.String()+` g JOIN member_of m ON g.`+pg.FieldGroupID.String()+` = m.id
+		ORDER BY g.`+pg.FieldGroupName.String(), Tenant(ctx), acct).
+		Scan(&groups)
+	return groups, res.Error
+}
+
+// issueJWT returns a JWT of acct of the tenant of ctx, with the roles, and
+// the groups if GroupsClaim, a login grants.
+func (ui *UI) issueJWT(ctx context.Context, acct string) (string, error) {
+	groups, err := MemberOfHdl(ctx, ui, acct)
+	if err != nil {
+		return "", err
+	}
+
+	tenant := Tenant(ctx)
+	opts := []secret.ClaimOption{
+		secret.WithTenant(tenant),
+		secret.WithRoles(ui.grantedRoles(tenant, acct, groups)...),
+	}
+	if ui.GroupsClaim {
+		names := make([]string, 0, len(groups))
+		for _, g := range groups {
+			names = append(names, g.Name)
+		}
+		opts = append(opts, secret.WithGroups(names...))
+	}
+	return secret.CreateUserJWT(acct, opts...)
+}
+
+// grantedRoles returns the roles of acct of tenant: those of its groups,
+// and those of the Admins and SuperAdmins of the operator. Only these grant
+// secret.RoleSuperAdmin; a group that has it from before it was refused
+// does not.
+func (ui *UI) grantedRoles(tenant, acct string, groups []pg.Group) []string {
+	var roles []string
+	seen := map[string]bool{}
+	grant := func(role string) {
+		if !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+
+	name := TenantAccount(tenant, acct)
+	if ui.SuperAdmins[name] {
+		grant(secret.RoleSuperAdmin)
+	}
+	if ui.Admins[name] {
+		grant(secret.RoleAdmin)
+	}
+	for _, g := range groups {
+		for _, role := range g.Roles {
+			if role != secret.RoleSuperAdmin {
+				grant(role)
+			}
+		}
+	}
+	return roles
+}
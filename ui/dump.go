@@ -0,0 +1,200 @@
+package ui
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dontang97/ui/dump"
+	"github.com/dontang97/ui/pg"
+	"github.com/jinzhu/gorm"
+)
+
+// DumpUsersHandlerFunc calls fn with each user of the tenant, by account.
+type DumpUsersHandlerFunc func(context.Context, *UI, func(*pg.User) error) error
+
+// DumpOptions are the options of a bulk export of the users.
+type DumpOptions struct {
+	// Format is dump.CSV, dump.NDJSON or dump.Parquet.
+	Format string
+
+	// Columns are the columns of the rows, of dump.Columns.
+	Columns []string
+
+	// Gzip compresses the dump.
+	Gzip bool
+}
+
+// FileName returns the name of a file of the dump.
+func (opts *DumpOptions) FileName() string {
+	name := "users." + opts.Format
+	if opts.Gzip {
+		name += ".gz"
+	}
+	return name
+}
+
+// ContentType returns the media type of the dump.
+func (opts *DumpOptions) ContentType() string {
+	if opts.Gzip {
+		return "application/gzip"
+	}
+	return dump.ContentTypes[opts.Format]
+}
+
+func parseDumpOptions(values url.Values) (*DumpOptions, error) {
+	opts := &DumpOptions{Format: dump.CSV}
+	if v := values.Get("format"); v != "" {
+		if _, ok := dump.ContentTypes[v]; !ok {
+			return nil, &queryError{"format", v}
+		}
+		opts.Format = v
+	}
+
+	var err error
+	if opts.Columns, err = dump.ParseColumns(values.Get("columns")); err != nil {
+		return nil, &queryError{"columns", values.Get("columns")}
+	}
+
+	if v := values.Get("gzip"); v != "" {
+		if opts.Gzip, err = strconv.ParseBool(v); err != nil {
+			return nil, &queryError{"gzip", v}
+		}
+	}
+	return opts, nil
+}
+
+// DumpUsersHdl reads the users through a server-side cursor in a read-only
+// transaction, so the dump holds a fetch of rows at a time however many
+// there are, and sees them as of its start.
+var DumpUsersHdl DumpUsersHandlerFunc = func(ctx context.Context, ui *UI, fn func(*pg.User) error) error {
+	return ui.ReadTransaction(ctx, func(tx *gorm.DB) error {
+		if ui.RowSecurity {
+			if res := tx.Exec("SELECT set_config(?, ?, true)", pg.TenantSetting, Tenant(ctx)); res.Error != nil {
+				return res.Error
+			}
+		}
+
+		return pg.ScanCursor(tx, `
+			SELECT `+strings.Join([]string{
+			pg.FieldUserTenant.String(),
+			pg.FieldUserAcct.String(),
+			pg.FieldUserFullname.String(),
+			pg.FieldUserCreatedAt.String(),
+			pg.FieldUserUpdatedAt.String(),
+			pg.FieldUserAttributes.String(),
+		}, ", ")+`
+			FROM `+pg.TableUsers.String()+`
+			WHERE `+pg.FieldUserTenant.String()+` = ?
+			ORDER BY `+pg.FieldUserAcct.String(), []interface{}{Tenant(ctx)}, func(rows *sql.Rows) error {
+			var user pg.User
+			if err := ui.DB().ScanRows(rows, &user); err != nil {
+				return err
+			}
+			var err error
+			if user.Fullname, err = ui.openFullname(user.Fullname); err != nil {
+				return err
+			}
+			return fn(&user)
+		})
+	})
+}
+
+// Dump writes the users of the tenant of ctx to w as opts tells, and
+// returns how many it wrote. A dump cut short by an error is left
+// incomplete: a Parquet or gzip one unreadable, a CSV or NDJSON one
+// missing rows.
+func (ui *UI) Dump(ctx context.Context, w io.Writer, opts *DumpOptions) (int, error) {
+	var gz *gzip.Writer
+	if opts.Gzip {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+
+	dw, err := dump.NewWriter(w, opts.Format, opts.Columns)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	if err := DumpUsersHdl(ctx, ui, func(user *pg.User) error {
+		n++
+		return dw.Write(user)
+	}); err != nil {
+		return n, err
+	}
+
+	if err := dw.Close(); err != nil {
+		return n, err
+	}
+	if gz != nil {
+		return n, gz.Close()
+	}
+	return n, nil
+}
+
+// dumpWriter sends the headers of a dump with its first bytes, so a dump
+// that fails before any can still answer with an error, and pushes the
+// write deadline of the connection of r before each write, so a long dump
+// is not cut at the server write timeout.
+type dumpWriter struct {
+	w     http.ResponseWriter
+	r     *http.Request
+	opts  *DumpOptions
+	wrote bool
+}
+
+func (dw *dumpWriter) start() {
+	if !dw.wrote {
+		dw.wrote = true
+		dw.w.Header().Set("Content-Type", dw.opts.ContentType())
+		dw.w.Header().Set("Content-Disposition", `attachment; filename="`+dw.opts.FileName()+`"`)
+		dw.w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (dw *dumpWriter) Write(p []byte) (int, error) {
+	dw.start()
+	extendWriteDeadline(dw.r)
+	return dw.w.Write(p)
+}
+
+///////////////////////////////////////////
+//////    GET /ui/v1/users/export    //////
+///////////////////////////////////////////
+
+func (ui *UI) ExportUsers(w http.ResponseWriter, r *http.Request) {
+	aw := ui.startAudit(w, r, AuditActionExport)
+	defer aw.finish()
+	w = aw
+
+	opts, err := parseDumpOptions(r.URL.Query())
+	if err != nil {
+		qe := err.(*queryError)
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": qe.field, "value": qe.value}}, w)
+		return
+	}
+
+	dw := &dumpWriter{w: w, r: r, opts: opts}
+	n, err := ui.Dump(r.Context(), dw, opts)
+	if err == nil {
+		// an empty NDJSON dump has no bytes at all
+		dw.start()
+		return
+	}
+	if !dw.wrote {
+		WriteErrorResponse(err, w)
+		return
+	}
+
+	// the status went out with the first rows, so the failure shows as a
+	// truncated dump
+	log.Printf("Export of users failed after %v rows: %v", n, err)
+}
@@ -0,0 +1,128 @@
+package ui_test
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/ui"
+	"github.com/stretchr/testify/suite"
+)
+
+type _dumpSuite struct {
+	suite.Suite
+	UI *ui.UI
+
+	DumpUsersHdl ui.DumpUsersHandlerFunc
+	AuditHdl     ui.AuditHandlerFunc
+
+	users  []pg.User
+	err    error
+	events []pg.AuditEvent
+}
+
+func (s *_dumpSuite) SetupSuite() {
+	s.UI = ui.New()
+}
+
+func (s *_dumpSuite) TearDownSuite() {
+}
+
+func (s *_dumpSuite) SetupTest() {
+	at := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	s.users = []pg.User{
+		{Tenant: pg.DefaultTenant, Acct: "kobe_bryant", Pwd: "123456789", Fullname: "Kobe", Created_at: at, Updated_at: at},
+		{Tenant: pg.DefaultTenant, Acct: "lebron_james", Pwd: "123456789", Fullname: "LeBron", Created_at: at, Updated_at: at},
+	}
+	s.err, s.events = nil, nil
+
+	s.DumpUsersHdl, ui.DumpUsersHdl = ui.DumpUsersHdl, func(_ context.Context, _ *ui.UI, fn func(*pg.User) error) error {
+		if s.err != nil {
+			return s.err
+		}
+		for i := range s.users {
+			if err := fn(&s.users[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	s.AuditHdl, ui.AuditHdl = ui.AuditHdl, func(_ context.Context, _ *ui.UI, ev *pg.AuditEvent) error {
+		s.events = append(s.events, *ev)
+		return nil
+	}
+}
+
+func (s *_dumpSuite) TearDownTest() {
+	ui.DumpUsersHdl, s.DumpUsersHdl = s.DumpUsersHdl, nil
+	ui.AuditHdl, s.AuditHdl = s.AuditHdl, nil
+}
+
+func (s *_dumpSuite) serve(query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "http://test.com/ui/v1/users/export"+query, nil)
+	rcd := httptest.NewRecorder()
+	http.HandlerFunc(s.UI.ExportUsers).ServeHTTP(rcd, req)
+	return rcd
+}
+
+func (s *_dumpSuite) TestExportCSV() {
+	rcd := s.serve("?columns=account,fullname")
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal("text/csv", rcd.Header().Get("Content-Type"))
+	s.Equal(`attachment; filename="users.csv"`, rcd.Header().Get("Content-Disposition"))
+	s.Equal("account,fullname\nkobe_bryant,Kobe\nlebron_james,LeBron\n", rcd.Body.String())
+
+	s.Equal(1, len(s.events))
+	s.Equal(ui.AuditActionExport, s.events[0].Action)
+	s.Equal("", s.events[0].Target)
+}
+
+func (s *_dumpSuite) TestExportNDJSONGzip() {
+	rcd := s.serve("?format=ndjson&columns=account,created_at&gzip=true")
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal("application/gzip", rcd.Header().Get("Content-Type"))
+	s.Equal(`attachment; filename="users.ndjson.gz"`, rcd.Header().Get("Content-Disposition"))
+
+	zr, err := gzip.NewReader(rcd.Body)
+	s.Equal(nil, err)
+	b, err := ioutil.ReadAll(zr)
+	s.Equal(nil, err)
+	s.Equal(`{"account":"kobe_bryant","created_at":"2021-03-04T05:06:07Z"}`+"\n"+
+		`{"account":"lebron_james","created_at":"2021-03-04T05:06:07Z"}`+"\n", string(b))
+}
+
+func (s *_dumpSuite) TestExportEmpty() {
+	s.users = nil
+	rcd := s.serve("?format=ndjson")
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal("application/x-ndjson", rcd.Header().Get("Content-Type"))
+	s.Equal("", rcd.Body.String())
+}
+
+func (s *_dumpSuite) TestExportInvalid() {
+	for _, query := range []string{"?format=xml", "?columns=account,pwd", "?gzip=maybe"} {
+		rcd := s.serve(query)
+		s.Equal(http.StatusBadRequest, rcd.Code, query)
+	}
+}
+
+func (s *_dumpSuite) TestExportError() {
+	s.err = errors.New("no database")
+	rcd := s.serve("?format=ndjson")
+	s.Equal(http.StatusInternalServerError, rcd.Code)
+	s.Equal("", rcd.Header().Get("Content-Disposition"))
+
+	s.err = context.DeadlineExceeded
+	rcd = s.serve("?format=ndjson")
+	s.Equal(http.StatusGatewayTimeout, rcd.Code)
+}
+
+func TestRunDump(t *testing.T) {
+	suite.Run(t, new(_dumpSuite))
+}
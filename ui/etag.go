@@ -0,0 +1,120 @@
+package ui
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dontang97/ui/pg"
+)
+
+// ErrPreconditionFailed is returned by a conditional write whose
+// precondition does not match the stored user.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// Precondition restricts a write to the versions of a user the client has
+// seen. A nil *Precondition makes the write unconditional.
+type Precondition struct {
+	// Any matches every existing version (If-Match: *).
+	Any bool
+	// UpdatedAt lists the accepted values of the updated_at column.
+	UpdatedAt []time.Time
+}
+
+// UserETag derives the entity tag of a user from its last update time.
+func UserETag(user *pg.User) string {
+	return `"` + strconv.FormatInt(user.Updated_at.UnixNano(), 16) + `"`
+}
+
+// ViewETag derives the entity tag of the view of user for audience. The
+// views of a version differ, and so do their tags.
+func ViewETag(user *pg.User, audience string) string {
+	return strings.TrimSuffix(UserETag(user), `"`) + "." + audience + `"`
+}
+
+// parseETag is the inverse of UserETag, and of ViewETag but for the view:
+// every view of a version stands for it in If-Match. Weak tags are
+// accepted; callers decide whether weak comparison applies.
+func parseETag(tag string) (time.Time, bool) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return time.Time{}, false
+	}
+
+	version := strings.SplitN(tag[1:len(tag)-1], ".", 2)[0]
+	n, err := strconv.ParseInt(version, 16, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, n).UTC(), true
+}
+
+// etagList splits an If-Match or If-None-Match header value.
+func etagList(r *http.Request, header string) ([]string, bool) {
+	values, ok := r.Header[header]
+	if !ok {
+		return nil, false
+	}
+
+	var tags []string
+	for _, v := range values {
+		for _, tag := range strings.Split(v, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags, true
+}
+
+// notModified reports whether If-None-Match matches the current tag.
+func notModified(r *http.Request, etag string) bool {
+	tags, ok := etagList(r, "If-None-Match")
+	if !ok {
+		return false
+	}
+
+	for _, tag := range tags {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// precondition builds the Precondition of a write from If-Match. When the
+// header is required but missing, or holds nothing that could ever match,
+// the response is written and ok is false.
+func (ui *UI) precondition(w http.ResponseWriter, r *http.Request) (pre *Precondition, ok bool) {
+	tags, ok := etagList(r, "If-Match")
+	if !ok {
+		if ui.RequireIfMatch {
+			WriteJsonResponse(StatusPreconditionRequired, map[string]string{"header": "If-Match"}, w)
+			return nil, false
+		}
+		return nil, true
+	}
+
+	pre = &Precondition{}
+	for _, tag := range tags {
+		if tag == "*" {
+			pre.Any = true
+			continue
+		}
+		// If-Match uses the strong comparison, so weak tags never match
+		if strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		if t, ok := parseETag(tag); ok {
+			pre.UpdatedAt = append(pre.UpdatedAt, t)
+		}
+	}
+
+	if !pre.Any && len(pre.UpdatedAt) == 0 {
+		WriteJsonResponse(StatusPreconditionFailed, map[string]string{"header": "If-Match"}, w)
+		return nil, false
+	}
+	return pre, true
+}
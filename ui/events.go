@@ -0,0 +1,263 @@
+package ui
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dontang97/ui/events"
+	"github.com/dontang97/ui/outbox"
+	"github.com/dontang97/ui/pg"
+)
+
+const (
+	// DefaultEventKeepAlive is how often an idle stream sends a comment so
+	// proxies keep it open.
+	DefaultEventKeepAlive = time.Second * 15
+
+	// eventWriteTimeout bounds each write to a stream, which lives longer
+	// than the write timeout of the server.
+	eventWriteTimeout = time.Second * 10
+
+	// eventRetry is how long a client waits before reconnecting, in ms.
+	eventRetry = 2000
+
+	eventReplayPage = 500
+)
+
+type EventsSinceHandlerFunc func(context.Context, *UI, int64, int) ([]pg.OutboxMessage, error)
+
+// EventHandlerFunc returns the event of an ID, nil when there is none.
+type EventHandlerFunc func(context.Context, *UI, int64) (*pg.OutboxMessage, error)
+
+type connKey struct{}
+
+// WithConn returns a copy of ctx carrying the connection of the requests,
+// for http.Server.ConnContext.
+func WithConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// extendWriteDeadline gives the next write to the stream of r
+// eventWriteTimeout instead of what is left of the server write timeout.
+func extendWriteDeadline(r *http.Request) {
+	if c, ok := r.Context().Value(connKey{}).(net.Conn); ok {
+		if err := c.SetWriteDeadline(time.Now().Add(eventWriteTimeout)); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// ListenEvents feeds ui.EventBus with the user events announced by the
+// database until ctx is done.
+func (ui *UI) ListenEvents(ctx context.Context) error {
+	return ui.Listen(ctx, events.Channel, func(payload string) {
+		ui.PublishEvent(ctx, payload)
+	}, ui.EventBus.Reset)
+}
+
+// EventHdl reads an event of the outbox by its ID, across tenants.
+var EventHdl EventHandlerFunc = func(ctx context.Context, ui *UI, id int64) (*pg.OutboxMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.QueryTimeout)
+	defer cancel()
+
+	msgs := []pg.OutboxMessage{}
+	if res := ui.WithContext(ctx).
+		Table(pg.TableOutbox.String()).
+		Where(pg.FieldOutboxID.String()+" = ?", id).
+		Limit(1).
+		Find(&msgs); res.Error != nil || len(msgs) == 0 {
+		return nil, res.Error
+	}
+	return &msgs[0], nil
+}
+
+// PublishEvent publishes to ui.EventBus the event a notification of the
+// user_events channel announces. The notification names the event only, as
+// NOTIFY takes no payload of 8000 bytes, and the event is read from the
+// outbox.
+func (ui *UI) PublishEvent(ctx context.Context, payload string) {
+	note := &outbox.Envelope{}
+	if err := json.Unmarshal([]byte(payload), note); err != nil || note.ID == 0 {
+		log.Printf("Invalid user event %q: %v", payload, err)
+		return
+	}
+	if !events.Streamed[note.Type] {
+		return
+	}
+
+	msg, err := EventHdl(ctx, ui, note.ID)
+	if err != nil {
+		// the streams resume from the outbox
+		log.Print(err)
+		ui.EventBus.Reset()
+		return
+	}
+	if msg == nil {
+		log.Printf("User event %v not found", note.ID)
+		return
+	}
+	ui.EventBus.Publish(outbox.NewEnvelope(msg))
+}
+
+func writeEvent(w io.Writer, env *outbox.Envelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", env.ID, env.Type, data)
+	return err
+}
+
+// lastEventID is the event a reconnecting stream resumes after. Browsers
+// send the Last-Event-ID header; other clients may use the query string.
+func lastEventID(r *http.Request) (int64, error) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("last_event_id")
+	}
+	if v == "" {
+		return 0, nil
+	}
+
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id < 0 {
+		return 0, &queryError{"last_event_id", v}
+	}
+	return id, nil
+}
+
+/////////////////////////////////////
+//////    GET /ui/v1/events    //////
+/////////////////////////////////////
+
+// EventsSinceHdl returns up to limit of the streamed events of the tenant
+// after the one of ID after, for the streams resuming beyond what
+// ui.EventBus remembers.
+var EventsSinceHdl EventsSinceHandlerFunc = func(ctx context.Context, ui *UI, after int64, limit int) ([]pg.OutboxMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.QueryTimeout)
+	defer cancel()
+
+	types := []string{}
+	for typ := range events.Streamed {
+		types = append(types, typ)
+	}
+
+	msgs := []pg.OutboxMessage{}
+	res := ui.WithContext(ctx).
+		Table(pg.TableOutbox.String()).
+		Where(pg.FieldOutboxID.String()+" > ?", after).
+		Where(pg.FieldOutboxTenant.String()+" = ?", Tenant(ctx)).
+		Where(pg.FieldOutboxType.String()+" IN (?)", types).
+		Order(pg.FieldOutboxID.String()).
+		Limit(limit).
+		Find(&msgs)
+	return msgs, res.Error
+}
+
+// ofTenant reports whether env is about an account of tenant. Envelopes
+// from before tenants are about the default tenant.
+func ofTenant(env *outbox.Envelope, tenant string) bool {
+	if env.Tenant == "" {
+		return tenant == pg.DefaultTenant
+	}
+	return env.Tenant == tenant
+}
+
+// Events streams the user events of the tenant as Server-Sent Events until
+// the client goes away, after replaying those it missed since Last-Event-ID.
+func (ui *UI) Events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Print("Streaming is not supported by the response writer")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	after, err := lastEventID(r)
+	if err != nil {
+		qe := err.(*queryError)
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": qe.field, "value": qe.value}}, w)
+		return
+	}
+
+	tenant := Tenant(r.Context())
+	sub, backlog, remembered := ui.EventBus.Subscribe(after)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	extendWriteDeadline(r)
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", eventRetry); err != nil {
+		return
+	}
+
+	// the events replayed from the database may be published again
+	var replayed int64
+	if !remembered {
+		for {
+			msgs, err := EventsSinceHdl(r.Context(), ui, after, eventReplayPage)
+			if err != nil {
+				log.Print(err)
+				return
+			}
+			for i := range msgs {
+				extendWriteDeadline(r)
+				if err := writeEvent(w, outbox.NewEnvelope(&msgs[i])); err != nil {
+					return
+				}
+				after, replayed = msgs[i].ID, msgs[i].ID
+			}
+			if len(msgs) < eventReplayPage {
+				break
+			}
+		}
+	}
+	for _, env := range backlog {
+		if !ofTenant(env, tenant) {
+			continue
+		}
+		extendWriteDeadline(r)
+		if err := writeEvent(w, env); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(ui.EventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case env, ok := <-sub.C:
+			if !ok {
+				// fell behind, the client resumes from its last event
+				return
+			}
+			if env.ID <= replayed || !ofTenant(env, tenant) {
+				continue
+			}
+			extendWriteDeadline(r)
+			if err := writeEvent(w, env); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			extendWriteDeadline(r)
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,196 @@
+package ui_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dontang97/ui/events"
+	"github.com/dontang97/ui/outbox"
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/ui"
+	"github.com/stretchr/testify/suite"
+)
+
+type _eventsSuite struct {
+	suite.Suite
+	UI *ui.UI
+
+	EventsSinceHdl ui.EventsSinceHandlerFunc
+	EventHdl       ui.EventHandlerFunc
+}
+
+func (s *_eventsSuite) SetupSuite() {
+	s.UI = ui.New()
+}
+
+func (s *_eventsSuite) TearDownSuite() {
+}
+
+func (s *_eventsSuite) SetupTest() {
+	s.UI.EventBus = events.NewBus(4)
+	s.UI.EventKeepAlive = time.Hour
+	s.EventsSinceHdl, ui.EventsSinceHdl = ui.EventsSinceHdl, nil
+	s.EventHdl, ui.EventHdl = ui.EventHdl, nil
+}
+
+func (s *_eventsSuite) TearDownTest() {
+	ui.EventsSinceHdl, s.EventsSinceHdl = s.EventsSinceHdl, nil
+	ui.EventHdl, s.EventHdl = s.EventHdl, nil
+}
+
+func envelope(id int64, typ string) *outbox.Envelope {
+	return &outbox.Envelope{
+		ID:      id,
+		Type:    typ,
+		Account: "kobe_bryant",
+		Data:    pg.JSONB(`{"account":"kobe_bryant"}`),
+	}
+}
+
+// stream runs the stream of req until live returns, and returns the ids of
+// the events sent.
+func (s *_eventsSuite) stream(req *http.Request, live func()) (*httptest.ResponseRecorder, []int64) {
+	ctx, cancel := context.WithCancel(req.Context())
+	rcd := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		s.UI.Events(rcd, req.WithContext(ctx))
+		close(done)
+	}()
+
+	s.Eventually(func() bool { return s.UI.EventBus.Subscribers() == 1 }, time.Second, time.Millisecond)
+	live()
+	cancel()
+	<-done
+
+	ids := []int64{}
+	for _, line := range strings.Split(rcd.Body.String(), "\n") {
+		if strings.HasPrefix(line, "data: ") {
+			env := outbox.Envelope{}
+			s.Equal(nil, json.Unmarshal([]byte(line[len("data: "):]), &env))
+			ids = append(ids, env.ID)
+		}
+	}
+	return rcd, ids
+}
+
+func (s *_eventsSuite) TestPublishEvent() {
+	// the attributes alone are beyond what NOTIFY takes
+	attrs := pg.JSONB(`{"notes":"` + strings.Repeat("x", 10<<10) + `"}`)
+	ui.EventHdl = func(_ context.Context, _ *ui.UI, id int64) (*pg.OutboxMessage, error) {
+		switch id {
+		case 1:
+			return &pg.OutboxMessage{ID: 1, Type: outbox.UserUpdated, Tenant: pg.DefaultTenant, Account: "kobe_bryant", Payload: attrs}, nil
+		case 2:
+			return nil, errors.New("mock error")
+		}
+		return nil, nil
+	}
+
+	sub, _, _ := s.UI.EventBus.Subscribe(0)
+	s.UI.PublishEvent(context.Background(), `{"id": 1, "type": "user.updated", "tenant": "default"}`)
+	env := <-sub.C
+	s.Equal(int64(1), env.ID)
+	s.Equal("kobe_bryant", env.Account)
+	s.Equal(attrs, env.Data)
+
+	// invalid, not streamed or missing events are not published
+	for _, payload := range []string{`x`, `{"type": "user.updated"}`, `{"id": 3, "type": "user.logged_in"}`, `{"id": 3, "type": "user.updated"}`} {
+		s.UI.PublishEvent(context.Background(), payload)
+	}
+	s.Equal(0, len(sub.C))
+
+	// the streams resume from the outbox when an event cannot be read
+	s.UI.PublishEvent(context.Background(), `{"id": 2, "type": "user.updated"}`)
+	_, ok := <-sub.C
+	s.Equal(false, ok)
+}
+
+func (s *_eventsSuite) TestEvents() {
+	req := httptest.NewRequest(http.MethodGet, "http://test.com/ui/v1/events", nil)
+	rcd, ids := s.stream(req, func() {
+		s.UI.EventBus.Publish(envelope(1, outbox.UserCreated))
+		s.UI.EventBus.Publish(envelope(2, outbox.UserLoggedIn))
+		s.UI.EventBus.Publish(envelope(3, outbox.UserDeleted))
+		time.Sleep(20 * time.Millisecond)
+	})
+
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal("text/event-stream", rcd.Header().Get("Content-Type"))
+	s.Equal([]int64{1, 3}, ids)
+	s.Contains(rcd.Body.String(), "retry: 2000\n\n")
+	s.Contains(rcd.Body.String(), "id: 3\nevent: user.deleted\ndata: {")
+	s.Equal(0, s.UI.EventBus.Subscribers())
+}
+
+func (s *_eventsSuite) TestKeepAlive() {
+	s.UI.EventKeepAlive = 5 * time.Millisecond
+	req := httptest.NewRequest(http.MethodGet, "http://test.com/ui/v1/events", nil)
+	rcd, _ := s.stream(req, func() {
+		time.Sleep(30 * time.Millisecond)
+	})
+	s.Contains(rcd.Body.String(), ": keepalive\n\n")
+}
+
+func (s *_eventsSuite) TestResume() {
+	for _, id := range []int64{10, 11, 12} {
+		s.UI.EventBus.Publish(envelope(id, outbox.UserUpdated))
+	}
+
+	// from the events the bus remembers
+	req := httptest.NewRequest(http.MethodGet, "http://test.com/ui/v1/events", nil)
+	req.Header.Set("Last-Event-ID", "10")
+	_, ids := s.stream(req, func() {})
+	s.Equal([]int64{11, 12}, ids)
+
+	// from the database beyond them, without repeating what was replayed
+	var afters []int64
+	ui.EventsSinceHdl = func(_ context.Context, _ *ui.UI, after int64, limit int) ([]pg.OutboxMessage, error) {
+		afters = append(afters, after)
+		msgs := []pg.OutboxMessage{}
+		if after < 12 {
+			for id := after + 1; id <= 12; id++ {
+				msgs = append(msgs, pg.OutboxMessage{ID: id, Type: outbox.UserUpdated, Payload: pg.JSONB(`{}`)})
+			}
+		}
+		return msgs, nil
+	}
+	req = httptest.NewRequest(http.MethodGet, "http://test.com/ui/v1/events?last_event_id=8", nil)
+	_, ids = s.stream(req, func() {
+		s.UI.EventBus.Publish(envelope(12, outbox.UserUpdated))
+		s.UI.EventBus.Publish(envelope(13, outbox.UserUpdated))
+		time.Sleep(20 * time.Millisecond)
+	})
+	s.Equal([]int64{8}, afters)
+	s.Equal([]int64{9, 10, 11, 12, 13}, ids)
+}
+
+func (s *_eventsSuite) TestInvalid() {
+	for _, id := range []string{"x", "-1"} {
+		req := httptest.NewRequest(http.MethodGet, "http://test.com/ui/v1/events", nil)
+		req.Header.Set("Last-Event-ID", id)
+		rcd := httptest.NewRecorder()
+		s.UI.Events(rcd, req)
+		s.Equal(http.StatusBadRequest, rcd.Code)
+	}
+
+	// the stream ends when the replay fails and the client retries
+	ui.EventsSinceHdl = func(context.Context, *ui.UI, int64, int) ([]pg.OutboxMessage, error) {
+		return nil, errors.New("mock error")
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://test.com/ui/v1/events?last_event_id=1", nil)
+	rcd := httptest.NewRecorder()
+	s.UI.Events(rcd, req)
+	s.Equal("retry: 2000\n\n", rcd.Body.String())
+	s.Equal(0, s.UI.EventBus.Subscribers())
+}
+
+func TestRunEvents(t *testing.T) {
+	suite.Run(t, new(_eventsSuite))
+}
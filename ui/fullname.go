@@ -0,0 +1,137 @@
+package ui
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/jinzhu/gorm"
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// bidiControl reports whether r is a bidi embedding, override or isolate,
+// which reorder the text around them.
+func bidiControl(r rune) bool {
+	return (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069')
+}
+
+// fullnameLen is the length of fullname as a reader counts it, in grapheme
+// clusters: an accented letter or an emoji of several code points is one.
+func fullnameLen(fullname string) int {
+	return uniseg.GraphemeClusterCount(fullname)
+}
+
+// fullnameMessages describe the rules of normalizeFullname.
+var fullnameMessages = map[string]string{
+	RuleCharset: "must be UTF-8 without control characters or bidi controls",
+	RuleLength:  "must be 1 to " + strconv.Itoa(pg.FieldUserFullnameMaxLen) + " characters",
+}
+
+// normalizeFullname returns fullname in NFC, the form it is stored in, and
+// the rule it breaks, if any: RuleCharset unless it is free of control
+// characters and bidi controls, RuleLength unless it has 1 to
+// pg.FieldUserFullnameMaxLen grapheme clusters.
+func normalizeFullname(fullname string) (string, string) {
+	if !utf8.ValidString(fullname) {
+		return fullname, RuleCharset
+	}
+	for _, r := range fullname {
+		if unicode.IsControl(r) || bidiControl(r) {
+			return fullname, RuleCharset
+		}
+	}
+
+	fullname = norm.NFC.String(fullname)
+	if n := fullnameLen(fullname); n == 0 || n > pg.FieldUserFullnameMaxLen {
+		return fullname, RuleLength
+	}
+	return fullname, ""
+}
+
+// foldFullname returns the form the exact lookups match fullname by: its
+// NFC case fold, equal for the names that differ in case or normalization
+// only.
+func foldFullname(fullname string) string {
+	return norm.NFC.String(cases.Fold().String(norm.NFC.String(fullname)))
+}
+
+// KeyFullnamesHandlerFunc normalizes and keys the fullnames of up to n
+// users without a match key, and returns how many it did.
+type KeyFullnamesHandlerFunc func(context.Context, *UI, int) (int, error)
+
+// KeyFullnamesHdl stores in NFC, with their match key, the fullnames stored
+// before the keys, of every tenant. Like a re-encryption, it makes no
+// version and keeps the ETags. Rows locked by a write are left to the next
+// pass, and the write keys them meanwhile.
+var KeyFullnamesHdl KeyFullnamesHandlerFunc = func(ctx context.Context, ui *UI, n int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
+
+	done := 0
+	err := ui.Transaction(ctx, func(tx *gorm.DB) error {
+		if res := tx.Exec("SELECT set_config(?, 'on', true)", pg.ReencryptSetting); res.Error != nil {
+			return res.Error
+		}
+
+		users := []pg.User{}
+		if res := tx.
+			Table(pg.TableUsers.String()).
+			Select([]string{pg.FieldUserTenant.String(), pg.FieldUserAcct.String(), pg.FieldUserFullname.String()}).
+			Where(pg.FieldUserFullnameIndex.String()+" = ''").
+			Limit(n).
+			Set("gorm:query_option", "FOR UPDATE SKIP LOCKED").
+			Find(&users); res.Error != nil {
+			return res.Error
+		}
+
+		for _, user := range users {
+			fullname, err := ui.openFullname(user.Fullname)
+			if err != nil {
+				return err
+			}
+			values := map[string]interface{}{}
+			if normalized := norm.NFC.String(fullname); normalized != fullname {
+				if err := ui.fullnameValues(normalized, values); err != nil {
+					return err
+				}
+			} else {
+				values[pg.FieldUserFullnameIndex.String()] = ui.fullnameKey(fullname)
+			}
+			if res := tx.
+				Table(pg.TableUsers.String()).
+				Where(pg.FieldUserTenant.String()+" = ? AND "+pg.FieldUserAcct.String()+" = ?", user.Tenant, user.Acct).
+				Updates(values); res.Error != nil {
+				return res.Error
+			}
+		}
+		done = len(users)
+		return nil
+	})
+	return done, err
+}
+
+// KeyFullnames keys every fullname without a match key, batch after batch,
+// until none is left, or a batch fails. The exact lookups miss the users
+// not keyed yet.
+func (ui *UI) KeyFullnames(ctx context.Context) {
+	total := 0
+	for {
+		n, err := KeyFullnamesHdl(ctx, ui, ReencryptBatchSize)
+		if err != nil {
+			log.Print(err)
+			break
+		}
+		total += n
+		if n < ReencryptBatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("Keyed the fullnames of %v users", total)
+	}
+}
@@ -0,0 +1,660 @@
+package ui
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/secret"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrGroupNotFound  = errors.New("group not found")
+	ErrMemberNotFound = errors.New("member not found")
+	ErrGroupCycle     = errors.New("group nesting cycle")
+
+	// ErrRoleNotHeld is returned for a membership that would grant the
+	// member a role the caller does not hold.
+	ErrRoleNotHeld = errors.New("role not held")
+)
+
+var (
+	validGroupName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+	validRole      = regexp.MustCompile(`^[a-z0-9_.:-]{1,64}$`)
+)
+
+// groupNestingLock is the advisory lock serializing changes to the nesting
+// of groups, so two of them cannot close a cycle together.
+const groupNestingLock = 0x67726f75
+
+// GroupChanges are the fields of a group a PUT sets; nil fields are left
+// alone. The name of a group does not change.
+type GroupChanges struct {
+	Description *string   `json:"description"`
+	Roles       *[]string `json:"roles"`
+}
+
+// GroupMembers are the accounts and the groups in a group.
+type GroupMembers struct {
+	Accounts []string `json:"accounts"`
+	Groups   []string `json:"groups"`
+}
+
+type QueryGroupHandlerFunc func(context.Context, *UI, ...interface{}) ([]pg.Group, error)
+type AddGroupHandlerFunc func(context.Context, *UI, *pg.Group) error
+
+// UpdateGroupHandlerFunc and DeleteGroupHandlerFunc return nil for a group
+// that does not exist.
+type UpdateGroupHandlerFunc func(context.Context, *UI, string, *GroupChanges) (*pg.Group, error)
+type DeleteGroupHandlerFunc func(context.Context, *UI, string) (*pg.Group, error)
+
+// QueryMemberHandlerFunc returns the members of a group, and of the groups
+// nested in it when asked to, or nil for a group that does not exist.
+type QueryMemberHandlerFunc func(context.Context, *UI, string, bool) (*GroupMembers, error)
+
+// MembershipHandlerFunc puts a member, an account or a group, in a group
+// or takes it out. It fails with ErrGroupNotFound, ErrMemberNotFound,
+// ErrGroupCycle or ErrRoleNotHeld.
+type MembershipHandlerFunc func(context.Context, *UI, string, string) error
+
+// roles checks and dedupes the roles of a group. It returns the first
+// invalid one. No group grants secret.RoleSuperAdmin, which spans the
+// tenants: only the operator does, by name.
+func roles(list []string) ([]string, string, bool) {
+	seen := map[string]bool{}
+	valid := []string{}
+	for _, role := range list {
+		if !validRole.MatchString(role) || role == secret.RoleSuperAdmin {
+			return nil, role, false
+		}
+		if !seen[role] {
+			seen[role] = true
+			valid = append(valid, role)
+		}
+	}
+	return valid, "", true
+}
+
+// unheldRole returns the first of roles the caller of ctx does not hold,
+// and so may not grant. Super admins hold them all.
+func unheldRole(ctx context.Context, roles []string) (string, bool) {
+	claims, ok := secret.FromContext(ctx)
+	if ok && claims.HasRole(secret.RoleSuperAdmin) {
+		return "", false
+	}
+	for _, role := range roles {
+		if !ok || !claims.HasRole(role) {
+			return role, true
+		}
+	}
+	return "", false
+}
+
+func writeRoleNotHeld(role string, w http.ResponseWriter) {
+	WriteJsonResponse(StatusNoAuth,
+		map[string]string{"role": role, "error": "Only the holders of a role grant it"}, w)
+}
+
+// groupRoles returns the roles the members of group id get: its own, and
+// those of the groups it is nested in.
+func groupRoles(tx *gorm.DB, id int64) ([]string, error) {
+	return scanStrings(tx, `
+		WITH RECURSIVE above (id) AS (
+			SELECT CAST(? AS BIGINT)
+			UNION
+			SELECT s.`+pg.FieldSubgroupParentID.String()+` FROM `+pg.TableGroupSubgroups.String()+` s
+			JOIN above a ON s.`+pg.FieldSubgroupChildID.String()+` = a.id
+		)
+		SELECT DISTINCT unnest(g.`+pg.FieldGroupRoles.String()+`) FROM `+pg.TableGroups.String()+` g
+		JOIN above a ON g.`+pg.FieldGroupID.String()+` = a.id`, id)
+}
+
+// checkGroupRoles fails with ErrRoleNotHeld when the caller of ctx may not
+// grant the roles the members of group id get.
+func checkGroupRoles(ctx context.Context, tx *gorm.DB, id int64) error {
+	roles, err := groupRoles(tx, id)
+	if err != nil {
+		return err
+	}
+	if _, unheld := unheldRole(ctx, roles); unheld {
+		return ErrRoleNotHeld
+	}
+	return nil
+}
+
+// groupID returns the ID of the group name of tenant, or ErrGroupNotFound.
+func groupID(tx *gorm.DB, tenant, name string) (int64, error) {
+	rows, err := tx.
+		Table(pg.TableGroups.String()).
+		Select(pg.FieldGroupID.String()).
+		Where(pg.FieldGroupTenant.String()+" = ? AND "+pg.FieldGroupName.String()+" = ?", tenant, name).
+		Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		return 0, ErrGroupNotFound
+	}
+	var id int64
+	if err := rows.Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, rows.Err()
+}
+
+// scanStrings returns the single column of the rows of query.
+func scanStrings(db *gorm.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Raw(query, args...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	strs := []string{}
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		strs = append(strs, s)
+	}
+	return strs, rows.Err()
+}
+
+///////////////////////////////////////////////
+//////    GET /ui/v1/groups[/{group}]    //////
+///////////////////////////////////////////////
+
+// GroupsHdl lists the groups of the tenant, or only the one named args[0].
+var GroupsHdl QueryGroupHandlerFunc = func(ctx context.Context, ui *UI, args ...interface{}) ([]pg.Group, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.QueryTimeout)
+	defer cancel()
+
+	db := ui.readDB(ctx).
+		Table(pg.TableGroups.String()).
+		Where(pg.FieldGroupTenant.String()+" = ?", Tenant(ctx))
+	if len(args) > 0 {
+		db = db.Where(pg.FieldGroupName.String()+" = ?", args[0])
+	}
+
+	groups := []pg.Group{}
+	res := db.Order(pg.FieldGroupName.String()).Find(&groups)
+	return groups, res.Error
+}
+
+func (ui *UI) Groups(w http.ResponseWriter, r *http.Request) {
+	groups, err := GroupsHdl(r.Context(), ui)
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+
+	WriteJsonResponse(StatusOK, map[string]interface{}{"groups": groups}, w)
+}
+
+func (ui *UI) GroupInfo(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["group"]
+	groups, err := GroupsHdl(r.Context(), ui, name)
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+
+	if len(groups) == 0 {
+		WriteJsonResponse(StatusNotFound, map[string]string{"group": name}, w)
+		return
+	}
+
+	WriteJsonResponse(StatusOK, groups[0], w)
+}
+
+//////////////////////////////////////
+//////    POST /ui/v1/groups    //////
+//////////////////////////////////////
+
+var AddGroupHdl AddGroupHandlerFunc = func(ctx context.Context, ui *UI, group *pg.Group) error {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
+
+	return ui.WithContext(ctx).Table(pg.TableGroups.String()).Create(group).Error
+}
+
+func (ui *UI) AddGroup(w http.ResponseWriter, r *http.Request) {
+	req := pg.Group{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteJsonResponse(StatusInvalidContent, map[string]string{"error": err.Error()}, w)
+		return
+	}
+
+	if req.Name == "" {
+		WriteJsonResponse(StatusInvalidContent, map[string]string{"missing_field": "name"}, w)
+		return
+	}
+	if !validGroupName.MatchString(req.Name) {
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": "name", "value": req.Name}}, w)
+		return
+	}
+	if len(req.Description) > pg.FieldGroupDescriptionMaxLen {
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": "description", "value": truncate(req.Description, 256)}}, w)
+		return
+	}
+	valid, bad, ok := roles(req.Roles)
+	if !ok {
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": "roles", "value": bad}}, w)
+		return
+	}
+	if role, unheld := unheldRole(r.Context(), valid); unheld {
+		writeRoleNotHeld(role, w)
+		return
+	}
+
+	now := time.Now().UTC()
+	group := &pg.Group{
+		Tenant:      Tenant(r.Context()),
+		Name:        req.Name,
+		Description: req.Description,
+		Roles:       valid,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := AddGroupHdl(r.Context(), ui, group); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pq.ErrorCode("23505") {
+			WriteJsonResponse(StatusGroupExisted, map[string]string{"group": group.Name}, w)
+			return
+		}
+
+		WriteErrorResponse(err, w)
+		return
+	}
+
+	WriteJsonResponse(StatusOK, group, w)
+}
+
+/////////////////////////////////////////////
+//////    PUT /ui/v1/groups/{group}    //////
+/////////////////////////////////////////////
+
+var UpdateGroupHdl UpdateGroupHandlerFunc = func(ctx context.Context, ui *UI, name string, changes *GroupChanges) (*pg.Group, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
+
+	values := map[string]interface{}{
+		pg.FieldGroupUpdatedAt.String(): time.Now().UTC(),
+	}
+	if changes.Description != nil {
+		values[pg.FieldGroupDescription.String()] = *changes.Description
+	}
+	if changes.Roles != nil {
+		values[pg.FieldGroupRoles.String()] = pq.StringArray(*changes.Roles)
+	}
+
+	where := pg.FieldGroupTenant.String() + " = ? AND " + pg.FieldGroupName.String() + " = ?"
+	var group *pg.Group
+	err := ui.transaction(ctx, func(tx *gorm.DB) error {
+		res := tx.
+			Table(pg.TableGroups.String()).
+			Where(where, Tenant(ctx), name).
+			Updates(values)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+
+		group = &pg.Group{}
+		return tx.
+			Table(pg.TableGroups.String()).
+			Where(where, Tenant(ctx), name).
+			Find(group).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+func (ui *UI) UpdateGroup(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["group"]
+
+	changes := GroupChanges{}
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		WriteJsonResponse(StatusInvalidContent, map[string]string{"error": err.Error()}, w)
+		return
+	}
+
+	if changes.Description != nil && len(*changes.Description) > pg.FieldGroupDescriptionMaxLen {
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": "description", "value": truncate(*changes.Description, 256)}}, w)
+		return
+	}
+	if changes.Roles != nil {
+		valid, bad, ok := roles(*changes.Roles)
+		if !ok {
+			WriteJsonResponse(StatusInvalidContent,
+				map[string]map[string]string{"invalid": {"field": "roles", "value": bad}}, w)
+			return
+		}
+		if role, unheld := unheldRole(r.Context(), valid); unheld {
+			writeRoleNotHeld(role, w)
+			return
+		}
+		changes.Roles = &valid
+	}
+
+	group, err := UpdateGroupHdl(r.Context(), ui, name, &changes)
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+	if group == nil {
+		WriteJsonResponse(StatusNotFound, map[string]string{"group": name}, w)
+		return
+	}
+
+	WriteJsonResponse(StatusOK, group, w)
+}
+
+////////////////////////////////////////////////
+//////    DELETE /ui/v1/groups/{group}    //////
+////////////////////////////////////////////////
+
+// DeleteGroupHdl removes the group together with its memberships, in it
+// and of it.
+var DeleteGroupHdl DeleteGroupHandlerFunc = func(ctx context.Context, ui *UI, name string) (*pg.Group, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
+
+	groups := []pg.Group{}
+	if res := ui.WithContext(ctx).
+		Raw("DELETE FROM "+pg.TableGroups.String()+
+			" WHERE "+pg.FieldGroupTenant.String()+" = ? AND "+pg.FieldGroupName.String()+" = ? RETURNING *", Tenant(ctx), name).
+		Scan(&groups); res.Error != nil {
+		return nil, res.Error
+	}
+	if len(groups) == 0 {
+		return nil, nil
+	}
+	return &groups[0], nil
+}
+
+func (ui *UI) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["group"]
+	group, err := DeleteGroupHdl(r.Context(), ui, name)
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+	if group == nil {
+		WriteJsonResponse(StatusNotFound, map[string]string{"group": name}, w)
+		return
+	}
+
+	WriteJsonResponse(StatusOK, group, w)
+}
+
+/////////////////////////////////////////////////////
+//////    GET /ui/v1/groups/{group}/members    //////
+/////////////////////////////////////////////////////
+
+var GroupMembersHdl QueryMemberHandlerFunc = func(ctx context.Context, ui *UI, name string, nested bool) (*GroupMembers, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.QueryTimeout)
+	defer cancel()
+
+	db := ui.readDB(ctx)
+	id, err := groupID(db, Tenant(ctx), name)
+	if errors.Is(err, ErrGroupNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the groups in the group, and in those when nested
+	below := `
+		WITH RECURSIVE below (id) AS (
+			SELECT ` + pg.FieldSubgroupChildID.String() + ` FROM ` + pg.TableGroupSubgroups.String() + `
+			WHERE ` + pg.FieldSubgroupParentID.String() + ` = ?
+			UNION
+			SELECT s.` + pg.FieldSubgroupChildID.String() + ` FROM ` + pg.TableGroupSubgroups.String() + ` s
+			JOIN below b ON s.` + pg.FieldSubgroupParentID.String() + ` = b.id
+			WHERE ?
+		)`
+
+	members := &GroupMembers{}
+	if members.Accounts, err = scanStrings(db, below+`
+		SELECT DISTINCT `+pg.FieldMemberAcct.String()+` FROM `+pg.TableGroupMembers.String()+`
+		WHERE `+pg.FieldMemberGroupID.String()+` = ?
+		OR (? AND `+pg.FieldMemberGroupID.String()+` IN (SELECT id FROM below))
+		ORDER BY 1`, id, nested, id, nested); err != nil {
+		return nil, err
+	}
+	if members.Groups, err = scanStrings(db, below+`
+		SELECT g.`+pg.FieldGroupName.String()+` FROM `+pg.TableGroups.String()+` g
+		JOIN below b ON g.`+pg.FieldGroupID.String()+` = b.id
+		ORDER BY 1`, id, nested); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (ui *UI) GroupMembers(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["group"]
+
+	var nested bool
+	if v := r.URL.Query().Get("nested"); v != "" {
+		var err error
+		if nested, err = strconv.ParseBool(v); err != nil {
+			WriteJsonResponse(StatusInvalidContent,
+				map[string]map[string]string{"invalid": {"field": "nested", "value": v}}, w)
+			return
+		}
+	}
+
+	members, err := GroupMembersHdl(r.Context(), ui, name, nested)
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+	if members == nil {
+		WriteJsonResponse(StatusNotFound, map[string]string{"group": name}, w)
+		return
+	}
+
+	WriteJsonResponse(StatusOK, members, w)
+}
+
+/////////////////////////////////////////////////////////////////////
+//////    PUT|DELETE /ui/v1/groups/{group}/members/{member}    //////
+/////////////////////////////////////////////////////////////////////
+
+// AddMemberHdl puts an account in a group; an account already in it is
+// left alone. The caller must hold the roles the account gets.
+var AddMemberHdl MembershipHandlerFunc = func(ctx context.Context, ui *UI, name, acct string) error {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
+
+	return ui.transaction(ctx, func(tx *gorm.DB) error {
+		id, err := groupID(tx, Tenant(ctx), name)
+		if err != nil {
+			return err
+		}
+		if err := checkGroupRoles(ctx, tx, id); err != nil {
+			return err
+		}
+
+		res := tx.Exec(
+			"INSERT INTO "+pg.TableGroupMembers.String()+
+				" ("+pg.FieldMemberGroupID.String()+", "+pg.FieldMemberTenant.String()+", "+pg.FieldMemberAcct.String()+")"+
+				" VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+			id, Tenant(ctx), acct)
+		// the account does not exist
+		if pqErr, ok := res.Error.(*pq.Error); ok && pqErr.Code == pq.ErrorCode("23503") {
+			return ErrMemberNotFound
+		}
+		return res.Error
+	})
+}
+
+var RemoveMemberHdl MembershipHandlerFunc = func(ctx context.Context, ui *UI, name, acct string) error {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
+
+	return ui.transaction(ctx, func(tx *gorm.DB) error {
+		id, err := groupID(tx, Tenant(ctx), name)
+		if err != nil {
+			return err
+		}
+
+		res := tx.
+			Table(pg.TableGroupMembers.String()).
+			Where(pg.FieldMemberGroupID.String()+" = ? AND "+pg.FieldMemberAcct.String()+" = ?", id, acct).
+			Delete(nil)
+		if res.Error == nil && res.RowsAffected == 0 {
+			return ErrMemberNotFound
+		}
+		return res.Error
+	})
+}
+
+func (ui *UI) membership(hdl MembershipHandlerFunc, memberVar, memberKey string, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name, member := vars["group"], vars[memberVar]
+
+	err := hdl(r.Context(), ui, name, member)
+	switch {
+	case errors.Is(err, ErrGroupNotFound):
+		WriteJsonResponse(StatusNotFound, map[string]string{"group": name}, w)
+		return
+	case errors.Is(err, ErrMemberNotFound):
+		WriteJsonResponse(StatusNotFound, map[string]string{"group": name, memberKey: member}, w)
+		return
+	case errors.Is(err, ErrGroupCycle):
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": memberKey, "value": member}}, w)
+		return
+	case errors.Is(err, ErrRoleNotHeld):
+		WriteJsonResponse(StatusNoAuth, map[string]string{"group": name, memberKey: member,
+			"error": "Only the holders of the roles of the group add members to it"}, w)
+		return
+	case err != nil:
+		WriteErrorResponse(err, w)
+		return
+	}
+
+	if memberKey == "account" {
+		// the roles of the account changed
+		ui.markWritten(r.Context(), member)
+	}
+	WriteJsonResponse(StatusOK, map[string]string{"group": name, memberKey: member}, w)
+}
+
+func (ui *UI) AddGroupMember(w http.ResponseWriter, r *http.Request) {
+	ui.membership(AddMemberHdl, "member", "account", w, r)
+}
+
+func (ui *UI) RemoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	ui.membership(RemoveMemberHdl, "member", "account", w, r)
+}
+
+///////////////////////////////////////////////////////////////////
+//////    PUT|DELETE /ui/v1/groups/{group}/groups/{child}    //////
+///////////////////////////////////////////////////////////////////
+
+// AddSubgroupHdl nests a group in another, whose members its members
+// become. A nesting that would make a group its own member fails with
+// ErrGroupCycle, and one granting them roles the caller does not hold with
+// ErrRoleNotHeld.
+var AddSubgroupHdl MembershipHandlerFunc = func(ctx context.Context, ui *UI, name, child string) error {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
+
+	return ui.transaction(ctx, func(tx *gorm.DB) error {
+		if res := tx.Exec("SELECT pg_advisory_xact_lock(?)", groupNestingLock); res.Error != nil {
+			return res.Error
+		}
+
+		parentID, err := groupID(tx, Tenant(ctx), name)
+		if err != nil {
+			return err
+		}
+		childID, err := groupID(tx, Tenant(ctx), child)
+		if errors.Is(err, ErrGroupNotFound) {
+			return ErrMemberNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if parentID == childID {
+			return ErrGroupCycle
+		}
+
+		// the parent must not be below the child already
+		var cycle struct{ N int }
+		if res := tx.Raw(`
+			WITH RECURSIVE below (id) AS (
+				SELECT CAST(? AS BIGINT)
+				UNION
+				SELECT s.`+pg.FieldSubgroupChildID.String()+` FROM `+pg.TableGroupSubgroups.String()+` s
+				JOIN below b ON s.`+pg.FieldSubgroupParentID.String()+` = b.id
+			)
+			SELECT COUNT(*) AS n FROM below WHERE id = ?`, childID, parentID).
+			Scan(&cycle); res.Error != nil {
+			return res.Error
+		}
+		if cycle.N > 0 {
+			return ErrGroupCycle
+		}
+		if err := checkGroupRoles(ctx, tx, parentID); err != nil {
+			return err
+		}
+
+		return tx.Exec(
+			"INSERT INTO "+pg.TableGroupSubgroups.String()+
+				" ("+pg.FieldSubgroupParentID.String()+", "+pg.FieldSubgroupChildID.String()+") VALUES (?, ?) ON CONFLICT DO NOTHING",
+			parentID, childID).Error
+	})
+}
+
+var RemoveSubgroupHdl MembershipHandlerFunc = func(ctx context.Context, ui *UI, name, child string) error {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
+
+	return ui.transaction(ctx, func(tx *gorm.DB) error {
+		parentID, err := groupID(tx, Tenant(ctx), name)
+		if err != nil {
+			return err
+		}
+
+		res := tx.Exec(
+			"DELETE FROM "+pg.TableGroupSubgroups.String()+" s USING "+pg.TableGroups.String()+" g"+
+				" WHERE s."+pg.FieldSubgroupParentID.String()+" = ? AND s."+pg.FieldSubgroupChildID.String()+" = g."+pg.FieldGroupID.String()+
+				" AND g."+pg.FieldGroupTenant.String()+" = ? AND g."+pg.FieldGroupName.String()+" = ?",
+			parentID, Tenant(ctx), child)
+		if res.Error == nil && res.RowsAffected == 0 {
+			return ErrMemberNotFound
+		}
+		return res.Error
+	})
+}
+
+func (ui *UI) AddSubgroup(w http.ResponseWriter, r *http.Request) {
+	ui.membership(AddSubgroupHdl, "child", "subgroup", w, r)
+}
+
+func (ui *UI) RemoveSubgroup(w http.ResponseWriter, r *http.Request) {
+	ui.membership(RemoveSubgroupHdl, "child", "subgroup", w, r)
+}
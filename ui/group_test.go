@@ -0,0 +1,254 @@
+package ui_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/secret"
+	"github.com/dontang97/ui/ui"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/suite"
+)
+
+type _groupSuite struct {
+	suite.Suite
+	UI *ui.UI
+
+	GroupsHdl         ui.QueryGroupHandlerFunc
+	AddGroupHdl       ui.AddGroupHandlerFunc
+	UpdateGroupHdl    ui.UpdateGroupHandlerFunc
+	DeleteGroupHdl    ui.DeleteGroupHandlerFunc
+	GroupMembersHdl   ui.QueryMemberHandlerFunc
+	AddMemberHdl      ui.MembershipHandlerFunc
+	RemoveMemberHdl   ui.MembershipHandlerFunc
+	AddSubgroupHdl    ui.MembershipHandlerFunc
+	RemoveSubgroupHdl ui.MembershipHandlerFunc
+
+	// claims are of the caller of the requests
+	claims *secret.UserClaims
+}
+
+func (s *_groupSuite) SetupSuite() {
+	s.UI = ui.New()
+}
+
+func (s *_groupSuite) TearDownSuite() {
+}
+
+func (s *_groupSuite) SetupTest() {
+	s.GroupsHdl, ui.GroupsHdl = ui.GroupsHdl, nil
+	s.AddGroupHdl, ui.AddGroupHdl = ui.AddGroupHdl, nil
+	s.UpdateGroupHdl, ui.UpdateGroupHdl = ui.UpdateGroupHdl, nil
+	s.DeleteGroupHdl, ui.DeleteGroupHdl = ui.DeleteGroupHdl, nil
+	s.GroupMembersHdl, ui.GroupMembersHdl = ui.GroupMembersHdl, nil
+	s.AddMemberHdl, ui.AddMemberHdl = ui.AddMemberHdl, nil
+	s.RemoveMemberHdl, ui.RemoveMemberHdl = ui.RemoveMemberHdl, nil
+	s.AddSubgroupHdl, ui.AddSubgroupHdl = ui.AddSubgroupHdl, nil
+	s.RemoveSubgroupHdl, ui.RemoveSubgroupHdl = ui.RemoveSubgroupHdl, nil
+
+	s.claims = &secret.UserClaims{Acct: "jerry_buss", Roles: []string{secret.RoleSuperAdmin}}
+}
+
+func (s *_groupSuite) TearDownTest() {
+	ui.GroupsHdl, s.GroupsHdl = s.GroupsHdl, nil
+	ui.AddGroupHdl, s.AddGroupHdl = s.AddGroupHdl, nil
+	ui.UpdateGroupHdl, s.UpdateGroupHdl = s.UpdateGroupHdl, nil
+	ui.DeleteGroupHdl, s.DeleteGroupHdl = s.DeleteGroupHdl, nil
+	ui.GroupMembersHdl, s.GroupMembersHdl = s.GroupMembersHdl, nil
+	ui.AddMemberHdl, s.AddMemberHdl = s.AddMemberHdl, nil
+	ui.RemoveMemberHdl, s.RemoveMemberHdl = s.RemoveMemberHdl, nil
+	ui.AddSubgroupHdl, s.AddSubgroupHdl = s.AddSubgroupHdl, nil
+	ui.RemoveSubgroupHdl, s.RemoveSubgroupHdl = s.RemoveSubgroupHdl, nil
+}
+
+func (s *_groupSuite) serve(hdl http.HandlerFunc, method, target, body string, vars map[string]string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+	req = mux.SetURLVars(req, vars)
+	req = req.WithContext(secret.NewContext(req.Context(), s.claims))
+	rcd := httptest.NewRecorder()
+	hdl.ServeHTTP(rcd, req)
+
+	resp := map[string]interface{}{}
+	if rcd.Body.Len() > 0 {
+		s.Equal(nil, json.Unmarshal(rcd.Body.Bytes(), &resp))
+	}
+	return rcd, resp
+}
+
+func (s *_groupSuite) TestAddGroup() {
+	var added *pg.Group
+	ui.AddGroupHdl = func(_ context.Context, _ *ui.UI, group *pg.Group) error {
+		added = group
+		return nil
+	}
+
+	rcd, resp := s.serve(s.UI.AddGroup, http.MethodPost, "http://test.com",
+		`{"name": "lakers", "description": "LA", "roles": ["roster:write", "roster:read", "roster:write"]}`, nil)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal("lakers", added.Name)
+	s.Equal([]string{"roster:write", "roster:read"}, []string(added.Roles))
+	s.Equal("lakers", resp["data"].(map[string]interface{})["name"])
+
+	// a group without roles stores an empty array
+	rcd, _ = s.serve(s.UI.AddGroup, http.MethodPost, "http://test.com", `{"name": "nba"}`, nil)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal(0, len(added.Roles))
+	s.NotNil(added.Roles)
+
+	for body, field := range map[string]string{
+		`{"description": "LA"}`:                  "",
+		`{"name": "la lakers"}`:                  "name",
+		`{"name": "lakers", "roles": ["Admin"]}`: "roles",
+	} {
+		rcd, resp = s.serve(s.UI.AddGroup, http.MethodPost, "http://test.com", body, nil)
+		s.Equal(http.StatusBadRequest, rcd.Code, body)
+		if field != "" {
+			s.Equal(field, resp["data"].(map[string]interface{})["invalid"].(map[string]interface{})["field"], body)
+		}
+	}
+
+	// the name is taken
+	ui.AddGroupHdl = func(context.Context, *ui.UI, *pg.Group) error {
+		return &pq.Error{Code: "23505"}
+	}
+	rcd, _ = s.serve(s.UI.AddGroup, http.MethodPost, "http://test.com", `{"name": "lakers"}`, nil)
+	s.Equal(http.StatusConflict, rcd.Code)
+}
+
+func (s *_groupSuite) TestGroupRolesHeld() {
+	ui.AddGroupHdl = func(context.Context, *ui.UI, *pg.Group) error {
+		s.Fail("the group is not added")
+		return nil
+	}
+	ui.UpdateGroupHdl = func(context.Context, *ui.UI, string, *ui.GroupChanges) (*pg.Group, error) {
+		s.Fail("the group is not updated")
+		return nil, nil
+	}
+
+	// no group grants the super admin role, whoever asks
+	for _, body := range []string{`{"name": "lakers", "roles": ["super_admin"]}`, `{"name": "lakers", "roles": ["admin", "super_admin"]}`} {
+		rcd, resp := s.serve(s.UI.AddGroup, http.MethodPost, "http://test.com", body, nil)
+		s.Equal(http.StatusBadRequest, rcd.Code, body)
+		s.Equal("super_admin", resp["data"].(map[string]interface{})["invalid"].(map[string]interface{})["value"], body)
+	}
+
+	// an admin grants the roles it holds only
+	s.claims = &secret.UserClaims{Acct: "jerry_buss", Roles: []string{secret.RoleAdmin, "roster:read"}}
+	rcd, resp := s.serve(s.UI.AddGroup, http.MethodPost, "http://test.com", `{"name": "lakers", "roles": ["roster:read", "roster:write"]}`, nil)
+	s.Equal(http.StatusUnauthorized, rcd.Code)
+	s.Equal("roster:write", resp["data"].(map[string]interface{})["role"])
+	rcd, _ = s.serve(s.UI.UpdateGroup, http.MethodPut, "http://test.com", `{"roles": ["roster:write"]}`, map[string]string{"group": "lakers"})
+	s.Equal(http.StatusUnauthorized, rcd.Code)
+
+	var added *pg.Group
+	ui.AddGroupHdl = func(_ context.Context, _ *ui.UI, group *pg.Group) error {
+		added = group
+		return nil
+	}
+	rcd, _ = s.serve(s.UI.AddGroup, http.MethodPost, "http://test.com", `{"name": "lakers", "roles": ["admin", "roster:read"]}`, nil)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal([]string{"admin", "roster:read"}, []string(added.Roles))
+
+	// nor makes members of the groups granting others
+	ui.AddMemberHdl = func(context.Context, *ui.UI, string, string) error {
+		return ui.ErrRoleNotHeld
+	}
+	rcd, _ = s.serve(s.UI.AddGroupMember, http.MethodPut, "http://test.com", "", map[string]string{"group": "lakers", "member": "kobe_bryant"})
+	s.Equal(http.StatusUnauthorized, rcd.Code)
+}
+
+func (s *_groupSuite) TestUpdateGroup() {
+	var changes *ui.GroupChanges
+	ui.UpdateGroupHdl = func(_ context.Context, _ *ui.UI, name string, c *ui.GroupChanges) (*pg.Group, error) {
+		changes = c
+		if name != "lakers" {
+			return nil, nil
+		}
+		return &pg.Group{Name: name, Roles: *c.Roles}, nil
+	}
+
+	rcd, _ := s.serve(s.UI.UpdateGroup, http.MethodPut, "http://test.com",
+		`{"roles": ["roster:read", "roster:read"]}`, map[string]string{"group": "lakers"})
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal([]string{"roster:read"}, *changes.Roles)
+	s.Nil(changes.Description)
+
+	rcd, _ = s.serve(s.UI.UpdateGroup, http.MethodPut, "http://test.com",
+		`{"roles": []}`, map[string]string{"group": "celtics"})
+	s.Equal(http.StatusNotFound, rcd.Code)
+
+	changes = nil
+	rcd, _ = s.serve(s.UI.UpdateGroup, http.MethodPut, "http://test.com",
+		`{"roles": ["roster read"]}`, map[string]string{"group": "lakers"})
+	s.Equal(http.StatusBadRequest, rcd.Code)
+	s.Nil(changes)
+}
+
+func (s *_groupSuite) TestGroupMembers() {
+	var nested bool
+	ui.GroupMembersHdl = func(_ context.Context, _ *ui.UI, name string, n bool) (*ui.GroupMembers, error) {
+		nested = n
+		if name != "nba" {
+			return nil, nil
+		}
+		return &ui.GroupMembers{Accounts: []string{"kobe_bryant"}, Groups: []string{"lakers"}}, nil
+	}
+
+	rcd, resp := s.serve(s.UI.GroupMembers, http.MethodGet, "http://test.com?nested=true", "", map[string]string{"group": "nba"})
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal(true, nested)
+	data := resp["data"].(map[string]interface{})
+	s.Equal([]interface{}{"kobe_bryant"}, data["accounts"])
+	s.Equal([]interface{}{"lakers"}, data["groups"])
+
+	rcd, _ = s.serve(s.UI.GroupMembers, http.MethodGet, "http://test.com", "", map[string]string{"group": "nba"})
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal(false, nested)
+
+	rcd, _ = s.serve(s.UI.GroupMembers, http.MethodGet, "http://test.com?nested=maybe", "", map[string]string{"group": "nba"})
+	s.Equal(http.StatusBadRequest, rcd.Code)
+
+	rcd, _ = s.serve(s.UI.GroupMembers, http.MethodGet, "http://test.com", "", map[string]string{"group": "wnba"})
+	s.Equal(http.StatusNotFound, rcd.Code)
+}
+
+func (s *_groupSuite) TestMembership() {
+	var err error
+	mock := func(_ context.Context, _ *ui.UI, name, member string) error {
+		return err
+	}
+	ui.AddMemberHdl = mock
+	ui.AddSubgroupHdl = mock
+
+	vars := map[string]string{"group": "lakers", "member": "kobe_bryant"}
+	rcd, resp := s.serve(s.UI.AddGroupMember, http.MethodPut, "http://test.com", "", vars)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal(map[string]interface{}{"group": "lakers", "account": "kobe_bryant"}, resp["data"])
+
+	for e, code := range map[error]int{
+		ui.ErrGroupNotFound:  http.StatusNotFound,
+		ui.ErrMemberNotFound: http.StatusNotFound,
+	} {
+		err = e
+		rcd, _ = s.serve(s.UI.AddGroupMember, http.MethodPut, "http://test.com", "", vars)
+		s.Equal(code, rcd.Code, e.Error())
+	}
+
+	// nesting a group in one of its own members
+	err = ui.ErrGroupCycle
+	rcd, resp = s.serve(s.UI.AddSubgroup, http.MethodPut, "http://test.com", "",
+		map[string]string{"group": "lakers", "child": "nba"})
+	s.Equal(http.StatusBadRequest, rcd.Code)
+	s.Equal(map[string]interface{}{"field": "subgroup", "value": "nba"},
+		resp["data"].(map[string]interface{})["invalid"])
+}
+
+func TestRunGroup(t *testing.T) {
+	suite.Run(t, new(_groupSuite))
+}
@@ -0,0 +1,217 @@
+package ui
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/schema"
+	"github.com/gorilla/mux"
+)
+
+const (
+	DefaultHistoryLimit = 100
+	MaxHistoryLimit     = 1000
+)
+
+// HistoryQuery selects versions of the account Acct of the tenant: the
+// one current at AsOf if it is set, else the one of ID Version if that is,
+// else a page of Limit of them, newest first, older than the version
+// Before.
+type HistoryQuery struct {
+	Acct    string
+	AsOf    time.Time
+	Version int64
+	Limit   int
+	Before  int64
+}
+
+// QueryVersionHandlerFunc returns the versions q selects, one more than
+// q.Limit when there is a next page.
+type QueryVersionHandlerFunc func(context.Context, *UI, *HistoryQuery) ([]pg.UserVersion, error)
+
+var HistoryHdl QueryVersionHandlerFunc = func(ctx context.Context, ui *UI, q *HistoryQuery) ([]pg.UserVersion, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.QueryTimeout)
+	defer cancel()
+
+	db := ui.readDB(ctx, q.Acct).
+		Table(pg.TableUserHistory.String()).
+		Where(pg.FieldVersionTenant.String()+" = ? AND "+pg.FieldVersionAcct.String()+" = ?", Tenant(ctx), q.Acct)
+	switch {
+	case !q.AsOf.IsZero():
+		db = db.
+			Where(pg.FieldVersionValidFrom.String()+" <= ?", q.AsOf).
+			Where(pg.FieldVersionValidTo.String()+" IS NULL OR "+pg.FieldVersionValidTo.String()+" > ?", q.AsOf).
+			Limit(1)
+	case q.Version > 0:
+		db = db.Where(pg.FieldVersionID.String()+" = ?", q.Version)
+	default:
+		if q.Before > 0 {
+			db = db.Where(pg.FieldVersionID.String()+" < ?", q.Before)
+		}
+		db = db.Order(pg.FieldVersionID.String() + " DESC").Limit(q.Limit + 1)
+	}
+
+	versions := []pg.UserVersion{}
+	if res := db.Find(&versions); res.Error != nil {
+		return nil, res.Error
+	}
+	for i := range versions {
+		var err error
+		if versions[i].Fullname, err = ui.openFullname(versions[i].Fullname); err != nil {
+			return nil, err
+		}
+	}
+	return versions, nil
+}
+
+func parseHistoryQuery(acct string, values url.Values) (*HistoryQuery, error) {
+	q := &HistoryQuery{Acct: acct, Limit: DefaultHistoryLimit}
+
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > MaxHistoryLimit {
+			return nil, &queryError{"limit", v}
+		}
+		q.Limit = n
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, &queryError{"cursor", v}
+		}
+		q.Before = n
+	}
+
+	return q, nil
+}
+
+//////////////////////////////////////////////////////////////////////
+//////    GET /ui/v1/user/{acct:[A-Za-z0-9_]{8,20}}}/history    //////
+//////////////////////////////////////////////////////////////////////
+
+func (ui *UI) UserHistory(w http.ResponseWriter, r *http.Request) {
+	acct := mux.Vars(r)[pg.FieldUserAcct.String()]
+	q, err := parseHistoryQuery(acct, r.URL.Query())
+	if err != nil {
+		qe := err.(*queryError)
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": qe.field, "value": qe.value}}, w)
+		return
+	}
+
+	versions, err := HistoryHdl(r.Context(), ui, q)
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+
+	data := map[string]interface{}{}
+	if len(versions) > q.Limit {
+		versions = versions[:q.Limit]
+
+		next := *r.URL
+		values := next.Query()
+		values.Set("cursor", strconv.FormatInt(versions[len(versions)-1].ID, 10))
+		next.RawQuery = values.Encode()
+		data["next"] = next.RequestURI()
+	}
+	data["versions"] = versions
+
+	WriteJsonResponse(StatusOK, data, w)
+}
+
+///////////////////////////////////////////////////////////////////////////
+//////    GET /ui/v1/user/{acct:[A-Za-z0-9_]{8,20}}}?as_of={time}    //////
+///////////////////////////////////////////////////////////////////////////
+
+// userAsOf answers UserInfo with the user as of the time asOf, from its
+// history. A user deleted since is still found.
+func (ui *UI) userAsOf(w http.ResponseWriter, r *http.Request, acct, asOf string) {
+	t, err := time.Parse(time.RFC3339Nano, asOf)
+	if err != nil {
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": "as_of", "value": asOf}}, w)
+		return
+	}
+
+	versions, err := HistoryHdl(r.Context(), ui, &HistoryQuery{Acct: acct, AsOf: t})
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+	if len(versions) == 0 {
+		WriteJsonResponse(StatusNotFound, map[string]string{"user": acct, "as_of": asOf}, w)
+		return
+	}
+
+	user := versions[0].User()
+	WriteJsonResponse(StatusOK, UserView(&user, userAudience(r.Context(), &user)), w)
+}
+
+////////////////////////////////////////////////////////////////////////////////////////
+//////    POST /ui/v1/user/{acct:[A-Za-z0-9_]{8,20}}}/history/{version}/revert    //////
+////////////////////////////////////////////////////////////////////////////////////////
+
+// RevertUser restores the fullname and attributes of a version of the
+// user, as an update through UpdateHdl: it makes a new version, is audited
+// and announced like any other, and honors If-Match. The attributes must
+// still satisfy the schema.
+func (ui *UI) RevertUser(w http.ResponseWriter, r *http.Request) {
+	aw := ui.startAudit(w, r, AuditActionRevert)
+	defer aw.finish()
+	w = aw
+
+	acct := mux.Vars(r)[pg.FieldUserAcct.String()]
+	aw.event.Target = acct
+	id := pathID(r, "version")
+
+	versions, err := HistoryHdl(r.Context(), ui, &HistoryQuery{Acct: acct, Version: id})
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+	if len(versions) == 0 {
+		WriteJsonResponse(StatusNotFound, map[string]int64{"version": id}, w)
+		return
+	}
+	version := versions[0]
+
+	var attrs interface{}
+	if err := json.Unmarshal(version.Attributes, &attrs); err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+	user := &pg.User{Acct: acct, Fullname: version.Fullname}
+	var violations []schema.Violation
+	if user.Attributes, violations = ui.parseAttributes(attrs); violations != nil {
+		writeInvalidRequest(attributeErrors(violations), w)
+		return
+	}
+
+	pre, ok := ui.precondition(w, r)
+	if !ok {
+		return
+	}
+
+	before, err := UpdateHdl(r.Context(), ui, user, pre)
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+	if before == nil {
+		WriteJsonResponse(StatusNotFound, map[string]string{"user": acct}, w)
+		return
+	}
+
+	after := *before
+	after.Fullname, after.Attributes = user.Fullname, user.Attributes
+	aw.diff(before, &after)
+
+	WriteJsonResponse(StatusOK, nil, w)
+}
@@ -0,0 +1,203 @@
+package ui_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/secret"
+	"github.com/dontang97/ui/ui"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/suite"
+)
+
+type _historySuite struct {
+	suite.Suite
+	UI *ui.UI
+
+	HistoryHdl ui.QueryVersionHandlerFunc
+	UpdateHdl  ui.UpdateUserHandlerFunc
+	AuditHdl   ui.AuditHandlerFunc
+
+	updates []pg.User
+	events  []pg.AuditEvent
+}
+
+var (
+	signedUpAt = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	renamedAt  = time.Date(2021, 3, 2, 12, 0, 0, 0, time.UTC)
+)
+
+func (s *_historySuite) SetupSuite() {
+	s.UI = ui.New()
+}
+
+func (s *_historySuite) TearDownSuite() {
+}
+
+func (s *_historySuite) SetupTest() {
+	s.updates, s.events = nil, nil
+
+	// kobe_bryant signed up as Kobe and was renamed Kobe Bryant
+	versions := []pg.UserVersion{
+		{ID: 2, Acct: "kobe_bryant", Fullname: "Kobe Bryant", Attributes: pg.JSONB(`{}`), ValidFrom: renamedAt},
+		{ID: 1, Acct: "kobe_bryant", Fullname: "Kobe", Attributes: pg.JSONB(`{}`), ValidFrom: signedUpAt, ValidTo: &renamedAt},
+	}
+	s.HistoryHdl, ui.HistoryHdl = ui.HistoryHdl, func(_ context.Context, _ *ui.UI, q *ui.HistoryQuery) ([]pg.UserVersion, error) {
+		if q.Acct != "kobe_bryant" {
+			return []pg.UserVersion{}, nil
+		}
+		found := []pg.UserVersion{}
+		for _, v := range versions {
+			switch {
+			case !q.AsOf.IsZero():
+				if !v.ValidFrom.After(q.AsOf) && (v.ValidTo == nil || v.ValidTo.After(q.AsOf)) {
+					found = append(found, v)
+				}
+			case q.Version > 0:
+				if v.ID == q.Version {
+					found = append(found, v)
+				}
+			case q.Before == 0 || v.ID < q.Before:
+				found = append(found, v)
+			}
+		}
+		if q.Limit > 0 && len(found) > q.Limit+1 {
+			found = found[:q.Limit+1]
+		}
+		return found, nil
+	}
+	s.UpdateHdl, ui.UpdateHdl = ui.UpdateHdl, func(_ context.Context, _ *ui.UI, user *pg.User, _ *ui.Precondition) (*pg.User, error) {
+		s.updates = append(s.updates, *user)
+		if user.Acct != "kobe_bryant" {
+			return nil, nil
+		}
+		return &pg.User{Acct: user.Acct, Pwd: "123456789", Fullname: "Kobe Bryant", Attributes: pg.JSONB(`{}`)}, nil
+	}
+	s.AuditHdl, ui.AuditHdl = ui.AuditHdl, func(_ context.Context, _ *ui.UI, ev *pg.AuditEvent) error {
+		s.events = append(s.events, *ev)
+		return nil
+	}
+}
+
+func (s *_historySuite) TearDownTest() {
+	ui.HistoryHdl, s.HistoryHdl = s.HistoryHdl, nil
+	ui.UpdateHdl, s.UpdateHdl = s.UpdateHdl, nil
+	ui.AuditHdl, s.AuditHdl = s.AuditHdl, nil
+}
+
+// serve calls hdl on the path of acct with vars, as an admin.
+func (s *_historySuite) serve(hdl http.HandlerFunc, method, path string, vars map[string]string) (*httptest.ResponseRecorder, map[string]json.RawMessage) {
+	req := httptest.NewRequest(method, "http://test.com/ui/v1/user/"+path, nil)
+	req = mux.SetURLVars(req, vars)
+	req = req.WithContext(secret.NewContext(req.Context(), &secret.UserClaims{Acct: "jerry_buss", Roles: []string{secret.RoleAdmin}}))
+	rcd := httptest.NewRecorder()
+	hdl.ServeHTTP(rcd, req)
+
+	resp := struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}{}
+	if rcd.Body.Len() > 0 {
+		s.Equal(nil, json.Unmarshal(rcd.Body.Bytes(), &resp))
+	}
+	return rcd, resp.Data
+}
+
+func (s *_historySuite) TestUserHistory() {
+	vars := map[string]string{pg.FieldUserAcct.String(): "kobe_bryant"}
+
+	rcd, data := s.serve(s.UI.UserHistory, http.MethodGet, "kobe_bryant/history?limit=1", vars)
+	s.Equal(http.StatusOK, rcd.Code)
+	versions := []pg.UserVersion{}
+	s.Equal(nil, json.Unmarshal(data["versions"], &versions))
+	s.Equal(1, len(versions))
+	s.Equal("Kobe Bryant", versions[0].Fullname)
+	s.Nil(versions[0].ValidTo)
+
+	var next string
+	s.Equal(nil, json.Unmarshal(data["next"], &next))
+	s.Equal("/ui/v1/user/kobe_bryant/history?cursor=2&limit=1", next)
+
+	rcd, data = s.serve(s.UI.UserHistory, http.MethodGet, "kobe_bryant/history?cursor=2&limit=1", vars)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal(nil, json.Unmarshal(data["versions"], &versions))
+	s.Equal("Kobe", versions[0].Fullname)
+	s.Equal(renamedAt, *versions[0].ValidTo)
+	s.NotContains(data, "next")
+
+	for _, query := range []string{"limit=0", "limit=1001", "cursor=x"} {
+		rcd, _ = s.serve(s.UI.UserHistory, http.MethodGet, "kobe_bryant/history?"+query, vars)
+		s.Equal(http.StatusBadRequest, rcd.Code, query)
+	}
+}
+
+func (s *_historySuite) TestUserInfoAsOf() {
+	vars := map[string]string{pg.FieldUserAcct.String(): "kobe_bryant"}
+
+	rcd, _ := s.serve(s.UI.UserInfo, http.MethodGet, "kobe_bryant?as_of=2021-03-01T18:00:00Z", vars)
+	s.Equal(http.StatusOK, rcd.Code)
+	resp := struct {
+		Data pg.User `json:"data"`
+	}{}
+	s.Equal(nil, json.Unmarshal(rcd.Body.Bytes(), &resp))
+	s.Equal("Kobe", resp.Data.Fullname)
+	s.Equal("", resp.Data.Pwd)
+
+	// the version ends where the next begins
+	rcd, _ = s.serve(s.UI.UserInfo, http.MethodGet, "kobe_bryant?as_of=2021-03-02T12:00:00Z", vars)
+	s.Equal(nil, json.Unmarshal(rcd.Body.Bytes(), &resp))
+	s.Equal("Kobe Bryant", resp.Data.Fullname)
+
+	rcd, _ = s.serve(s.UI.UserInfo, http.MethodGet, "kobe_bryant?as_of=2021-02-01T00:00:00Z", vars)
+	s.Equal(http.StatusNotFound, rcd.Code)
+
+	rcd, _ = s.serve(s.UI.UserInfo, http.MethodGet, "kobe_bryant?as_of=yesterday", vars)
+	s.Equal(http.StatusBadRequest, rcd.Code)
+}
+
+func (s *_historySuite) TestRevertUser() {
+	vars := map[string]string{pg.FieldUserAcct.String(): "kobe_bryant", "version": "1"}
+
+	rcd, _ := s.serve(s.UI.RevertUser, http.MethodPost, "kobe_bryant/history/1/revert", vars)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal([]pg.User{{Acct: "kobe_bryant", Fullname: "Kobe", Attributes: pg.JSONB(`{}`)}}, s.updates)
+
+	// the revert is audited as the change it makes
+	s.Equal(1, len(s.events))
+	s.Equal(ui.AuditActionRevert, s.events[0].Action)
+	s.Equal("kobe_bryant", s.events[0].Target)
+	s.Contains(string(s.events[0].Diff), `"Kobe"`)
+
+	s.updates = nil
+	vars["version"] = "7"
+	rcd, _ = s.serve(s.UI.RevertUser, http.MethodPost, "kobe_bryant/history/7/revert", vars)
+	s.Equal(http.StatusNotFound, rcd.Code)
+	s.Nil(s.updates)
+}
+
+func (s *_historySuite) TestRevertInvalidAttributes() {
+	// the attributes of the version no longer pass, without a schema
+	ui.HistoryHdl = func(_ context.Context, _ *ui.UI, q *ui.HistoryQuery) ([]pg.UserVersion, error) {
+		return []pg.UserVersion{{ID: q.Version, Acct: q.Acct, Fullname: "Kobe", Attributes: pg.JSONB(`{"team":"lakers"}`)}}, nil
+	}
+
+	vars := map[string]string{pg.FieldUserAcct.String(): "kobe_bryant", "version": "1"}
+	rcd, data := s.serve(s.UI.RevertUser, http.MethodPost, "kobe_bryant/history/1/revert", vars)
+	s.Equal(http.StatusBadRequest, rcd.Code)
+	s.Nil(s.updates)
+
+	// answered as any invalid request
+	errs := []ui.FieldError{}
+	s.Equal(nil, json.Unmarshal(data["errors"], &errs))
+	s.Equal(1, len(errs))
+	s.Equal("attributes", errs[0].Field)
+	s.Equal(ui.RuleSchema, errs[0].Rule)
+}
+
+func TestRunHistory(t *testing.T) {
+	suite.Run(t, new(_historySuite))
+}
@@ -0,0 +1,526 @@
+package ui
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/dontang97/ui/outbox"
+	"github.com/dontang97/ui/pg"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	ImportCSV   = "csv"
+	ImportJSONL = "jsonl"
+
+	// what an import does with the rows of existing accounts
+	ImportConflictFail   = "fail"
+	ImportConflictSkip   = "skip"
+	ImportConflictUpdate = "update"
+
+	// the outcomes of the rows of an import
+	ImportCreated   = "created"
+	ImportUpdated   = "updated"
+	ImportUnchanged = "unchanged"
+	ImportSkipped   = "skipped"
+	ImportConflict  = "conflict"
+	ImportInvalid   = "invalid"
+	ImportError     = "error"
+
+	DefaultImportBatchSize = 100
+	MaxImportBatchSize     = 1000
+
+	// MaxImportSize bounds the body of POST /ui/v1/users/import. The
+	// command line import reads files of any size.
+	MaxImportSize = 16 << 20
+)
+
+// errImportDryRun rolls back the transaction of a dry run batch.
+var errImportDryRun = errors.New("dry run")
+
+// ImportBatchHandlerFunc applies a batch of valid users, of distinct
+// accounts, in one transaction and returns what became of each.
+type ImportBatchHandlerFunc func(context.Context, *UI, []pg.User, *ImportOptions) ([]ImportApplied, error)
+
+type ImportOptions struct {
+	Format     string
+	OnConflict string
+	DryRun     bool
+	BatchSize  int
+}
+
+// ImportApplied is the outcome of a user of a batch. Before is the
+// existing user of its account, if any, and Reason the account name policy
+// refused a conflicting account for.
+type ImportApplied struct {
+	Outcome string
+	Before  *pg.User
+	Reason  string
+}
+
+// ImportReport tells what became of each row of an import. A dry run
+// reports what would have, without changing anything.
+type ImportReport struct {
+	DryRun    bool        `json:"dry_run"`
+	Created   int         `json:"created"`
+	Updated   int         `json:"updated"`
+	Unchanged int         `json:"unchanged"`
+	Skipped   int         `json:"skipped"`
+	Failed    int         `json:"failed"`
+	Rows      []ImportRow `json:"rows"`
+}
+
+// ImportRow is the outcome of the row numbered Row, from 1 for the first
+// user of the input. Error is what a signup of the row would have been
+// answered with, for the rows that failed.
+type ImportRow struct {
+	Row     int         `json:"row"`
+	Account string      `json:"account,omitempty"`
+	Outcome string      `json:"outcome"`
+	Error   interface{} `json:"error,omitempty"`
+}
+
+// importRecord is a row of the input as the body of a signup, or the error
+// that kept it from being one.
+type importRecord struct {
+	row  int
+	body []byte
+	err  error
+}
+
+// readImport reads the rows of r. CSV has a header naming the columns
+// account, password and fullname, and optionally attributes, a JSON object;
+// JSONL has a signup body per line. A row that cannot be read fails on its
+// own; an error is returned only when the input cannot be read at all.
+func readImport(r io.Reader, format string) ([]importRecord, error) {
+	records := []importRecord{}
+
+	switch format {
+	case ImportCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		header, err := cr.Read()
+		if err != nil {
+			return nil, err
+		}
+		columns := map[string]bool{}
+		for i := range header {
+			header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+			columns[header[i]] = true
+		}
+		for _, col := range []string{"account", "password", "fullname"} {
+			if !columns[col] {
+				return nil, errors.New("the header has no " + col + " column")
+			}
+		}
+
+		for row := 1; ; row++ {
+			cells, err := cr.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			rec := importRecord{row: row}
+			if len(cells) != len(header) {
+				rec.err = errors.New("the row has " + strconv.Itoa(len(cells)) + " columns, the header " + strconv.Itoa(len(header)))
+			}
+			// the columns of a signup; the others are not imported
+			fields := map[string]interface{}{}
+			for i := 0; i < len(cells) && i < len(header); i++ {
+				switch header[i] {
+				case "account", "password", "fullname":
+					fields[header[i]] = cells[i]
+				case "attributes":
+					if cells[i] == "" {
+						continue
+					}
+					if !json.Valid([]byte(cells[i])) && rec.err == nil {
+						rec.err = errors.New("the attributes are not JSON")
+						continue
+					}
+					fields[header[i]] = json.RawMessage(cells[i])
+				}
+			}
+			if body, err := json.Marshal(fields); err != nil {
+				rec.err = err
+			} else {
+				rec.body = body
+			}
+			records = append(records, rec)
+		}
+
+	case ImportJSONL:
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64*1024), 1<<20)
+		row := 0
+		for sc.Scan() {
+			line := bytes.TrimSpace(sc.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			row++
+
+			records = append(records, importRecord{row: row, body: append([]byte(nil), line...)})
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, errors.New("unknown import format " + format)
+	}
+
+	return records, nil
+}
+
+// sameJSON reports whether a and b hold the same JSON value, however the
+// database laid them out.
+func sameJSON(a, b pg.JSONB) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+var ImportBatchHdl ImportBatchHandlerFunc = func(ctx context.Context, ui *UI, users []pg.User, opts *ImportOptions) ([]ImportApplied, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
+
+	tenant := Tenant(ctx)
+	accts := make([]string, len(users))
+	for i := range users {
+		accts[i] = users[i].Acct
+	}
+
+	applied := make([]ImportApplied, len(users))
+	err := ui.transaction(ctx, func(tx *gorm.DB) error {
+		rows, err := usersOf(ctx, tx).
+			Select("*").
+			Where(pg.FieldUserAcct.String()+" IN (?)", accts).
+			Set("gorm:query_option", "FOR UPDATE").
+			Rows()
+		if err != nil {
+			return err
+		}
+		existing, err := scanUsers(ui, rows)
+		if err != nil {
+			return err
+		}
+		byAcct := map[string]*pg.User{}
+		for i := range existing {
+			byAcct[existing[i].Acct] = &existing[i]
+		}
+
+		for i := range users {
+			user := &users[i]
+			user.Tenant = tenant
+			before := byAcct[user.Acct]
+			applied[i].Before = before
+
+			taken := false
+			if before == nil {
+				if taken, err = aliasTaken(ctx, tx, user.Acct, ""); err != nil {
+					return err
+				}
+			}
+			if before == nil && !taken {
+				var pe *AccountPolicyError
+				if err := checkAccount(ctx, tx, user.Acct, ""); errors.As(err, &pe) {
+					taken, applied[i].Reason = true, pe.Reason
+				} else if err != nil {
+					return err
+				}
+			}
+
+			switch {
+			case taken:
+				// the former name of a renamed account, or confusable with
+				// an account
+				applied[i].Outcome = ImportConflict
+
+			case before == nil:
+				stored, err := ui.sealUser(*user)
+				if err != nil {
+					return err
+				}
+				if res := tx.Table(pg.TableUsers.String()).Create(&stored); res.Error != nil {
+					return res.Error
+				}
+				if err := outbox.Enqueue(tx, outbox.UserCreated, tenant, user.Acct, userEvent{
+					Acct:       user.Acct,
+					Fullname:   ui.eventFullname(user.Fullname),
+					Attributes: user.Attributes,
+				}); err != nil {
+					return err
+				}
+				applied[i].Outcome = ImportCreated
+
+			case opts.OnConflict == ImportConflictUpdate:
+				// the row replaces the account as a whole
+				values := map[string]interface{}{}
+				event := userEvent{Acct: user.Acct, Fullname: ui.eventFullname(user.Fullname)}
+				if user.Fullname != before.Fullname {
+					if err := ui.fullnameValues(user.Fullname, values); err != nil {
+						return err
+					}
+					event.Changed = append(event.Changed, "fullname")
+				}
+				if user.Pwd != before.Pwd {
+					values[pg.FieldUserPwd.String()] = user.Pwd
+					event.Changed = append(event.Changed, "password")
+				}
+				if !sameJSON(user.Attributes, before.Attributes) {
+					values[pg.FieldUserAttributes.String()] = user.Attributes
+					event.Attributes = user.Attributes
+					event.Changed = append(event.Changed, "attributes")
+				}
+				if len(values) == 0 {
+					applied[i].Outcome = ImportUnchanged
+					continue
+				}
+
+				if res := usersOf(ctx, tx).
+					Where(pg.FieldUserAcct.String()+" = ?", user.Acct).
+					Updates(values); res.Error != nil {
+					return res.Error
+				}
+				if err := outbox.Enqueue(tx, outbox.UserUpdated, tenant, user.Acct, event); err != nil {
+					return err
+				}
+				applied[i].Outcome = ImportUpdated
+
+			case opts.OnConflict == ImportConflictSkip:
+				applied[i].Outcome = ImportSkipped
+
+			default:
+				applied[i].Outcome = ImportConflict
+			}
+		}
+
+		// a dry run goes through the same statements, so it meets the same
+		// conflicts and constraints, and rolls them back
+		if opts.DryRun {
+			return errImportDryRun
+		}
+		return nil
+	})
+	if err != nil && err != errImportDryRun {
+		return nil, err
+	}
+
+	if !opts.DryRun {
+		ui.markWritten(ctx, accts...)
+	}
+	return applied, nil
+}
+
+// Import signs up the users read from r in the tenant of ctx, validating
+// each row as a signup, in transactions of opts.BatchSize rows. Rows of
+// accounts that exist are handled as opts.OnConflict says. A batch that
+// fails fails its rows only. Each account created or updated gets an audit
+// event based on base, the event of the import.
+func (ui *UI) Import(ctx context.Context, r io.Reader, opts *ImportOptions, base pg.AuditEvent) (*ImportReport, error) {
+	records, err := readImport(r, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{DryRun: opts.DryRun, Rows: make([]ImportRow, 0, len(records))}
+	seen := map[string]int{}
+	var batch []pg.User
+	var batchRows []int
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		defer func() { batch, batchRows = nil, nil }()
+
+		applied, err := ImportBatchHdl(ctx, ui, batch, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			log.Print(err)
+			for _, i := range batchRows {
+				report.Rows[i].Outcome = ImportError
+				report.Rows[i].Error = map[string]string{"error": "the batch of the row was rolled back"}
+			}
+			return nil
+		}
+
+		for j, i := range batchRows {
+			report.Rows[i].Outcome = applied[j].Outcome
+			if applied[j].Outcome == ImportConflict {
+				conflict := map[string]string{"user": batch[j].Acct}
+				if applied[j].Reason != "" {
+					conflict["reason"] = applied[j].Reason
+				}
+				report.Rows[i].Error = conflict
+			}
+			if opts.DryRun {
+				continue
+			}
+			switch applied[j].Outcome {
+			case ImportCreated:
+				ui.auditAccount(base, Tenant(ctx), AuditActionSignUp, batch[j].Acct, nil, &batch[j])
+			case ImportUpdated:
+				ui.auditAccount(base, Tenant(ctx), AuditActionUpdate, batch[j].Acct, applied[j].Before, &batch[j])
+			}
+		}
+		return nil
+	}
+
+	for _, rec := range records {
+		row := ImportRow{Row: rec.row}
+		req := SignUpRequest{}
+		var errs []FieldError
+		if rec.body != nil {
+			errs = decodeRequest(rec.body, &req)
+			row.Account = truncate(req.Account, auditMaxLen)
+		}
+
+		if rec.err != nil {
+			row.Outcome, row.Error = ImportInvalid, map[string]string{"error": rec.err.Error()}
+		} else if user, errs := ui.parseUser(&req, errs); len(errs) > 0 {
+			row.Outcome, row.Error = ImportInvalid, InvalidRequest{Errors: errs}
+		} else if ui.reservedAccount(user.Acct) || ui.outranks(ctx, Tenant(ctx), user.Acct) {
+			row.Outcome, row.Error = ImportInvalid, map[string]string{"user": user.Acct, "reason": AccountReserved}
+		} else if first, ok := seen[user.Acct]; ok {
+			row.Outcome, row.Error = ImportInvalid, map[string]int{"duplicate_of_row": first}
+		} else {
+			seen[user.Acct] = rec.row
+			batch = append(batch, user)
+			batchRows = append(batchRows, len(report.Rows))
+		}
+		report.Rows = append(report.Rows, row)
+
+		if len(batch) >= opts.BatchSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	for _, row := range report.Rows {
+		switch row.Outcome {
+		case ImportCreated:
+			report.Created++
+		case ImportUpdated:
+			report.Updated++
+		case ImportUnchanged:
+			report.Unchanged++
+		case ImportSkipped:
+			report.Skipped++
+		default:
+			report.Failed++
+		}
+	}
+	return report, nil
+}
+
+// ValidImportConflict reports whether onConflict names a way to handle
+// the rows of existing accounts.
+func ValidImportConflict(onConflict string) bool {
+	switch onConflict {
+	case ImportConflictFail, ImportConflictSkip, ImportConflictUpdate:
+		return true
+	}
+	return false
+}
+
+// parseImportOptions reads the query string of an import. The format
+// defaults to the one of the content type.
+func parseImportOptions(r *http.Request) (*ImportOptions, error) {
+	values := r.URL.Query()
+	opts := &ImportOptions{
+		Format:     values.Get("format"),
+		OnConflict: ImportConflictFail,
+		BatchSize:  DefaultImportBatchSize,
+	}
+
+	if opts.Format == "" {
+		mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mt {
+		case "text/csv":
+			opts.Format = ImportCSV
+		case "application/jsonl", "application/x-ndjson", "application/x-jsonlines":
+			opts.Format = ImportJSONL
+		}
+	}
+	if opts.Format != ImportCSV && opts.Format != ImportJSONL {
+		return nil, &queryError{"format", opts.Format}
+	}
+
+	if v := values.Get("on_conflict"); v != "" {
+		if !ValidImportConflict(v) {
+			return nil, &queryError{"on_conflict", v}
+		}
+		opts.OnConflict = v
+	}
+
+	if v := values.Get("dry_run"); v != "" {
+		var err error
+		if opts.DryRun, err = strconv.ParseBool(v); err != nil {
+			return nil, &queryError{"dry_run", v}
+		}
+	}
+
+	if v := values.Get("batch_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > MaxImportBatchSize {
+			return nil, &queryError{"batch_size", v}
+		}
+		opts.BatchSize = n
+	}
+
+	return opts, nil
+}
+
+////////////////////////////////////////////
+//////    POST /ui/v1/users/import    //////
+////////////////////////////////////////////
+
+func (ui *UI) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	aw := ui.startAudit(w, r, AuditActionImport)
+	defer aw.finish()
+	w = aw
+
+	opts, err := parseImportOptions(r)
+	if err != nil {
+		qe := err.(*queryError)
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": qe.field, "value": qe.value}}, w)
+		return
+	}
+
+	report, err := ui.Import(r.Context(), http.MaxBytesReader(w, r.Body, MaxImportSize), opts, aw.event)
+	if err != nil {
+		if r.Context().Err() != nil {
+			WriteErrorResponse(err, w)
+			return
+		}
+		WriteJsonResponse(StatusInvalidContent, map[string]string{"error": err.Error()}, w)
+		return
+	}
+
+	WriteJsonResponse(StatusOK, report, w)
+}
@@ -0,0 +1,244 @@
+package ui_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/secret"
+	"github.com/dontang97/ui/ui"
+	"github.com/stretchr/testify/suite"
+)
+
+type _importSuite struct {
+	suite.Suite
+	UI *ui.UI
+
+	ImportBatchHdl ui.ImportBatchHandlerFunc
+	AuditHdl       ui.AuditHandlerFunc
+
+	batches [][]pg.User
+	events  []pg.AuditEvent
+}
+
+func (s *_importSuite) SetupSuite() {
+	s.UI = ui.New()
+}
+
+func (s *_importSuite) TearDownSuite() {
+}
+
+func (s *_importSuite) SetupTest() {
+	s.batches, s.events = nil, nil
+
+	// lebron_james exists
+	s.ImportBatchHdl, ui.ImportBatchHdl = ui.ImportBatchHdl, func(_ context.Context, _ *ui.UI, users []pg.User, opts *ui.ImportOptions) ([]ui.ImportApplied, error) {
+		s.batches = append(s.batches, users)
+		applied := []ui.ImportApplied{}
+		for _, user := range users {
+			if user.Acct != "lebron_james" {
+				applied = append(applied, ui.ImportApplied{Outcome: ui.ImportCreated})
+				continue
+			}
+			before := &pg.User{Acct: user.Acct, Pwd: "123456789", Fullname: "LeBron", Attributes: pg.JSONB(`{}`)}
+			switch opts.OnConflict {
+			case ui.ImportConflictUpdate:
+				applied = append(applied, ui.ImportApplied{Outcome: ui.ImportUpdated, Before: before})
+			case ui.ImportConflictSkip:
+				applied = append(applied, ui.ImportApplied{Outcome: ui.ImportSkipped, Before: before})
+			default:
+				applied = append(applied, ui.ImportApplied{Outcome: ui.ImportConflict, Before: before})
+			}
+		}
+		return applied, nil
+	}
+	s.AuditHdl, ui.AuditHdl = ui.AuditHdl, func(_ context.Context, _ *ui.UI, ev *pg.AuditEvent) error {
+		s.events = append(s.events, *ev)
+		return nil
+	}
+}
+
+func (s *_importSuite) TearDownTest() {
+	ui.ImportBatchHdl, s.ImportBatchHdl = s.ImportBatchHdl, nil
+	ui.AuditHdl, s.AuditHdl = s.AuditHdl, nil
+}
+
+func (s *_importSuite) serve(query, contentType, body string) (*httptest.ResponseRecorder, *ui.ImportReport) {
+	req := httptest.NewRequest(http.MethodPost, "http://test.com/ui/v1/users/import"+query, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", contentType)
+	rcd := httptest.NewRecorder()
+	http.HandlerFunc(s.UI.ImportUsers).ServeHTTP(rcd, req)
+
+	resp := struct {
+		Data *ui.ImportReport `json:"data"`
+	}{}
+	s.Equal(nil, json.Unmarshal(rcd.Body.Bytes(), &resp))
+	return rcd, resp.Data
+}
+
+func outcomes(report *ui.ImportReport) []string {
+	os := []string{}
+	for _, row := range report.Rows {
+		os = append(os, row.Outcome)
+	}
+	return os
+}
+
+const importCSV = `account,password,fullname
+kobe_bryant,123456789,Kobe Bryant
+lebron_james,123456789,LeBron James
+short,123456789,Too Short
+kobe_bryant,123456789,Kobe Again
+"shaquille_o",123456789
+magic_johnson,123456789,Magic Johnson
+`
+
+func (s *_importSuite) TestImportCSV() {
+	rcd, report := s.serve("?batch_size=2", "text/csv", importCSV)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal([]string{
+		ui.ImportCreated,
+		ui.ImportConflict,
+		ui.ImportInvalid,
+		ui.ImportInvalid,
+		ui.ImportInvalid,
+		ui.ImportCreated,
+	}, outcomes(report))
+	s.Equal(2, report.Created)
+	s.Equal(4, report.Failed)
+	s.Equal(false, report.DryRun)
+
+	// the rows are validated as signups, and within the input
+	s.Equal(map[string]interface{}{"errors": []interface{}{
+		map[string]interface{}{"field": "account", "rule": ui.RulePattern, "message": "must match ^[A-Za-z0-9_]{8,20}$"},
+	}}, report.Rows[2].Error)
+	s.Equal(map[string]interface{}{"duplicate_of_row": float64(1)}, report.Rows[3].Error)
+	s.Equal("shaquille_o", report.Rows[4].Account)
+
+	// the valid rows go in batches
+	s.Equal(2, len(s.batches))
+	s.Equal("kobe_bryant", s.batches[0][0].Acct)
+	s.Equal("magic_johnson", s.batches[1][0].Acct)
+
+	// each account created is audited, and the import
+	actions := []string{}
+	for _, ev := range s.events {
+		actions = append(actions, ev.Action+":"+ev.Target)
+	}
+	s.Equal([]string{
+		ui.AuditActionSignUp + ":kobe_bryant",
+		ui.AuditActionSignUp + ":magic_johnson",
+		ui.AuditActionImport + ":",
+	}, actions)
+}
+
+func (s *_importSuite) TestImportRoleAccounts() {
+	s.UI.SuperAdmins["lebron_james"] = true
+	defer delete(s.UI.SuperAdmins, "lebron_james")
+
+	// an admin imports the account the operator makes a super admin neither
+	// over it nor in its place
+	serve := func(roles ...string) *ui.ImportReport {
+		req := httptest.NewRequest(http.MethodPost, "http://test.com/ui/v1/users/import?on_conflict=update",
+			bytes.NewBufferString("account,password,fullname\nlebron_james,123456789,LeBron James\n"))
+		req.Header.Set("Content-Type", "text/csv")
+		req = req.WithContext(secret.NewContext(req.Context(), &secret.UserClaims{Acct: "jerry_buss", Roles: roles}))
+		rcd := httptest.NewRecorder()
+		http.HandlerFunc(s.UI.ImportUsers).ServeHTTP(rcd, req)
+		s.Equal(http.StatusOK, rcd.Code)
+
+		resp := struct {
+			Data *ui.ImportReport `json:"data"`
+		}{}
+		s.Equal(nil, json.Unmarshal(rcd.Body.Bytes(), &resp))
+		return resp.Data
+	}
+
+	report := serve(secret.RoleAdmin)
+	s.Equal([]string{ui.ImportInvalid}, outcomes(report))
+	s.Equal(map[string]interface{}{"user": "lebron_james", "reason": ui.AccountReserved}, report.Rows[0].Error)
+	s.Nil(s.batches)
+
+	report = serve(secret.RoleSuperAdmin)
+	s.Equal([]string{ui.ImportUpdated}, outcomes(report))
+}
+
+func (s *_importSuite) TestImportConflicts() {
+	for onConflict, outcome := range map[string]string{
+		ui.ImportConflictFail:   ui.ImportConflict,
+		ui.ImportConflictSkip:   ui.ImportSkipped,
+		ui.ImportConflictUpdate: ui.ImportUpdated,
+	} {
+		s.events = nil
+		rcd, report := s.serve("?on_conflict="+onConflict, "text/csv", importCSV)
+		s.Equal(http.StatusOK, rcd.Code)
+		s.Equal(outcome, report.Rows[1].Outcome, onConflict)
+		if outcome == ui.ImportUpdated {
+			s.Equal(1, report.Updated)
+			s.Equal(ui.AuditActionUpdate, s.events[1].Action)
+			s.JSONEq(`{"fullname": {"before": "LeBron", "after": "LeBron James"}}`, string(s.events[1].Diff))
+		}
+	}
+}
+
+func (s *_importSuite) TestImportJSONL() {
+	body := `{"account": "kobe_bryant", "password": "123456789", "fullname": "Kobe Bryant"}
+
+{"account": "magic_johnson", "password": "123456789", "fullname": "Magic", "attributes": {"team": "lakers"}}
+{"account": "shaquille_o",
+`
+	rcd, report := s.serve("?dry_run=true", "application/x-ndjson", body)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal(true, report.DryRun)
+	s.Equal([]string{ui.ImportCreated, ui.ImportInvalid, ui.ImportInvalid}, outcomes(report))
+	s.Equal(3, report.Rows[2].Row)
+
+	// without a schema there are no attributes
+	errs := report.Rows[1].Error.(map[string]interface{})["errors"].([]interface{})
+	s.Equal(ui.RuleSchema, errs[0].(map[string]interface{})["rule"])
+	s.Equal(ui.RuleSyntax, report.Rows[2].Error.(map[string]interface{})["errors"].([]interface{})[0].(map[string]interface{})["rule"])
+
+	// a dry run changes nothing to audit but the import
+	s.Equal(1, len(s.events))
+	s.Equal(ui.AuditActionImport, s.events[0].Action)
+}
+
+func (s *_importSuite) TestImportBatchError() {
+	ui.ImportBatchHdl = func(context.Context, *ui.UI, []pg.User, *ui.ImportOptions) ([]ui.ImportApplied, error) {
+		return nil, errors.New("connection reset")
+	}
+
+	rcd, report := s.serve("?format=csv", "", importCSV)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal(ui.ImportError, report.Rows[0].Outcome)
+	s.Equal(6, report.Failed)
+}
+
+func (s *_importSuite) TestImportInvalid() {
+	for query, contentType := range map[string]string{
+		"":                      "application/json",
+		"?format=xml":           "text/csv",
+		"?on_conflict=merge":    "text/csv",
+		"?dry_run=maybe":        "text/csv",
+		"?batch_size=0":         "text/csv",
+		"?batch_size=1000000":   "text/csv",
+		"?format=csv&dry_run=1": "",
+	} {
+		req := httptest.NewRequest(http.MethodPost, "http://test.com/ui/v1/users/import"+query,
+			bytes.NewBufferString("account,password\nkobe_bryant,123456789\n"))
+		req.Header.Set("Content-Type", contentType)
+		rcd := httptest.NewRecorder()
+		http.HandlerFunc(s.UI.ImportUsers).ServeHTTP(rcd, req)
+		s.Equal(http.StatusBadRequest, rcd.Code, query)
+	}
+	s.Equal(0, len(s.batches))
+}
+
+func TestRunImport(t *testing.T) {
+	suite.Run(t, new(_importSuite))
+}
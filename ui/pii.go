@@ -0,0 +1,317 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/pii"
+	"github.com/jinzhu/gorm"
+)
+
+// ReencryptBatchSize is the users re-encrypted per transaction.
+const ReencryptBatchSize = 100
+
+// ReencryptHandlerFunc re-encrypts up to n users not sealed with the
+// active data key, and returns how many it did.
+type ReencryptHandlerFunc func(context.Context, *UI, int) (int, error)
+
+// errNoKeys is returned for a sealed value when encryption is off.
+var errNoKeys = errors.New("the fullname is encrypted, but field encryption is off")
+
+// fullnameKey returns the key the exact lookups match fullname by: its
+// fold, or with field encryption on, the blind index of that.
+func (ui *UI) fullnameKey(fullname string) string {
+	if ui.Fields == nil {
+		return foldFullname(fullname)
+	}
+	return ui.Fields.BlindIndex(pg.FieldUserFullname.String(), foldFullname(fullname))
+}
+
+// sealFullname returns the stored value and match key of fullname. With
+// field encryption off, the value is fullname.
+func (ui *UI) sealFullname(fullname string) (string, string, error) {
+	if ui.Fields == nil {
+		return fullname, ui.fullnameKey(fullname), nil
+	}
+	sealed, err := ui.Fields.Seal(pg.FieldUserFullname.String(), fullname)
+	if err != nil {
+		return "", "", err
+	}
+	return sealed, ui.fullnameKey(fullname), nil
+}
+
+// sealUser returns user as stored: its fullname sealed and indexed.
+func (ui *UI) sealUser(user pg.User) (pg.User, error) {
+	var err error
+	user.Fullname, user.FullnameIndex, err = ui.sealFullname(user.Fullname)
+	return user, err
+}
+
+// fullnameValues are the column values of an update of the fullname.
+func (ui *UI) fullnameValues(fullname string, values map[string]interface{}) error {
+	sealed, index, err := ui.sealFullname(fullname)
+	if err != nil {
+		return err
+	}
+	values[pg.FieldUserFullname.String()] = sealed
+	values[pg.FieldUserFullnameIndex.String()] = index
+	return nil
+}
+
+// openFullname returns the plaintext of a stored fullname.
+func (ui *UI) openFullname(value string) (string, error) {
+	if ui.Fields == nil {
+		if pii.IsSealed(value) {
+			return "", errNoKeys
+		}
+		return value, nil
+	}
+	return ui.Fields.Open(pg.FieldUserFullname.String(), value)
+}
+
+// whereFullname filters db to the users of fullname by its match key, so
+// the names that differ from it in case or normalization only match too.
+func (ui *UI) whereFullname(db *gorm.DB, fullname string) *gorm.DB {
+	return db.Where(pg.FieldUserFullnameIndex.String()+" = ?", ui.fullnameKey(fullname))
+}
+
+// LoadKeys unwraps the data keys with master into ui.Fields, which turns
+// field encryption on. On a database without keys it makes the first data
+// key and the index key. Every key must be wrapped by master.
+func (ui *UI) LoadKeys(ctx context.Context, master *pii.Master) error {
+	keys := []pg.DataKey{}
+	err := ui.Transaction(ctx, func(tx *gorm.DB) error {
+		// the instances starting together make one set of keys
+		if res := tx.Exec("LOCK TABLE " + pg.TableDataKeys.String() + " IN EXCLUSIVE MODE"); res.Error != nil {
+			return res.Error
+		}
+		if res := tx.Table(pg.TableDataKeys.String()).Order(pg.FieldDataKeyID.String()).Find(&keys); res.Error != nil {
+			return res.Error
+		}
+
+		for _, purpose := range []string{pg.DataKeyIndex, pg.DataKeyData} {
+			found := false
+			for _, key := range keys {
+				found = found || key.Purpose == purpose
+			}
+			if found {
+				continue
+			}
+			key, err := newDataKey(tx, master, purpose)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, *key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return ui.loadKeyring(master, keys)
+}
+
+func newDataKey(tx *gorm.DB, master *pii.Master, purpose string) (*pg.DataKey, error) {
+	raw, err := pii.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := master.Wrap(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &pg.DataKey{Purpose: purpose, Wrapped: wrapped, MasterID: master.ID(), CreatedAt: time.Now().UTC()}
+	if res := tx.Table(pg.TableDataKeys.String()).Create(key); res.Error != nil {
+		return nil, res.Error
+	}
+	return key, nil
+}
+
+// loadKeyring unwraps keys into ui.Fields. A reload only adds the data keys
+// rotated since, as the handlers read ui.Fields meanwhile.
+func (ui *UI) loadKeyring(master *pii.Master, keys []pg.DataKey) error {
+	kr := ui.Fields
+	for _, key := range keys {
+		if key.Purpose == pg.DataKeyIndex && kr == nil {
+			raw, err := master.Unwrap(key.Wrapped)
+			if err != nil {
+				return err
+			}
+			kr = pii.NewKeyring(raw)
+		}
+	}
+	if kr == nil {
+		return errors.New("no blind index key")
+	}
+
+	for _, key := range keys {
+		if key.Purpose != pg.DataKeyData || kr.Has(key.ID) {
+			continue
+		}
+		raw, err := master.Unwrap(key.Wrapped)
+		if err != nil {
+			return err
+		}
+		if err := kr.Add(key.ID, raw); err != nil {
+			return err
+		}
+	}
+
+	if ui.Fields == nil {
+		ui.Fields = kr
+	}
+	return nil
+}
+
+// RotateKey makes a new data key, wrapped with master, that seals the new
+// values from the next reload of the keys of each instance on, and the
+// old ones as they are re-encrypted.
+func (ui *UI) RotateKey(ctx context.Context, master *pii.Master) (*pg.DataKey, error) {
+	var key *pg.DataKey
+	err := ui.Transaction(ctx, func(tx *gorm.DB) (err error) {
+		key, err = newDataKey(tx, master, pg.DataKeyData)
+		return err
+	})
+	return key, err
+}
+
+// RewrapKeys wraps the keys wrapped by old with master instead, for the
+// rotation of the master key. The data stays as it is.
+func (ui *UI) RewrapKeys(ctx context.Context, old, master *pii.Master) (int, error) {
+	n := 0
+	err := ui.Transaction(ctx, func(tx *gorm.DB) error {
+		keys := []pg.DataKey{}
+		if res := tx.
+			Table(pg.TableDataKeys.String()).
+			Where(pg.FieldDataKeyMasterID.String()+" = ?", old.ID()).
+			Set("gorm:query_option", "FOR UPDATE").
+			Find(&keys); res.Error != nil {
+			return res.Error
+		}
+
+		for _, key := range keys {
+			raw, err := old.Unwrap(key.Wrapped)
+			if err != nil {
+				return err
+			}
+			wrapped, err := master.Wrap(raw)
+			if err != nil {
+				return err
+			}
+			if res := tx.
+				Table(pg.TableDataKeys.String()).
+				Where(pg.FieldDataKeyID.String()+" = ?", key.ID).
+				Updates(map[string]interface{}{
+					pg.FieldDataKeyWrapped.String():  wrapped,
+					pg.FieldDataKeyMasterID.String(): master.ID(),
+				}); res.Error != nil {
+				return res.Error
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// ReencryptHdl seals with the active data key the fullnames sealed with an
+// older one, or not at all, of every tenant. Rows locked by a write are
+// left to the next pass.
+var ReencryptHdl ReencryptHandlerFunc = func(ctx context.Context, ui *UI, n int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
+
+	done := 0
+	err := ui.Transaction(ctx, func(tx *gorm.DB) error {
+		if res := tx.Exec("SELECT set_config(?, 'on', true)", pg.ReencryptSetting); res.Error != nil {
+			return res.Error
+		}
+
+		users := []pg.User{}
+		if res := tx.
+			Table(pg.TableUsers.String()).
+			Select([]string{pg.FieldUserTenant.String(), pg.FieldUserAcct.String(), pg.FieldUserFullname.String()}).
+			Where(pg.FieldUserFullname.String()+" NOT LIKE ?", ui.Fields.ActivePrefix()+"%").
+			Limit(n).
+			Set("gorm:query_option", "FOR UPDATE SKIP LOCKED").
+			Find(&users); res.Error != nil {
+			return res.Error
+		}
+
+		for _, user := range users {
+			fullname, err := ui.openFullname(user.Fullname)
+			if err != nil {
+				return err
+			}
+			values := map[string]interface{}{}
+			if err := ui.fullnameValues(fullname, values); err != nil {
+				return err
+			}
+			if res := tx.
+				Table(pg.TableUsers.String()).
+				Where(pg.FieldUserTenant.String()+" = ? AND "+pg.FieldUserAcct.String()+" = ?", user.Tenant, user.Acct).
+				Updates(values); res.Error != nil {
+				return res.Error
+			}
+		}
+		done = len(users)
+		return nil
+	})
+	return done, err
+}
+
+// RunReencryption reloads the keys every interval until ctx is done, to
+// take up the data keys rotated by any instance, and re-encrypts the users
+// not sealed with the active one. Each pass scans the users for them.
+func (ui *UI) RunReencryption(ctx context.Context, master *pii.Master, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := ui.LoadKeys(ctx, master); err != nil {
+			log.Print(err)
+		} else {
+			total := 0
+			for {
+				n, err := ReencryptHdl(ctx, ui, ReencryptBatchSize)
+				if err != nil {
+					log.Print(err)
+					break
+				}
+				total += n
+				if n < ReencryptBatchSize {
+					break
+				}
+			}
+			if total > 0 {
+				log.Printf("Re-encrypted %v users with data key %v", total, ui.Fields.Active())
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sealedField reports whether field is sealed at rest. Its plaintext is
+// kept out of the copies of a user too: the audit log and the events.
+func (ui *UI) sealedField(field string) bool {
+	return ui.Fields != nil && field == pg.FieldUserFullname.String()
+}
+
+// eventFullname returns the fullname a user event carries, none once the
+// fullname is sealed.
+func (ui *UI) eventFullname(fullname string) string {
+	if ui.sealedField(pg.FieldUserFullname.String()) {
+		return ""
+	}
+	return fullname
+}
@@ -0,0 +1,164 @@
+package ui_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dontang97/ui/outbox"
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/pii"
+	"github.com/dontang97/ui/secret"
+	"github.com/dontang97/ui/ui"
+	"github.com/dontang97/ui/webhook"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/suite"
+)
+
+// testDBHost names the Postgres of docker-compose.yml the tests touching
+// the database run against; they are skipped without it.
+const testDBHost = "UI_TEST_DB_HOST"
+
+type _piiSuite struct {
+	suite.Suite
+	UI *ui.UI
+
+	SignUpHdl ui.AddUserHandlerFunc
+	UpdateHdl ui.UpdateUserHandlerFunc
+	AuditHdl  ui.AuditHandlerFunc
+
+	events []pg.AuditEvent
+}
+
+func (s *_piiSuite) SetupSuite() {
+	secret.InitSecretKey("../secret")
+}
+
+func (s *_piiSuite) SetupTest() {
+	s.UI = ui.New()
+	s.UI.Fields = pii.NewKeyring(make([]byte, 32))
+	s.Nil(s.UI.Fields.Add(1, make([]byte, 32)))
+
+	s.SignUpHdl, s.UpdateHdl = ui.SignUpHdl, ui.UpdateHdl
+	s.events = nil
+	s.AuditHdl, ui.AuditHdl = ui.AuditHdl, func(_ context.Context, _ *ui.UI, ev *pg.AuditEvent) error {
+		s.events = append(s.events, *ev)
+		return nil
+	}
+}
+
+func (s *_piiSuite) TearDownTest() {
+	ui.SignUpHdl, ui.UpdateHdl, ui.AuditHdl = s.SignUpHdl, s.UpdateHdl, s.AuditHdl
+}
+
+// signUp and update send the requests of acct to the handlers.
+func (s *_piiSuite) signUp(acct, fullname string) int {
+	js, err := json.Marshal(map[string]string{"account": acct, "password": "123456789", "fullname": fullname})
+	s.Nil(err)
+	req := httptest.NewRequest(http.MethodPost, "http://test.com", bytes.NewBuffer(js))
+	rcd := httptest.NewRecorder()
+	http.HandlerFunc(s.UI.SignUp).ServeHTTP(rcd, req)
+	return rcd.Code
+}
+
+func (s *_piiSuite) update(acct, fullname string) int {
+	js, err := json.Marshal(map[string]string{"fullname": fullname})
+	s.Nil(err)
+	req := httptest.NewRequest(http.MethodPut, "http://test.com/", bytes.NewBuffer(js))
+	req = mux.SetURLVars(req, map[string]string{"acct": acct})
+	rcd := httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Update).ServeHTTP(rcd, req)
+	return rcd.Code
+}
+
+func (s *_piiSuite) TestSealedAudit() {
+	ui.SignUpHdl = func(context.Context, *ui.UI, *pg.User) error {
+		return nil
+	}
+	ui.UpdateHdl = func(_ context.Context, _ *ui.UI, user *pg.User, _ *ui.Precondition) (*pg.User, error) {
+		return &pg.User{Acct: user.Acct, Fullname: "Kobe Bryant"}, nil
+	}
+
+	s.Equal(http.StatusOK, s.signUp("kobe_bryant", "Kobe Bryant"))
+	s.Equal(http.StatusOK, s.update("kobe_bryant", "Black Mamba"))
+
+	// the change is recorded, but not the names
+	s.Equal(2, len(s.events))
+	for _, ev := range s.events {
+		s.NotContains(string(ev.Diff), "Kobe Bryant")
+		s.NotContains(string(ev.Diff), "Black Mamba")
+	}
+	diff := map[string]map[string]interface{}{}
+	s.Nil(json.Unmarshal(s.events[1].Diff, &diff))
+	s.Equal(map[string]interface{}{"before": "[REDACTED]", "after": "[REDACTED]"}, diff["fullname"])
+
+	// the same fullname is no change
+	s.events = nil
+	s.Equal(http.StatusOK, s.update("kobe_bryant", "Kobe Bryant"))
+	s.Equal("{}", string(s.events[0].Diff))
+}
+
+// TestSealedCopies greps the tables holding a copy of a user for its
+// fullname, after its signup and update are relayed to a webhook.
+func (s *_piiSuite) TestSealedCopies() {
+	host := os.Getenv(testDBHost)
+	if host == "" {
+		s.T().Skip(testDBHost + " is not set")
+	}
+
+	// the migrations are found from the root of the module
+	wd, err := os.Getwd()
+	s.Require().Nil(err)
+	s.Require().Nil(os.Chdir(".."))
+	defer os.Chdir(wd)
+
+	s.UI.Connect(host, 5432)
+	defer s.UI.Disconnect()
+	ui.AuditHdl = s.AuditHdl
+
+	db := s.UI.DB()
+	var hook struct{ ID int64 }
+	s.Require().Nil(db.Raw("INSERT INTO webhooks (url, secret) VALUES ('http://127.0.0.1:1/', 'secret') RETURNING id").Scan(&hook).Error)
+	defer db.Exec("DELETE FROM webhooks WHERE id = ?", hook.ID)
+
+	acct := "sealed_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	defer db.Exec("DELETE FROM users WHERE acct = ?", acct)
+	s.Equal(http.StatusOK, s.signUp(acct, "Plaintext Signup"))
+	s.Equal(http.StatusOK, s.update(acct, "Plaintext Update"))
+
+	relay := &outbox.Relay{PG: &s.UI.PG, Sink: &webhook.Fanout{PG: &s.UI.PG}}
+	for {
+		n, err := relay.RelayOnce(context.Background())
+		s.Require().Nil(err)
+		if n == 0 {
+			break
+		}
+	}
+
+	for table, column := range map[string]string{
+		pg.TableUsers.String():             pg.FieldUserFullname.String(),
+		pg.TableUserHistory.String():       pg.FieldUserFullname.String(),
+		pg.TableAuditEvents.String():       pg.FieldAuditDiff.String(),
+		pg.TableOutbox.String():            pg.FieldOutboxPayload.String(),
+		pg.TableWebhookDeliveries.String(): pg.FieldDeliveryPayload.String(),
+	} {
+		var found struct{ Count int }
+		s.Nil(db.Raw("SELECT count(*) AS count FROM " + table + " WHERE " + column + "::text LIKE '%Plaintext%'").Scan(&found).Error)
+		s.Equal(0, found.Count, table)
+	}
+
+	// the user is there all the same
+	var delivered struct{ Count int }
+	s.Nil(db.Raw("SELECT count(*) AS count FROM webhook_deliveries WHERE webhook_id = ? AND payload::text LIKE ?", hook.ID, "%"+acct+"%").Scan(&delivered).Error)
+	s.Equal(2, delivered.Count)
+}
+
+func TestRunPII(t *testing.T) {
+	suite.Run(t, new(_piiSuite))
+}
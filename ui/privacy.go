@@ -0,0 +1,371 @@
+package ui
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/dontang97/ui/audit"
+	"github.com/dontang97/ui/outbox"
+	"github.com/dontang97/ui/pg"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// pseudonymPrefix starts the pseudonyms of erased accounts, which fit the
+// account columns but never match a valid account of a signup.
+const pseudonymPrefix = "erased_"
+
+type ExportUserHandlerFunc func(context.Context, *UI, string) (*UserExport, error)
+
+// EraseUserHandlerFunc erases an account of the tenant and returns its
+// pseudonym, or "" when there is no such account.
+type EraseUserHandlerFunc func(context.Context, *UI, string) (string, error)
+
+// UserExport is everything the service holds about an account, answering a
+// subject access request. The profile is the view of the account itself,
+// without its password.
+type UserExport struct {
+	ExportedAt time.Time    `json:"exported_at"`
+	Tenant     string       `json:"tenant"`
+	Account    string       `json:"account"`
+	Profile    UserSelfView `json:"profile"`
+
+	// History are the versions of the profile, newest first.
+	History []pg.UserVersion `json:"history"`
+
+	// Aliases are the former names of the account, whose audit events are
+	// among AuditEvents.
+	Aliases []pg.AccountAlias `json:"aliases"`
+
+	// Groups are the groups the account is directly in.
+	Groups []string `json:"groups"`
+
+	// Sessions are the JWTs issued to the account. JWTs are not stored, so
+	// the successful logins of the audit log are all there is of them.
+	Sessions []ExportSession `json:"sessions"`
+
+	// AuditEvents are the events the account is the actor or target of.
+	// Those of its acts on other accounts keep neither the target nor the
+	// diff, which are the data of those.
+	AuditEvents []pg.AuditEvent `json:"audit_events"`
+
+	// Events are the lifecycle events of the account in the outbox, and
+	// Deliveries the copies of them sent to webhooks.
+	Events     []pg.OutboxMessage   `json:"events"`
+	Deliveries []pg.WebhookDelivery `json:"deliveries"`
+}
+
+type ExportSession struct {
+	IssuedAt  time.Time `json:"issued_at"`
+	SourceIP  string    `json:"source_ip"`
+	RequestID string    `json:"request_id"`
+}
+
+// newPseudonym returns a random pseudonym. Nothing links it back to the
+// account it replaces.
+func newPseudonym() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return pseudonymPrefix + hex.EncodeToString(b), nil
+}
+
+/////////////////////////////////////////////////////////////////////
+//////    GET /ui/v1/user/{acct:[A-Za-z0-9_]{8,20}}}/export    //////
+/////////////////////////////////////////////////////////////////////
+
+var ExportUserHdl ExportUserHandlerFunc = func(ctx context.Context, ui *UI, acct string) (*UserExport, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.QueryTimeout)
+	defer cancel()
+
+	tenant := Tenant(ctx)
+	db := ui.readDB(ctx, acct)
+
+	rows, err := usersOf(ctx, db).
+		Select(profileFields).
+		Where(pg.FieldUserAcct.String()+" = ?", acct).
+		Limit(1).
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	users, err := scanUsers(ui, rows)
+	if err != nil || len(users) == 0 {
+		return nil, err
+	}
+
+	export := &UserExport{Tenant: tenant, Account: acct, Profile: selfView(&users[0])}
+
+	export.History = []pg.UserVersion{}
+	if res := db.
+		Table(pg.TableUserHistory.String()).
+		Where(pg.FieldVersionTenant.String()+" = ? AND "+pg.FieldVersionAcct.String()+" = ?", tenant, acct).
+		Order(pg.FieldVersionID.String() + " DESC").
+		Find(&export.History); res.Error != nil {
+		return nil, res.Error
+	}
+	for i := range export.History {
+		if export.History[i].Fullname, err = ui.openFullname(export.History[i].Fullname); err != nil {
+			return nil, err
+		}
+	}
+
+	if export.Groups, err = scanStrings(db, `
+		SELECT g.`+pg.FieldGroupName.String()+`
+		FROM `+pg.TableGroupMembers.String()+` m
+		JOIN `+pg.TableGroups.String()+` g ON g.`+pg.FieldGroupID.String()+` = m.`+pg.FieldMemberGroupID.String()+`
+		WHERE m.`+pg.FieldMemberTenant.String()+` = ? AND m.`+pg.FieldMemberAcct.String()+` = ?
+		ORDER BY g.`+pg.FieldGroupName.String(), tenant, acct); err != nil {
+		return nil, err
+	}
+
+	export.Aliases = []pg.AccountAlias{}
+	if res := db.
+		Table(pg.TableAccountAliases.String()).
+		Where(pg.FieldAliasTenant.String()+" = ? AND "+pg.FieldAliasAcct.String()+" = ?", tenant, acct).
+		Order(pg.FieldAliasID.String()).
+		Find(&export.Aliases); res.Error != nil {
+		return nil, res.Error
+	}
+	names := []string{TenantAccount(tenant, acct)}
+	for _, alias := range export.Aliases {
+		names = append(names, TenantAccount(tenant, alias.Alias))
+	}
+
+	export.AuditEvents = []pg.AuditEvent{}
+	if res := db.
+		Table(pg.TableAuditEvents.String()).
+		Where(pg.FieldAuditActor.String()+" IN (?) OR "+pg.FieldAuditTarget.String()+" IN (?)", names, names).
+		Order(pg.FieldAuditID.String()).
+		Find(&export.AuditEvents); res.Error != nil {
+		return nil, res.Error
+	}
+
+	export.Events = []pg.OutboxMessage{}
+	if res := db.
+		Table(pg.TableOutbox.String()).
+		Where(pg.FieldOutboxTenant.String()+" = ? AND "+pg.FieldOutboxAccount.String()+" = ?", tenant, acct).
+		Order(pg.FieldOutboxID.String()).
+		Find(&export.Events); res.Error != nil {
+		return nil, res.Error
+	}
+
+	ids := []int64{}
+	for _, msg := range export.Events {
+		ids = append(ids, msg.ID)
+	}
+	export.Deliveries = []pg.WebhookDelivery{}
+	if len(ids) > 0 {
+		if res := db.
+			Table(pg.TableWebhookDeliveries.String()).
+			Where(pg.FieldDeliveryOutboxID.String()+" IN (?)", ids).
+			Order(pg.FieldDeliveryID.String()).
+			Find(&export.Deliveries); res.Error != nil {
+			return nil, res.Error
+		}
+	}
+
+	return export, nil
+}
+
+func (ui *UI) ExportUser(w http.ResponseWriter, r *http.Request) {
+	aw := ui.startAudit(w, r, AuditActionExport)
+	defer aw.finish()
+	w = aw
+
+	vars := mux.Vars(r)
+	acct := vars[pg.FieldUserAcct.String()]
+	aw.event.Target = acct
+
+	export, err := ExportUserHdl(r.Context(), ui, acct)
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+	if export == nil {
+		WriteJsonResponse(StatusNotFound, map[string]string{"user": acct}, w)
+		return
+	}
+
+	export.ExportedAt = time.Now().UTC()
+	export.Sessions = []ExportSession{}
+	names := map[string]bool{TenantAccount(export.Tenant, acct): true}
+	for _, alias := range export.Aliases {
+		names[TenantAccount(export.Tenant, alias.Alias)] = true
+	}
+	for i := range export.AuditEvents {
+		ev := &export.AuditEvents[i]
+		if !names[ev.Target] {
+			if ev.Target != "" {
+				ev.Target = redacted
+			}
+			ev.Diff = nil
+		}
+		if ev.Action == AuditActionLogin && ev.Outcome == AuditOutcomeSuccess && names[ev.Target] {
+			export.Sessions = append(export.Sessions, ExportSession{
+				IssuedAt:  ev.At,
+				SourceIP:  ev.SourceIP,
+				RequestID: ev.RequestID,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="`+acct+`.json"`)
+	WriteJsonResponse(StatusOK, export, w)
+}
+
+/////////////////////////////////////////////////////////////////////
+//////    POST /ui/v1/user/{acct:[A-Za-z0-9_]{8,20}}}/erase    //////
+/////////////////////////////////////////////////////////////////////
+
+// EraseUserHdl deletes the account and its history, and anonymizes what
+// else refers to it, in one transaction: its audit events get the pseudonym
+// in its place, as by audit.Erase, and its outbox messages and their
+// webhook deliveries the pseudonym instead of its data. A user.erased
+// message tells the downstream systems to erase their copies; it is the one
+// record of the account left, and nothing links it to the pseudonym.
+var EraseUserHdl EraseUserHandlerFunc = func(ctx context.Context, ui *UI, acct string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
+
+	pseudonym, err := newPseudonym()
+	if err != nil {
+		return "", err
+	}
+
+	tenant := Tenant(ctx)
+	alias := TenantAccount(tenant, pseudonym)
+	erased := false
+	err = ui.transaction(ctx, func(tx *gorm.DB) error {
+		before, err := lockUser(ctx, ui, tx, acct, nil)
+		if err != nil || before == nil {
+			return err
+		}
+		erased = true
+
+		// the audit log knows the account by its former names too
+		accts, err := accountNames(ctx, tx, acct)
+		if err != nil {
+			return err
+		}
+		names := make([]string, len(accts))
+		for i, a := range accts {
+			names[i] = TenantAccount(tenant, a)
+		}
+
+		// the memberships go with the account
+		if res := usersOf(ctx, tx).
+			Delete(&pg.User{}, pg.FieldUserAcct.String()+" = ?", acct); res.Error != nil {
+			return res.Error
+		}
+
+		// and so do its versions and aliases
+		if res := tx.
+			Table(pg.TableUserHistory.String()).
+			Where(pg.FieldVersionTenant.String()+" = ? AND "+pg.FieldVersionAcct.String()+" = ?", tenant, acct).
+			Delete(&pg.UserVersion{}); res.Error != nil {
+			return res.Error
+		}
+		if res := tx.
+			Table(pg.TableAccountAliases.String()).
+			Where(pg.FieldAliasTenant.String()+" = ? AND "+pg.FieldAliasAcct.String()+" = ?", tenant, acct).
+			Delete(&pg.AccountAlias{}); res.Error != nil {
+			return res.Error
+		}
+
+		if res := tx.Exec("SELECT set_config('ui.audit_erasure', 'on', true)"); res.Error != nil {
+			return res.Error
+		}
+		events := []pg.AuditEvent{}
+		if res := tx.
+			Table(pg.TableAuditEvents.String()).
+			Where(pg.FieldAuditActor.String()+" IN (?) OR "+pg.FieldAuditTarget.String()+" IN (?)", names, names).
+			Find(&events); res.Error != nil {
+			return res.Error
+		}
+		for i := range events {
+			ev := &events[i]
+			for _, name := range names {
+				if _, err := audit.Erase(ev, name, alias); err != nil {
+					return err
+				}
+			}
+			if res := tx.
+				Table(pg.TableAuditEvents.String()).
+				Where(pg.FieldAuditID.String()+" = ?", ev.ID).
+				Updates(map[string]interface{}{
+					pg.FieldAuditActor.String():    ev.Actor,
+					pg.FieldAuditTarget.String():   ev.Target,
+					pg.FieldAuditSourceIP.String(): ev.SourceIP,
+					pg.FieldAuditDiff.String():     ev.Diff,
+					pg.FieldAuditErased.String():   ev.Erased,
+				}); res.Error != nil {
+				return res.Error
+			}
+		}
+
+		if res := tx.Exec(`
+			UPDATE `+pg.TableWebhookDeliveries.String()+`
+			SET `+pg.FieldDeliveryPayload.String()+` = `+pg.FieldDeliveryPayload.String()+` ||
+				jsonb_build_object('account', ?::text, 'data', jsonb_build_object('account', ?::text))
+			WHERE `+pg.FieldDeliveryOutboxID.String()+` IN (
+				SELECT `+pg.FieldOutboxID.String()+` FROM `+pg.TableOutbox.String()+`
+				WHERE `+pg.FieldOutboxTenant.String()+` = ? AND `+pg.FieldOutboxAccount.String()+` = ?)`,
+			pseudonym, pseudonym, tenant, acct); res.Error != nil {
+			return res.Error
+		}
+		if res := tx.Exec(`
+			UPDATE `+pg.TableOutbox.String()+`
+			SET `+pg.FieldOutboxAccount.String()+` = ?, `+pg.FieldOutboxPayload.String()+` = jsonb_build_object('account', ?::text)
+			WHERE `+pg.FieldOutboxTenant.String()+` = ? AND `+pg.FieldOutboxAccount.String()+` = ?`,
+			pseudonym, pseudonym, tenant, acct); res.Error != nil {
+			return res.Error
+		}
+
+		return outbox.Enqueue(tx, outbox.UserErased, tenant, acct, userEvent{Acct: acct})
+	})
+	if err != nil || !erased {
+		return "", err
+	}
+
+	ui.markWritten(ctx, acct)
+	return pseudonym, nil
+}
+
+func (ui *UI) EraseUser(w http.ResponseWriter, r *http.Request) {
+	aw := ui.startAudit(w, r, AuditActionErase)
+	defer aw.finish()
+	w = aw
+
+	vars := mux.Vars(r)
+	acct := vars[pg.FieldUserAcct.String()]
+	aw.event.Target = acct
+
+	if ui.outranks(r.Context(), Tenant(r.Context()), acct) {
+		writeOutranked(acct, w)
+		return
+	}
+
+	pseudonym, err := EraseUserHdl(r.Context(), ui, acct)
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+	if pseudonym == "" {
+		WriteJsonResponse(StatusNotFound, map[string]string{"user": acct}, w)
+		return
+	}
+
+	// the event of the erasure names the account by its pseudonym too,
+	// and claims the erased events of the audit log
+	aw.event.Target = pseudonym
+	if aw.event.Actor == TenantAccount(aw.tenant, acct) {
+		aw.event.Actor = TenantAccount(aw.tenant, pseudonym)
+	}
+
+	WriteJsonResponse(StatusOK, map[string]string{"user": acct}, w)
+}
@@ -0,0 +1,161 @@
+package ui_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/secret"
+	"github.com/dontang97/ui/ui"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/suite"
+)
+
+type _privacySuite struct {
+	suite.Suite
+	UI *ui.UI
+
+	ExportUserHdl ui.ExportUserHandlerFunc
+	EraseUserHdl  ui.EraseUserHandlerFunc
+	AuditHdl      ui.AuditHandlerFunc
+
+	events []pg.AuditEvent
+}
+
+func (s *_privacySuite) SetupSuite() {
+	s.UI = ui.New()
+}
+
+func (s *_privacySuite) TearDownSuite() {
+}
+
+func (s *_privacySuite) SetupTest() {
+	s.events = nil
+	s.ExportUserHdl, ui.ExportUserHdl = ui.ExportUserHdl, nil
+	s.EraseUserHdl, ui.EraseUserHdl = ui.EraseUserHdl, nil
+	s.AuditHdl, ui.AuditHdl = ui.AuditHdl, func(_ context.Context, _ *ui.UI, ev *pg.AuditEvent) error {
+		s.events = append(s.events, *ev)
+		return nil
+	}
+}
+
+func (s *_privacySuite) TearDownTest() {
+	ui.ExportUserHdl, s.ExportUserHdl = s.ExportUserHdl, nil
+	ui.EraseUserHdl, s.EraseUserHdl = s.EraseUserHdl, nil
+	ui.AuditHdl, s.AuditHdl = s.AuditHdl, nil
+}
+
+// serve calls hdl on acct as the bearer of claims.
+func (s *_privacySuite) serve(hdl http.HandlerFunc, method, acct string, claims *secret.UserClaims) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "http://test.com/ui/v1/user/"+acct, nil)
+	req = mux.SetURLVars(req, map[string]string{pg.FieldUserAcct.String(): acct})
+	req = req.WithContext(secret.NewContext(req.Context(), claims))
+	rcd := httptest.NewRecorder()
+	hdl.ServeHTTP(rcd, req)
+	return rcd
+}
+
+func (s *_privacySuite) TestExportUser() {
+	at := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	ui.ExportUserHdl = func(ctx context.Context, _ *ui.UI, acct string) (*ui.UserExport, error) {
+		if acct != "kobe_bryant" {
+			return nil, nil
+		}
+		return &ui.UserExport{
+			Tenant:  ui.Tenant(ctx),
+			Account: acct,
+			Profile: ui.UserSelfView{Acct: acct, Fullname: "Kobe Bryant"},
+			Groups:  []string{"lakers"},
+			AuditEvents: []pg.AuditEvent{
+				{ID: 1, At: at, Target: acct, Action: ui.AuditActionSignUp, Outcome: ui.AuditOutcomeSuccess,
+					Diff: pg.JSONB(`{"password":{"before":null,"after":"123456789"}}`)},
+				{ID: 2, At: at, Target: acct, Action: ui.AuditActionLogin, Outcome: ui.AuditOutcomeFailure},
+				{ID: 3, At: at, Target: acct, Action: ui.AuditActionLogin, Outcome: ui.AuditOutcomeSuccess, SourceIP: "10.0.0.1"},
+				// an act of the account on another
+				{ID: 4, At: at, Actor: acct, Target: "lebron_james", Action: ui.AuditActionUpdate, Outcome: ui.AuditOutcomeSuccess,
+					Diff: pg.JSONB(`{"fullname":{"before":"LeBron","after":"King James"}}`)},
+			},
+			Events:     []pg.OutboxMessage{{ID: 1, Account: acct, Type: "user.created"}},
+			Deliveries: []pg.WebhookDelivery{{ID: 1, OutboxID: 1}},
+		}, nil
+	}
+
+	rcd := s.serve(s.UI.ExportUser, http.MethodGet, "kobe_bryant", &secret.UserClaims{Acct: "kobe_bryant"})
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Contains(rcd.Header().Get("Content-Disposition"), "kobe_bryant.json")
+
+	resp := struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}{}
+	s.Equal(nil, json.Unmarshal(rcd.Body.Bytes(), &resp))
+	for _, key := range []string{"exported_at", "profile", "history", "groups", "sessions", "audit_events", "events", "deliveries"} {
+		s.Contains(resp.Data, key)
+	}
+
+	// no password is exported, not even one an event kept, and the
+	// sessions are the successful logins
+	profile := map[string]interface{}{}
+	s.Equal(nil, json.Unmarshal(resp.Data["profile"], &profile))
+	s.NotContains(profile, "password")
+	s.Equal("Kobe Bryant", profile["fullname"])
+	s.NotContains(rcd.Body.String(), "123456789")
+	s.Contains(string(resp.Data["audit_events"]), `"after": "[REDACTED]"`)
+
+	// nor the data of the accounts it acted on
+	s.NotContains(rcd.Body.String(), "lebron_james")
+	s.NotContains(rcd.Body.String(), "King James")
+	events := []pg.AuditEvent{}
+	s.Equal(nil, json.Unmarshal(resp.Data["audit_events"], &events))
+	s.Equal("[REDACTED]", events[3].Target)
+	s.Equal(ui.AuditActionUpdate, events[3].Action)
+	s.Equal("null", string(events[3].Diff))
+
+	sessions := []ui.ExportSession{}
+	s.Equal(nil, json.Unmarshal(resp.Data["sessions"], &sessions))
+	s.Equal([]ui.ExportSession{{IssuedAt: at, SourceIP: "10.0.0.1"}}, sessions)
+
+	// the access to the data is audited
+	s.Equal(1, len(s.events))
+	s.Equal(ui.AuditActionExport, s.events[0].Action)
+	s.Equal("kobe_bryant", s.events[0].Target)
+
+	rcd = s.serve(s.UI.ExportUser, http.MethodGet, "lebron_james", &secret.UserClaims{Acct: "kobe_bryant", Roles: []string{secret.RoleAdmin}})
+	s.Equal(http.StatusNotFound, rcd.Code)
+}
+
+func (s *_privacySuite) TestEraseUser() {
+	ui.EraseUserHdl = func(_ context.Context, _ *ui.UI, acct string) (string, error) {
+		if acct != "kobe_bryant" {
+			return "", nil
+		}
+		return "erased_0123456789ab", nil
+	}
+
+	// the event of a self erasure names neither actor nor target
+	rcd := s.serve(s.UI.EraseUser, http.MethodPost, "kobe_bryant", &secret.UserClaims{Acct: "kobe_bryant", Tenant: "lakers"})
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal(1, len(s.events))
+	s.Equal(ui.AuditActionErase, s.events[0].Action)
+	s.Equal("lakers/erased_0123456789ab", s.events[0].Actor)
+	s.Equal("lakers/erased_0123456789ab", s.events[0].Target)
+
+	s.events = nil
+	rcd = s.serve(s.UI.EraseUser, http.MethodPost, "kobe_bryant", &secret.UserClaims{Acct: "jerry_buss", Roles: []string{secret.RoleAdmin}})
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal("jerry_buss", s.events[0].Actor)
+	s.Equal("erased_0123456789ab", s.events[0].Target)
+
+	s.events = nil
+	rcd = s.serve(s.UI.EraseUser, http.MethodPost, "lebron_james", &secret.UserClaims{Acct: "lebron_james"})
+	s.Equal(http.StatusNotFound, rcd.Code)
+	s.Equal("lebron_james", s.events[0].Target)
+	s.Equal(ui.AuditOutcomeFailure, s.events[0].Outcome)
+}
+
+func TestRunPrivacy(t *testing.T) {
+	suite.Run(t, new(_privacySuite))
+}
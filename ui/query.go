@@ -0,0 +1,190 @@
+package ui
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/schema"
+)
+
+const (
+	DefaultUsersLimit = 100
+	MaxUsersLimit     = 1000
+)
+
+// UserCursor marks the last user of a page: the value of the sort field and
+// the account, which breaks ties between equal sort values.
+type UserCursor struct {
+	Value string `json:"v"`
+	Acct  string `json:"a"`
+}
+
+func (c *UserCursor) Encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeUserCursor(s string) (*UserCursor, error) {
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &UserCursor{}
+	if err := json.Unmarshal(js, c); err != nil {
+		return nil, err
+	}
+	if c.Acct == "" {
+		return nil, errors.New("cursor without account")
+	}
+	return c, nil
+}
+
+// UserListQuery is the parsed query string of GET /ui/v1/users.
+type UserListQuery struct {
+	Limit int
+	After *UserCursor
+
+	// Sort is one of acct, created_at or updated_at.
+	Sort pg.Field
+	Desc bool
+
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+	Prefix        string
+
+	// Attributes is the object the attributes of the users must contain.
+	Attributes map[string]interface{}
+
+	// Count asks for the total number of users matching the filters and
+	// Summary for full user summaries instead of bare accounts.
+	Count   bool
+	Summary bool
+}
+
+// queryError names the query parameter that failed to parse.
+type queryError struct {
+	field string
+	value string
+}
+
+func (err *queryError) Error() string {
+	return "invalid query parameter " + err.field
+}
+
+var sortableUserFields = map[string]pg.Field{
+	pg.FieldUserAcct.String():      pg.FieldUserAcct,
+	pg.FieldUserCreatedAt.String(): pg.FieldUserCreatedAt,
+	pg.FieldUserUpdatedAt.String(): pg.FieldUserUpdatedAt,
+}
+
+func parseUserListQuery(values url.Values, sch *schema.Schema) (*UserListQuery, error) {
+	q := &UserListQuery{
+		Limit: DefaultUsersLimit,
+		Sort:  pg.FieldUserAcct,
+	}
+
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > MaxUsersLimit {
+			return nil, &queryError{"limit", v}
+		}
+		q.Limit = n
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		c, err := decodeUserCursor(v)
+		if err != nil {
+			return nil, &queryError{"cursor", v}
+		}
+		q.After = c
+	}
+
+	if v := values.Get("sort"); v != "" {
+		field, ok := sortableUserFields[strings.TrimPrefix(v, "-")]
+		if !ok {
+			return nil, &queryError{"sort", v}
+		}
+		q.Sort = field
+		q.Desc = strings.HasPrefix(v, "-")
+	}
+
+	for name, t := range map[string]*time.Time{
+		"created_after":  &q.CreatedAfter,
+		"created_before": &q.CreatedBefore,
+		"updated_after":  &q.UpdatedAfter,
+		"updated_before": &q.UpdatedBefore,
+	} {
+		v := values.Get(name)
+		if v == "" {
+			continue
+		}
+		var err error
+		if *t, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return nil, &queryError{name, v}
+		}
+	}
+
+	// the cursor of a time ordered page carries a timestamp
+	if q.After != nil && q.Sort != pg.FieldUserAcct {
+		if _, err := time.Parse(time.RFC3339Nano, q.After.Value); err != nil {
+			return nil, &queryError{"cursor", values.Get("cursor")}
+		}
+	}
+
+	q.Prefix = values.Get("prefix")
+	if q.Prefix != "" && !validAcctPrefix.MatchString(q.Prefix) {
+		return nil, &queryError{"prefix", q.Prefix}
+	}
+
+	var err error
+	if q.Attributes, err = parseAttributeFilters(values, sch); err != nil {
+		return nil, err
+	}
+
+	if v := values.Get("count"); v != "" {
+		if q.Count, err = strconv.ParseBool(v); err != nil {
+			return nil, &queryError{"count", v}
+		}
+	}
+
+	switch v := values.Get("view"); v {
+	case "", "account":
+	case "summary":
+		q.Summary = true
+	default:
+		return nil, &queryError{"view", v}
+	}
+
+	return q, nil
+}
+
+// cursorOf returns the cursor pointing right after user in the order of q.
+func (q *UserListQuery) cursorOf(user *pg.User) *UserCursor {
+	c := &UserCursor{Acct: user.Acct}
+	switch q.Sort {
+	case pg.FieldUserCreatedAt:
+		c.Value = user.Created_at.Format(time.RFC3339Nano)
+	case pg.FieldUserUpdatedAt:
+		c.Value = user.Updated_at.Format(time.RFC3339Nano)
+	default:
+		c.Value = user.Acct
+	}
+	return c
+}
+
+// UserSummary is the view=summary entry of GET /ui/v1/users.
+type UserSummary struct {
+	Acct       string    `json:"account"`
+	Fullname   string    `json:"fullname"`
+	Created_at time.Time `json:"created_at"`
+	Updated_at time.Time `json:"updated_at"`
+}
@@ -0,0 +1,130 @@
+package ui
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// redacted stands in for the values never shown.
+const redacted = "[REDACTED]"
+
+// credentialFields are the keys of credential material, by their lowercase
+// form. Their values are masked in audit diffs and in every response.
+var credentialFields = map[string]bool{
+	"password": true,
+	"pwd":      true,
+}
+
+// credentialKey finds a key of credentialFields in a JSON document.
+var credentialKey = regexp.MustCompile(`(?i)"(password|pwd)"\s*:`)
+
+// logSecrets find the credentials and tokens of a log line: the string
+// values of their JSON keys, password= pairs as in a connection string, and
+// bearer tokens. The first group of each is kept, and the second, if any.
+var logSecrets = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)("(?:password|pwd|jwt|token|secret|authorization)"\s*:\s*")(?:[^"\\]|\\.)*(")`),
+	regexp.MustCompile(`(?i)\b((?:password|pwd)=)(?:'[^']*'|[^\s&]+)`),
+	regexp.MustCompile(`(?i)\b(bearer\s+)[A-Za-z0-9\-_.~+/]+=*`),
+}
+
+// RedactJSON returns js, a JSON document, with the values of the keys of
+// fields masked at any depth: their scalars, those of objects and arrays
+// too, but null. The keys keep their order, and the result is compact.
+func RedactJSON(js []byte, fields map[string]bool) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	var buf bytes.Buffer
+	if err := redactValue(dec, &buf, fields, false); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// redactValue copies the value dec is at to buf, every scalar of it masked
+// when mask.
+func redactValue(dec *json.Decoder, buf *bytes.Buffer, fields map[string]bool, mask bool) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		buf.WriteByte('{')
+		for i := 0; dec.More(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			writeToken(buf, key)
+			buf.WriteByte(':')
+			if err := redactValue(dec, buf, fields, mask || fields[strings.ToLower(key.(string))]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case json.Delim('['):
+		buf.WriteByte('[')
+		for i := 0; dec.More(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := redactValue(dec, buf, fields, mask); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		if mask && tok != nil {
+			tok = redacted
+		}
+		writeToken(buf, tok)
+		return nil
+	}
+
+	// the closing delimiter
+	_, err = dec.Token()
+	return err
+}
+
+// writeToken writes a scalar token, or key, as JSON.
+func writeToken(buf *bytes.Buffer, tok json.Token) {
+	if n, ok := tok.(json.Number); ok {
+		buf.WriteString(n.String())
+		return
+	}
+	js, _ := json.Marshal(tok)
+	buf.Write(js)
+}
+
+// RedactText returns s with the credentials and tokens in it masked.
+func RedactText(s string) string {
+	for _, re := range logSecrets {
+		s = re.ReplaceAllString(s, "${1}"+redacted+"${2}")
+	}
+	return s
+}
+
+type redactWriter struct {
+	w io.Writer
+}
+
+// RedactWriter returns a writer that masks the credentials and tokens of
+// what it writes to w, as log output, one entry per write.
+func RedactWriter(w io.Writer) io.Writer {
+	return &redactWriter{w: w}
+}
+
+func (rw *redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, RedactText(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
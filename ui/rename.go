@@ -0,0 +1,300 @@
+package ui
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dontang97/ui/outbox"
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/secret"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+// DefaultAliasGracePeriod is how long the former name of a renamed account
+// stays reserved for it by default.
+const DefaultAliasGracePeriod = time.Hour * 24 * 30
+
+// ErrAccountTaken is returned for a rename to the reserved former name of
+// another account.
+var ErrAccountTaken = errors.New("the account is the alias of another one")
+
+// RenameUserHandlerFunc renames an account of the tenant and returns the
+// alias its former name became, or nil when there is no such account.
+type RenameUserHandlerFunc func(ctx context.Context, ui *UI, acct, to string, pre *Precondition) (*pg.AccountAlias, error)
+
+// ResolveAliasHandlerFunc returns the account a reserved alias of the
+// tenant resolves to, or "" when it is none.
+type ResolveAliasHandlerFunc func(ctx context.Context, ui *UI, alias string) (string, error)
+
+// aliasGracePeriod is AliasGracePeriod, but never shorter than a JWT lives:
+// a JWT of the former name must not outlive its reservation, or it would
+// act as whoever takes the name next.
+func (ui *UI) aliasGracePeriod() time.Duration {
+	if ui.AliasGracePeriod < secret.ValidDuration {
+		return secret.ValidDuration
+	}
+	return ui.AliasGracePeriod
+}
+
+// aliasTaken reports whether acct is reserved as the alias of an account
+// other than owner, "" for any.
+func aliasTaken(ctx context.Context, tx *gorm.DB, acct, owner string) (bool, error) {
+	var n int
+	res := tx.
+		Table(pg.TableAccountAliases.String()).
+		Where(pg.FieldAliasTenant.String()+" = ? AND "+pg.FieldAliasAlias.String()+" = ?", Tenant(ctx), acct).
+		Where(pg.FieldAliasAcct.String()+" <> ?", owner).
+		Where(pg.FieldAliasExpiresAt.String()+" > ?", time.Now().UTC()).
+		Count(&n)
+	return n > 0, res.Error
+}
+
+// accountNames returns acct and every former name of it, reserved or not,
+// as the names the audit log may know the account by.
+func accountNames(ctx context.Context, db *gorm.DB, acct string) ([]string, error) {
+	aliases := []pg.AccountAlias{}
+	if res := db.
+		Table(pg.TableAccountAliases.String()).
+		Where(pg.FieldAliasTenant.String()+" = ? AND "+pg.FieldAliasAcct.String()+" = ?", Tenant(ctx), acct).
+		Order(pg.FieldAliasID.String()).
+		Find(&aliases); res.Error != nil {
+		return nil, res.Error
+	}
+
+	names := []string{acct}
+	for _, alias := range aliases {
+		names = append(names, alias.Alias)
+	}
+	return names, nil
+}
+
+////////////////////////////////////////////////////////////////////
+//////    POST /ui/v1/user/{acct:[A-Za-z0-9_]{8,20}}}/rename    //////
+////////////////////////////////////////////////////////////////////
+
+// RenameHdl renames the account in one transaction: its memberships, by
+// cascade, its history and its outbox messages move along, its aliases
+// resolve to the new name, and its former name becomes one of them for
+// aliasGracePeriod. The audit log keeps the former name, which the aliases
+// tie to the account. Renaming back to a reserved alias releases it.
+var RenameHdl RenameUserHandlerFunc = func(ctx context.Context, ui *UI, acct, to string, pre *Precondition) (*pg.AccountAlias, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
+
+	tenant := Tenant(ctx)
+	var alias *pg.AccountAlias
+	err := ui.transaction(ctx, func(tx *gorm.DB) error {
+		before, err := lockUser(ctx, ui, tx, acct, pre)
+		if err != nil || before == nil {
+			return err
+		}
+		if err := checkAccount(ctx, tx, to, acct); err != nil {
+			return err
+		}
+
+		if res := usersOf(ctx, tx).
+			Where(pg.FieldUserAcct.String()+" = ?", acct).
+			Update(pg.FieldUserAcct.String(), to); res.Error != nil {
+			return res.Error
+		}
+
+		// checked after the update, which waits for a rename away from to
+		// under way, so its alias is seen
+		if taken, err := aliasTaken(ctx, tx, to, acct); err != nil || taken {
+			if taken {
+				err = ErrAccountTaken
+			}
+			return err
+		}
+
+		now := time.Now().UTC()
+		aliases := tx.Table(pg.TableAccountAliases.String()).
+			Where(pg.FieldAliasTenant.String()+" = ? AND "+pg.FieldAliasAcct.String()+" = ?", tenant, acct)
+		if res := aliases.
+			Where(pg.FieldAliasAlias.String()+" = ? AND "+pg.FieldAliasExpiresAt.String()+" > ?", to, now).
+			Update(pg.FieldAliasExpiresAt.String(), now); res.Error != nil {
+			return res.Error
+		}
+		if res := aliases.Update(pg.FieldAliasAcct.String(), to); res.Error != nil {
+			return res.Error
+		}
+		alias = &pg.AccountAlias{Tenant: tenant, Alias: acct, Acct: to, RenamedAt: now, ExpiresAt: now.Add(ui.aliasGracePeriod())}
+		if res := tx.Table(pg.TableAccountAliases.String()).Create(alias); res.Error != nil {
+			return res.Error
+		}
+
+		if res := tx.
+			Table(pg.TableUserHistory.String()).
+			Where(pg.FieldVersionTenant.String()+" = ? AND "+pg.FieldVersionAcct.String()+" = ?", tenant, acct).
+			Update(pg.FieldVersionAcct.String(), to); res.Error != nil {
+			return res.Error
+		}
+		if res := tx.
+			Table(pg.TableOutbox.String()).
+			Where(pg.FieldOutboxTenant.String()+" = ? AND "+pg.FieldOutboxAccount.String()+" = ?", tenant, acct).
+			Update(pg.FieldOutboxAccount.String(), to); res.Error != nil {
+			return res.Error
+		}
+
+		return outbox.Enqueue(tx, outbox.UserRenamed, tenant, to, userEvent{Acct: to, Previous: acct})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if alias != nil {
+		ui.markWritten(ctx, acct, to)
+	}
+	return alias, nil
+}
+
+// RenameUser renames the account of the path to the account of the body,
+// which the account name policy applies to as to a signup. The accounts
+// the operator names in Admins or SuperAdmins are bound to their names, and
+// no account is renamed to one of those. A caller renaming
+// itself gets a JWT of its new name, as its own names the former one.
+func (ui *UI) RenameUser(w http.ResponseWriter, r *http.Request) {
+	aw := ui.startAudit(w, r, AuditActionRename)
+	defer aw.finish()
+	w = aw
+
+	acct := mux.Vars(r)[pg.FieldUserAcct.String()]
+	aw.event.Target = acct
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Print(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	jsmap := map[string]interface{}{}
+	if err := json.Unmarshal(body, &jsmap); err != nil {
+		WriteJsonResponse(StatusInvalidContent, map[string]string{"error": err.Error()}, w)
+		return
+	}
+
+	to, ok := jsmap["account"].(string)
+	if !ok {
+		WriteJsonResponse(StatusInvalidContent, map[string]string{"missing_field": "account"}, w)
+		return
+	}
+
+	tenant := Tenant(r.Context())
+	pinned := func(acct string) bool {
+		return ui.roleAccount(tenant, acct)
+	}
+	if !validAcctPwd.MatchString(to) || to == acct || pinned(to) {
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": "account", "value": to}}, w)
+		return
+	}
+	if pinned(acct) {
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": "user", "value": acct}}, w)
+		return
+	}
+	if ui.reservedAccount(to) {
+		writeAccountNotAllowed(to, &AccountPolicyError{Reason: AccountReserved}, w)
+		return
+	}
+
+	pre, ok := ui.precondition(w, r)
+	if !ok {
+		return
+	}
+
+	alias, err := RenameHdl(r.Context(), ui, acct, to, pre)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); (ok && pqErr.Code == pq.ErrorCode("23505")) || errors.Is(err, ErrAccountTaken) {
+			WriteJsonResponse(StatusUserExisted, map[string]string{"user": to}, w)
+			return
+		}
+		if writeAccountNotAllowed(to, err, w) {
+			return
+		}
+		WriteErrorResponse(err, w)
+		return
+	}
+	if alias == nil {
+		WriteJsonResponse(StatusNotFound, map[string]string{"user": acct}, w)
+		return
+	}
+
+	if js, err := json.Marshal(map[string]map[string]interface{}{
+		"account": {"before": acct, "after": to},
+	}); err == nil {
+		aw.event.Diff = js
+	}
+
+	data := map[string]interface{}{
+		"user":             to,
+		"previous":         acct,
+		"alias_expires_at": alias.ExpiresAt,
+	}
+	if claims, ok := secret.FromContext(r.Context()); ok && claims.Acct == acct && ClaimsTenant(claims) == tenant {
+		token, err := ui.issueJWT(r.Context(), to)
+		if err != nil {
+			// the rename stands; a login gets the JWT
+			log.Print(err)
+		} else {
+			data["JWT"] = token
+		}
+	}
+
+	WriteJsonResponse(StatusOK, data, w)
+}
+
+// ResolveAliasHdl resolves the alias on the primary if it was written
+// within the read-your-writes window, so a rename redirects right away.
+var ResolveAliasHdl ResolveAliasHandlerFunc = func(ctx context.Context, ui *UI, alias string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.QueryTimeout)
+	defer cancel()
+
+	var acct string
+	err := ui.readDB(ctx, alias).
+		Table(pg.TableAccountAliases.String()).
+		Select(pg.FieldAliasAcct.String()).
+		Where(pg.FieldAliasTenant.String()+" = ? AND "+pg.FieldAliasAlias.String()+" = ?", Tenant(ctx), alias).
+		Where(pg.FieldAliasExpiresAt.String()+" > ?", time.Now().UTC()).
+		Order(pg.FieldAliasID.String() + " DESC").
+		Limit(1).
+		Row().
+		Scan(&acct)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return acct, err
+}
+
+// ResolveAlias redirects the requests naming an account by a reserved
+// former name to the same path under its current one. The redirect is
+// temporary, as the alias expires and its name may be taken, and keeps
+// the method and body of the request.
+func (ui *UI) ResolveAlias(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acct := mux.Vars(r)[pg.FieldUserAcct.String()]
+		to, err := ResolveAliasHdl(r.Context(), ui, acct)
+		if err != nil {
+			WriteErrorResponse(err, w)
+			return
+		}
+		if to == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		u := *r.URL
+		u.Path = strings.Replace(u.Path, "/user/"+acct, "/user/"+to, 1)
+		u.RawPath = ""
+		http.Redirect(w, r, u.RequestURI(), http.StatusTemporaryRedirect)
+	})
+}
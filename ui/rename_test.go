@@ -0,0 +1,175 @@
+package ui_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/secret"
+	"github.com/dontang97/ui/ui"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/suite"
+)
+
+type _renameSuite struct {
+	suite.Suite
+	UI *ui.UI
+
+	RenameHdl       ui.RenameUserHandlerFunc
+	ResolveAliasHdl ui.ResolveAliasHandlerFunc
+	MemberOfHdl     ui.MemberOfHandlerFunc
+	AuditHdl        ui.AuditHandlerFunc
+
+	renames [][2]string
+	events  []pg.AuditEvent
+}
+
+func (s *_renameSuite) SetupSuite() {
+	secret.InitSecretKey("../secret")
+	s.UI = ui.New()
+	s.UI.Admins[ui.TenantAccount(pg.DefaultTenant, "jerry_buss")] = true
+}
+
+func (s *_renameSuite) TearDownSuite() {
+}
+
+func (s *_renameSuite) SetupTest() {
+	s.renames, s.events = nil, nil
+
+	// kobe_bryant exists, and magic_johnson was lebron_james before
+	s.RenameHdl, ui.RenameHdl = ui.RenameHdl, func(ctx context.Context, _ *ui.UI, acct, to string, _ *ui.Precondition) (*pg.AccountAlias, error) {
+		s.renames = append(s.renames, [2]string{acct, to})
+		switch {
+		case acct != "kobe_bryant":
+			return nil, nil
+		case to == "lebron_james":
+			return nil, ui.ErrAccountTaken
+		case to == "Magic_Johnson":
+			return nil, &ui.AccountPolicyError{Reason: ui.AccountCaseConflict}
+		}
+		now := time.Now().UTC()
+		return &pg.AccountAlias{Tenant: ui.Tenant(ctx), Alias: acct, Acct: to, RenamedAt: now, ExpiresAt: now.Add(ui.DefaultAliasGracePeriod)}, nil
+	}
+	s.ResolveAliasHdl, ui.ResolveAliasHdl = ui.ResolveAliasHdl, func(_ context.Context, _ *ui.UI, alias string) (string, error) {
+		if alias == "lebron_james" {
+			return "magic_johnson", nil
+		}
+		return "", nil
+	}
+	s.MemberOfHdl, ui.MemberOfHdl = ui.MemberOfHdl, func(context.Context, *ui.UI, string) ([]pg.Group, error) {
+		return nil, nil
+	}
+	s.AuditHdl, ui.AuditHdl = ui.AuditHdl, func(_ context.Context, _ *ui.UI, ev *pg.AuditEvent) error {
+		s.events = append(s.events, *ev)
+		return nil
+	}
+}
+
+func (s *_renameSuite) TearDownTest() {
+	ui.RenameHdl, s.RenameHdl = s.RenameHdl, nil
+	ui.ResolveAliasHdl, s.ResolveAliasHdl = s.ResolveAliasHdl, nil
+	ui.MemberOfHdl, s.MemberOfHdl = s.MemberOfHdl, nil
+	ui.AuditHdl, s.AuditHdl = s.AuditHdl, nil
+}
+
+// rename renames acct to the account of body as the bearer of claims.
+func (s *_renameSuite) rename(acct, body string, claims *secret.UserClaims) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req := httptest.NewRequest(http.MethodPost, "http://test.com/ui/v1/user/"+acct+"/rename", bytes.NewBufferString(body))
+	req = mux.SetURLVars(req, map[string]string{pg.FieldUserAcct.String(): acct})
+	req = req.WithContext(secret.NewContext(req.Context(), claims))
+	rcd := httptest.NewRecorder()
+	http.HandlerFunc(s.UI.RenameUser).ServeHTTP(rcd, req)
+
+	resp := struct {
+		Data map[string]interface{} `json:"data"`
+	}{}
+	s.Equal(nil, json.Unmarshal(rcd.Body.Bytes(), &resp))
+	return rcd, resp.Data
+}
+
+func (s *_renameSuite) TestRenameSelf() {
+	rcd, data := s.rename("kobe_bryant", `{"account":"black_mamba"}`, &secret.UserClaims{Acct: "kobe_bryant"})
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal([][2]string{{"kobe_bryant", "black_mamba"}}, s.renames)
+	s.Equal("black_mamba", data["user"])
+	s.Equal("kobe_bryant", data["previous"])
+	s.Contains(data, "alias_expires_at")
+
+	// the JWT of the caller named its former account
+	claims, err := secret.ParseUserJWT(data["JWT"].(string))
+	s.Equal(nil, err)
+	s.Equal("black_mamba", claims.Acct)
+
+	s.Equal(1, len(s.events))
+	s.Equal(ui.AuditActionRename, s.events[0].Action)
+	s.Equal("kobe_bryant", s.events[0].Target)
+	s.JSONEq(`{"account":{"before":"kobe_bryant","after":"black_mamba"}}`, string(s.events[0].Diff))
+}
+
+func (s *_renameSuite) TestRenameByAdmin() {
+	rcd, data := s.rename("kobe_bryant", `{"account":"black_mamba"}`, &secret.UserClaims{Acct: "jerry_buss", Roles: []string{secret.RoleAdmin}})
+	s.Equal(http.StatusOK, rcd.Code)
+	s.NotContains(data, "JWT")
+
+	rcd, _ = s.rename("shaquille_o", `{"account":"big_aristotle"}`, &secret.UserClaims{Acct: "jerry_buss", Roles: []string{secret.RoleAdmin}})
+	s.Equal(http.StatusNotFound, rcd.Code)
+}
+
+func (s *_renameSuite) TestRenameRejected() {
+	claims := &secret.UserClaims{Acct: "kobe_bryant"}
+	for _, c := range []struct {
+		body string
+		code int
+	}{
+		{`{}`, http.StatusBadRequest},
+		{`{"account":"kobe"}`, http.StatusBadRequest},
+		{`{"account":"kobe bryant!!"}`, http.StatusBadRequest},
+		{`{"account":"` + strings.Repeat("kobe_bryant", 20) + `"}`, http.StatusBadRequest},
+		{`{"account":"kobe_bryant"}`, http.StatusBadRequest},
+		// the operator grants roles by name
+		{`{"account":"jerry_buss"}`, http.StatusBadRequest},
+		// reserved by the rename of another account
+		{`{"account":"lebron_james"}`, http.StatusNotAcceptable},
+		// the account name policy
+		{`{"account":"administrator"}`, http.StatusUnprocessableEntity},
+		{`{"account":"Magic_Johnson"}`, http.StatusUnprocessableEntity},
+	} {
+		rcd, _ := s.rename("kobe_bryant", c.body, claims)
+		s.Equal(c.code, rcd.Code, c.body)
+	}
+
+	s.renames = nil
+	rcd, _ := s.rename("jerry_buss", `{"account":"jerry_west"}`, &secret.UserClaims{Acct: "jerry_buss"})
+	s.Equal(http.StatusBadRequest, rcd.Code)
+	s.Nil(s.renames)
+}
+
+func (s *_renameSuite) TestResolveAlias() {
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	serve := func(method, acct, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://test.com"+path, nil)
+		req = mux.SetURLVars(req, map[string]string{pg.FieldUserAcct.String(): acct})
+		rcd := httptest.NewRecorder()
+		s.UI.ResolveAlias(next).ServeHTTP(rcd, req)
+		return rcd
+	}
+
+	rcd := serve(http.MethodGet, "kobe_bryant", "/ui/v1/user/kobe_bryant")
+	s.Equal(http.StatusTeapot, rcd.Code)
+
+	rcd = serve(http.MethodPut, "lebron_james", "/ui/v1/user/lebron_james/history?limit=5")
+	s.Equal(http.StatusTemporaryRedirect, rcd.Code)
+	s.Equal("/ui/v1/user/magic_johnson/history?limit=5", rcd.Header().Get("Location"))
+}
+
+func TestRunRename(t *testing.T) {
+	suite.Run(t, new(_renameSuite))
+}
@@ -0,0 +1,303 @@
+package ui
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dontang97/ui/schema"
+)
+
+// The rules a request body breaks, as FieldError.Rule.
+const (
+	RuleSyntax       = "syntax"
+	RuleTrailingData = "trailing_data"
+	RuleDuplicateKey = "duplicate_key"
+	RuleUnknownField = "unknown_field"
+	RuleRequired     = "required"
+	RuleType         = "type"
+	RulePattern      = "pattern"
+	RuleLength       = "length"
+	RuleCharset      = "charset"
+	RuleSchema       = "schema"
+)
+
+// FieldError is a rule a field of a request body breaks. Field is the
+// top-level key of the body, "" for the body as a whole, and Path the JSON
+// pointer within the field of the value at fault, if nested.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+// InvalidRequest is the data of the response to a request body that breaks
+// rules: every one it breaks, those of the body first, then by field.
+type InvalidRequest struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func writeInvalidRequest(errs []FieldError, w http.ResponseWriter) {
+	WriteJsonResponse(StatusInvalidContent, InvalidRequest{Errors: errs}, w)
+}
+
+// SignUpRequest is the body of POST /ui/v1/signup, and a row of an import.
+type SignUpRequest struct {
+	Tenant     *string     `json:"tenant" validate:"tenant"`
+	Account    string      `json:"account" validate:"required,account"`
+	Password   string      `json:"password" validate:"required,password"`
+	Fullname   string      `json:"fullname" validate:"required,fullname"`
+	Attributes interface{} `json:"attributes"`
+}
+
+// UpdateRequest is the body of PUT /ui/v1/user/{acct}. The fields left out
+// are left as they are; the attributes are replaced as a whole.
+type UpdateRequest struct {
+	Password   *string     `json:"password" validate:"password"`
+	Fullname   *string     `json:"fullname" validate:"fullname"`
+	Attributes interface{} `json:"attributes"`
+}
+
+// LoginRequest is the body of POST /ui/v1/login.
+type LoginRequest struct {
+	Tenant   *string `json:"tenant" validate:"tenant"`
+	Account  string  `json:"account" validate:"required,account"`
+	Password string  `json:"password" validate:"required,password"`
+}
+
+// stringRules check, and may normalize, a string field. Each returns the
+// error of the rule the field breaks, if any.
+var stringRules = map[string]func(*string) *FieldError{
+	"account": func(s *string) *FieldError {
+		if !validAcctPwd.MatchString(*s) {
+			return &FieldError{Rule: RulePattern, Message: "must match " + validAcctPwd.String()}
+		}
+		return nil
+	},
+	"password": func(s *string) *FieldError {
+		if !validAcctPwd.MatchString(*s) {
+			return &FieldError{Rule: RulePattern, Message: "must match " + validAcctPwd.String()}
+		}
+		return nil
+	},
+	"tenant": func(s *string) *FieldError {
+		if !validTenant.MatchString(*s) {
+			return &FieldError{Rule: RulePattern, Message: "must match " + validTenant.String()}
+		}
+		return nil
+	},
+	"fullname": func(s *string) *FieldError {
+		var rule string
+		if *s, rule = normalizeFullname(*s); rule != "" {
+			return &FieldError{Rule: rule, Message: fullnameMessages[rule]}
+		}
+		return nil
+	},
+}
+
+// decodeRequest decodes body, a JSON object, into the struct dst points
+// to, field by field as its json tags name them, and checks the rules of
+// their validate tags: required, and those of stringRules, run on the
+// fields present. A field is present when its key is, and null is of no
+// type. It returns every error found, of the body first: it must hold a
+// single object, without duplicate keys at any depth, or keys dst has no
+// field for.
+func decodeRequest(body []byte, dst interface{}) []FieldError {
+	errs, ok := checkObject(body)
+	if !ok {
+		return errs
+	}
+
+	// the first value only, as trailing data is an error of its own
+	raw := map[string]json.RawMessage{}
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&raw); err != nil {
+		return append(errs, FieldError{Rule: RuleSyntax, Message: err.Error()})
+	}
+
+	v := reflect.ValueOf(dst).Elem()
+	known := map[string]bool{}
+	var fieldErrs []FieldError
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		known[name] = true
+
+		var tags []string
+		if tag := f.Tag.Get("validate"); tag != "" {
+			tags = strings.Split(tag, ",")
+		}
+
+		js, ok := raw[name]
+		if !ok {
+			for _, tag := range tags {
+				if tag == "required" {
+					fieldErrs = append(fieldErrs, FieldError{Field: name, Rule: RuleRequired, Message: "is required"})
+				}
+			}
+			continue
+		}
+		if bytes.Equal(bytes.TrimSpace(js), []byte("null")) {
+			fieldErrs = append(fieldErrs, FieldError{Field: name, Rule: RuleType, Message: "must not be null"})
+			continue
+		}
+
+		field := v.Field(i)
+		if field.Kind() == reflect.Ptr {
+			field.Set(reflect.New(field.Type().Elem()))
+			field = field.Elem()
+		}
+		if err := json.Unmarshal(js, field.Addr().Interface()); err != nil {
+			fieldErrs = append(fieldErrs, FieldError{Field: name, Rule: RuleType, Message: "must be of type " + jsonType(field.Type())})
+			continue
+		}
+
+		s, ok := field.Addr().Interface().(*string)
+		if !ok {
+			continue
+		}
+		for _, tag := range tags {
+			if rule, ok := stringRules[tag]; ok {
+				if fe := rule(s); fe != nil {
+					fe.Field = name
+					fieldErrs = append(fieldErrs, *fe)
+					break
+				}
+			}
+		}
+	}
+
+	var unknown []string
+	for name := range raw {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		errs = append(errs, FieldError{Field: name, Rule: RuleUnknownField, Message: "is not a field of the request"})
+	}
+
+	return append(errs, fieldErrs...)
+}
+
+// jsonType names the JSON type of t.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// checkObject checks that body is a single JSON object without duplicate
+// keys, which json.Unmarshal would take the last of. It reports whether
+// body holds an object at all.
+func checkObject(body []byte) ([]FieldError, bool) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	tok, err := dec.Token()
+	if err != nil {
+		return []FieldError{{Rule: RuleSyntax, Message: syntaxMessage(err)}}, false
+	}
+	if tok != json.Delim('{') {
+		return []FieldError{{Rule: RuleType, Message: "must be of type object"}}, false
+	}
+
+	var errs []FieldError
+	if err := checkKeys(dec, true, "", "", &errs); err != nil {
+		return []FieldError{{Rule: RuleSyntax, Message: syntaxMessage(err)}}, false
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		errs = append(errs, FieldError{Rule: RuleTrailingData, Message: "must hold a single JSON value"})
+	}
+	return errs, true
+}
+
+// checkKeys reads the rest of the object the opening brace of which dec
+// read, the body when top, else at path within field, and appends its
+// duplicate keys, and those of the objects in it, to errs.
+func checkKeys(dec *json.Decoder, top bool, field, path string, errs *[]FieldError) error {
+	seen := map[string]bool{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+
+		f, p := field, path+"/"+pointerEscape(key)
+		if top {
+			f, p = key, ""
+		}
+		if seen[key] {
+			*errs = append(*errs, FieldError{Field: f, Rule: RuleDuplicateKey, Path: p, Message: "is given more than once"})
+		}
+		seen[key] = true
+
+		if err := checkValue(dec, f, p, errs); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// checkValue reads the value dec is at, at path within field.
+func checkValue(dec *json.Decoder, field, path string, errs *[]FieldError) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok {
+	case json.Delim('{'):
+		return checkKeys(dec, false, field, path, errs)
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			if err := checkValue(dec, field, path+"/"+strconv.Itoa(i), errs); err != nil {
+				return err
+			}
+		}
+		_, err := dec.Token()
+		return err
+	}
+	return nil
+}
+
+func pointerEscape(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+// syntaxMessage is err, as read from a body that is not JSON.
+func syntaxMessage(err error) string {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return "unexpected end of JSON input"
+	}
+	return err.Error()
+}
+
+// attributeErrors are the errors of the violations of the attributes.
+func attributeErrors(violations []schema.Violation) []FieldError {
+	errs := make([]FieldError, 0, len(violations))
+	for _, v := range violations {
+		errs = append(errs, FieldError{Field: "attributes", Rule: RuleSchema, Path: v.Path, Message: v.Message})
+	}
+	return errs
+}
@@ -1,11 +1,18 @@
 package ui
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 )
 
+// httpStatusClientClosedRequest is the de facto code for a request whose
+// client went away before the response was written.
+const httpStatusClientClosedRequest = 499
+
 type Status int
 
 const (
@@ -15,6 +22,14 @@ const (
 	StatusUserNotFound
 	StatusWrongPassword
 	StatusInvalidContent
+	StatusTimeout
+	StatusCanceled
+	StatusPreconditionFailed
+	StatusPreconditionRequired
+	StatusNotFound
+	StatusGroupExisted
+	StatusTenantExisted
+	StatusAccountNotAllowed
 )
 
 func (status Status) String() string {
@@ -31,6 +46,22 @@ func (status Status) String() string {
 		return "The login password is incorrect"
 	case StatusInvalidContent:
 		return "The content is invalid"
+	case StatusTimeout:
+		return "The database operation timed out"
+	case StatusCanceled:
+		return "The request was canceled"
+	case StatusPreconditionFailed:
+		return "The user has been modified since it was read"
+	case StatusPreconditionRequired:
+		return "The request must be conditional"
+	case StatusNotFound:
+		return "The resource was not found"
+	case StatusGroupExisted:
+		return "The group to be created has been existed"
+	case StatusTenantExisted:
+		return "The tenant to be created has been existed"
+	case StatusAccountNotAllowed:
+		return "The account name is not allowed"
 	default:
 		return ""
 	}
@@ -60,6 +91,20 @@ func WriteJsonResponse(status Status, data interface{}, w http.ResponseWriter) {
 		w.WriteHeader(http.StatusUnauthorized)
 	case StatusNoAuth:
 		w.WriteHeader(http.StatusUnauthorized)
+	case StatusTimeout:
+		w.WriteHeader(http.StatusGatewayTimeout)
+	case StatusCanceled:
+		w.WriteHeader(httpStatusClientClosedRequest)
+	case StatusPreconditionFailed:
+		w.WriteHeader(http.StatusPreconditionFailed)
+	case StatusPreconditionRequired:
+		w.WriteHeader(http.StatusPreconditionRequired)
+	case StatusNotFound:
+		w.WriteHeader(http.StatusNotFound)
+	case StatusGroupExisted, StatusTenantExisted:
+		w.WriteHeader(http.StatusConflict)
+	case StatusAccountNotAllowed:
+		w.WriteHeader(http.StatusUnprocessableEntity)
 	}
 
 	resp := Response{
@@ -71,6 +116,9 @@ func WriteJsonResponse(status Status, data interface{}, w http.ResponseWriter) {
 	}
 
 	body, err := resp.JsonIdent()
+	if err == nil && credentialKey.Match(body) {
+		body, err = redactBody(body)
+	}
 	if err != nil {
 		log.Print(err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -84,3 +132,35 @@ func WriteJsonResponse(status Status, data interface{}, w http.ResponseWriter) {
 	}
 	w.Header()["Content-Type"] = []string{"application/json"}
 }
+
+// redactBody masks the credentials of body, in case a view or an error
+// payload carries any, and indents it back.
+func redactBody(body []byte) ([]byte, error) {
+	js, err := RedactJSON(body, credentialFields)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, js, "", "    "); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteErrorResponse reports an error from the database layer. Operations
+// aborted by their deadline or by the client going away get a dedicated
+// status; anything else is logged and answered with a bare 500.
+func WriteErrorResponse(err error, w http.ResponseWriter) {
+	switch {
+	case errors.Is(err, ErrPreconditionFailed):
+		WriteJsonResponse(StatusPreconditionFailed, nil, w)
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Print(err)
+		WriteJsonResponse(StatusTimeout, nil, w)
+	case errors.Is(err, context.Canceled):
+		WriteJsonResponse(StatusCanceled, nil, w)
+	default:
+		log.Print(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
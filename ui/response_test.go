@@ -1,6 +1,7 @@
 package ui_test
 
 import (
+	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
@@ -59,6 +60,18 @@ func (s *_respSuite) TestWriteJsonResponse() {
 	//fmt.Println(rcd.Body.String())
 	contentType := rcd.Header()["Content-Type"]
 	s.Equal(contentType, []string{"application/json"})
+	s.NotContains(rcd.Body.String(), "pwd1")
+
+	// the credentials of any payload are masked, and the rest kept as is
+	rcd = httptest.NewRecorder()
+	ui.WriteJsonResponse(ui.StatusInvalidContent, map[string]interface{}{
+		"invalid": map[string]string{"account": "acct1", "password": "pwd1"},
+		"token":   "not a credential",
+	}, rcd)
+	s.Equal(http.StatusBadRequest, rcd.Code)
+	s.NotContains(rcd.Body.String(), "pwd1")
+	s.Contains(rcd.Body.String(), `"password": "[REDACTED]"`)
+	s.Contains(rcd.Body.String(), `"token": "not a credential"`)
 }
 
 func TestRunResp(t *testing.T) {
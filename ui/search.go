@@ -0,0 +1,282 @@
+package ui
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/jinzhu/gorm"
+	"golang.org/x/text/unicode/norm"
+)
+
+type SearchMode string
+
+const (
+	SearchExact    SearchMode = "exact"
+	SearchCaseless SearchMode = "caseless"
+	SearchPrefix   SearchMode = "prefix"
+	SearchContains SearchMode = "contains"
+	SearchSimilar  SearchMode = "similar"
+
+	// SimilarityThreshold is the minimum trigram similarity of a match in
+	// SearchSimilar mode, the default of pg_trgm.similarity_threshold.
+	SimilarityThreshold = 0.3
+
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// UserSearch is the parsed query string of GET /ui/v1/users/search.
+type UserSearch struct {
+	Query string
+	Mode  SearchMode
+	Limit int
+}
+
+// UserMatch is a search result. Score is the trigram similarity between
+// the query and the fullname, from 0 to 1.
+type UserMatch struct {
+	Acct     string  `json:"account"`
+	Fullname string  `json:"fullname"`
+	Score    float64 `json:"score"`
+}
+
+type SearchUserHandlerFunc func(context.Context, *UI, *UserSearch) ([]UserMatch, error)
+
+// trigrams splits s the way pg_trgm does: words of letters and digits,
+// lower cased, padded with two spaces in front and one behind.
+func trigrams(s string) map[string]struct{} {
+	set := map[string]struct{}{}
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		rs := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(rs); i++ {
+			set[string(rs[i:i+3])] = struct{}{}
+		}
+	}
+	return set
+}
+
+// TrigramSimilarity mirrors similarity() of pg_trgm.
+func TrigramSimilarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for t := range ta {
+		if _, ok := tb[t]; ok {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
+
+// MatchFullname reports whether fullname matches query in mode, and its
+// score. It is the pure Go counterpart of the Postgres search.
+func MatchFullname(mode SearchMode, query, fullname string) (float64, bool) {
+	lq, lf := strings.ToLower(query), strings.ToLower(fullname)
+
+	var ok bool
+	switch mode {
+	case SearchExact:
+		ok = fullname == query
+	case SearchCaseless:
+		ok = lf == lq
+	case SearchPrefix:
+		ok = strings.HasPrefix(lf, lq)
+	case SearchContains:
+		ok = strings.Contains(lf, lq)
+	case SearchSimilar:
+		ok = TrigramSimilarity(lq, lf) >= SimilarityThreshold
+	}
+	if !ok {
+		return 0, false
+	}
+	if lf == lq {
+		return 1, true
+	}
+	return TrigramSimilarity(lq, lf), true
+}
+
+// sortMatches ranks exact (caseless) matches first, then by descending
+// score, and breaks ties by account.
+func sortMatches(query string, matches []UserMatch) {
+	lq := strings.ToLower(query)
+	sort.SliceStable(matches, func(i, j int) bool {
+		ei := strings.ToLower(matches[i].Fullname) == lq
+		ej := strings.ToLower(matches[j].Fullname) == lq
+		if ei != ej {
+			return ei
+		}
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Acct < matches[j].Acct
+	})
+}
+
+// likePattern escapes the LIKE wildcards of s.
+func likePattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+///////////////////////////////////////////////////////////////
+//////    GET /ui/v1/users/search?q={query}&mode={mode}    //////
+///////////////////////////////////////////////////////////////
+
+// SearchHdl matches fullnames with the trigram indexes of pg_trgm on
+// Postgres, and falls back to scanning every user in Go on other stores.
+// Sealed fullnames are matched by their blind index only, the rows of which
+// are opened and checked in Go: Search refuses the modes it cannot serve.
+var SearchHdl SearchUserHandlerFunc = func(ctx context.Context, ui *UI, search *UserSearch) ([]UserMatch, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.QueryTimeout)
+	defer cancel()
+
+	if ui.DB().Dialect().GetName() != "postgres" {
+		return searchInGo(ui, usersOf(ctx, ui.readDB(ctx)), search)
+	}
+	if ui.Fields != nil {
+		return searchInGo(ui, ui.whereFullname(usersOf(ctx, ui.readDB(ctx)), search.Query), search)
+	}
+
+	fullname := pg.FieldUserFullname.String()
+	db := usersOf(ctx, ui.readDB(ctx))
+
+	switch search.Mode {
+	case SearchExact:
+		db = db.Where(fullname+" = ?", search.Query)
+	case SearchCaseless:
+		db = db.Where("lower("+fullname+") = lower(?)", search.Query)
+	case SearchPrefix:
+		db = db.Where("lower("+fullname+") LIKE lower(?)", likePattern(search.Query)+"%")
+	case SearchContains:
+		db = db.Where("lower("+fullname+") LIKE lower(?)", "%"+likePattern(search.Query)+"%")
+	case SearchSimilar:
+		db = db.Where("lower("+fullname+") % lower(?)", search.Query)
+	}
+
+	rows, err := db.
+		Select(pg.FieldUserAcct.String()+", "+fullname+", similarity(lower("+fullname+"), lower(?)) AS score", search.Query).
+		Order(gorm.Expr("lower("+fullname+") = lower(?) DESC", search.Query)).
+		Order("score DESC").
+		Order(pg.FieldUserAcct.String()).
+		Limit(search.Limit).
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []UserMatch{}
+	for rows.Next() {
+		var m UserMatch
+		if err := rows.Scan(&m.Acct, &m.Fullname, &m.Score); err != nil {
+			return nil, err
+		}
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+func searchInGo(ui *UI, db *gorm.DB, search *UserSearch) ([]UserMatch, error) {
+	rows, err := db.
+		Select([]string{pg.FieldUserAcct.String(), pg.FieldUserFullname.String()}).
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []UserMatch{}
+	for rows.Next() {
+		var m UserMatch
+		if err := rows.Scan(&m.Acct, &m.Fullname); err != nil {
+			return nil, err
+		}
+		var err error
+		if m.Fullname, err = ui.openFullname(m.Fullname); err != nil {
+			return nil, err
+		}
+
+		var ok bool
+		if m.Score, ok = MatchFullname(search.Mode, search.Query, m.Fullname); ok {
+			matches = append(matches, m)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sortMatches(search.Query, matches)
+	if len(matches) > search.Limit {
+		matches = matches[:search.Limit]
+	}
+	return matches, nil
+}
+
+func (ui *UI) Search(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	search := &UserSearch{
+		Query: values.Get("q"),
+		Mode:  SearchMode(values.Get("mode")),
+		Limit: DefaultSearchLimit,
+	}
+
+	search.Query = norm.NFC.String(search.Query)
+	if search.Query == "" || fullnameLen(search.Query) > pg.FieldUserFullnameMaxLen {
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": "q", "value": search.Query}}, w)
+		return
+	}
+
+	// sealed fullnames are only matched whole, by their blind index
+	sealed := ui.Fields != nil
+	switch search.Mode {
+	case "":
+		search.Mode = SearchSimilar
+		if sealed {
+			search.Mode = SearchCaseless
+		}
+	case SearchExact, SearchCaseless:
+	case SearchPrefix, SearchContains, SearchSimilar:
+		if !sealed {
+			break
+		}
+		fallthrough
+	default:
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": "mode", "value": string(search.Mode)}}, w)
+		return
+	}
+
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > MaxSearchLimit {
+			WriteJsonResponse(StatusInvalidContent,
+				map[string]map[string]string{"invalid": {"field": "limit", "value": v}}, w)
+			return
+		}
+		search.Limit = n
+	}
+
+	matches, err := SearchHdl(r.Context(), ui, search)
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+
+	data := map[string]interface{}{
+		"q":     search.Query,
+		"mode":  search.Mode,
+		"users": matches,
+	}
+	WriteJsonResponse(StatusOK, data, w)
+}
@@ -0,0 +1,136 @@
+package ui_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dontang97/ui/pii"
+	"github.com/dontang97/ui/ui"
+	"github.com/stretchr/testify/suite"
+)
+
+type _searchSuite struct {
+	suite.Suite
+	UI *ui.UI
+
+	SearchHdl ui.SearchUserHandlerFunc
+}
+
+func (s *_searchSuite) SetupSuite() {
+	s.UI = ui.New()
+}
+
+func (s *_searchSuite) TearDownSuite() {
+}
+
+func (s *_searchSuite) SetupTest() {
+	s.SearchHdl, ui.SearchHdl = ui.SearchHdl, nil
+}
+
+func (s *_searchSuite) TearDownTest() {
+	ui.SearchHdl, s.SearchHdl = s.SearchHdl, nil
+}
+
+func (s *_searchSuite) TestTrigramSimilarity() {
+	s.Equal(1.0, ui.TrigramSimilarity("John Smith", "john smith"))
+	s.Equal(0.0, ui.TrigramSimilarity("abc", "xyz"))
+	s.Equal(0.0, ui.TrigramSimilarity("", "xyz"))
+
+	// "  w"," wo","wor","ord","rd " against "  w"," wo","wor","orl","rld","ld "
+	s.InDelta(3.0/8.0, ui.TrigramSimilarity("word", "world"), 1e-9)
+}
+
+func (s *_searchSuite) TestMatchFullname() {
+	cases := []struct {
+		mode     ui.SearchMode
+		query    string
+		fullname string
+		ok       bool
+	}{
+		{ui.SearchExact, "John Smith", "John Smith", true},
+		{ui.SearchExact, "john smith", "John Smith", false},
+		{ui.SearchCaseless, "john smith", "John Smith", true},
+		{ui.SearchPrefix, "john", "John Smith", true},
+		{ui.SearchPrefix, "smith", "John Smith", false},
+		{ui.SearchContains, "smi", "John Smith", true},
+		{ui.SearchContains, "smy", "John Smith", false},
+		{ui.SearchSimilar, "jon smith", "John Smith", true},
+		{ui.SearchSimilar, "kobe", "John Smith", false},
+	}
+
+	for _, c := range cases {
+		score, ok := ui.MatchFullname(c.mode, c.query, c.fullname)
+		s.Equal(c.ok, ok, "%v %q %q", c.mode, c.query, c.fullname)
+		if ok {
+			s.True(score > 0 && score <= 1)
+		}
+	}
+
+	score, _ := ui.MatchFullname(ui.SearchCaseless, "JOHN SMITH", "John Smith")
+	s.Equal(1.0, score)
+}
+
+func (s *_searchSuite) TestSearch() {
+	var search *ui.UserSearch
+	ui.SearchHdl = func(_ context.Context, _ *ui.UI, us *ui.UserSearch) ([]ui.UserMatch, error) {
+		search = us
+		return []ui.UserMatch{{Acct: "User1", Fullname: "John Smith", Score: 1}}, nil
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://test.com?q=john+smith", nil)
+	rcd := httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Search).ServeHTTP(rcd, req)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal("john smith", search.Query)
+	s.Equal(ui.SearchSimilar, search.Mode)
+	s.Equal(ui.DefaultSearchLimit, search.Limit)
+
+	body := map[string]interface{}{}
+	err := json.Unmarshal(rcd.Body.Bytes(), &body)
+	s.Equal(nil, err)
+	users := body["data"].(map[string]interface{})["users"].([]interface{})
+	s.Equal("User1", users[0].(map[string]interface{})["account"])
+
+	for _, q := range []string{"", "q=abc&mode=regex", "q=abc&limit=0", "q=abc&limit=101"} {
+		req = httptest.NewRequest(http.MethodGet, "http://test.com?"+q, nil)
+		rcd = httptest.NewRecorder()
+		http.HandlerFunc(s.UI.Search).ServeHTTP(rcd, req)
+		s.Equal(http.StatusBadRequest, rcd.Code, q)
+	}
+}
+
+func (s *_searchSuite) TestSearchSealed() {
+	s.UI.Fields = pii.NewKeyring(make([]byte, 32))
+	defer func() { s.UI.Fields = nil }()
+
+	var search *ui.UserSearch
+	ui.SearchHdl = func(_ context.Context, _ *ui.UI, us *ui.UserSearch) ([]ui.UserMatch, error) {
+		search = us
+		return []ui.UserMatch{}, nil
+	}
+
+	// whole fullnames only, caseless by default
+	for q, mode := range map[string]ui.SearchMode{"q=kobe": ui.SearchCaseless, "q=kobe&mode=exact": ui.SearchExact} {
+		req := httptest.NewRequest(http.MethodGet, "http://test.com?"+q, nil)
+		rcd := httptest.NewRecorder()
+		http.HandlerFunc(s.UI.Search).ServeHTTP(rcd, req)
+		s.Equal(http.StatusOK, rcd.Code, q)
+		s.Equal(mode, search.Mode, q)
+	}
+
+	search = nil
+	for _, mode := range []ui.SearchMode{ui.SearchPrefix, ui.SearchContains, ui.SearchSimilar} {
+		req := httptest.NewRequest(http.MethodGet, "http://test.com?q=kobe&mode="+string(mode), nil)
+		rcd := httptest.NewRecorder()
+		http.HandlerFunc(s.UI.Search).ServeHTTP(rcd, req)
+		s.Equal(http.StatusBadRequest, rcd.Code, mode)
+	}
+	s.Nil(search)
+}
+
+func TestRunSearch(t *testing.T) {
+	suite.Run(t, new(_searchSuite))
+}
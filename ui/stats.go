@@ -0,0 +1,21 @@
+package ui
+
+import (
+	"net/http"
+
+	"github.com/dontang97/ui/pg"
+)
+
+type PoolStatsHandlerFunc func(*UI) []pg.PoolStats
+
+var PoolStatsHdl PoolStatsHandlerFunc = func(ui *UI) []pg.PoolStats {
+	return ui.PoolStats()
+}
+
+///////////////////////////////////////
+//////    GET /ui/v1/db/stats    //////
+///////////////////////////////////////
+
+func (ui *UI) DBStats(w http.ResponseWriter, r *http.Request) {
+	WriteJsonResponse(StatusOK, map[string]interface{}{"pools": PoolStatsHdl(ui)}, w)
+}
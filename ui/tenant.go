@@ -0,0 +1,150 @@
+package ui
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/secret"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+var validTenant = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+type QueryTenantHandlerFunc func(context.Context, *UI) ([]pg.Tenant, error)
+type AddTenantHandlerFunc func(context.Context, *UI, *pg.Tenant) error
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx acting in tenant rather than in the
+// tenant of the caller.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// Tenant returns the tenant a request acts in: the one set by WithTenant,
+// else the tenant of the JWT of the caller, else pg.DefaultTenant.
+func Tenant(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok && tenant != "" {
+		return tenant
+	}
+	if claims, ok := secret.FromContext(ctx); ok {
+		return ClaimsTenant(claims)
+	}
+	return pg.DefaultTenant
+}
+
+// ClaimsTenant returns the tenant of the bearer of claims.
+func ClaimsTenant(claims *secret.UserClaims) string {
+	if claims.Tenant == "" {
+		return pg.DefaultTenant
+	}
+	return claims.Tenant
+}
+
+// TenantAccount names acct of tenant uniquely across tenants, as
+// tenant/acct. The accounts of the default tenant keep their bare name.
+func TenantAccount(tenant, acct string) string {
+	if tenant == "" || tenant == pg.DefaultTenant {
+		return acct
+	}
+	return tenant + "/" + acct
+}
+
+// usersOf returns db on the users of the tenant of ctx.
+func usersOf(ctx context.Context, db *gorm.DB) *gorm.DB {
+	return db.
+		Table(pg.TableUsers.String()).
+		Where(pg.FieldUserTenant.String()+" = ?", Tenant(ctx))
+}
+
+// transaction runs fn in a transaction of the primary. With RowSecurity
+// the row level security policies confine it to the tenant of ctx, should
+// a statement miss its tenant filter.
+func (ui *UI) transaction(ctx context.Context, fn func(tx *gorm.DB) error) error {
+	return ui.Transaction(ctx, func(tx *gorm.DB) error {
+		if ui.RowSecurity {
+			if res := tx.Exec("SELECT set_config(?, ?, true)", pg.TenantSetting, Tenant(ctx)); res.Error != nil {
+				return res.Error
+			}
+		}
+		return fn(tx)
+	})
+}
+
+//////////////////////////////////////
+//////    GET /ui/v1/tenants    //////
+//////////////////////////////////////
+
+var TenantsHdl QueryTenantHandlerFunc = func(ctx context.Context, ui *UI) ([]pg.Tenant, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.QueryTimeout)
+	defer cancel()
+
+	tenants := []pg.Tenant{}
+	res := ui.readDB(ctx).
+		Table(pg.TableTenants.String()).
+		Order(pg.FieldTenantID.String()).
+		Find(&tenants)
+	return tenants, res.Error
+}
+
+func (ui *UI) Tenants(w http.ResponseWriter, r *http.Request) {
+	tenants, err := TenantsHdl(r.Context(), ui)
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+
+	WriteJsonResponse(StatusOK, map[string]interface{}{"tenants": tenants}, w)
+}
+
+///////////////////////////////////////
+//////    POST /ui/v1/tenants    //////
+///////////////////////////////////////
+
+var AddTenantHdl AddTenantHandlerFunc = func(ctx context.Context, ui *UI, tenant *pg.Tenant) error {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
+
+	return ui.WithContext(ctx).Table(pg.TableTenants.String()).Create(tenant).Error
+}
+
+func (ui *UI) AddTenant(w http.ResponseWriter, r *http.Request) {
+	req := pg.Tenant{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteJsonResponse(StatusInvalidContent, map[string]string{"error": err.Error()}, w)
+		return
+	}
+
+	if req.ID == "" {
+		WriteJsonResponse(StatusInvalidContent, map[string]string{"missing_field": "id"}, w)
+		return
+	}
+	if !validTenant.MatchString(req.ID) {
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": "id", "value": req.ID}}, w)
+		return
+	}
+	if len(req.Name) > pg.FieldTenantNameMaxLen {
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": "name", "value": req.Name}}, w)
+		return
+	}
+
+	tenant := &pg.Tenant{ID: req.ID, Name: req.Name, CreatedAt: time.Now().UTC()}
+	if err := AddTenantHdl(r.Context(), ui, tenant); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pq.ErrorCode("23505") {
+			WriteJsonResponse(StatusTenantExisted, map[string]string{"tenant": tenant.ID}, w)
+			return
+		}
+
+		WriteErrorResponse(err, w)
+		return
+	}
+
+	WriteJsonResponse(StatusOK, tenant, w)
+}
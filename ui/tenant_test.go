@@ -0,0 +1,98 @@
+package ui_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/ui"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/suite"
+)
+
+type _tenantSuite struct {
+	suite.Suite
+	UI *ui.UI
+
+	TenantsHdl   ui.QueryTenantHandlerFunc
+	AddTenantHdl ui.AddTenantHandlerFunc
+}
+
+func (s *_tenantSuite) SetupSuite() {
+	s.UI = ui.New()
+}
+
+func (s *_tenantSuite) TearDownSuite() {
+}
+
+func (s *_tenantSuite) SetupTest() {
+	s.TenantsHdl, ui.TenantsHdl = ui.TenantsHdl, nil
+	s.AddTenantHdl, ui.AddTenantHdl = ui.AddTenantHdl, nil
+}
+
+func (s *_tenantSuite) TearDownTest() {
+	ui.TenantsHdl, s.TenantsHdl = s.TenantsHdl, nil
+	ui.AddTenantHdl, s.AddTenantHdl = s.AddTenantHdl, nil
+}
+
+func (s *_tenantSuite) serve(hdl http.HandlerFunc, method, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req := httptest.NewRequest(method, "http://test.com/ui/v1/tenants", bytes.NewBufferString(body))
+	rcd := httptest.NewRecorder()
+	hdl.ServeHTTP(rcd, req)
+
+	resp := map[string]interface{}{}
+	if rcd.Body.Len() > 0 {
+		s.Equal(nil, json.Unmarshal(rcd.Body.Bytes(), &resp))
+	}
+	return rcd, resp
+}
+
+func (s *_tenantSuite) TestTenants() {
+	ui.TenantsHdl = func(context.Context, *ui.UI) ([]pg.Tenant, error) {
+		return []pg.Tenant{{ID: pg.DefaultTenant}, {ID: "lakers", Name: "LA Lakers"}}, nil
+	}
+
+	rcd, resp := s.serve(s.UI.Tenants, http.MethodGet, "")
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal(2, len(resp["data"].(map[string]interface{})["tenants"].([]interface{})))
+}
+
+func (s *_tenantSuite) TestAddTenant() {
+	var added *pg.Tenant
+	ui.AddTenantHdl = func(_ context.Context, _ *ui.UI, tenant *pg.Tenant) error {
+		added = tenant
+		if tenant.ID == "celtics" {
+			return &pq.Error{Code: "23505"}
+		}
+		return nil
+	}
+
+	rcd, _ := s.serve(s.UI.AddTenant, http.MethodPost, `{"id": "lakers", "name": "LA Lakers"}`)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal("lakers", added.ID)
+	s.Equal("LA Lakers", added.Name)
+	s.False(added.CreatedAt.IsZero())
+
+	rcd, _ = s.serve(s.UI.AddTenant, http.MethodPost, `{"id": "celtics"}`)
+	s.Equal(http.StatusConflict, rcd.Code)
+
+	for _, body := range []string{
+		`{"name": "LA Lakers"}`,
+		`{"id": "LA Lakers"}`,
+		`{"id": "-lakers"}`,
+		`{"id": "lakers_lakers_lakers_lakers_lakers"}`,
+		`{"id": 24}`,
+		`{"id":`,
+	} {
+		rcd, _ = s.serve(s.UI.AddTenant, http.MethodPost, body)
+		s.Equal(http.StatusBadRequest, rcd.Code, body)
+	}
+}
+
+func TestRunTenant(t *testing.T) {
+	suite.Run(t, new(_tenantSuite))
+}
@@ -1,13 +1,101 @@
 package ui
 
 import (
+	"context"
+	"time"
+
+	"github.com/dontang97/ui/events"
 	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/pii"
+	"github.com/dontang97/ui/schema"
+	"github.com/dontang97/ui/secret"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	DefaultQueryTimeout = time.Second * 5
+	DefaultExecTimeout  = time.Second * 10
 )
 
 type UI struct {
 	pg.PG
+
+	// QueryTimeout bounds every read against the database and ExecTimeout
+	// every write. Both run under the request context as well.
+	QueryTimeout time.Duration
+	ExecTimeout  time.Duration
+
+	// RequireIfMatch rejects updates and deletes that carry no If-Match
+	// header instead of applying them unconditionally.
+	RequireIfMatch bool
+
+	// Admins are the accounts granted secret.RoleAdmin at login, and
+	// SuperAdmins those granted secret.RoleSuperAdmin, both named by
+	// TenantAccount.
+	Admins      map[string]bool
+	SuperAdmins map[string]bool
+
+	// ReservedAccounts are the names no account is signed up with, or
+	// renamed to, but for case, underscores, trailing digits and look-alike
+	// characters.
+	ReservedAccounts []string
+
+	// AliasGracePeriod is how long the former name of a renamed account
+	// stays reserved for it, and resolves to it.
+	AliasGracePeriod time.Duration
+
+	// RowSecurity confines the transactions of a request to its tenant by
+	// the row level security policies of the database as well.
+	RowSecurity bool
+
+	// GroupsClaim names the groups of the account in the JWT of a login,
+	// besides granting their roles.
+	GroupsClaim bool
+
+	// AttributeSchema validates the profile attributes of users. Without
+	// it users have none.
+	AttributeSchema *schema.Schema
+
+	// Fields seals the fullnames of users at rest, set by LoadKeys. Without
+	// it they are stored as they are.
+	Fields *pii.Keyring
+
+	// EventBus feeds the streams of GET /ui/v1/events, which send a comment
+	// every EventKeepAlive while idle.
+	EventBus       *events.Bus
+	EventKeepAlive time.Duration
 }
 
 func New() *UI {
-	return &UI{}
+	return &UI{
+		QueryTimeout: DefaultQueryTimeout,
+		ExecTimeout:  DefaultExecTimeout,
+		Admins:       map[string]bool{},
+		SuperAdmins:  map[string]bool{},
+
+		ReservedAccounts: DefaultReservedAccounts,
+		AliasGracePeriod: DefaultAliasGracePeriod,
+
+		EventBus:       events.NewBus(events.DefaultBacklog),
+		EventKeepAlive: DefaultEventKeepAlive,
+	}
+}
+
+// readDB returns a handle for the reads of a request, on a replica unless
+// one of accts or the caller itself was written within the read-your-writes
+// window.
+func (ui *UI) readDB(ctx context.Context, accts ...string) *gorm.DB {
+	if claims, ok := secret.FromContext(ctx); ok {
+		accts = append(accts, claims.Acct)
+	}
+	return ui.ReadContext(ctx, accts...)
+}
+
+// markWritten pins the reads of accts and of the caller to the primary
+// until the replicas have caught up with a write.
+func (ui *UI) markWritten(ctx context.Context, accts ...string) {
+	if claims, ok := secret.FromContext(ctx); ok {
+		accts = append(accts, claims.Acct)
+	}
+	ui.MarkWritten(accts...)
 }
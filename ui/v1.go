@@ -1,48 +1,174 @@
 package ui
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"regexp"
+	"strings"
+	"time"
 
+	"github.com/dontang97/ui/outbox"
 	"github.com/dontang97/ui/pg"
-	"github.com/dontang97/ui/secret"
+	"github.com/dontang97/ui/schema"
 	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
 	"github.com/lib/pq"
 )
 
-var validAcctPwd = regexp.MustCompile(`[A-Za-z0-9_]{8,20}`)
+var validAcctPwd = regexp.MustCompile(`^[A-Za-z0-9_]{8,20}$`)
+var validAcctPrefix = regexp.MustCompile(`^[A-Za-z0-9_]{1,20}$`)
 
-type QueryUserHandlerFunc func(*UI, ...interface{}) ([]pg.User, error)
-type AddUserHandlerFunc func(*UI, *pg.User) error
-type DeleteUserHandlerFunc func(*UI, *pg.User) error
-type UpdateUserHandlerFunc func(*UI, *pg.User) error
+// The handler funcs receive the request context. Implementations must issue
+// their statements through it so that canceled requests abort their SQL.
+type QueryUserHandlerFunc func(context.Context, *UI, ...interface{}) ([]pg.User, error)
+type CountUserHandlerFunc func(context.Context, *UI, ...interface{}) (int, error)
+type AddUserHandlerFunc func(context.Context, *UI, *pg.User) error
+
+// DeleteUserHandlerFunc and UpdateUserHandlerFunc return the user as it was
+// before the write, or nil if it did not exist.
+type DeleteUserHandlerFunc func(context.Context, *UI, *pg.User, *Precondition) (*pg.User, error)
+type UpdateUserHandlerFunc func(context.Context, *UI, *pg.User, *Precondition) (*pg.User, error)
+
+// lockUser reads and locks the row of acct of the tenant of ctx within tx,
+// and checks it against pre. A missing user fails every precondition.
+func lockUser(ctx context.Context, ui *UI, tx *gorm.DB, acct string, pre *Precondition) (*pg.User, error) {
+	rows, err := usersOf(ctx, tx).
+		Select("*").
+		Where(pg.FieldUserAcct.String()+" = ?", acct).
+		Set("gorm:query_option", "FOR UPDATE").
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := scanUsers(ui, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(users) == 0 {
+		if pre != nil {
+			return nil, ErrPreconditionFailed
+		}
+		return nil, nil
+	}
+
+	if pre != nil && !pre.Any {
+		matched := false
+		for _, t := range pre.UpdatedAt {
+			if t.Equal(users[0].Updated_at) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, ErrPreconditionFailed
+		}
+	}
+
+	return &users[0], nil
+}
 
 func scanUsers(ui *UI, rows *sql.Rows) ([]pg.User, error) {
+	defer rows.Close()
+
 	var users []pg.User
 	for rows.Next() {
 		var user pg.User
 		if err := ui.DB().ScanRows(rows, &user); err != nil {
 			return nil, err
 		}
+		var err error
+		if user.Fullname, err = ui.openFullname(user.Fullname); err != nil {
+			return nil, err
+		}
 		users = append(users, user)
 	}
 
-	return users, nil
+	return users, rows.Err()
 }
 
 //////////////////////////////////
 /////    GET /ui/v1/users    /////
 //////////////////////////////////
 
-var UsersHdl QueryUserHandlerFunc = func(ui *UI, _ ...interface{}) ([]pg.User, error) {
-	rows, err := ui.DB().
-		Table(pg.TableUsers.String()).
-		Select(pg.FieldUserAcct.String()).
+// filterUsers applies the filters of q, but not its cursor, to db.
+func filterUsers(db *gorm.DB, q *UserListQuery) *gorm.DB {
+	for _, f := range []struct {
+		field pg.Field
+		op    string
+		t     time.Time
+	}{
+		{pg.FieldUserCreatedAt, ">=", q.CreatedAfter},
+		{pg.FieldUserCreatedAt, "<", q.CreatedBefore},
+		{pg.FieldUserUpdatedAt, ">=", q.UpdatedAfter},
+		{pg.FieldUserUpdatedAt, "<", q.UpdatedBefore},
+	} {
+		if !f.t.IsZero() {
+			db = db.Where(f.field.String()+" "+f.op+" ?", f.t)
+		}
+	}
+
+	if q.Prefix != "" {
+		// '_' is a LIKE wildcard but a legal account character
+		db = db.Where(pg.FieldUserAcct.String()+" LIKE ?", strings.ReplaceAll(q.Prefix, "_", `\_`)+"%")
+	}
+
+	if len(q.Attributes) > 0 {
+		js, _ := json.Marshal(q.Attributes)
+		db = db.Where(pg.FieldUserAttributes.String()+" @> ?::jsonb", string(js))
+	}
+	return db
+}
+
+// UsersHdl lists one page of users. args[0] is a *UserListQuery; one user
+// more than the limit is returned when there is a next page.
+var UsersHdl QueryUserHandlerFunc = func(ctx context.Context, ui *UI, args ...interface{}) ([]pg.User, error) {
+	q := args[0].(*UserListQuery)
+
+	ctx, cancel := context.WithTimeout(ctx, ui.QueryTimeout)
+	defer cancel()
+
+	db := filterUsers(usersOf(ctx, ui.readDB(ctx)), q)
+
+	cmp, dir := ">", "ASC"
+	if q.Desc {
+		cmp, dir = "<", "DESC"
+	}
+
+	if c := q.After; c != nil {
+		if q.Sort == pg.FieldUserAcct {
+			db = db.Where(pg.FieldUserAcct.String()+" "+cmp+" ?", c.Acct)
+		} else {
+			v, _ := time.Parse(time.RFC3339Nano, c.Value)
+			db = db.Where("("+q.Sort.String()+", "+pg.FieldUserAcct.String()+") "+cmp+" (?, ?)", v, c.Acct)
+		}
+	}
+
+	fields := []string{pg.FieldUserAcct.String()}
+	if q.Summary {
+		fields = append(fields,
+			pg.FieldUserFullname.String(),
+			pg.FieldUserCreatedAt.String(),
+			pg.FieldUserUpdatedAt.String(),
+		)
+	} else if q.Sort != pg.FieldUserAcct {
+		fields = append(fields, q.Sort.String())
+	}
+
+	if q.Sort != pg.FieldUserAcct {
+		db = db.Order(q.Sort.String() + " " + dir)
+	}
+	rows, err := db.
+		Select(fields).
+		Order(pg.FieldUserAcct.String() + " " + dir).
+		Limit(q.Limit + 1).
 		Rows()
 	if err != nil {
 		return nil, err
@@ -51,21 +177,75 @@ var UsersHdl QueryUserHandlerFunc = func(ui *UI, _ ...interface{}) ([]pg.User, e
 	return scanUsers(ui, rows)
 }
 
-func (ui *UI) Users(w http.ResponseWriter, _ *http.Request) {
-	users, err := UsersHdl(ui)
+// UsersCountHdl counts the users matching the filters of args[0], a
+// *UserListQuery, regardless of its cursor.
+var UsersCountHdl CountUserHandlerFunc = func(ctx context.Context, ui *UI, args ...interface{}) (int, error) {
+	q := args[0].(*UserListQuery)
+
+	ctx, cancel := context.WithTimeout(ctx, ui.QueryTimeout)
+	defer cancel()
 
+	var count int
+	res := filterUsers(usersOf(ctx, ui.readDB(ctx)), q).Count(&count)
+	return count, res.Error
+}
+
+func (ui *UI) Users(w http.ResponseWriter, r *http.Request) {
+	q, err := parseUserListQuery(r.URL.Query(), ui.AttributeSchema)
 	if err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		qe := err.(*queryError)
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": qe.field, "value": qe.value}}, w)
 		return
 	}
 
-	accts := []string{}
-	for _, user := range users {
-		accts = append(accts, user.Acct)
+	users, err := UsersHdl(r.Context(), ui, q)
+
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+
+	data := map[string]interface{}{}
+
+	if len(users) > q.Limit {
+		users = users[:q.Limit]
+
+		next := *r.URL
+		values := next.Query()
+		values.Set("cursor", q.cursorOf(&users[len(users)-1]).Encode())
+		next.RawQuery = values.Encode()
+		data["next"] = next.RequestURI()
+	}
+
+	if q.Count {
+		total, err := UsersCountHdl(r.Context(), ui, q)
+		if err != nil {
+			WriteErrorResponse(err, w)
+			return
+		}
+		data["total"] = total
+	}
+
+	if q.Summary {
+		summaries := []UserSummary{}
+		for _, user := range users {
+			summaries = append(summaries, UserSummary{
+				Acct:       user.Acct,
+				Fullname:   user.Fullname,
+				Created_at: user.Created_at,
+				Updated_at: user.Updated_at,
+			})
+		}
+		data["users"] = summaries
+	} else {
+		accts := []string{}
+		for _, user := range users {
+			accts = append(accts, user.Acct)
+		}
+		data["users"] = accts
 	}
 
-	data := map[string][]string{"users": accts}
 	WriteJsonResponse(StatusOK, data, w)
 }
 
@@ -73,11 +253,15 @@ func (ui *UI) Users(w http.ResponseWriter, _ *http.Request) {
 //////    GET /ui/v1/user?fullname={fullname}    //////
 ///////////////////////////////////////////////////////
 
-var FullnameQueryHdl QueryUserHandlerFunc = func(ui *UI, args ...interface{}) ([]pg.User, error) {
-	rows, err := ui.DB().
-		Table(pg.TableUsers.String()).
+// FullnameQueryHdl matches the fullname whatever its case or normalization.
+var FullnameQueryHdl QueryUserHandlerFunc = func(ctx context.Context, ui *UI, args ...interface{}) ([]pg.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.QueryTimeout)
+	defer cancel()
+
+	fullname, _ := args[0].(string)
+	rows, err := ui.whereFullname(usersOf(ctx, ui.readDB(ctx)), fullname).
 		Select(pg.FieldUserAcct.String()).
-		Where(pg.FieldUserFullname.String()+" = ?", args[0]).Rows()
+		Rows()
 	if err != nil {
 		return nil, err
 	}
@@ -87,11 +271,10 @@ var FullnameQueryHdl QueryUserHandlerFunc = func(ui *UI, args ...interface{}) ([
 
 func (ui *UI) FullnameQuery(w http.ResponseWriter, r *http.Request) {
 	fullname := r.URL.Query().Get(pg.FieldUserFullname.String())
-	users, err := FullnameQueryHdl(ui, fullname)
+	users, err := FullnameQueryHdl(r.Context(), ui, fullname)
 
 	if err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		WriteErrorResponse(err, w)
 		return
 	}
 
@@ -112,10 +295,13 @@ func (ui *UI) FullnameQuery(w http.ResponseWriter, r *http.Request) {
 //////    GET /ui/v1/user/{acct:[A-Za-z0-9_]{8,20}}}    //////
 //////////////////////////////////////////////////////////////
 
-var UserInfoHdl QueryUserHandlerFunc = func(ui *UI, args ...interface{}) ([]pg.User, error) {
-	rows, err := ui.DB().
-		Table(pg.TableUsers.String()).
-		Select("*").
+var UserInfoHdl QueryUserHandlerFunc = func(ctx context.Context, ui *UI, args ...interface{}) ([]pg.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.QueryTimeout)
+	defer cancel()
+
+	acct, _ := args[0].(string)
+	rows, err := usersOf(ctx, ui.readDB(ctx, acct)).
+		Select(profileFields).
 		Where(pg.FieldUserAcct.String()+" = ?", args[0]).
 		Limit(1).
 		Rows()
@@ -129,11 +315,15 @@ var UserInfoHdl QueryUserHandlerFunc = func(ui *UI, args ...interface{}) ([]pg.U
 func (ui *UI) UserInfo(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	acct := vars[pg.FieldUserAcct.String()]
-	users, err := UserInfoHdl(ui, acct)
+	if asOf := r.URL.Query().Get("as_of"); asOf != "" {
+		ui.userAsOf(w, r, acct, asOf)
+		return
+	}
+
+	users, err := UserInfoHdl(r.Context(), ui, acct)
 
 	if err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		WriteErrorResponse(err, w)
 		return
 	}
 
@@ -147,24 +337,106 @@ func (ui *UI) UserInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	WriteJsonResponse(StatusOK, users[0], w)
+	// the view depends on the caller, and so does its tag
+	audience := userAudience(r.Context(), &users[0])
+	etag := ViewETag(&users[0], audience)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Vary", "Authorization")
+	if notModified(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	WriteJsonResponse(StatusOK, UserView(&users[0], audience), w)
+}
+
+// userEvent is the payload of the outbox messages about a user. Passwords
+// never leave the service; Changed only names the fields an update set.
+type userEvent struct {
+	Acct       string   `json:"account"`
+	Fullname   string   `json:"fullname,omitempty"`
+	Attributes pg.JSONB `json:"attributes,omitempty"`
+	Changed    []string `json:"changed,omitempty"`
+
+	// Previous is the former account of a rename.
+	Previous string `json:"previous_account,omitempty"`
+}
+
+// bodyTenant returns r acting in the tenant named in the body of a signup
+// or login, the default tenant when there is none. An invalid tenant is an
+// error of the body, which is answered before anything acts in it.
+func (ui *UI) bodyTenant(tenant *string, aw *auditWriter, r *http.Request) *http.Request {
+	name := pg.DefaultTenant
+	if tenant != nil && validTenant.MatchString(*tenant) {
+		name = *tenant
+	}
+	aw.tenant = name
+	return r.WithContext(WithTenant(r.Context(), name))
+}
+
+// parseUser validates the attributes of a signup against the schema. It
+// returns the user, and its errors after errs, those of decoding it.
+func (ui *UI) parseUser(req *SignUpRequest, errs []FieldError) (pg.User, []FieldError) {
+	user := pg.User{Acct: req.Account, Pwd: req.Password, Fullname: req.Fullname}
+
+	attrs := req.Attributes
+	if attrs == nil {
+		attrs = map[string]interface{}{}
+	}
+	var violations []schema.Violation
+	if user.Attributes, violations = ui.parseAttributes(attrs); violations != nil {
+		errs = append(errs, attributeErrors(violations)...)
+	}
+
+	return user, errs
 }
 
 //////////////////////////////////////
 //////    POST /ui/v1/signup    //////
 //////////////////////////////////////
 
-var SignUpHdl AddUserHandlerFunc = func(ui *UI, user *pg.User) error {
+var SignUpHdl AddUserHandlerFunc = func(ctx context.Context, ui *UI, user *pg.User) error {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
 
-	if res := ui.DB().Table(pg.TableUsers.String()).Create(user); res.Error != nil {
-		err := res.Error
+	user.Tenant = Tenant(ctx)
+	stored, err := ui.sealUser(*user)
+	if err != nil {
+		return err
+	}
+	err = ui.transaction(ctx, func(tx *gorm.DB) error {
+		if err := checkAccount(ctx, tx, user.Acct, ""); err != nil {
+			return err
+		}
+		if res := tx.Table(pg.TableUsers.String()).Create(&stored); res.Error != nil {
+			err := res.Error
+			return err
+		}
+		if taken, err := aliasTaken(ctx, tx, user.Acct, ""); err != nil || taken {
+			if taken {
+				err = ErrAccountTaken
+			}
+			return err
+		}
+		return outbox.Enqueue(tx, outbox.UserCreated, user.Tenant, user.Acct, userEvent{
+			Acct:       user.Acct,
+			Fullname:   ui.eventFullname(user.Fullname),
+			Attributes: user.Attributes,
+		})
+	})
+	if err != nil {
 		return err
 	}
 
+	ui.markWritten(ctx, user.Acct)
 	return nil
 }
 
 func (ui *UI) SignUp(w http.ResponseWriter, r *http.Request) {
+	aw := ui.startAudit(w, r, AuditActionSignUp)
+	defer aw.finish()
+	w = aw
+
 	// TODO: check content-type
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -173,64 +445,44 @@ func (ui *UI) SignUp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	jsmap := map[string]interface{}{}
-	err = json.Unmarshal(body, &jsmap)
-	if err != nil {
-		log.Print(err)
-		WriteJsonResponse(StatusInvalidContent,
-			map[string]string{"error": err.Error()},
-			w,
-		)
-		return
+	req := SignUpRequest{}
+	errs := decodeRequest(body, &req)
+	r = ui.bodyTenant(req.Tenant, aw, r)
+	if req.Account != "" {
+		aw.event.Target = req.Account
 	}
-
-	var ok bool
-	user := pg.User{}
-
-	if user.Acct, ok = jsmap["account"].(string); !ok {
-		WriteJsonResponse(StatusInvalidContent, map[string]string{"missing_field": "account"}, w)
+	user, errs := ui.parseUser(&req, errs)
+	if len(errs) > 0 {
+		writeInvalidRequest(errs, w)
 		return
 	}
-
-	if user.Pwd, ok = jsmap["password"].(string); !ok {
-		WriteJsonResponse(StatusInvalidContent, map[string]string{"missing_field": "password"}, w)
+	if ui.reservedAccount(user.Acct) || ui.roleAccount(Tenant(r.Context()), user.Acct) {
+		writeAccountNotAllowed(user.Acct, &AccountPolicyError{Reason: AccountReserved}, w)
 		return
 	}
 
-	if user.Fullname, ok = jsmap["fullname"].(string); !ok {
-		WriteJsonResponse(StatusInvalidContent, map[string]string{"missing_field": "fullname"}, w)
-		return
-	}
-
-	// check valid acct, pwd and fullname
-	if !validAcctPwd.MatchString(user.Acct) {
-		WriteJsonResponse(StatusInvalidContent,
-			map[string]map[string]string{"invalid": {"field": "account", "value": user.Acct}}, w)
-		return
-	}
-	if !validAcctPwd.MatchString(user.Pwd) {
-		WriteJsonResponse(StatusInvalidContent,
-			map[string]map[string]string{"invalid": {"field": "password", "value": user.Pwd}}, w)
-		return
-	}
-	if user.Fullname == "" || len(user.Fullname) > pg.FieldUserFullnameMaxLen {
-		WriteJsonResponse(StatusInvalidContent,
-			map[string]map[string]string{"invalid": {"field": "fullname", "value": user.Fullname}}, w)
-		return
-	}
-
-	err = SignUpHdl(ui, &user)
+	err = SignUpHdl(r.Context(), ui, &user)
 	if err != nil {
-		// user has existed
-		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pq.ErrorCode("23505") {
+		// another account has it but for case, or looks alike
+		if writeAccountNotAllowed(user.Acct, err, w) {
+			return
+		}
+		// user has existed, or has been renamed from the account
+		if pqErr, ok := err.(*pq.Error); (ok && pqErr.Code == pq.ErrorCode("23505")) || errors.Is(err, ErrAccountTaken) {
 			WriteJsonResponse(StatusUserExisted, map[string]string{"user": user.Acct}, w)
 			return
 		}
+		// the tenant does not exist
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pq.ErrorCode("23503") {
+			WriteJsonResponse(StatusInvalidContent,
+				map[string]map[string]string{"invalid": {"field": "tenant", "value": user.Tenant}}, w)
+			return
+		}
 
-		log.Print(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		WriteErrorResponse(err, w)
 		return
 	}
+	aw.diff(nil, &user)
 
 	WriteJsonResponse(StatusOK, map[string]string{"user": user.Acct}, w)
 }
@@ -239,26 +491,59 @@ func (ui *UI) SignUp(w http.ResponseWriter, r *http.Request) {
 //////   DELETE /ui/v1/user/{acct:[A-Za-z0-9_]{8,20}}}   /////
 //////////////////////////////////////////////////////////////
 
-var DeleteHdl DeleteUserHandlerFunc = func(ui *UI, user *pg.User) error {
-	if res := ui.DB().
-		Table(pg.TableUsers.String()).
-		Delete(&pg.User{}, pg.FieldUserAcct.String()+" = ?", user.Acct); res.Error != nil {
-		err := res.Error
-		return err
+var DeleteHdl DeleteUserHandlerFunc = func(ctx context.Context, ui *UI, user *pg.User, pre *Precondition) (*pg.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
+
+	var before *pg.User
+	err := ui.transaction(ctx, func(tx *gorm.DB) error {
+		var err error
+		if before, err = lockUser(ctx, ui, tx, user.Acct, pre); err != nil || before == nil {
+			return err
+		}
+
+		if res := usersOf(ctx, tx).
+			Delete(&pg.User{}, pg.FieldUserAcct.String()+" = ?", user.Acct); res.Error != nil {
+			err := res.Error
+			return err
+		}
+		return outbox.Enqueue(tx, outbox.UserDeleted, before.Tenant, user.Acct, userEvent{Acct: user.Acct})
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	if before != nil {
+		ui.markWritten(ctx, user.Acct)
+	}
+	return before, nil
 }
 
 func (ui *UI) Delete(w http.ResponseWriter, r *http.Request) {
+	aw := ui.startAudit(w, r, AuditActionDelete)
+	defer aw.finish()
+	w = aw
+
 	vars := mux.Vars(r)
 	acct := vars[pg.FieldUserAcct.String()]
-	err := DeleteHdl(ui, &pg.User{Acct: acct})
+	aw.event.Target = acct
+
+	if ui.outranks(r.Context(), Tenant(r.Context()), acct) {
+		writeOutranked(acct, w)
+		return
+	}
+
+	pre, ok := ui.precondition(w, r)
+	if !ok {
+		return
+	}
+
+	before, err := DeleteHdl(r.Context(), ui, &pg.User{Acct: acct}, pre)
 
 	if err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		WriteErrorResponse(err, w)
 		return
 	}
+	aw.diff(before, nil)
 
 	WriteJsonResponse(StatusOK, map[string]string{"user": acct}, w)
 }
@@ -267,77 +552,130 @@ func (ui *UI) Delete(w http.ResponseWriter, r *http.Request) {
 //////   UPDATE /ui/v1/user/{acct:[A-Za-z0-9_]{8,20}}}   /////
 //////////////////////////////////////////////////////////////
 
-var UpdateHdl UpdateUserHandlerFunc = func(ui *UI, user *pg.User) error {
+var UpdateHdl UpdateUserHandlerFunc = func(ctx context.Context, ui *UI, user *pg.User, pre *Precondition) (*pg.User, error) {
 	values := map[string]interface{}{}
 	if user.Pwd != "" {
 		values[pg.FieldUserPwd.String()] = user.Pwd
 	}
 	if user.Fullname != "" {
-		values[pg.FieldUserFullname.String()] = user.Fullname
+		if err := ui.fullnameValues(user.Fullname, values); err != nil {
+			return nil, err
+		}
+	}
+	if user.Attributes != nil {
+		values[pg.FieldUserAttributes.String()] = user.Attributes
 	}
 	if len(values) == 0 {
-		return nil
+		return nil, nil
 	}
 
-	if res := ui.DB().
-		Table(pg.TableUsers.String()).
-		Where(pg.FieldUserAcct.String()+" = ?", user.Acct).
-		Updates(values); res.Error != nil {
-		err := res.Error
-		return err
-	}
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
 
-	return nil
+	var before *pg.User
+	err := ui.transaction(ctx, func(tx *gorm.DB) error {
+		var err error
+		if before, err = lockUser(ctx, ui, tx, user.Acct, pre); err != nil || before == nil {
+			return err
+		}
+
+		if res := usersOf(ctx, tx).
+			Where(pg.FieldUserAcct.String()+" = ?", user.Acct).
+			Updates(values); res.Error != nil {
+			err := res.Error
+			return err
+		}
+
+		event := userEvent{Acct: user.Acct, Fullname: ui.eventFullname(before.Fullname)}
+		if user.Fullname != "" {
+			event.Fullname = ui.eventFullname(user.Fullname)
+			event.Changed = append(event.Changed, "fullname")
+		}
+		if user.Pwd != "" {
+			event.Changed = append(event.Changed, "password")
+		}
+		if user.Attributes != nil {
+			event.Attributes = user.Attributes
+			event.Changed = append(event.Changed, "attributes")
+		}
+		return outbox.Enqueue(tx, outbox.UserUpdated, before.Tenant, user.Acct, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if before != nil {
+		ui.markWritten(ctx, user.Acct)
+	}
+	return before, nil
 }
 
 func (ui *UI) Update(w http.ResponseWriter, r *http.Request) {
+	aw := ui.startAudit(w, r, AuditActionUpdate)
+	defer aw.finish()
+	w = aw
+
 	// TODO: check content-type
 	vars := mux.Vars(r)
 	user := &pg.User{
 		Acct: vars[pg.FieldUserAcct.String()],
 	}
+	aw.event.Target = user.Acct
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusInternalServerError)
+	if ui.outranks(r.Context(), Tenant(r.Context()), user.Acct) {
+		writeOutranked(user.Acct, w)
 		return
 	}
 
-	jsmap := map[string]interface{}{}
-	err = json.Unmarshal(body, &jsmap)
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		log.Print(err)
-		WriteJsonResponse(StatusInvalidContent,
-			map[string]string{"error": err.Error()},
-			w,
-		)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	var ok bool
-	if user.Pwd, ok = jsmap["password"].(string); ok {
-		if !validAcctPwd.MatchString(user.Pwd) {
-			WriteJsonResponse(StatusInvalidContent,
-				map[string]map[string]string{"invalid": {"field": "password", "value": user.Pwd}}, w)
-			return
+	req := UpdateRequest{}
+	errs := decodeRequest(body, &req)
+	if req.Password != nil {
+		user.Pwd = *req.Password
+	}
+	if req.Fullname != nil {
+		user.Fullname = *req.Fullname
+	}
+	// the attributes are replaced as a whole
+	if req.Attributes != nil {
+		var violations []schema.Violation
+		if user.Attributes, violations = ui.parseAttributes(req.Attributes); violations != nil {
+			errs = append(errs, attributeErrors(violations)...)
 		}
 	}
+	if len(errs) > 0 {
+		writeInvalidRequest(errs, w)
+		return
+	}
 
-	if user.Fullname, ok = jsmap["fullname"].(string); ok {
-		if user.Fullname == "" || len(user.Fullname) > pg.FieldUserFullnameMaxLen {
-			WriteJsonResponse(StatusInvalidContent,
-				map[string]map[string]string{"invalid": {"field": "fullname", "value": user.Fullname}}, w)
-			return
-		}
+	pre, ok := ui.precondition(w, r)
+	if !ok {
+		return
 	}
 
-	err = UpdateHdl(ui, user)
+	before, err := UpdateHdl(r.Context(), ui, user, pre)
 	if err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		WriteErrorResponse(err, w)
 		return
 	}
+	if before != nil {
+		after := *before
+		if user.Pwd != "" {
+			after.Pwd = user.Pwd
+		}
+		if user.Fullname != "" {
+			after.Fullname = user.Fullname
+		}
+		if user.Attributes != nil {
+			after.Attributes = user.Attributes
+		}
+		aw.diff(before, &after)
+	}
 
 	WriteJsonResponse(StatusOK, nil, w)
 }
@@ -346,9 +684,12 @@ func (ui *UI) Update(w http.ResponseWriter, r *http.Request) {
 //////    POST /ui/v1/login     //////
 //////////////////////////////////////
 
-var LoginHdl QueryUserHandlerFunc = func(ui *UI, args ...interface{}) ([]pg.User, error) {
-	rows, err := ui.DB().
-		Table(pg.TableUsers.String()).
+var LoginHdl QueryUserHandlerFunc = func(ctx context.Context, ui *UI, args ...interface{}) ([]pg.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.QueryTimeout)
+	defer cancel()
+
+	acct, _ := args[0].(string)
+	rows, err := usersOf(ctx, ui.readDB(ctx, acct)).
 		Select(pg.FieldUserPwd.String()).
 		Where(pg.FieldUserAcct.String()+" = ?", args[0]).Rows()
 	if err != nil {
@@ -359,6 +700,10 @@ var LoginHdl QueryUserHandlerFunc = func(ui *UI, args ...interface{}) ([]pg.User
 }
 
 func (ui *UI) Login(w http.ResponseWriter, r *http.Request) {
+	aw := ui.startAudit(w, r, AuditActionLogin)
+	defer aw.finish()
+	w = aw
+
 	// TODO: check content-type
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -367,47 +712,21 @@ func (ui *UI) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	jsmap := map[string]interface{}{}
-	err = json.Unmarshal(body, &jsmap)
-	if err != nil {
-		log.Print(err)
-		WriteJsonResponse(StatusInvalidContent,
-			map[string]string{"error": err.Error()},
-			w,
-		)
-		return
-	}
-
-	var ok bool
-	user := pg.User{}
-
-	if user.Acct, ok = jsmap["account"].(string); !ok {
-		WriteJsonResponse(StatusInvalidContent, map[string]string{"missing_field": "account"}, w)
-		return
+	req := LoginRequest{}
+	errs := decodeRequest(body, &req)
+	r = ui.bodyTenant(req.Tenant, aw, r)
+	if req.Account != "" {
+		aw.event.Target = req.Account
 	}
-
-	if user.Pwd, ok = jsmap["password"].(string); !ok {
-		WriteJsonResponse(StatusInvalidContent, map[string]string{"missing_field": "password"}, w)
+	if len(errs) > 0 {
+		writeInvalidRequest(errs, w)
 		return
 	}
+	user := pg.User{Acct: req.Account, Pwd: req.Password}
 
-	// check valid acct, pwd and fullname
-	if !validAcctPwd.MatchString(user.Acct) {
-		WriteJsonResponse(StatusInvalidContent,
-			map[string]map[string]string{"invalid": {"field": "account", "value": user.Acct}}, w)
-		return
-	}
-
-	if !validAcctPwd.MatchString(user.Pwd) {
-		WriteJsonResponse(StatusInvalidContent,
-			map[string]map[string]string{"invalid": {"field": "password", "value": user.Pwd}}, w)
-		return
-	}
-
-	users, err := LoginHdl(ui, user.Acct)
+	users, err := LoginHdl(r.Context(), ui, user.Acct)
 	if err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		WriteErrorResponse(err, w)
 		return
 	}
 
@@ -430,12 +749,16 @@ func (ui *UI) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// JWT token return
-	token, err := secret.CreateUserJWT(user.Acct)
+	token, err := ui.issueJWT(r.Context(), user.Acct)
 	if err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		WriteErrorResponse(err, w)
 		return
 	}
 
+	// a login is not worth failing for its announcement
+	if err := RecordLoginHdl(r.Context(), ui, user.Acct); err != nil {
+		log.Print(err)
+	}
+
 	WriteJsonResponse(StatusOK, map[string]string{"user": user.Acct, "JWT": token}, w)
 }
@@ -2,16 +2,23 @@ package ui_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/schema"
 	"github.com/dontang97/ui/secret"
 	"github.com/dontang97/ui/ui"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -20,12 +27,20 @@ type _v1Suite struct {
 	UI *ui.UI
 
 	UsersHdl         ui.QueryUserHandlerFunc
+	UsersCountHdl    ui.CountUserHandlerFunc
 	FullnameQueryHdl ui.QueryUserHandlerFunc
 	UserInfoHdl      ui.QueryUserHandlerFunc
 	SignUpHdl        ui.AddUserHandlerFunc
 	DeleteHdl        ui.DeleteUserHandlerFunc
 	UpdateHdl        ui.UpdateUserHandlerFunc
 	LoginHdl         ui.QueryUserHandlerFunc
+	AuditHdl         ui.AuditHandlerFunc
+	PoolStatsHdl     ui.PoolStatsHandlerFunc
+	RecordLoginHdl   ui.RecordLoginHandlerFunc
+	MemberOfHdl      ui.MemberOfHandlerFunc
+
+	events []pg.AuditEvent
+	logins []string
 }
 
 func (s *_v1Suite) SetupSuite() {
@@ -38,35 +53,58 @@ func (s *_v1Suite) TearDownSuite() {
 
 func (s *_v1Suite) SetupTest() {
 	s.UsersHdl, ui.UsersHdl = ui.UsersHdl, nil
+	s.UsersCountHdl, ui.UsersCountHdl = ui.UsersCountHdl, nil
 	s.FullnameQueryHdl, ui.FullnameQueryHdl = ui.FullnameQueryHdl, nil
 	s.UserInfoHdl, ui.UserInfoHdl = ui.UserInfoHdl, nil
 	s.SignUpHdl, ui.SignUpHdl = ui.SignUpHdl, nil
 	s.DeleteHdl, ui.DeleteHdl = ui.DeleteHdl, nil
 	s.UpdateHdl, ui.UpdateHdl = ui.UpdateHdl, nil
 	s.LoginHdl, ui.LoginHdl = ui.LoginHdl, nil
+	s.PoolStatsHdl, ui.PoolStatsHdl = ui.PoolStatsHdl, nil
+	s.MemberOfHdl, ui.MemberOfHdl = ui.MemberOfHdl, func(context.Context, *ui.UI, string) ([]pg.Group, error) {
+		return nil, nil
+	}
+
+	s.logins = nil
+	s.RecordLoginHdl, ui.RecordLoginHdl = ui.RecordLoginHdl, func(_ context.Context, _ *ui.UI, acct string) error {
+		s.logins = append(s.logins, acct)
+		return nil
+	}
+
+	s.events = nil
+	s.AuditHdl, ui.AuditHdl = ui.AuditHdl, func(_ context.Context, _ *ui.UI, ev *pg.AuditEvent) error {
+		s.events = append(s.events, *ev)
+		return nil
+	}
 }
 
 func (s *_v1Suite) TearDownTest() {
 	ui.UsersHdl, s.UsersHdl = s.UsersHdl, nil
+	ui.UsersCountHdl, s.UsersCountHdl = s.UsersCountHdl, nil
 	ui.FullnameQueryHdl, s.FullnameQueryHdl = s.FullnameQueryHdl, nil
 	ui.UserInfoHdl, s.UserInfoHdl = s.UserInfoHdl, nil
 	ui.SignUpHdl, s.SignUpHdl = s.SignUpHdl, nil
 	ui.DeleteHdl, s.DeleteHdl = s.DeleteHdl, nil
 	ui.UpdateHdl, s.UpdateHdl = s.UpdateHdl, nil
 	ui.LoginHdl, s.LoginHdl = s.LoginHdl, nil
+	ui.AuditHdl, s.AuditHdl = s.AuditHdl, nil
+	ui.PoolStatsHdl, s.PoolStatsHdl = s.PoolStatsHdl, nil
+	ui.RecordLoginHdl, s.RecordLoginHdl = s.RecordLoginHdl, nil
+	ui.MemberOfHdl, s.MemberOfHdl = s.MemberOfHdl, nil
 }
 
 func (s *_v1Suite) TestUsers() {
 	// normal case
-	ui.UsersHdl = func(ui *ui.UI, args ...interface{}) ([]pg.User, error) {
+	ui.UsersHdl = func(_ context.Context, ui *ui.UI, args ...interface{}) ([]pg.User, error) {
 		return []pg.User{
 			{Acct: "User1"},
 			{Acct: "User2"},
 		}, nil
 	}
 
+	req := httptest.NewRequest(http.MethodGet, "http://test.com", nil)
 	rcd := httptest.NewRecorder()
-	http.HandlerFunc(s.UI.Users).ServeHTTP(rcd, nil)
+	http.HandlerFunc(s.UI.Users).ServeHTTP(rcd, req)
 	s.Equal(http.StatusOK, rcd.Code)
 
 	body := map[string]interface{}{}
@@ -76,18 +114,91 @@ func (s *_v1Suite) TestUsers() {
 	s.Equal([]interface{}([]interface{}{"User1", "User2"}), v["users"])
 
 	// error case
-	ui.UsersHdl = func(ui *ui.UI, args ...interface{}) ([]pg.User, error) {
+	ui.UsersHdl = func(_ context.Context, ui *ui.UI, args ...interface{}) ([]pg.User, error) {
 		return nil, errors.New("mock error")
 	}
 	rcd = httptest.NewRecorder()
-	http.HandlerFunc(s.UI.Users).ServeHTTP(rcd, nil)
+	http.HandlerFunc(s.UI.Users).ServeHTTP(rcd, req)
 	s.Equal(http.StatusInternalServerError, rcd.Code)
 	s.Equal("", rcd.Body.String())
+
+	// timeout case
+	ui.UsersHdl = func(_ context.Context, ui *ui.UI, args ...interface{}) ([]pg.User, error) {
+		return nil, fmt.Errorf("%w: mock error", context.DeadlineExceeded)
+	}
+	rcd = httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Users).ServeHTTP(rcd, req)
+	s.Equal(http.StatusGatewayTimeout, rcd.Code)
+
+	// canceled case
+	ctx, cancel := context.WithCancel(context.Background())
+	ui.UsersHdl = func(ctx context.Context, ui *ui.UI, args ...interface{}) ([]pg.User, error) {
+		cancel()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	rcd = httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Users).ServeHTTP(rcd, req.WithContext(ctx))
+	s.Equal(499, rcd.Code)
+}
+
+func (s *_v1Suite) TestUsersPagination() {
+	var query *ui.UserListQuery
+	ui.UsersHdl = func(_ context.Context, _ *ui.UI, args ...interface{}) ([]pg.User, error) {
+		query = args[0].(*ui.UserListQuery)
+		return []pg.User{
+			{Acct: "User1", Fullname: "Fullname1", Pwd: "Pwd1"},
+			{Acct: "User2", Fullname: "Fullname2", Pwd: "Pwd2"},
+			{Acct: "User3", Fullname: "Fullname3", Pwd: "Pwd3"},
+		}, nil
+	}
+	ui.UsersCountHdl = func(context.Context, *ui.UI, ...interface{}) (int, error) {
+		return 42, nil
+	}
+
+	req := httptest.NewRequest(http.MethodGet,
+		"http://test.com/ui/v1/users?limit=2&sort=-created_at&prefix=User&count=true&view=summary", nil)
+	rcd := httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Users).ServeHTTP(rcd, req)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal(2, query.Limit)
+	s.Equal(pg.FieldUserCreatedAt, query.Sort)
+	s.Equal(true, query.Desc)
+	s.Equal("User", query.Prefix)
+	s.NotContains(rcd.Body.String(), "Pwd")
+
+	body := map[string]interface{}{}
+	err := json.Unmarshal(rcd.Body.Bytes(), &body)
+	s.Equal(nil, err)
+	v := body["data"].(map[string]interface{})
+	s.Equal(float64(42), v["total"])
+	users := v["users"].([]interface{})
+	s.Equal(2, len(users))
+	s.Equal("Fullname2", users[1].(map[string]interface{})["fullname"])
+
+	// follow the next link
+	next, err := url.Parse(v["next"].(string))
+	s.Equal(nil, err)
+	s.Equal("/ui/v1/users", next.Path)
+	req = httptest.NewRequest(http.MethodGet, "http://test.com"+next.RequestURI(), nil)
+	rcd = httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Users).ServeHTTP(rcd, req)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal("User2", query.After.Acct)
+	s.Equal(time.Time{}.Format(time.RFC3339Nano), query.After.Value)
+
+	// invalid parameters
+	for _, q := range []string{"limit=0", "limit=abc", "sort=pwd", "cursor=???", "prefix=a%25", "view=full"} {
+		req = httptest.NewRequest(http.MethodGet, "http://test.com/ui/v1/users?"+q, nil)
+		rcd = httptest.NewRecorder()
+		http.HandlerFunc(s.UI.Users).ServeHTTP(rcd, req)
+		s.Equal(http.StatusBadRequest, rcd.Code, q)
+	}
 }
 
 func (s *_v1Suite) TestFullnameQuery() {
 	// normal case
-	ui.FullnameQueryHdl = func(ui *ui.UI, args ...interface{}) ([]pg.User, error) {
+	ui.FullnameQueryHdl = func(_ context.Context, ui *ui.UI, args ...interface{}) ([]pg.User, error) {
 		return []pg.User{
 			{Acct: "User1"},
 			{Acct: "User2"},
@@ -107,7 +218,7 @@ func (s *_v1Suite) TestFullnameQuery() {
 	s.Equal([]interface{}([]interface{}{"User1", "User2"}), v["users"])
 
 	// error case
-	ui.FullnameQueryHdl = func(ui *ui.UI, args ...interface{}) ([]pg.User, error) {
+	ui.FullnameQueryHdl = func(_ context.Context, ui *ui.UI, args ...interface{}) ([]pg.User, error) {
 		return nil, errors.New("mock error")
 	}
 	rcd = httptest.NewRecorder()
@@ -118,7 +229,7 @@ func (s *_v1Suite) TestFullnameQuery() {
 
 func (s *_v1Suite) TestUserInfo() {
 	// normal case
-	ui.UserInfoHdl = func(ui *ui.UI, args ...interface{}) ([]pg.User, error) {
+	ui.UserInfoHdl = func(_ context.Context, ui *ui.UI, args ...interface{}) ([]pg.User, error) {
 		return []pg.User{
 			{
 				Acct:       "User1",
@@ -140,13 +251,14 @@ func (s *_v1Suite) TestUserInfo() {
 	s.Equal(nil, err)
 	v := body["data"].(map[string]interface{})
 	s.Equal(interface{}("User1"), v["account"])
-	s.Equal(interface{}("Pwd1"), v["password"])
 	s.Equal(interface{}("Fullname1"), v["fullname"])
+	s.NotContains(v, "password")
+	s.NotContains(rcd.Body.String(), "Pwd1")
 	//s.Equal(interface{}(time.Time{}.String()), v["created_at"])
 	//s.Equal(interface{}(time.Time{}.String()), v["updated_at"])
 
 	// error case
-	ui.FullnameQueryHdl = func(ui *ui.UI, args ...interface{}) ([]pg.User, error) {
+	ui.FullnameQueryHdl = func(_ context.Context, ui *ui.UI, args ...interface{}) ([]pg.User, error) {
 		return nil, errors.New("mock error")
 	}
 	rcd = httptest.NewRecorder()
@@ -155,9 +267,98 @@ func (s *_v1Suite) TestUserInfo() {
 	s.Equal("", rcd.Body.String())
 }
 
+func (s *_v1Suite) TestUserInfoViews() {
+	at := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	ui.UserInfoHdl = func(context.Context, *ui.UI, ...interface{}) ([]pg.User, error) {
+		return []pg.User{{
+			Tenant:     pg.DefaultTenant,
+			Acct:       "kobe_bryant",
+			Pwd:        "secret_pwd",
+			Fullname:   "Kobe",
+			Created_at: at,
+			Updated_at: at,
+			Attributes: pg.JSONB(`{"team": "lakers"}`),
+		}}, nil
+	}
+
+	for _, c := range []struct {
+		claims *secret.UserClaims
+		tenant string
+		keys   []string
+	}{
+		{nil, "", []string{"account", "fullname"}},
+		{&secret.UserClaims{Acct: "lebron_james"}, "", []string{"account", "fullname"}},
+		// the namesake of another tenant
+		{&secret.UserClaims{Acct: "kobe_bryant"}, "celtics", []string{"account", "fullname"}},
+		{&secret.UserClaims{Acct: "kobe_bryant"}, "", []string{"account", "fullname", "created_at", "updated_at", "attributes"}},
+		{&secret.UserClaims{Acct: "lebron_james", Roles: []string{secret.RoleAdmin}}, "",
+			[]string{"tenant", "account", "fullname", "created_at", "updated_at", "attributes"}},
+		{&secret.UserClaims{Acct: "lebron_james", Roles: []string{secret.RoleSuperAdmin}}, "celtics",
+			[]string{"tenant", "account", "fullname", "created_at", "updated_at", "attributes"}},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://test.com/ui/v1/user/kobe_bryant", nil)
+		req = mux.SetURLVars(req, map[string]string{pg.FieldUserAcct.String(): "kobe_bryant"})
+		if c.claims != nil {
+			req = req.WithContext(secret.NewContext(req.Context(), c.claims))
+		}
+		if c.tenant != "" {
+			req = req.WithContext(ui.WithTenant(req.Context(), c.tenant))
+		}
+		rcd := httptest.NewRecorder()
+		http.HandlerFunc(s.UI.UserInfo).ServeHTTP(rcd, req)
+		s.Equal(http.StatusOK, rcd.Code)
+		s.NotContains(rcd.Body.String(), "secret_pwd")
+
+		body := struct {
+			Data map[string]interface{} `json:"data"`
+		}{}
+		s.Equal(nil, json.Unmarshal(rcd.Body.Bytes(), &body))
+		keys := []string{}
+		for key := range body.Data {
+			keys = append(keys, key)
+		}
+		s.ElementsMatch(c.keys, keys, c.claims)
+	}
+}
+
+// TestNoPasswordInResponses sends passwords to every handler that reads
+// one, and checks that none is answered with.
+func (s *_v1Suite) TestNoPasswordInResponses() {
+	ui.SignUpHdl = func(context.Context, *ui.UI, *pg.User) error {
+		return &pq.Error{Code: "23505"}
+	}
+	ui.UpdateHdl = func(_ context.Context, _ *ui.UI, user *pg.User, _ *ui.Precondition) (*pg.User, error) {
+		return user, nil
+	}
+	ui.LoginHdl = func(context.Context, *ui.UI, ...interface{}) ([]pg.User, error) {
+		return []pg.User{{Acct: "kobe_bryant", Pwd: "stored_pwd"}}, nil
+	}
+
+	for _, c := range []struct {
+		hdl  http.HandlerFunc
+		body string
+	}{
+		{s.UI.SignUp, `{"account": "kobe_bryant", "password": "signup_pwd", "fullname": "Kobe"}`},
+		{s.UI.SignUp, `{"account": "kobe_bryant", "password": "signup pwd!", "fullname": "Kobe"}`},
+		{s.UI.SignUp, `{"account": "kobe_bryant", "password": "signup_pwd", "fullname": "Kobe"} "signup_pwd"`},
+		{s.UI.Update, `{"password": "update_pwd"}`},
+		{s.UI.Update, `{"password": "update pwd!"}`},
+		{s.UI.Login, `{"account": "kobe_bryant", "password": "login_pwd"}`},
+		{s.UI.Login, `{"account": "kobe_bryant", "password": "login pwd!"}`},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "http://test.com/ui/v1/user/kobe_bryant", bytes.NewBufferString(c.body))
+		req = mux.SetURLVars(req, map[string]string{pg.FieldUserAcct.String(): "kobe_bryant"})
+		rcd := httptest.NewRecorder()
+		c.hdl.ServeHTTP(rcd, req)
+		for _, pwd := range []string{"stored_pwd", "signup_pwd", "signup pwd!", "update_pwd", "update pwd!", "login_pwd", "login pwd!"} {
+			s.NotContains(rcd.Body.String(), pwd, c.body)
+		}
+	}
+}
+
 func (s *_v1Suite) TestSignUp() {
 	// normal case
-	ui.SignUpHdl = func(ui *ui.UI, user *pg.User) error {
+	ui.SignUpHdl = func(_ context.Context, ui *ui.UI, user *pg.User) error {
 		return nil
 	}
 
@@ -181,7 +382,7 @@ func (s *_v1Suite) TestSignUp() {
 	s.Equal(http.StatusOK, rcd.Code)
 
 	// error case
-	ui.SignUpHdl = func(ui *ui.UI, user *pg.User) error {
+	ui.SignUpHdl = func(_ context.Context, ui *ui.UI, user *pg.User) error {
 		return errors.New("mock error")
 	}
 
@@ -194,8 +395,8 @@ func (s *_v1Suite) TestSignUp() {
 
 func (s *_v1Suite) TestDelete() {
 	// normal case
-	ui.DeleteHdl = func(ui *ui.UI, user *pg.User) error {
-		return nil
+	ui.DeleteHdl = func(_ context.Context, ui *ui.UI, user *pg.User, _ *ui.Precondition) (*pg.User, error) {
+		return nil, nil
 	}
 	req := httptest.NewRequest(http.MethodDelete, "http://test.com", nil)
 	rcd := httptest.NewRecorder()
@@ -204,8 +405,8 @@ func (s *_v1Suite) TestDelete() {
 	s.Equal(http.StatusOK, rcd.Code)
 
 	// error case
-	ui.DeleteHdl = func(ui *ui.UI, user *pg.User) error {
-		return errors.New("mock error")
+	ui.DeleteHdl = func(_ context.Context, ui *ui.UI, user *pg.User, _ *ui.Precondition) (*pg.User, error) {
+		return nil, errors.New("mock error")
 	}
 	req = httptest.NewRequest(http.MethodDelete, "http://test.com", nil)
 	rcd = httptest.NewRecorder()
@@ -216,8 +417,8 @@ func (s *_v1Suite) TestDelete() {
 
 func (s *_v1Suite) TestUpdate() {
 	// normal case
-	ui.UpdateHdl = func(ui *ui.UI, user *pg.User) error {
-		return nil
+	ui.UpdateHdl = func(_ context.Context, ui *ui.UI, user *pg.User, _ *ui.Precondition) (*pg.User, error) {
+		return nil, nil
 	}
 	user := struct {
 		Pwd      string `json:"password"`
@@ -237,8 +438,8 @@ func (s *_v1Suite) TestUpdate() {
 	s.Equal(http.StatusOK, rcd.Code)
 
 	// error case
-	ui.UpdateHdl = func(ui *ui.UI, user *pg.User) error {
-		return errors.New("mock error")
+	ui.UpdateHdl = func(_ context.Context, ui *ui.UI, user *pg.User, _ *ui.Precondition) (*pg.User, error) {
+		return nil, errors.New("mock error")
 	}
 	req = httptest.NewRequest(http.MethodPut, "http://test.com/", bytes.NewBuffer(js))
 	rcd = httptest.NewRecorder()
@@ -247,9 +448,397 @@ func (s *_v1Suite) TestUpdate() {
 	s.Equal(http.StatusInternalServerError, rcd.Code)
 }
 
+func (s *_v1Suite) TestFullnameUnicode() {
+	var added *pg.User
+	ui.SignUpHdl = func(_ context.Context, _ *ui.UI, user *pg.User) error {
+		added = user
+		return nil
+	}
+	signUp := func(fullname string) int {
+		js, err := json.Marshal(map[string]string{"account": "123456789", "password": "123456789", "fullname": fullname})
+		s.Equal(nil, err)
+		req := httptest.NewRequest(http.MethodPost, "http://test.com", bytes.NewBuffer(js))
+		rcd := httptest.NewRecorder()
+		http.HandlerFunc(s.UI.SignUp).ServeHTTP(rcd, req)
+		return rcd.Code
+	}
+
+	// 50 CJK characters are 150 bytes, but 50 graphemes
+	s.Equal(http.StatusOK, signUp(strings.Repeat("布", 50)))
+	s.Equal(http.StatusBadRequest, signUp(strings.Repeat("布", 51)))
+	// a family emoji of seven code points is one grapheme
+	s.Equal(http.StatusOK, signUp(strings.Repeat("👨\u200d👩\u200d👧\u200d👦", 50)))
+
+	// stored in NFC
+	s.Equal(http.StatusOK, signUp("Zoe\u0301 Saldan\u0303a"))
+	s.Equal("Zo\u00e9 Salda\u00f1a", added.Fullname)
+
+	for _, fullname := range []string{"", "Kobe\nBryant", "Kobe\u0000", "Kobe \u202eBryant", "\u2067Kobe"} {
+		s.Equal(http.StatusBadRequest, signUp(fullname), fullname)
+	}
+
+	var updated *pg.User
+	ui.UpdateHdl = func(_ context.Context, _ *ui.UI, user *pg.User, _ *ui.Precondition) (*pg.User, error) {
+		updated = user
+		return &pg.User{Acct: user.Acct}, nil
+	}
+	update := func(fullname string) int {
+		js, err := json.Marshal(map[string]string{"fullname": fullname})
+		s.Equal(nil, err)
+		req := httptest.NewRequest(http.MethodPut, "http://test.com/", bytes.NewBuffer(js))
+		rcd := httptest.NewRecorder()
+		http.HandlerFunc(s.UI.Update).ServeHTTP(rcd, req)
+		return rcd.Code
+	}
+
+	s.Equal(http.StatusOK, update("A\u030angstro\u0308m"))
+	s.Equal("\u00c5ngstr\u00f6m", updated.Fullname)
+	s.Equal(http.StatusBadRequest, update(strings.Repeat("é", 51)))
+	s.Equal(http.StatusBadRequest, update("Kobe\u202dBryant"))
+}
+
+func (s *_v1Suite) TestSignUpAccountPolicy() {
+	var conflict error
+	ui.SignUpHdl = func(_ context.Context, _ *ui.UI, user *pg.User) error {
+		return conflict
+	}
+	signUp := func(acct string) (int, map[string]interface{}) {
+		js, err := json.Marshal(map[string]string{"account": acct, "password": "123456789", "fullname": "Kobe"})
+		s.Equal(nil, err)
+		req := httptest.NewRequest(http.MethodPost, "http://test.com", bytes.NewBuffer(js))
+		rcd := httptest.NewRecorder()
+		http.HandlerFunc(s.UI.SignUp).ServeHTTP(rcd, req)
+		resp := map[string]interface{}{}
+		s.Equal(nil, json.Unmarshal(rcd.Body.Bytes(), &resp))
+		data, _ := resp["data"].(map[string]interface{})
+		return rcd.Code, data
+	}
+
+	// reserved but for case, underscores, trailing digits and look-alikes
+	for _, acct := range []string{"Administrator", "admin_2024", "r00t_0001", "Sup_Port", "5uperuser", "adm1n_001"} {
+		code, data := signUp(acct)
+		s.Equal(http.StatusUnprocessableEntity, code, acct)
+		s.Equal(ui.AccountReserved, data["reason"], acct)
+	}
+	code, _ := signUp("rootbeer_lover")
+	s.Equal(http.StatusOK, code)
+
+	// the reserved names are configurable
+	s.UI.ReservedAccounts = []string{"kobe_bryant"}
+	defer func() { s.UI.ReservedAccounts = ui.DefaultReservedAccounts }()
+	code, _ = signUp("KobeBryant24")
+	s.Equal(http.StatusUnprocessableEntity, code)
+	code, _ = signUp("administrator")
+	s.Equal(http.StatusOK, code)
+
+	// the accounts granted roles by name, in their tenant only
+	s.UI.Admins["kobe_admin"] = true
+	s.UI.SuperAdmins["lakers/kobe_super"] = true
+	defer delete(s.UI.Admins, "kobe_admin")
+	defer delete(s.UI.SuperAdmins, "lakers/kobe_super")
+	code, data := signUp("kobe_admin")
+	s.Equal(http.StatusUnprocessableEntity, code)
+	s.Equal(ui.AccountReserved, data["reason"])
+	code, _ = signUp("kobe_super")
+	s.Equal(http.StatusOK, code)
+	js := []byte(`{"tenant": "lakers", "account": "kobe_super", "password": "123456789", "fullname": "Kobe"}`)
+	req := httptest.NewRequest(http.MethodPost, "http://test.com", bytes.NewBuffer(js))
+	rcd := httptest.NewRecorder()
+	http.HandlerFunc(s.UI.SignUp).ServeHTTP(rcd, req)
+	s.Equal(http.StatusUnprocessableEntity, rcd.Code)
+
+	// an account differing in case, or looking alike
+	for _, reason := range []string{ui.AccountCaseConflict, ui.AccountConfusable} {
+		conflict = &ui.AccountPolicyError{Reason: reason}
+		code, data := signUp("magic_johnson")
+		s.Equal(http.StatusUnprocessableEntity, code)
+		s.Equal(reason, data["reason"])
+		s.Equal("magic_johnson", data["user"])
+	}
+}
+
+// invalidRequest serves body to hdl and returns the rules it breaks, as
+// field/rule[path].
+func (s *_v1Suite) invalidRequest(hdl http.HandlerFunc, body string) []string {
+	req := httptest.NewRequest(http.MethodPost, "http://test.com", bytes.NewBufferString(body))
+	rcd := httptest.NewRecorder()
+	hdl.ServeHTTP(rcd, req)
+	s.Equal(http.StatusBadRequest, rcd.Code, body)
+
+	resp := struct {
+		Data ui.InvalidRequest `json:"data"`
+	}{}
+	s.Equal(nil, json.Unmarshal(rcd.Body.Bytes(), &resp))
+	rules := []string{}
+	for _, fe := range resp.Data.Errors {
+		s.NotEqual("", fe.Message)
+		rules = append(rules, fe.Field+"/"+fe.Rule+fe.Path)
+	}
+	return rules
+}
+
+func (s *_v1Suite) TestSignUpErrors() {
+	ui.SignUpHdl = func(context.Context, *ui.UI, *pg.User) error {
+		s.Fail("an invalid signup is not applied")
+		return nil
+	}
+	signUp := http.HandlerFunc(s.UI.SignUp)
+
+	// every error at once, those of the body first
+	s.Equal([]string{"nickname/unknown_field", "tenant/pattern", "account/required", "password/type", "fullname/length"},
+		s.invalidRequest(signUp, `{"tenant": "Lakers!", "password": 123456789, "fullname": "", "nickname": "Mamba"}`))
+	s.Equal([]string{"account/duplicate_key", "attributes/duplicate_key/team", "account/pattern", "attributes/schema"},
+		s.invalidRequest(signUp, `{"account": "kobe_bryant", "account": "kobe", "password": "123456789",
+			"fullname": "Kobe", "attributes": {"team": "lakers", "team": "celtics"}}`))
+	s.Equal([]string{"/trailing_data"},
+		s.invalidRequest(signUp, `{"account": "kobe_bryant", "password": "123456789", "fullname": "Kobe"} {}`))
+	// the audit keeps the account of an invalid signup
+	s.Equal("kobe_bryant", s.events[len(s.events)-1].Target)
+	s.Equal([]string{"fullname/type", "attributes/type"},
+		s.invalidRequest(signUp, `{"account": "kobe_bryant", "password": "123456789", "fullname": null, "attributes": null}`))
+	s.Equal([]string{"fullname/charset"},
+		s.invalidRequest(signUp, `{"account": "kobe_bryant", "password": "123456789", "fullname": "Kobe\u202eBryant"}`))
+	// the whole account and password match the pattern, not a part of them
+	s.Equal([]string{"account/pattern", "password/pattern"},
+		s.invalidRequest(signUp, `{"account": "a b!!abcdefgh", "password": "12345678 9!", "fullname": "Kobe"}`))
+	s.Equal([]string{"account/pattern", "password/pattern"},
+		s.invalidRequest(signUp, `{"account": "`+strings.Repeat("a", 200)+`", "password": "`+strings.Repeat("1", 21)+`", "fullname": "Kobe"}`))
+	s.Equal([]string{"/syntax"}, s.invalidRequest(signUp, `{"account": "kobe_bryant",`))
+	s.Equal([]string{"/syntax"}, s.invalidRequest(signUp, ``))
+	s.Equal([]string{"/type"}, s.invalidRequest(signUp, `["kobe_bryant"]`))
+}
+
+func (s *_v1Suite) TestRoleAccounts() {
+	s.UI.SuperAdmins["kobe_bryant"] = true
+	s.UI.Admins["magic_johnson"] = true
+	defer delete(s.UI.SuperAdmins, "kobe_bryant")
+	defer delete(s.UI.Admins, "magic_johnson")
+
+	var acted []string
+	ui.UpdateHdl = func(_ context.Context, _ *ui.UI, user *pg.User, _ *ui.Precondition) (*pg.User, error) {
+		acted = append(acted, "update:"+user.Acct)
+		return &pg.User{Acct: user.Acct}, nil
+	}
+	ui.DeleteHdl = func(_ context.Context, _ *ui.UI, user *pg.User, _ *ui.Precondition) (*pg.User, error) {
+		acted = append(acted, "delete:"+user.Acct)
+		return &pg.User{Acct: user.Acct}, nil
+	}
+	erase := ui.EraseUserHdl
+	defer func() { ui.EraseUserHdl = erase }()
+	ui.EraseUserHdl = func(_ context.Context, _ *ui.UI, acct string) (string, error) {
+		acted = append(acted, "erase:"+acct)
+		return "erased_1", nil
+	}
+
+	serve := func(hdl http.HandlerFunc, method, acct string, claims *secret.UserClaims) int {
+		req := httptest.NewRequest(method, "http://test.com", bytes.NewBufferString(`{"password": "123456789"}`))
+		req = mux.SetURLVars(req, map[string]string{pg.FieldUserAcct.String(): acct})
+		req = req.WithContext(secret.NewContext(req.Context(), claims))
+		rcd := httptest.NewRecorder()
+		hdl.ServeHTTP(rcd, req)
+		return rcd.Code
+	}
+
+	// the accounts the operator grants a role are out of reach of whoever
+	// does not hold it
+	admin := &secret.UserClaims{Acct: "jerry_buss", Roles: []string{secret.RoleAdmin}}
+	for _, c := range []struct {
+		acct   string
+		claims *secret.UserClaims
+		code   int
+	}{
+		{"kobe_bryant", admin, http.StatusUnauthorized},
+		{"magic_johnson", &secret.UserClaims{Acct: "lebron_james"}, http.StatusUnauthorized},
+		{"magic_johnson", admin, http.StatusOK},
+		{"kobe_bryant", &secret.UserClaims{Acct: "kobe_bryant", Roles: []string{secret.RoleSuperAdmin}}, http.StatusOK},
+		{"lebron_james", admin, http.StatusOK},
+	} {
+		acted = nil
+		for _, hdl := range []struct {
+			f      http.HandlerFunc
+			method string
+		}{{s.UI.Update, http.MethodPut}, {s.UI.Delete, http.MethodDelete}, {s.UI.EraseUser, http.MethodDelete}} {
+			s.Equal(c.code, serve(hdl.f, hdl.method, c.acct, c.claims), c.acct)
+		}
+		if c.code == http.StatusOK {
+			s.Equal([]string{"update:" + c.acct, "delete:" + c.acct, "erase:" + c.acct}, acted)
+		} else {
+			s.Nil(acted)
+		}
+	}
+}
+
+func (s *_v1Suite) TestUpdateErrors() {
+	ui.UpdateHdl = func(context.Context, *ui.UI, *pg.User, *ui.Precondition) (*pg.User, error) {
+		s.Fail("an invalid update is not applied")
+		return nil, nil
+	}
+	update := http.HandlerFunc(s.UI.Update)
+
+	s.Equal([]string{"account/unknown_field", "password/pattern", "fullname/type", "attributes/schema"},
+		s.invalidRequest(update, `{"account": "kobe_bryant", "password": "short", "fullname": 24, "attributes": {"team": "lakers"}}`))
+	s.Equal([]string{"/trailing_data"}, s.invalidRequest(update, `{"fullname": "Kobe"}x`))
+}
+
+func (s *_v1Suite) TestLoginErrors() {
+	ui.LoginHdl = func(context.Context, *ui.UI, ...interface{}) ([]pg.User, error) {
+		s.Fail("an invalid login is not tried")
+		return nil, nil
+	}
+	login := http.HandlerFunc(s.UI.Login)
+
+	s.Equal([]string{"account/required", "password/required"}, s.invalidRequest(login, `{}`))
+	s.Equal([]string{"fullname/unknown_field", "account/pattern"},
+		s.invalidRequest(login, `{"account": "kobe", "password": "123456789", "fullname": "Kobe"}`))
+}
+
+const attributesSchema = `{
+	"type": "object",
+	"properties": {
+		"department": {"type": "string", "enum": ["sales", "engineering"]},
+		"locale": {"type": "string", "pattern": "^[a-z]{2}-[A-Z]{2}$"},
+		"level": {"type": "integer", "minimum": 1},
+		"remote": {"type": "boolean"}
+	},
+	"required": ["department"],
+	"additionalProperties": false
+}`
+
+func (s *_v1Suite) TestSignUpAttributes() {
+	var added *pg.User
+	ui.SignUpHdl = func(_ context.Context, _ *ui.UI, user *pg.User) error {
+		added = user
+		return nil
+	}
+	signUp := func(body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, "http://test.com", bytes.NewBufferString(body))
+		rcd := httptest.NewRecorder()
+		http.HandlerFunc(s.UI.SignUp).ServeHTTP(rcd, req)
+		resp := map[string]interface{}{}
+		s.Equal(nil, json.Unmarshal(rcd.Body.Bytes(), &resp))
+		return rcd, resp
+	}
+
+	// without a schema users have no attributes
+	rcd, _ := signUp(`{"account": "123456789", "password": "123456789", "fullname": "Kobe"}`)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal(pg.JSONB(`{}`), added.Attributes)
+	rcd, _ = signUp(`{"account": "123456789", "password": "123456789", "fullname": "Kobe", "attributes": {"department": "sales"}}`)
+	s.Equal(http.StatusBadRequest, rcd.Code)
+
+	sch, err := schema.Parse([]byte(attributesSchema))
+	s.Equal(nil, err)
+	s.UI.AttributeSchema = sch
+	defer func() { s.UI.AttributeSchema = nil }()
+
+	rcd, _ = signUp(`{"account": "123456789", "password": "123456789", "fullname": "Kobe",
+		"attributes": {"department": "sales", "locale": "en-US", "level": 3}}`)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.JSONEq(`{"department": "sales", "locale": "en-US", "level": 3}`, string(added.Attributes))
+
+	rcd, resp := signUp(`{"account": "123456789", "password": "123456789", "fullname": "Kobe",
+		"attributes": {"locale": "english", "level": 0.5, "phone": "555"}}`)
+	s.Equal(http.StatusBadRequest, rcd.Code)
+	data := resp["data"].(map[string]interface{})
+	paths := []string{}
+	for _, v := range data["errors"].([]interface{}) {
+		s.Equal("attributes", v.(map[string]interface{})["field"])
+		s.Equal(ui.RuleSchema, v.(map[string]interface{})["rule"])
+		paths = append(paths, v.(map[string]interface{})["path"].(string))
+	}
+	s.Equal([]string{"/department", "/level", "/locale", "/phone"}, paths)
+
+	rcd, _ = signUp(`{"account": "123456789", "password": "123456789", "fullname": "Kobe", "attributes": ["sales"]}`)
+	s.Equal(http.StatusBadRequest, rcd.Code)
+}
+
+// TestSignUpLargeAttributes signs up attributes larger than a notification
+// of the user events may be, but within MaxAttributesSize.
+func (s *_v1Suite) TestSignUpLargeAttributes() {
+	var added *pg.User
+	ui.SignUpHdl = func(_ context.Context, _ *ui.UI, user *pg.User) error {
+		added = user
+		return nil
+	}
+	sch, err := schema.Parse([]byte(`{"type": "object", "properties": {"notes": {"type": "string"}}}`))
+	s.Equal(nil, err)
+	s.UI.AttributeSchema = sch
+	defer func() { s.UI.AttributeSchema = nil }()
+
+	notes := strings.Repeat("x", 10<<10)
+	js := `{"account": "123456789", "password": "123456789", "fullname": "Kobe", "attributes": {"notes": "` + notes + `"}}`
+	req := httptest.NewRequest(http.MethodPost, "http://test.com", bytes.NewBufferString(js))
+	rcd := httptest.NewRecorder()
+	http.HandlerFunc(s.UI.SignUp).ServeHTTP(rcd, req)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal(pg.JSONB(`{"notes":"`+notes+`"}`), added.Attributes)
+}
+
+func (s *_v1Suite) TestUpdateAttributes() {
+	sch, err := schema.Parse([]byte(attributesSchema))
+	s.Equal(nil, err)
+	s.UI.AttributeSchema = sch
+	defer func() { s.UI.AttributeSchema = nil }()
+
+	var updated *pg.User
+	ui.UpdateHdl = func(_ context.Context, _ *ui.UI, user *pg.User, _ *ui.Precondition) (*pg.User, error) {
+		updated = user
+		return &pg.User{Acct: user.Acct, Attributes: pg.JSONB(`{"department": "sales"}`)}, nil
+	}
+
+	// the attributes are replaced as a whole
+	req := httptest.NewRequest(http.MethodPut, "http://test.com/",
+		bytes.NewBufferString(`{"attributes": {"department": "engineering", "remote": true}}`))
+	rcd := httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Update).ServeHTTP(rcd, req)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.JSONEq(`{"department": "engineering", "remote": true}`, string(updated.Attributes))
+	s.Contains(string(s.events[len(s.events)-1].Diff), `"attributes"`)
+
+	// untouched without the field
+	req = httptest.NewRequest(http.MethodPut, "http://test.com/", bytes.NewBufferString(`{"fullname": "Kobe"}`))
+	rcd = httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Update).ServeHTTP(rcd, req)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal(pg.JSONB(nil), updated.Attributes)
+
+	req = httptest.NewRequest(http.MethodPut, "http://test.com/", bytes.NewBufferString(`{"attributes": {"remote": true}}`))
+	rcd = httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Update).ServeHTTP(rcd, req)
+	s.Equal(http.StatusBadRequest, rcd.Code)
+}
+
+func (s *_v1Suite) TestUsersAttributeFilter() {
+	var query *ui.UserListQuery
+	ui.UsersHdl = func(_ context.Context, _ *ui.UI, args ...interface{}) ([]pg.User, error) {
+		query = args[0].(*ui.UserListQuery)
+		return []pg.User{}, nil
+	}
+	users := func(q string) int {
+		req := httptest.NewRequest(http.MethodGet, "http://test.com/ui/v1/users?"+q, nil)
+		rcd := httptest.NewRecorder()
+		http.HandlerFunc(s.UI.Users).ServeHTTP(rcd, req)
+		return rcd.Code
+	}
+
+	s.Equal(http.StatusBadRequest, users("attr.department=sales"))
+
+	sch, err := schema.Parse([]byte(attributesSchema))
+	s.Equal(nil, err)
+	s.UI.AttributeSchema = sch
+	defer func() { s.UI.AttributeSchema = nil }()
+
+	s.Equal(http.StatusOK, users("attr.department=sales&attr.level=3&attr.remote=true"))
+	s.Equal(map[string]interface{}{"department": "sales", "level": int64(3), "remote": true}, query.Attributes)
+
+	for _, q := range []string{"attr.level=high", "attr.remote=maybe", "attr.phone=555"} {
+		s.Equal(http.StatusBadRequest, users(q), q)
+	}
+}
+
 func (s *_v1Suite) TestLogin() {
 	// normal case
-	ui.LoginHdl = func(ui *ui.UI, args ...interface{}) ([]pg.User, error) {
+	ui.LoginHdl = func(_ context.Context, ui *ui.UI, args ...interface{}) ([]pg.User, error) {
 		return []pg.User{{Pwd: "123456789"}}, nil
 	}
 	user := struct {
@@ -270,9 +859,19 @@ func (s *_v1Suite) TestLogin() {
 	//str := rcd.Body.String()
 	//fmt.Println(str)
 	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal([]string{"123456789"}, s.logins)
+
+	// failing to announce the login does not fail it
+	ui.RecordLoginHdl = func(context.Context, *ui.UI, string) error {
+		return errors.New("mock error")
+	}
+	req = httptest.NewRequest(http.MethodPost, "http://test.com/", bytes.NewBuffer(js))
+	rcd = httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Login).ServeHTTP(rcd, req)
+	s.Equal(http.StatusOK, rcd.Code)
 
 	// error case
-	ui.LoginHdl = func(ui *ui.UI, args ...interface{}) ([]pg.User, error) {
+	ui.LoginHdl = func(_ context.Context, ui *ui.UI, args ...interface{}) ([]pg.User, error) {
 		return nil, errors.New("mock error")
 	}
 
@@ -283,6 +882,335 @@ func (s *_v1Suite) TestLogin() {
 	s.Equal(http.StatusInternalServerError, rcd.Code)
 }
 
+func (s *_v1Suite) TestUserInfoETag() {
+	updated := time.Date(2021, 7, 1, 12, 0, 0, 123456000, time.UTC)
+	ui.UserInfoHdl = func(_ context.Context, ui *ui.UI, args ...interface{}) ([]pg.User, error) {
+		return []pg.User{{Acct: "User1", Updated_at: updated}}, nil
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://test.com", nil)
+	rcd := httptest.NewRecorder()
+	http.HandlerFunc(s.UI.UserInfo).ServeHTTP(rcd, req)
+	s.Equal(http.StatusOK, rcd.Code)
+	etag := rcd.Header().Get("ETag")
+	s.Equal(ui.ViewETag(&pg.User{Updated_at: updated}, ui.ViewPublic), etag)
+	s.Equal("Authorization", rcd.Header().Get("Vary"))
+
+	// unchanged
+	req = httptest.NewRequest(http.MethodGet, "http://test.com", nil)
+	req.Header.Set("If-None-Match", "W/"+etag)
+	rcd = httptest.NewRecorder()
+	http.HandlerFunc(s.UI.UserInfo).ServeHTTP(rcd, req)
+	s.Equal(http.StatusNotModified, rcd.Code)
+	s.Equal("", rcd.Body.String())
+
+	// changed
+	req = httptest.NewRequest(http.MethodGet, "http://test.com", nil)
+	req.Header.Set("If-None-Match", `"0"`)
+	rcd = httptest.NewRecorder()
+	http.HandlerFunc(s.UI.UserInfo).ServeHTTP(rcd, req)
+	s.Equal(http.StatusOK, rcd.Code)
+
+	// the tag of the public view is not that of the whole profile
+	req = httptest.NewRequest(http.MethodGet, "http://test.com", nil)
+	req = req.WithContext(secret.NewContext(req.Context(), &secret.UserClaims{Acct: "User1"}))
+	req.Header.Set("If-None-Match", etag)
+	rcd = httptest.NewRecorder()
+	http.HandlerFunc(s.UI.UserInfo).ServeHTTP(rcd, req)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal(ui.ViewETag(&pg.User{Updated_at: updated}, ui.ViewSelf), rcd.Header().Get("ETag"))
+	s.Contains(rcd.Body.String(), "updated_at")
+}
+
+func (s *_v1Suite) TestUpdateIfMatch() {
+	updated := time.Date(2021, 7, 1, 12, 0, 0, 123456000, time.UTC)
+	etag := ui.UserETag(&pg.User{Updated_at: updated})
+
+	var pre *ui.Precondition
+	ui.UpdateHdl = func(_ context.Context, _ *ui.UI, _ *pg.User, p *ui.Precondition) (*pg.User, error) {
+		pre = p
+		if p != nil && !p.Any && !p.UpdatedAt[0].Equal(updated) {
+			return nil, ui.ErrPreconditionFailed
+		}
+		return nil, nil
+	}
+	js := []byte(`{"fullname": "123456789"}`)
+
+	// matching version
+	req := httptest.NewRequest(http.MethodPut, "http://test.com/", bytes.NewBuffer(js))
+	req.Header.Set("If-Match", etag)
+	rcd := httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Update).ServeHTTP(rcd, req)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal([]time.Time{updated}, pre.UpdatedAt)
+
+	// the tag of any view of the version
+	pre = nil
+	req = httptest.NewRequest(http.MethodPut, "http://test.com/", bytes.NewBuffer(js))
+	req.Header.Set("If-Match", ui.ViewETag(&pg.User{Updated_at: updated}, ui.ViewPublic))
+	rcd = httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Update).ServeHTTP(rcd, req)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal([]time.Time{updated}, pre.UpdatedAt)
+
+	// stale version
+	req = httptest.NewRequest(http.MethodPut, "http://test.com/", bytes.NewBuffer(js))
+	req.Header.Set("If-Match", `"1"`)
+	rcd = httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Update).ServeHTTP(rcd, req)
+	s.Equal(http.StatusPreconditionFailed, rcd.Code)
+
+	// weak tags never match
+	req = httptest.NewRequest(http.MethodPut, "http://test.com/", bytes.NewBuffer(js))
+	req.Header.Set("If-Match", "W/"+etag)
+	rcd = httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Update).ServeHTTP(rcd, req)
+	s.Equal(http.StatusPreconditionFailed, rcd.Code)
+
+	// missing but required
+	s.UI.RequireIfMatch = true
+	defer func() { s.UI.RequireIfMatch = false }()
+	req = httptest.NewRequest(http.MethodPut, "http://test.com/", bytes.NewBuffer(js))
+	rcd = httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Update).ServeHTTP(rcd, req)
+	s.Equal(http.StatusPreconditionRequired, rcd.Code)
+
+	// any version
+	req = httptest.NewRequest(http.MethodPut, "http://test.com/", bytes.NewBuffer(js))
+	req.Header.Set("If-Match", "*")
+	rcd = httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Update).ServeHTTP(rcd, req)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal(true, pre.Any)
+}
+
+func (s *_v1Suite) TestDeleteIfMatch() {
+	ui.DeleteHdl = func(_ context.Context, _ *ui.UI, _ *pg.User, p *ui.Precondition) (*pg.User, error) {
+		return nil, ui.ErrPreconditionFailed
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "http://test.com", nil)
+	req.Header.Set("If-Match", `"1"`)
+	rcd := httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Delete).ServeHTTP(rcd, req)
+	s.Equal(http.StatusPreconditionFailed, rcd.Code)
+}
+
+func (s *_v1Suite) TestAudit() {
+	ui.SignUpHdl = func(context.Context, *ui.UI, *pg.User) error {
+		return nil
+	}
+	js := []byte(`{"account": "123456789", "password": "secret_pwd", "fullname": "Kobe"}`)
+	req := httptest.NewRequest(http.MethodPost, "http://test.com", bytes.NewBuffer(js))
+	req = req.WithContext(ui.WithRequestID(req.Context(), "req-1"))
+	rcd := httptest.NewRecorder()
+	http.HandlerFunc(s.UI.SignUp).ServeHTTP(rcd, req)
+	s.Equal(http.StatusOK, rcd.Code)
+
+	s.Equal(1, len(s.events))
+	ev := s.events[0]
+	s.Equal(ui.AuditActionSignUp, ev.Action)
+	s.Equal("123456789", ev.Target)
+	s.Equal("", ev.Actor)
+	s.Equal("req-1", ev.RequestID)
+	s.Equal("192.0.2.1", ev.SourceIP)
+	s.Equal(ui.AuditOutcomeSuccess, ev.Outcome)
+	s.NotContains(string(ev.Diff), "secret_pwd")
+
+	diff := map[string]map[string]interface{}{}
+	s.Equal(nil, json.Unmarshal(ev.Diff, &diff))
+	s.Equal(map[string]interface{}{"before": nil, "after": "Kobe"}, diff["fullname"])
+	s.Equal(map[string]interface{}{"before": nil, "after": "[REDACTED]"}, diff["password"])
+
+	// update by an admin
+	ui.UpdateHdl = func(context.Context, *ui.UI, *pg.User, *ui.Precondition) (*pg.User, error) {
+		return &pg.User{Acct: "123456789", Pwd: "secret_pwd", Fullname: "Kobe"}, nil
+	}
+	js = []byte(`{"fullname": "Kobe Bryant"}`)
+	req = httptest.NewRequest(http.MethodPut, "http://test.com", bytes.NewBuffer(js))
+	req = req.WithContext(secret.NewContext(req.Context(), &secret.UserClaims{Acct: "admin_acct"}))
+	req = mux.SetURLVars(req, map[string]string{"acct": "123456789"})
+	rcd = httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Update).ServeHTTP(rcd, req)
+	s.Equal(http.StatusOK, rcd.Code)
+
+	ev = s.events[1]
+	s.Equal(ui.AuditActionUpdate, ev.Action)
+	s.Equal("admin_acct", ev.Actor)
+	s.Equal("123456789", ev.Target)
+	diff = map[string]map[string]interface{}{}
+	s.Equal(nil, json.Unmarshal(ev.Diff, &diff))
+	s.Equal(1, len(diff))
+	s.Equal(map[string]interface{}{"before": "Kobe", "after": "Kobe Bryant"}, diff["fullname"])
+
+	// failed login
+	ui.LoginHdl = func(context.Context, *ui.UI, ...interface{}) ([]pg.User, error) {
+		return []pg.User{{Pwd: "other_pwd"}}, nil
+	}
+	js = []byte(`{"account": "123456789", "password": "secret_pwd"}`)
+	req = httptest.NewRequest(http.MethodPost, "http://test.com", bytes.NewBuffer(js))
+	rcd = httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Login).ServeHTTP(rcd, req)
+	s.Equal(http.StatusUnauthorized, rcd.Code)
+
+	ev = s.events[2]
+	s.Equal(ui.AuditActionLogin, ev.Action)
+	s.Equal(ui.AuditOutcomeFailure, ev.Outcome)
+	s.Equal(http.StatusUnauthorized, ev.Status)
+}
+
+func (s *_v1Suite) TestLoginRoles() {
+	ui.LoginHdl = func(context.Context, *ui.UI, ...interface{}) ([]pg.User, error) {
+		return []pg.User{{Pwd: "123456789"}}, nil
+	}
+	s.UI.Admins["123456789"] = true
+	defer delete(s.UI.Admins, "123456789")
+
+	js := []byte(`{"account": "123456789", "password": "123456789"}`)
+	req := httptest.NewRequest(http.MethodPost, "http://test.com", bytes.NewBuffer(js))
+	rcd := httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Login).ServeHTTP(rcd, req)
+	s.Equal(http.StatusOK, rcd.Code)
+
+	body := map[string]map[string]interface{}{}
+	s.Equal(nil, json.Unmarshal(rcd.Body.Bytes(), &body))
+	claims, err := secret.ParseUserJWT(body["data"]["JWT"].(string))
+	s.Equal(nil, err)
+	s.Equal(true, claims.HasRole(secret.RoleAdmin))
+	s.Equal(0, len(claims.Groups))
+
+	// the roles of the groups, nested ones included, are granted too
+	ui.MemberOfHdl = func(_ context.Context, _ *ui.UI, acct string) ([]pg.Group, error) {
+		s.Equal("123456789", acct)
+		return []pg.Group{
+			{Name: "lakers", Roles: []string{"roster:write", secret.RoleAdmin}},
+			// but the super admin role, which groups do not grant
+			{Name: "nba", Roles: []string{"roster:read", secret.RoleSuperAdmin}},
+		}, nil
+	}
+	s.UI.GroupsClaim = true
+	defer func() { s.UI.GroupsClaim = false }()
+
+	req = httptest.NewRequest(http.MethodPost, "http://test.com", bytes.NewBuffer(js))
+	rcd = httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Login).ServeHTTP(rcd, req)
+	s.Equal(http.StatusOK, rcd.Code)
+
+	s.Equal(nil, json.Unmarshal(rcd.Body.Bytes(), &body))
+	claims, err = secret.ParseUserJWT(body["data"]["JWT"].(string))
+	s.Equal(nil, err)
+	s.Equal([]string{secret.RoleAdmin, "roster:write", "roster:read"}, claims.Roles)
+	s.Equal([]string{"lakers", "nba"}, claims.Groups)
+
+	// a failed lookup fails the login
+	ui.MemberOfHdl = func(context.Context, *ui.UI, string) ([]pg.Group, error) {
+		return nil, errors.New("mock error")
+	}
+	req = httptest.NewRequest(http.MethodPost, "http://test.com", bytes.NewBuffer(js))
+	rcd = httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Login).ServeHTTP(rcd, req)
+	s.Equal(http.StatusInternalServerError, rcd.Code)
+}
+
+func (s *_v1Suite) TestTenants() {
+	var tenant string
+	ui.SignUpHdl = func(ctx context.Context, _ *ui.UI, user *pg.User) error {
+		tenant = ui.Tenant(ctx)
+		if tenant == "nowhere" {
+			return &pq.Error{Code: "23503"}
+		}
+		return nil
+	}
+
+	for body, code := range map[string]int{
+		`{"account": "kobe_bryant", "password": "123456789", "fullname": "Kobe"}`:                        http.StatusOK,
+		`{"tenant": "lakers", "account": "kobe_bryant", "password": "123456789", "fullname": "Kobe"}`:    http.StatusOK,
+		`{"tenant": "nowhere", "account": "kobe_bryant", "password": "123456789", "fullname": "Kobe"}`:   http.StatusBadRequest,
+		`{"tenant": "LA Lakers", "account": "kobe_bryant", "password": "123456789", "fullname": "Kobe"}`: http.StatusBadRequest,
+		`{"tenant": 24, "account": "kobe_bryant", "password": "123456789", "fullname": "Kobe"}`:          http.StatusBadRequest,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "http://test.com", bytes.NewBufferString(body))
+		rcd := httptest.NewRecorder()
+		http.HandlerFunc(s.UI.SignUp).ServeHTTP(rcd, req)
+		s.Equal(code, rcd.Code, body)
+	}
+
+	// the audit log names the accounts of the other tenants with theirs
+	s.events = nil
+	req := httptest.NewRequest(http.MethodPost, "http://test.com",
+		bytes.NewBufferString(`{"tenant": "lakers", "account": "kobe_bryant", "password": "123456789", "fullname": "Kobe"}`))
+	http.HandlerFunc(s.UI.SignUp).ServeHTTP(httptest.NewRecorder(), req)
+	s.Equal("lakers", tenant)
+	s.Equal("lakers/kobe_bryant", s.events[0].Target)
+
+	// a login is to the account of its tenant, which its JWT names
+	ui.LoginHdl = func(ctx context.Context, _ *ui.UI, _ ...interface{}) ([]pg.User, error) {
+		tenant = ui.Tenant(ctx)
+		return []pg.User{{Pwd: "123456789"}}, nil
+	}
+	s.UI.SuperAdmins["lakers/kobe_bryant"] = true
+	defer delete(s.UI.SuperAdmins, "lakers/kobe_bryant")
+
+	req = httptest.NewRequest(http.MethodPost, "http://test.com",
+		bytes.NewBufferString(`{"tenant": "lakers", "account": "kobe_bryant", "password": "123456789"}`))
+	rcd := httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Login).ServeHTTP(rcd, req)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal("lakers", tenant)
+
+	body := map[string]map[string]interface{}{}
+	s.Equal(nil, json.Unmarshal(rcd.Body.Bytes(), &body))
+	claims, err := secret.ParseUserJWT(body["data"]["JWT"].(string))
+	s.Equal(nil, err)
+	s.Equal("lakers", claims.Tenant)
+	s.Equal([]string{secret.RoleSuperAdmin}, claims.Roles)
+
+	// the super admins of a tenant are not those of another
+	req = httptest.NewRequest(http.MethodPost, "http://test.com",
+		bytes.NewBufferString(`{"account": "kobe_bryant", "password": "123456789"}`))
+	rcd = httptest.NewRecorder()
+	http.HandlerFunc(s.UI.Login).ServeHTTP(rcd, req)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal(nil, json.Unmarshal(rcd.Body.Bytes(), &body))
+	claims, err = secret.ParseUserJWT(body["data"]["JWT"].(string))
+	s.Equal(nil, err)
+	s.Equal(pg.DefaultTenant, claims.Tenant)
+	s.Equal(0, len(claims.Roles))
+
+	// the handlers act in the tenant of the caller, or the one chosen
+	ctx := secret.NewContext(context.Background(), &secret.UserClaims{Acct: "kobe_bryant"})
+	s.Equal(pg.DefaultTenant, ui.Tenant(ctx))
+	ctx = secret.NewContext(context.Background(), &secret.UserClaims{Acct: "kobe_bryant", Tenant: "lakers"})
+	s.Equal("lakers", ui.Tenant(ctx))
+	s.Equal("celtics", ui.Tenant(ui.WithTenant(ctx, "celtics")))
+	s.Equal("kobe_bryant", ui.TenantAccount(pg.DefaultTenant, "kobe_bryant"))
+	s.Equal("lakers/kobe_bryant", ui.TenantAccount("lakers", "kobe_bryant"))
+}
+
+func (s *_v1Suite) TestDBStats() {
+	ui.PoolStatsHdl = func(*ui.UI) []pg.PoolStats {
+		return []pg.PoolStats{
+			{Database: pg.PrimaryName, MaxOpen: 10, Open: 4, InUse: 3, Idle: 1, WaitCount: 2, WaitDurationSecs: 0.5},
+			{Database: "replica:5432", MaxOpen: 10},
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://test.com", nil)
+	rcd := httptest.NewRecorder()
+	http.HandlerFunc(s.UI.DBStats).ServeHTTP(rcd, req)
+	s.Equal(http.StatusOK, rcd.Code)
+
+	body := map[string]interface{}{}
+	s.Equal(nil, json.Unmarshal(rcd.Body.Bytes(), &body))
+	pools := body["data"].(map[string]interface{})["pools"].([]interface{})
+	s.Equal(2, len(pools))
+	primary := pools[0].(map[string]interface{})
+	s.Equal("primary", primary["database"])
+	s.Equal(float64(3), primary["in_use"])
+	s.Equal(float64(2), primary["wait_count"])
+	s.Equal(0.5, primary["wait_duration_seconds"])
+}
+
 func TestRunV1(t *testing.T) {
 	suite.Run(t, new(_v1Suite))
 }
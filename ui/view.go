@@ -0,0 +1,87 @@
+package ui
+
+import (
+	"context"
+	"time"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/secret"
+)
+
+// The audiences a user is shown to, each in a view of its own. No view has
+// a field for credential material.
+const (
+	ViewPublic = "public"
+	ViewSelf   = "self"
+	ViewAdmin  = "admin"
+)
+
+// profileFields are the columns of the views: everything but the password
+// and the match key of the fullname.
+var profileFields = []string{
+	pg.FieldUserTenant.String(),
+	pg.FieldUserAcct.String(),
+	pg.FieldUserFullname.String(),
+	pg.FieldUserCreatedAt.String(),
+	pg.FieldUserUpdatedAt.String(),
+	pg.FieldUserAttributes.String(),
+}
+
+// UserPublicView is a user as the other accounts of its tenant see it.
+type UserPublicView struct {
+	Acct     string `json:"account"`
+	Fullname string `json:"fullname"`
+}
+
+// UserSelfView is a user as it sees itself: its whole profile.
+type UserSelfView struct {
+	Acct       string    `json:"account"`
+	Fullname   string    `json:"fullname"`
+	Created_at time.Time `json:"created_at"`
+	Updated_at time.Time `json:"updated_at"`
+	Attributes pg.JSONB  `json:"attributes,omitempty"`
+}
+
+// UserAdminView is a user as the admins of its tenant see it.
+type UserAdminView struct {
+	Tenant string `json:"tenant,omitempty"`
+	UserSelfView
+}
+
+// userAudience returns the audience the caller of ctx is of for user: the
+// admins, itself, or anyone else.
+func userAudience(ctx context.Context, user *pg.User) string {
+	claims, ok := secret.FromContext(ctx)
+	switch {
+	case !ok:
+		return ViewPublic
+	case claims.HasRole(secret.RoleAdmin) || claims.HasRole(secret.RoleSuperAdmin):
+		return ViewAdmin
+	case claims.Acct == user.Acct && ClaimsTenant(claims) == Tenant(ctx):
+		return ViewSelf
+	default:
+		return ViewPublic
+	}
+}
+
+// UserView returns user as audience sees it.
+func UserView(user *pg.User, audience string) interface{} {
+	switch audience {
+	case ViewAdmin:
+		return UserAdminView{Tenant: user.Tenant, UserSelfView: selfView(user)}
+	case ViewSelf:
+		return selfView(user)
+	default:
+		return UserPublicView{Acct: user.Acct, Fullname: user.Fullname}
+	}
+}
+
+func selfView(user *pg.User) UserSelfView {
+	return UserSelfView{
+		Acct:       user.Acct,
+		Fullname:   user.Fullname,
+		Created_at: user.Created_at,
+		Updated_at: user.Updated_at,
+		Attributes: user.Attributes,
+	}
+}
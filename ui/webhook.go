@@ -0,0 +1,474 @@
+package ui
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dontang97/ui/outbox"
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/webhook"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+const (
+	webhookURLMaxLen = 2048
+
+	DefaultDeliveriesLimit = 50
+	MaxDeliveriesLimit     = 500
+)
+
+// WebhookChanges are the fields of a webhook a PUT sets; nil fields are
+// left alone. RotateSecret replaces the signing secret.
+type WebhookChanges struct {
+	URL          *string   `json:"url"`
+	EventTypes   *[]string `json:"event_types"`
+	Active       *bool     `json:"active"`
+	RotateSecret bool      `json:"rotate_secret"`
+}
+
+// DeliveryQuery is the parsed query string of the delivery log of a
+// webhook, listed from the newest; Before is the ID the page starts below.
+type DeliveryQuery struct {
+	WebhookID int64
+	Status    string
+	Before    int64
+	Limit     int
+}
+
+type QueryWebhookHandlerFunc func(context.Context, *UI, ...interface{}) ([]pg.Webhook, error)
+type AddWebhookHandlerFunc func(context.Context, *UI, *pg.Webhook) error
+
+// UpdateWebhookHandlerFunc and DeleteWebhookHandlerFunc return nil for a
+// webhook that does not exist.
+type UpdateWebhookHandlerFunc func(context.Context, *UI, int64, *WebhookChanges, string) (*pg.Webhook, error)
+type DeleteWebhookHandlerFunc func(context.Context, *UI, int64) (*pg.Webhook, error)
+
+type QueryDeliveryHandlerFunc func(context.Context, *UI, *DeliveryQuery) ([]pg.WebhookDelivery, error)
+
+// RedeliverHandlerFunc returns nil for a delivery that does not exist.
+type RedeliverHandlerFunc func(context.Context, *UI, int64, int64) (*pg.WebhookDelivery, error)
+
+type RecordLoginHandlerFunc func(context.Context, *UI, string) error
+
+// RecordLoginHdl announces a successful login to the outbox.
+var RecordLoginHdl RecordLoginHandlerFunc = func(ctx context.Context, ui *UI, acct string) error {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
+
+	return ui.transaction(ctx, func(tx *gorm.DB) error {
+		return outbox.Enqueue(tx, outbox.UserLoggedIn, Tenant(ctx), acct, userEvent{Acct: acct})
+	})
+}
+
+// validWebhookURL accepts absolute http and https URLs.
+func validWebhookURL(s string) bool {
+	if len(s) > webhookURLMaxLen {
+		return false
+	}
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// eventTypes checks and dedupes the event types of a webhook. It returns
+// the first invalid one.
+func eventTypes(types []string) ([]string, string, bool) {
+	seen := map[string]bool{}
+	valid := []string{}
+	for _, typ := range types {
+		if !webhook.ValidEventType(typ) {
+			return nil, typ, false
+		}
+		if !seen[typ] {
+			seen[typ] = true
+			valid = append(valid, typ)
+		}
+	}
+	return valid, "", true
+}
+
+func pathID(r *http.Request, name string) int64 {
+	// the route only matches digits
+	id, _ := strconv.ParseInt(mux.Vars(r)[name], 10, 64)
+	return id
+}
+
+//////////////////////////////////////////////
+//////    GET /ui/v1/webhooks[/{id}]    //////
+//////////////////////////////////////////////
+
+// WebhooksHdl lists the webhooks, or only the one of ID args[0].
+var WebhooksHdl QueryWebhookHandlerFunc = func(ctx context.Context, ui *UI, args ...interface{}) ([]pg.Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.QueryTimeout)
+	defer cancel()
+
+	db := ui.WithContext(ctx).Table(pg.TableWebhooks.String())
+	if len(args) > 0 {
+		db = db.Where(pg.FieldWebhookID.String()+" = ?", args[0])
+	}
+
+	hooks := []pg.Webhook{}
+	res := db.Order(pg.FieldWebhookID.String()).Find(&hooks)
+	return hooks, res.Error
+}
+
+func (ui *UI) Webhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := WebhooksHdl(r.Context(), ui)
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+
+	WriteJsonResponse(StatusOK, map[string]interface{}{"webhooks": hooks}, w)
+}
+
+func (ui *UI) WebhookInfo(w http.ResponseWriter, r *http.Request) {
+	id := pathID(r, "id")
+	hooks, err := WebhooksHdl(r.Context(), ui, id)
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+
+	if len(hooks) == 0 {
+		WriteJsonResponse(StatusNotFound, map[string]int64{"id": id}, w)
+		return
+	}
+
+	WriteJsonResponse(StatusOK, hooks[0], w)
+}
+
+////////////////////////////////////////
+//////    POST /ui/v1/webhooks    //////
+////////////////////////////////////////
+
+var AddWebhookHdl AddWebhookHandlerFunc = func(ctx context.Context, ui *UI, hook *pg.Webhook) error {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
+
+	return ui.WithContext(ctx).Table(pg.TableWebhooks.String()).Create(hook).Error
+}
+
+func (ui *UI) AddWebhook(w http.ResponseWriter, r *http.Request) {
+	req := WebhookChanges{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteJsonResponse(StatusInvalidContent, map[string]string{"error": err.Error()}, w)
+		return
+	}
+
+	if req.URL == nil {
+		WriteJsonResponse(StatusInvalidContent, map[string]string{"missing_field": "url"}, w)
+		return
+	}
+	if !validWebhookURL(*req.URL) {
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": "url", "value": *req.URL}}, w)
+		return
+	}
+
+	types := []string{}
+	if req.EventTypes != nil {
+		var bad string
+		var ok bool
+		if types, bad, ok = eventTypes(*req.EventTypes); !ok {
+			WriteJsonResponse(StatusInvalidContent,
+				map[string]map[string]string{"invalid": {"field": "event_types", "value": bad}}, w)
+			return
+		}
+	}
+
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+
+	now := time.Now().UTC()
+	hook := &pg.Webhook{
+		URL:        *req.URL,
+		Secret:     secret,
+		EventTypes: types,
+		Active:     req.Active == nil || *req.Active,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := AddWebhookHdl(r.Context(), ui, hook); err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+
+	// the secret is shown once, to be set up on the receiving end
+	WriteJsonResponse(StatusOK, map[string]interface{}{"webhook": hook, "secret": secret}, w)
+}
+
+////////////////////////////////////////////
+//////    PUT /ui/v1/webhooks/{id}    //////
+////////////////////////////////////////////
+
+var UpdateWebhookHdl UpdateWebhookHandlerFunc = func(ctx context.Context, ui *UI, id int64, changes *WebhookChanges, secret string) (*pg.Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
+
+	values := map[string]interface{}{
+		pg.FieldWebhookUpdatedAt.String(): time.Now().UTC(),
+	}
+	if changes.URL != nil {
+		values[pg.FieldWebhookURL.String()] = *changes.URL
+	}
+	if changes.EventTypes != nil {
+		values[pg.FieldWebhookEventTypes.String()] = pq.StringArray(*changes.EventTypes)
+	}
+	if changes.Active != nil {
+		values[pg.FieldWebhookActive.String()] = *changes.Active
+		if *changes.Active {
+			// a webhook turned back on starts with a closed circuit
+			values[pg.FieldWebhookFailures.String()] = 0
+			values[pg.FieldWebhookOpenUntil.String()] = nil
+		}
+	}
+	if secret != "" {
+		values[pg.FieldWebhookSecret.String()] = secret
+	}
+
+	var hook *pg.Webhook
+	err := ui.transaction(ctx, func(tx *gorm.DB) error {
+		res := tx.
+			Table(pg.TableWebhooks.String()).
+			Where(pg.FieldWebhookID.String()+" = ?", id).
+			Updates(values)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+
+		hook = &pg.Webhook{}
+		return tx.
+			Table(pg.TableWebhooks.String()).
+			Where(pg.FieldWebhookID.String()+" = ?", id).
+			Find(hook).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+func (ui *UI) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	id := pathID(r, "id")
+
+	changes := WebhookChanges{}
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		WriteJsonResponse(StatusInvalidContent, map[string]string{"error": err.Error()}, w)
+		return
+	}
+
+	if changes.URL != nil && !validWebhookURL(*changes.URL) {
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": "url", "value": *changes.URL}}, w)
+		return
+	}
+	if changes.EventTypes != nil {
+		types, bad, ok := eventTypes(*changes.EventTypes)
+		if !ok {
+			WriteJsonResponse(StatusInvalidContent,
+				map[string]map[string]string{"invalid": {"field": "event_types", "value": bad}}, w)
+			return
+		}
+		changes.EventTypes = &types
+	}
+
+	var secret string
+	if changes.RotateSecret {
+		var err error
+		if secret, err = webhook.NewSecret(); err != nil {
+			WriteErrorResponse(err, w)
+			return
+		}
+	}
+
+	hook, err := UpdateWebhookHdl(r.Context(), ui, id, &changes, secret)
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+	if hook == nil {
+		WriteJsonResponse(StatusNotFound, map[string]int64{"id": id}, w)
+		return
+	}
+
+	data := map[string]interface{}{"webhook": hook}
+	if secret != "" {
+		data["secret"] = secret
+	}
+	WriteJsonResponse(StatusOK, data, w)
+}
+
+///////////////////////////////////////////////
+//////    DELETE /ui/v1/webhooks/{id}    //////
+///////////////////////////////////////////////
+
+// DeleteWebhookHdl removes the webhook together with its delivery log.
+var DeleteWebhookHdl DeleteWebhookHandlerFunc = func(ctx context.Context, ui *UI, id int64) (*pg.Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
+
+	hooks := []pg.Webhook{}
+	if res := ui.WithContext(ctx).
+		Raw("DELETE FROM "+pg.TableWebhooks.String()+" WHERE "+pg.FieldWebhookID.String()+" = ? RETURNING *", id).
+		Scan(&hooks); res.Error != nil {
+		return nil, res.Error
+	}
+	if len(hooks) == 0 {
+		return nil, nil
+	}
+	return &hooks[0], nil
+}
+
+func (ui *UI) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := pathID(r, "id")
+	hook, err := DeleteWebhookHdl(r.Context(), ui, id)
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+	if hook == nil {
+		WriteJsonResponse(StatusNotFound, map[string]int64{"id": id}, w)
+		return
+	}
+
+	WriteJsonResponse(StatusOK, hook, w)
+}
+
+///////////////////////////////////////////////////////
+//////    GET /ui/v1/webhooks/{id}/deliveries    //////
+///////////////////////////////////////////////////////
+
+var DeliveriesHdl QueryDeliveryHandlerFunc = func(ctx context.Context, ui *UI, q *DeliveryQuery) ([]pg.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.QueryTimeout)
+	defer cancel()
+
+	db := ui.WithContext(ctx).
+		Table(pg.TableWebhookDeliveries.String()).
+		Where(pg.FieldDeliveryWebhookID.String()+" = ?", q.WebhookID)
+	if q.Status != "" {
+		db = db.Where(pg.FieldDeliveryStatus.String()+" = ?", q.Status)
+	}
+	if q.Before > 0 {
+		db = db.Where(pg.FieldDeliveryID.String()+" < ?", q.Before)
+	}
+
+	deliveries := []pg.WebhookDelivery{}
+	res := db.Order(pg.FieldDeliveryID.String() + " DESC").Limit(q.Limit + 1).Find(&deliveries)
+	return deliveries, res.Error
+}
+
+func parseDeliveryQuery(r *http.Request) (*DeliveryQuery, error) {
+	values := r.URL.Query()
+	q := &DeliveryQuery{
+		WebhookID: pathID(r, "id"),
+		Status:    values.Get("status"),
+		Limit:     DefaultDeliveriesLimit,
+	}
+
+	switch q.Status {
+	case "", pg.DeliveryPending, pg.DeliverySucceeded, pg.DeliveryDead:
+	default:
+		return nil, &queryError{"status", q.Status}
+	}
+
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > MaxDeliveriesLimit {
+			return nil, &queryError{"limit", v}
+		}
+		q.Limit = n
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, &queryError{"cursor", v}
+		}
+		q.Before = n
+	}
+
+	return q, nil
+}
+
+func (ui *UI) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	q, err := parseDeliveryQuery(r)
+	if err != nil {
+		qe := err.(*queryError)
+		WriteJsonResponse(StatusInvalidContent,
+			map[string]map[string]string{"invalid": {"field": qe.field, "value": qe.value}}, w)
+		return
+	}
+
+	deliveries, err := DeliveriesHdl(r.Context(), ui, q)
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+
+	data := map[string]interface{}{}
+	if len(deliveries) > q.Limit {
+		deliveries = deliveries[:q.Limit]
+
+		next := *r.URL
+		values := next.Query()
+		values.Set("cursor", strconv.FormatInt(deliveries[len(deliveries)-1].ID, 10))
+		next.RawQuery = values.Encode()
+		data["next"] = next.RequestURI()
+	}
+	data["deliveries"] = deliveries
+
+	WriteJsonResponse(StatusOK, data, w)
+}
+
+/////////////////////////////////////////////////////////////////////////////
+//////    POST /ui/v1/webhooks/{id}/deliveries/{delivery}/redeliver    //////
+/////////////////////////////////////////////////////////////////////////////
+
+var RedeliverHdl RedeliverHandlerFunc = func(ctx context.Context, ui *UI, hookID, deliveryID int64) (*pg.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, ui.ExecTimeout)
+	defer cancel()
+
+	var again *pg.WebhookDelivery
+	err := ui.transaction(ctx, func(tx *gorm.DB) error {
+		deliveries := []pg.WebhookDelivery{}
+		if res := tx.
+			Table(pg.TableWebhookDeliveries.String()).
+			Where(pg.FieldDeliveryID.String()+" = ?", deliveryID).
+			Where(pg.FieldDeliveryWebhookID.String()+" = ?", hookID).
+			Find(&deliveries); res.Error != nil || len(deliveries) == 0 {
+			return res.Error
+		}
+
+		var err error
+		again, err = webhook.Redeliver(tx, &deliveries[0])
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return again, nil
+}
+
+func (ui *UI) Redeliver(w http.ResponseWriter, r *http.Request) {
+	hookID, deliveryID := pathID(r, "id"), pathID(r, "delivery")
+	again, err := RedeliverHdl(r.Context(), ui, hookID, deliveryID)
+	if err != nil {
+		WriteErrorResponse(err, w)
+		return
+	}
+	if again == nil {
+		WriteJsonResponse(StatusNotFound, map[string]int64{"id": hookID, "delivery": deliveryID}, w)
+		return
+	}
+
+	WriteJsonResponse(StatusOK, again, w)
+}
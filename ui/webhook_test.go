@@ -0,0 +1,227 @@
+package ui_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dontang97/ui/pg"
+	"github.com/dontang97/ui/ui"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/suite"
+)
+
+type _webhookSuite struct {
+	suite.Suite
+	UI *ui.UI
+
+	WebhooksHdl      ui.QueryWebhookHandlerFunc
+	AddWebhookHdl    ui.AddWebhookHandlerFunc
+	UpdateWebhookHdl ui.UpdateWebhookHandlerFunc
+	DeleteWebhookHdl ui.DeleteWebhookHandlerFunc
+	DeliveriesHdl    ui.QueryDeliveryHandlerFunc
+	RedeliverHdl     ui.RedeliverHandlerFunc
+}
+
+func (s *_webhookSuite) SetupSuite() {
+	s.UI = ui.New()
+}
+
+func (s *_webhookSuite) TearDownSuite() {
+}
+
+func (s *_webhookSuite) SetupTest() {
+	s.WebhooksHdl, ui.WebhooksHdl = ui.WebhooksHdl, nil
+	s.AddWebhookHdl, ui.AddWebhookHdl = ui.AddWebhookHdl, nil
+	s.UpdateWebhookHdl, ui.UpdateWebhookHdl = ui.UpdateWebhookHdl, nil
+	s.DeleteWebhookHdl, ui.DeleteWebhookHdl = ui.DeleteWebhookHdl, nil
+	s.DeliveriesHdl, ui.DeliveriesHdl = ui.DeliveriesHdl, nil
+	s.RedeliverHdl, ui.RedeliverHdl = ui.RedeliverHdl, nil
+}
+
+func (s *_webhookSuite) TearDownTest() {
+	ui.WebhooksHdl, s.WebhooksHdl = s.WebhooksHdl, nil
+	ui.AddWebhookHdl, s.AddWebhookHdl = s.AddWebhookHdl, nil
+	ui.UpdateWebhookHdl, s.UpdateWebhookHdl = s.UpdateWebhookHdl, nil
+	ui.DeleteWebhookHdl, s.DeleteWebhookHdl = s.DeleteWebhookHdl, nil
+	ui.DeliveriesHdl, s.DeliveriesHdl = s.DeliveriesHdl, nil
+	ui.RedeliverHdl, s.RedeliverHdl = s.RedeliverHdl, nil
+}
+
+func (s *_webhookSuite) serve(hdl http.HandlerFunc, method, target, body string, vars map[string]string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+	req = mux.SetURLVars(req, vars)
+	rcd := httptest.NewRecorder()
+	hdl.ServeHTTP(rcd, req)
+
+	resp := map[string]interface{}{}
+	if rcd.Body.Len() > 0 {
+		s.Equal(nil, json.Unmarshal(rcd.Body.Bytes(), &resp))
+	}
+	return rcd, resp
+}
+
+func (s *_webhookSuite) TestAddWebhook() {
+	var added *pg.Webhook
+	ui.AddWebhookHdl = func(_ context.Context, _ *ui.UI, hook *pg.Webhook) error {
+		hook.ID = 7
+		added = hook
+		return nil
+	}
+
+	rcd, resp := s.serve(s.UI.AddWebhook, http.MethodPost, "http://test.com",
+		`{"url": "https://example.com/hook", "event_types": ["user.created", "user.login", "user.created"]}`, nil)
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal([]string{"user.created", "user.login"}, []string(added.EventTypes))
+	s.Equal(true, added.Active)
+
+	// the secret is only shown on creation
+	data := resp["data"].(map[string]interface{})
+	s.Equal(added.Secret, data["secret"])
+	hook := data["webhook"].(map[string]interface{})
+	s.Equal(float64(7), hook["id"])
+	_, ok := hook["secret"]
+	s.Equal(false, ok)
+
+	// every event type by default
+	_, _ = s.serve(s.UI.AddWebhook, http.MethodPost, "http://test.com",
+		`{"url": "http://hooks.internal:8080/users", "active": false}`, nil)
+	s.Equal([]string{}, []string(added.EventTypes))
+	s.Equal(false, added.Active)
+
+	// invalid cases
+	for body, invalid := range map[string]map[string]interface{}{
+		`{"url": "ftp://example.com"}`:                              {"field": "url", "value": "ftp://example.com"},
+		`{"url": "/hook"}`:                                          {"field": "url", "value": "/hook"},
+		`{"url": "https://example.com", "event_types": ["user.*"]}`: {"field": "event_types", "value": "user.*"},
+	} {
+		rcd, resp = s.serve(s.UI.AddWebhook, http.MethodPost, "http://test.com", body, nil)
+		s.Equal(http.StatusBadRequest, rcd.Code)
+		s.Equal(invalid, resp["data"].(map[string]interface{})["invalid"])
+	}
+
+	rcd, resp = s.serve(s.UI.AddWebhook, http.MethodPost, "http://test.com", `{}`, nil)
+	s.Equal(http.StatusBadRequest, rcd.Code)
+	s.Equal("url", resp["data"].(map[string]interface{})["missing_field"])
+}
+
+func (s *_webhookSuite) TestWebhookInfo() {
+	ui.WebhooksHdl = func(_ context.Context, _ *ui.UI, args ...interface{}) ([]pg.Webhook, error) {
+		if args[0].(int64) == 7 {
+			return []pg.Webhook{{ID: 7, URL: "https://example.com/hook", Secret: "whsec_test"}}, nil
+		}
+		return []pg.Webhook{}, nil
+	}
+
+	rcd, resp := s.serve(s.UI.WebhookInfo, http.MethodGet, "http://test.com", "", map[string]string{"id": "7"})
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal("https://example.com/hook", resp["data"].(map[string]interface{})["url"])
+	s.NotContains(rcd.Body.String(), "whsec_test")
+
+	rcd, _ = s.serve(s.UI.WebhookInfo, http.MethodGet, "http://test.com", "", map[string]string{"id": "8"})
+	s.Equal(http.StatusNotFound, rcd.Code)
+}
+
+func (s *_webhookSuite) TestUpdateWebhook() {
+	var secret string
+	ui.UpdateWebhookHdl = func(_ context.Context, _ *ui.UI, id int64, changes *ui.WebhookChanges, sec string) (*pg.Webhook, error) {
+		if id != 7 {
+			return nil, nil
+		}
+		secret = sec
+		return &pg.Webhook{ID: id, URL: *changes.URL}, nil
+	}
+
+	rcd, resp := s.serve(s.UI.UpdateWebhook, http.MethodPut, "http://test.com",
+		`{"url": "https://example.com/v2"}`, map[string]string{"id": "7"})
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal("", secret)
+	_, ok := resp["data"].(map[string]interface{})["secret"]
+	s.Equal(false, ok)
+
+	rcd, resp = s.serve(s.UI.UpdateWebhook, http.MethodPut, "http://test.com",
+		`{"url": "https://example.com/v2", "rotate_secret": true}`, map[string]string{"id": "7"})
+	s.Equal(http.StatusOK, rcd.Code)
+	s.NotEqual("", secret)
+	s.Equal(secret, resp["data"].(map[string]interface{})["secret"])
+
+	rcd, _ = s.serve(s.UI.UpdateWebhook, http.MethodPut, "http://test.com",
+		`{"event_types": ["user.removed"]}`, map[string]string{"id": "7"})
+	s.Equal(http.StatusBadRequest, rcd.Code)
+
+	rcd, _ = s.serve(s.UI.UpdateWebhook, http.MethodPut, "http://test.com",
+		`{"url": "https://example.com/v2"}`, map[string]string{"id": "8"})
+	s.Equal(http.StatusNotFound, rcd.Code)
+}
+
+func (s *_webhookSuite) TestDeleteWebhook() {
+	ui.DeleteWebhookHdl = func(_ context.Context, _ *ui.UI, id int64) (*pg.Webhook, error) {
+		switch id {
+		case 7:
+			return &pg.Webhook{ID: 7}, nil
+		case 8:
+			return nil, nil
+		}
+		return nil, errors.New("mock error")
+	}
+
+	rcd, _ := s.serve(s.UI.DeleteWebhook, http.MethodDelete, "http://test.com", "", map[string]string{"id": "7"})
+	s.Equal(http.StatusOK, rcd.Code)
+	rcd, _ = s.serve(s.UI.DeleteWebhook, http.MethodDelete, "http://test.com", "", map[string]string{"id": "8"})
+	s.Equal(http.StatusNotFound, rcd.Code)
+	rcd, _ = s.serve(s.UI.DeleteWebhook, http.MethodDelete, "http://test.com", "", map[string]string{"id": "9"})
+	s.Equal(http.StatusInternalServerError, rcd.Code)
+}
+
+func (s *_webhookSuite) TestWebhookDeliveries() {
+	var got *ui.DeliveryQuery
+	ui.DeliveriesHdl = func(_ context.Context, _ *ui.UI, q *ui.DeliveryQuery) ([]pg.WebhookDelivery, error) {
+		got = q
+		return []pg.WebhookDelivery{{ID: 30}, {ID: 29}, {ID: 28}}, nil
+	}
+
+	rcd, resp := s.serve(s.UI.WebhookDeliveries, http.MethodGet,
+		"http://test.com/ui/v1/webhooks/7/deliveries?status=dead&limit=2", "", map[string]string{"id": "7"})
+	s.Equal(http.StatusOK, rcd.Code)
+	s.Equal(&ui.DeliveryQuery{WebhookID: 7, Status: pg.DeliveryDead, Limit: 2}, got)
+
+	data := resp["data"].(map[string]interface{})
+	s.Equal(2, len(data["deliveries"].([]interface{})))
+	s.Equal("/ui/v1/webhooks/7/deliveries?cursor=29&limit=2&status=dead", data["next"])
+
+	for _, query := range []string{"status=sent", "limit=0", "limit=501", "cursor=x"} {
+		rcd, _ = s.serve(s.UI.WebhookDeliveries, http.MethodGet,
+			"http://test.com/ui/v1/webhooks/7/deliveries?"+query, "", map[string]string{"id": "7"})
+		s.Equal(http.StatusBadRequest, rcd.Code, query)
+	}
+}
+
+func (s *_webhookSuite) TestRedeliver() {
+	ui.RedeliverHdl = func(_ context.Context, _ *ui.UI, hookID, deliveryID int64) (*pg.WebhookDelivery, error) {
+		if hookID != 7 || deliveryID != 12 {
+			return nil, nil
+		}
+		id := deliveryID
+		return &pg.WebhookDelivery{ID: 40, WebhookID: hookID, RedeliveryOf: &id, Status: pg.DeliveryPending}, nil
+	}
+
+	rcd, resp := s.serve(s.UI.Redeliver, http.MethodPost, "http://test.com", "",
+		map[string]string{"id": "7", "delivery": "12"})
+	s.Equal(http.StatusOK, rcd.Code)
+	data := resp["data"].(map[string]interface{})
+	s.Equal(float64(40), data["id"])
+	s.Equal(float64(12), data["redelivery_of"])
+
+	// the delivery must belong to the webhook
+	rcd, _ = s.serve(s.UI.Redeliver, http.MethodPost, "http://test.com", "",
+		map[string]string{"id": "8", "delivery": "12"})
+	s.Equal(http.StatusNotFound, rcd.Code)
+}
+
+func TestRunWebhook(t *testing.T) {
+	suite.Run(t, new(_webhookSuite))
+}
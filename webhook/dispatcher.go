@@ -0,0 +1,323 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dontang97/ui/outbox"
+	"github.com/dontang97/ui/pg"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	DefaultMaxAttempts = 8
+	DefaultTimeout     = time.Second * 10
+
+	// the circuit of a webhook opens after BreakerThreshold consecutive
+	// failures, for BreakerCooldown; the first delivery after that decides
+	// whether it closes or opens again
+	BreakerThreshold = 5
+	BreakerCooldown  = time.Minute
+
+	batchSize   = 50
+	maxErrorLen = 1000
+
+	// leaseMargin is added to the time a claimed batch takes to send at
+	// worst, before its deliveries are due again.
+	leaseMargin = time.Minute
+)
+
+// Fanout is the outbox sink of the webhooks: it queues each event once for
+// every active webhook subscribed to its type.
+type Fanout struct {
+	PG *pg.PG
+}
+
+const fanoutSQL = `
+INSERT INTO webhook_deliveries
+	(webhook_id, outbox_id, event_type, payload, status, next_attempt_at, created_at)
+SELECT id, ?, ?, ?, ?, ?, ? FROM webhooks
+WHERE active AND (cardinality(event_types) = 0 OR ? = ANY(event_types))
+ON CONFLICT (webhook_id, outbox_id) WHERE redelivery_of IS NULL DO NOTHING`
+
+func (f *Fanout) Deliver(ctx context.Context, env *outbox.Envelope) error {
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return outbox.Permanent(err)
+	}
+
+	now := time.Now().UTC()
+	return f.PG.WithContext(ctx).
+		Exec(fanoutSQL, env.ID, env.Type, pg.JSONB(payload), pg.DeliveryPending, now, now, env.Type).
+		Error
+}
+
+// Redeliver queues delivery again as a new delivery and returns it.
+func Redeliver(db *gorm.DB, delivery *pg.WebhookDelivery) (*pg.WebhookDelivery, error) {
+	now := time.Now().UTC()
+	id := delivery.ID
+	again := &pg.WebhookDelivery{
+		WebhookID:     delivery.WebhookID,
+		OutboxID:      delivery.OutboxID,
+		RedeliveryOf:  &id,
+		EventType:     delivery.EventType,
+		Payload:       delivery.Payload,
+		Status:        pg.DeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+	if res := db.Table(pg.TableWebhookDeliveries.String()).Create(again); res.Error != nil {
+		return nil, res.Error
+	}
+	return again, nil
+}
+
+// Dispatcher sends the queued deliveries.
+type Dispatcher struct {
+	PG     *pg.PG
+	Client *http.Client
+
+	// Zero values stand for the defaults above.
+	MaxAttempts int
+	Interval    time.Duration
+}
+
+// Run dispatches until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	interval := d.Interval
+	if interval <= 0 {
+		interval = outbox.DefaultInterval
+	}
+	for {
+		n, err := d.DispatchOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Print(err)
+		}
+		if n > 0 && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+const dueSQL = `
+SELECT d.* FROM webhook_deliveries d
+JOIN webhooks w ON w.id = d.webhook_id
+WHERE d.status = ? AND d.next_attempt_at <= ?
+AND w.active AND (w.open_until IS NULL OR w.open_until <= ?)
+ORDER BY d.id
+LIMIT ?
+FOR UPDATE OF d SKIP LOCKED`
+
+// DispatchOnce sends one batch and returns the number of attempts made.
+// The batch is claimed in a transaction of its own, which counts the
+// attempts and leases the deliveries until they could all have timed out,
+// and sent after it commits: no lock or connection is held while a
+// subscriber is slow. The outcome of each is recorded on its own, unless a
+// dispatcher took the delivery over once the lease ran out.
+func (d *Dispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	deliveries, byID, err := d.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for i := range deliveries {
+		hook := byID[deliveries[i].WebhookID]
+		if ctx.Err() != nil || hook == nil || (hook.OpenUntil != nil && hook.OpenUntil.After(time.Now().UTC())) {
+			// the circuit opened earlier in this batch
+			if err := d.release(&deliveries[i]); err != nil {
+				return n, err
+			}
+			continue
+		}
+		if err := d.dispatch(ctx, hook, &deliveries[i]); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// claim selects a batch of due deliveries, and leases them with their
+// attempt counted. It returns their webhooks by id as well.
+func (d *Dispatcher) claim(ctx context.Context) ([]pg.WebhookDelivery, map[int64]*pg.Webhook, error) {
+	deliveries := []pg.WebhookDelivery{}
+	byID := map[int64]*pg.Webhook{}
+	err := d.PG.Transaction(ctx, func(tx *gorm.DB) error {
+		now := time.Now().UTC()
+		if res := tx.Raw(dueSQL, pg.DeliveryPending, now, now, batchSize).Scan(&deliveries); res.Error != nil {
+			return res.Error
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+
+		ids, hookIDs := []int64{}, []int64{}
+		for i := range deliveries {
+			ids = append(ids, deliveries[i].ID)
+			hookIDs = append(hookIDs, deliveries[i].WebhookID)
+			deliveries[i].Attempts++
+		}
+		hooks := []pg.Webhook{}
+		if res := tx.
+			Table(pg.TableWebhooks.String()).
+			Where(pg.FieldWebhookID.String()+" IN (?)", hookIDs).
+			Find(&hooks); res.Error != nil {
+			return res.Error
+		}
+		for i := range hooks {
+			byID[hooks[i].ID] = &hooks[i]
+		}
+
+		lease := now.Add(time.Duration(len(deliveries))*DefaultTimeout + leaseMargin)
+		return tx.
+			Table(pg.TableWebhookDeliveries.String()).
+			Where(pg.FieldDeliveryID.String()+" IN (?)", ids).
+			Updates(map[string]interface{}{
+				pg.FieldDeliveryAttempts.String():      gorm.Expr(pg.FieldDeliveryAttempts.String() + " + 1"),
+				pg.FieldDeliveryNextAttemptAt.String(): lease,
+			}).Error
+	})
+	return deliveries, byID, err
+}
+
+// release gives back a claimed delivery left unsent, due at once and
+// without the attempt.
+func (d *Dispatcher) release(delivery *pg.WebhookDelivery) error {
+	// ctx may be done, and the delivery is released regardless
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	return d.PG.WithContext(ctx).
+		Table(pg.TableWebhookDeliveries.String()).
+		Where(pg.FieldDeliveryID.String()+" = ? AND "+pg.FieldDeliveryAttempts.String()+" = ?", delivery.ID, delivery.Attempts).
+		Updates(map[string]interface{}{
+			pg.FieldDeliveryAttempts.String():      delivery.Attempts - 1,
+			pg.FieldDeliveryNextAttemptAt.String(): time.Now().UTC(),
+		}).Error
+}
+
+// send POSTs the payload of delivery to hook and returns the status of
+// the response, 0 when there was none.
+func (d *Dispatcher) send(ctx context.Context, hook *pg.Webhook, delivery *pg.WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderID, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, ts, delivery.Payload))
+
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("%v answered %v", hook.URL, resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// circuitSQL counts a failure of a webhook, and opens its circuit once
+// they are BreakerThreshold in a row.
+const circuitSQL = `
+UPDATE webhooks SET failures = failures + 1,
+	open_until = CASE WHEN failures + 1 >= ? THEN ? ELSE open_until END
+WHERE id = ?
+RETURNING failures`
+
+// dispatch makes one attempt of delivery, claimed, and records its outcome
+// on the delivery and on the circuit of hook.
+func (d *Dispatcher) dispatch(ctx context.Context, hook *pg.Webhook, delivery *pg.WebhookDelivery) error {
+	status, err := d.send(ctx, hook, delivery)
+
+	now := time.Now().UTC()
+	values := map[string]interface{}{
+		pg.FieldDeliveryLastStatus.String(): status,
+		pg.FieldDeliveryLastError.String():  "",
+	}
+
+	maxAttempts := d.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	if err == nil {
+		values[pg.FieldDeliveryStatus.String()] = pg.DeliverySucceeded
+		values[pg.FieldDeliveryDeliveredAt.String()] = now
+	} else {
+		values[pg.FieldDeliveryLastError.String()] = truncate(err.Error(), maxErrorLen)
+		if delivery.Attempts >= maxAttempts {
+			log.Printf("Webhook delivery %v to %v is dead: %v", delivery.ID, hook.URL, err)
+			values[pg.FieldDeliveryStatus.String()] = pg.DeliveryDead
+		} else {
+			values[pg.FieldDeliveryNextAttemptAt.String()] = now.Add(outbox.Backoff(delivery.Attempts - 1))
+		}
+	}
+
+	return d.PG.Transaction(ctx, func(tx *gorm.DB) error {
+		res := tx.
+			Table(pg.TableWebhookDeliveries.String()).
+			Where(pg.FieldDeliveryID.String()+" = ? AND "+pg.FieldDeliveryAttempts.String()+" = ?", delivery.ID, delivery.Attempts).
+			Updates(values)
+		if res.Error != nil || res.RowsAffected == 0 {
+			// claimed again since, by a dispatcher that records it
+			return res.Error
+		}
+
+		if err == nil {
+			hook.Failures = 0
+			hook.OpenUntil = nil
+			return tx.
+				Table(pg.TableWebhooks.String()).
+				Where(pg.FieldWebhookID.String()+" = ?", hook.ID).
+				Updates(map[string]interface{}{
+					pg.FieldWebhookFailures.String():  0,
+					pg.FieldWebhookOpenUntil.String(): nil,
+				}).Error
+		}
+
+		// the failures of the other dispatchers count too
+		until := now.Add(BreakerCooldown)
+		if err := tx.Raw(circuitSQL, BreakerThreshold, until, hook.ID).Row().Scan(&hook.Failures); err != nil {
+			return err
+		}
+		if hook.Failures >= BreakerThreshold {
+			hook.OpenUntil = &until
+			log.Printf("Webhook %v failed %v times in a row, pausing it until %v", hook.ID, hook.Failures, until)
+		}
+		return nil
+	})
+}
+
+func truncate(s string, n int) string {
+	if rs := []rune(s); len(rs) > n {
+		return string(rs[:n])
+	}
+	return s
+}
@@ -0,0 +1,101 @@
+// Package webhook pushes the user lifecycle events to subscribed HTTP
+// endpoints. Events reach it from the outbox, are queued once per matching
+// subscription and sent signed, with retries and a circuit breaker per
+// subscription.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dontang97/ui/outbox"
+)
+
+const (
+	HeaderID        = "X-Webhook-ID"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+
+	signatureVersion = "v1"
+
+	// DefaultTolerance is how old a signed request a receiver should
+	// accept, against replays.
+	DefaultTolerance = time.Minute * 5
+)
+
+// EventTypes are the types a webhook may subscribe to.
+var EventTypes = []string{
+	outbox.UserCreated,
+	outbox.UserUpdated,
+	outbox.UserDeleted,
+	outbox.UserLoggedIn,
+	outbox.UserErased,
+	outbox.UserRenamed,
+}
+
+func ValidEventType(typ string) bool {
+	for _, t := range EventTypes {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSecret returns a random signing secret.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+func mac(secret string, ts int64, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(strconv.FormatInt(ts, 10)))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// Sign returns the X-Webhook-Signature of body sent at ts, a Unix time:
+// the HMAC-SHA256 of "<ts>.<body>" keyed with secret.
+func Sign(secret string, ts int64, body []byte) string {
+	return signatureVersion + "=" + hex.EncodeToString(mac(secret, ts, body))
+}
+
+var (
+	ErrBadSignature = errors.New("webhook: bad signature")
+	ErrStale        = errors.New("webhook: timestamp out of tolerance")
+)
+
+// Verify is the check of a receiver: signature and timestamp are the
+// headers of the request, and its timestamp must be within tolerance of now.
+func Verify(secret, timestamp, signature string, body []byte, tolerance time.Duration, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > tolerance || d < -tolerance {
+		return ErrStale
+	}
+
+	for _, sig := range strings.Split(signature, ",") {
+		parts := strings.SplitN(strings.TrimSpace(sig), "=", 2)
+		if len(parts) != 2 || parts[0] != signatureVersion {
+			continue
+		}
+		if got, err := hex.DecodeString(parts[1]); err == nil && hmac.Equal(got, mac(secret, ts, body)) {
+			return nil
+		}
+	}
+	return ErrBadSignature
+}
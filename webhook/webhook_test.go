@@ -0,0 +1,111 @@
+package webhook
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dontang97/ui/outbox"
+	"github.com/dontang97/ui/pg"
+	"github.com/stretchr/testify/suite"
+)
+
+type _Suite struct {
+	suite.Suite
+}
+
+func (s *_Suite) SetupTest() {
+}
+
+func (s *_Suite) TearDownTest() {
+}
+
+func (s *_Suite) TestSign() {
+	body := []byte(`{"id":1,"type":"user.created"}`)
+	now := time.Unix(1614600000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	sig := Sign("whsec_test", now.Unix(), body)
+	s.Equal(true, strings.HasPrefix(sig, "v1="))
+	s.Equal(3+64, len(sig))
+
+	s.Equal(nil, Verify("whsec_test", ts, sig, body, DefaultTolerance, now))
+	s.Equal(nil, Verify("whsec_test", ts, sig, body, DefaultTolerance, now.Add(4*time.Minute)))
+
+	// during a rotation the receiver may be sent several signatures
+	s.Equal(nil, Verify("whsec_test", ts, "v1=00,"+sig, body, DefaultTolerance, now))
+
+	s.Equal(ErrBadSignature, Verify("whsec_other", ts, sig, body, DefaultTolerance, now))
+	s.Equal(ErrBadSignature, Verify("whsec_test", ts, sig, []byte(`{"id":2}`), DefaultTolerance, now))
+	s.Equal(ErrBadSignature, Verify("whsec_test", ts, "v0="+sig[3:], body, DefaultTolerance, now))
+	s.Equal(ErrBadSignature, Verify("whsec_test", "noon", sig, body, DefaultTolerance, now))
+
+	// the timestamp is signed too, and old requests are replays
+	s.Equal(ErrBadSignature, Verify("whsec_test", strconv.FormatInt(now.Unix()+1, 10), sig, body, DefaultTolerance, now))
+	s.Equal(ErrStale, Verify("whsec_test", ts, sig, body, DefaultTolerance, now.Add(6*time.Minute)))
+}
+
+func (s *_Suite) TestEventTypes() {
+	for _, typ := range []string{outbox.UserCreated, outbox.UserUpdated, outbox.UserDeleted, outbox.UserLoggedIn} {
+		s.Equal(true, ValidEventType(typ))
+	}
+	s.Equal(false, ValidEventType("user.*"))
+	s.Equal(false, ValidEventType(""))
+
+	a, err := NewSecret()
+	s.Equal(nil, err)
+	b, err := NewSecret()
+	s.Equal(nil, err)
+	s.NotEqual(a, b)
+	s.Equal(true, strings.HasPrefix(a, "whsec_"))
+}
+
+func (s *_Suite) TestSend() {
+	var header http.Header
+	var body string
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	hook := &pg.Webhook{ID: 3, URL: srv.URL, Secret: "whsec_test"}
+	delivery := &pg.WebhookDelivery{
+		ID:        9,
+		WebhookID: 3,
+		EventType: outbox.UserDeleted,
+		Payload:   pg.JSONB(`{"id": 5, "type": "user.deleted"}`),
+	}
+
+	d := &Dispatcher{}
+	code, err := d.send(context.Background(), hook, delivery)
+	s.Equal(nil, err)
+	s.Equal(http.StatusNoContent, code)
+	s.Equal(string(delivery.Payload), body)
+	s.Equal("9", header.Get(HeaderID))
+	s.Equal(outbox.UserDeleted, header.Get(HeaderEvent))
+	s.Equal(nil, Verify("whsec_test", header.Get(HeaderTimestamp), header.Get(HeaderSignature),
+		[]byte(body), DefaultTolerance, time.Now()))
+
+	status = http.StatusInternalServerError
+	code, err = d.send(context.Background(), hook, delivery)
+	s.NotNil(err)
+	s.Equal(http.StatusInternalServerError, code)
+
+	srv.Close()
+	code, err = d.send(context.Background(), hook, delivery)
+	s.NotNil(err)
+	s.Equal(0, code)
+}
+
+func TestSuite(t *testing.T) {
+	suite.Run(t, new(_Suite))
+}